@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/maksimulitin/internal/controllers"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"os"
+	"time"
+)
+
+// runAdminCommand handles the admin subcommand, which grants or revokes the
+// admin role. It is the only way to create an admin, so no API request can
+// promote a customer.
+func runAdminCommand(args []string) (bool, int) {
+	if len(args) == 0 || args[0] != "admin" {
+		return false, 0
+	}
+
+	if len(args) != 3 || (args[1] != "grant" && args[1] != "revoke") {
+		fmt.Fprintln(os.Stderr, "usage: admin grant|revoke EMAIL")
+		return true, 2
+	}
+
+	role := models.RoleAdmin
+	if args[1] == "revoke" {
+		role = models.RoleCustomer
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := database.SetUserRole(ctx, controllers.UserCollection, args[2], role); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return true, 1
+	}
+
+	fmt.Printf("%s is now %s\n", args[2], role)
+	return true, 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/maksimulitin/internal/catalog"
+	"github.com/maksimulitin/internal/controllers"
+	"io"
+	"os"
+	"time"
+)
+
+// runCatalogCommand handles the import and export subcommands. It returns
+// false when args do not name one of them.
+func runCatalogCommand(args []string) (bool, int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+
+	switch args[0] {
+	case "import":
+		return true, runImport(args[1:])
+	case "export":
+		return true, runExport(args[1:])
+	}
+
+	return false, 0
+}
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "input format: csv or jsonl (default: from file extension)")
+	dryRun := fs.Bool("dry-run", false, "validate and report without writing")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import [-format csv|jsonl] [-dry-run] FILE")
+		return 2
+	}
+
+	path := fs.Arg(0)
+
+	parsedFormat, err := catalog.ParseFormat(*format, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	report, err := catalog.Import(ctx, controllers.ProductCollection, file, parsedFormat, *dryRun)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if report.Invalid > 0 {
+		return 3
+	}
+
+	return 0
+}
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "output format: csv or jsonl (default: from -o extension, else csv)")
+	output := fs.String("o", "", "output file (default: stdout)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	name := *output
+	if *format == "" && name == "" {
+		name = "products.csv"
+	}
+
+	parsedFormat, err := catalog.ParseFormat(*format, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	count, err := catalog.Export(ctx, controllers.ProductCollection, w, parsedFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d products\n", count)
+	return 0
+}
@@ -1,21 +1,40 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
 	"github.com/maksimulitin/internal/controllers"
 	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/middleware"
 	"github.com/maksimulitin/internal/routes"
+	"github.com/maksimulitin/lib/jobs"
 	"github.com/maksimulitin/lib/logger"
 	"github.com/maksimulitin/lib/serverutils"
+	"github.com/maksimulitin/lib/storage"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
 	config.LoadConfigEnv()
 
+	if handled, code := runCatalogCommand(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+
+	if handled, code := runOpenAPICommand(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+
+	if handled, code := runAdminCommand(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+
 	serverPort := os.Getenv("SERVER_PORT")
 	if serverPort == "" {
 		serverPort = "8084"
@@ -26,18 +45,132 @@ func main() {
 		serverPortFallback = "8085"
 	}
 
+	mediaRoot := os.Getenv("MEDIA_ROOT")
+	if mediaRoot == "" {
+		mediaRoot = "./media"
+	}
+
+	mediaBaseURL := os.Getenv("MEDIA_BASE_URL")
+	if mediaBaseURL == "" {
+		mediaBaseURL = "/media"
+	}
+
 	logger.Info("Starting application initialization")
 
+	if err := controllers.LoadExchangeRates(); err != nil {
+		logger.Error("Failed to load exchange rates", slog.Any("error", err))
+		log.Fatal(err)
+	}
+
+	if err := controllers.LoadTaxRules(); err != nil {
+		logger.Error("Failed to load tax rules", slog.Any("error", err))
+		log.Fatal(err)
+	}
+
+	if err := controllers.LoadPaymentProviders(); err != nil {
+		logger.Error("Failed to configure payment providers", slog.Any("error", err))
+		log.Fatal(err)
+	}
+
+	if err := controllers.LoadGuestCartSecret(); err != nil {
+		logger.Error("Failed to configure guest carts", slog.Any("error", err))
+		log.Fatal(err)
+	}
+
+	if err := controllers.LoadNotifier(); err != nil {
+		logger.Error("Failed to configure notifier", slog.Any("error", err))
+		log.Fatal(err)
+	}
+
+	imageStorage, err := storage.NewLocal(mediaRoot, mediaBaseURL)
+	if err != nil {
+		logger.Error("Failed to initialize media storage", slog.String("root", mediaRoot), slog.Any("error", err))
+		log.Fatal(err)
+	}
+
 	app := controllers.NewApplication(
 		database.ProductData(database.Client, "Products"),
 		database.UserData(database.Client, "Users"),
+		imageStorage,
 	)
 
 	logger.Info("Application controllers initialized successfully")
 
+	setupCtx, cancelSetup := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := database.MigrateLegacyPrices(setupCtx, controllers.ProductCollection, controllers.UserCollection, config.StoreCurrency()); err != nil {
+		logger.Warn("Legacy prices could not be migrated", slog.Any("error", err))
+	}
+	if err := database.MigrateLegacyRatings(setupCtx, controllers.ProductCollection, controllers.ReviewCollection); err != nil {
+		logger.Warn("Legacy ratings could not be migrated", slog.Any("error", err))
+	}
+	if err := database.MigrateProductImages(setupCtx, controllers.ProductCollection); err != nil {
+		logger.Warn("Product images could not be migrated", slog.Any("error", err))
+	}
+	if err := database.MigrateSearchTerms(setupCtx, controllers.ProductCollection); err != nil {
+		logger.Warn("Product search terms could not be migrated", slog.Any("error", err))
+	}
+	if err := database.MigrateLegacyAddresses(setupCtx, controllers.UserCollection); err != nil {
+		logger.Warn("Legacy addresses could not be migrated", slog.Any("error", err))
+	}
+	if err := database.MigrateCartTimestamps(setupCtx, controllers.UserCollection); err != nil {
+		logger.Warn("Cart timestamps could not be migrated", slog.Any("error", err))
+	}
+	if err := database.EnsureProductIndexes(setupCtx, controllers.ProductCollection); err != nil {
+		logger.Warn("Product indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureReviewIndexes(setupCtx, controllers.ReviewCollection); err != nil {
+		logger.Warn("Review indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureCouponIndexes(setupCtx, controllers.CouponCollection, controllers.RedemptionCollection); err != nil {
+		logger.Warn("Coupon indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsurePromotionIndexes(setupCtx, controllers.PromotionCollection); err != nil {
+		logger.Warn("Promotion indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureShippingIndexes(setupCtx, controllers.ShippingCollection); err != nil {
+		logger.Warn("Shipping indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsurePaymentIndexes(setupCtx, controllers.TransactionCollection); err != nil {
+		logger.Warn("Payment indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureWishlistIndexes(setupCtx, controllers.WishlistCollection); err != nil {
+		logger.Warn("Wishlist indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureGuestCartIndexes(setupCtx, controllers.GuestCartCollection); err != nil {
+		logger.Warn("Guest cart indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureCartReminderIndexes(setupCtx, controllers.CartReminderCollection); err != nil {
+		logger.Warn("Cart reminder indexes could not be ensured", slog.Any("error", err))
+	}
+	if err := database.EnsureIdempotencyIndexes(setupCtx, controllers.IdempotencyCollection); err != nil {
+		logger.Warn("Idempotency indexes could not be ensured", slog.Any("error", err))
+	}
+	cancelSetup()
+
+	controllers.RefreshSuggestions()
+
+	if interval := config.CartReminderInterval(); interval > 0 {
+		scheduler := jobs.New(jobs.Job{Name: "cart-reminders", Interval: interval, Run: controllers.RemindIdleCarts})
+		scheduler.Start(context.Background())
+		defer scheduler.Stop()
+	} else {
+		logger.Info("Cart reminders are turned off")
+	}
+
 	router := gin.New()
-	router.Use(gin.Logger())
+	router.HandleMethodNotAllowed = true
+	router.Use(middleware.RequestID(), gin.Logger(), gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		logger.Error("Handler panicked", slog.String("path", c.FullPath()), slog.Any("panic", recovered))
+		apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "internal server error")
+	}))
+	router.NoRoute(func(c *gin.Context) {
+		apierror.Abort(c, http.StatusNotFound, apierror.CodeNotFound, "route not found")
+	})
+	router.NoMethod(func(c *gin.Context) {
+		apierror.Abort(c, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+	})
 	routes.SetupRoutes(router, app)
+	router.Static(mediaBaseURL, mediaRoot)
 
 	logger.Info("Router configured successfully", slog.String("port", serverPort), slog.Any("routes", router.Routes()))
 	logger.Info("Attempting to start server on port " + serverPort)
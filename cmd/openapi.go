@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/maksimulitin/internal/controllers"
+	"github.com/maksimulitin/internal/routes"
+	"io"
+	"os"
+)
+
+// runOpenAPICommand handles the openapi subcommand, which writes the API
+// document or, with -check, fails when it and the router disagree.
+func runOpenAPICommand(args []string) (bool, int) {
+	if len(args) == 0 || args[0] != "openapi" {
+		return false, 0
+	}
+
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	check := fs.Bool("check", false, "exit 1 if a registered route is undocumented or a documented one is not registered")
+	output := fs.String("o", "", "output file (default: stdout)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return true, 2
+	}
+
+	if *check {
+		return true, checkOpenAPI()
+	}
+
+	var w io.Writer = os.Stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return true, 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(routes.Spec()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return true, 1
+	}
+
+	return true, 0
+}
+
+// checkOpenAPI registers every route, the legacy ones included, on a bare
+// router and compares them with the document. Nothing is served.
+func checkOpenAPI() int {
+	os.Setenv("LEGACY_ROUTES", "true")
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	routes.SetupRoutes(router, controllers.NewApplication(nil, nil, nil))
+
+	undocumented, unregistered := routes.Coverage(router)
+
+	for _, route := range undocumented {
+		fmt.Fprintf(os.Stderr, "undocumented route: %s\n", route)
+	}
+
+	for _, route := range unregistered {
+		fmt.Fprintf(os.Stderr, "documented route is not registered: %s\n", route)
+	}
+
+	if len(undocumented) > 0 || len(unregistered) > 0 {
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "all %d routes are documented\n", len(router.Routes()))
+	return 0
+}
@@ -5,11 +5,122 @@ import (
 	"github.com/maksimulitin/lib/logger"
 	"log"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+func findEnvFile() string {
+	dir, err := os.Getwd()
+
+	for err == nil {
+		path := filepath.Join(dir, ".env")
+		if _, statErr := os.Stat(path); statErr == nil {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return ".env"
+}
+
+const defaultStoreCurrency = "USD"
+
+// LoadConfigEnv loads the nearest .env file, looking in the working directory
+// and then its parents, so commands and tests run from a package directory
+// find the one at the module root.
 func LoadConfigEnv() {
-	if err := godotenv.Load(); err != nil {
+	if err := godotenv.Load(findEnvFile()); err != nil {
 		logger.Error("not found .env file", slog.Any("err", err))
 		log.Fatal("Error loading .env file")
 	}
 }
+
+// StoreCurrency is the ISO 4217 code every catalog price is stored in.
+func StoreCurrency() string {
+	if currency := strings.ToUpper(strings.TrimSpace(os.Getenv("STORE_CURRENCY"))); currency != "" {
+		return currency
+	}
+	return defaultStoreCurrency
+}
+
+const defaultMaxAddresses = 10
+
+// MaxAddresses is how many addresses a user may keep, from MAX_ADDRESSES.
+func MaxAddresses() int {
+	if limit, err := strconv.Atoi(os.Getenv("MAX_ADDRESSES")); err == nil && limit > 0 {
+		return limit
+	}
+	return defaultMaxAddresses
+}
+
+const defaultGuestCartTTL = 7 * 24 * time.Hour
+
+// GuestCartTTL is how long an anonymous cart is kept after its last change,
+// from GUEST_CART_TTL.
+func GuestCartTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultGuestCartTTL
+}
+
+// GuestCartSecret is the key guest cart tokens are signed with, from
+// GUEST_CART_SECRET. It has no default.
+func GuestCartSecret() string {
+	return os.Getenv("GUEST_CART_SECRET")
+}
+
+// CartMergeStrategy decides how quantities add up when a guest cart is merged
+// into a user's cart on login, from CART_MERGE_STRATEGY: "max" (the default)
+// keeps the larger quantity of a product in both carts, "sum" adds them and
+// "guest" lets the guest cart's quantity win.
+func CartMergeStrategy() string {
+	switch strategy := strings.ToLower(strings.TrimSpace(os.Getenv("CART_MERGE_STRATEGY"))); strategy {
+	case "sum", "guest":
+		return strategy
+	default:
+		return "max"
+	}
+}
+
+const (
+	defaultCartReminderAfter    = 24 * time.Hour
+	defaultCartReminderInterval = 15 * time.Minute
+	defaultCartRecoveryWindow   = 7 * 24 * time.Hour
+)
+
+// CartReminderAfter is how long a cart has to sit unchanged before its owner
+// is reminded of it, from CART_REMINDER_AFTER.
+func CartReminderAfter() time.Duration {
+	if after, err := time.ParseDuration(os.Getenv("CART_REMINDER_AFTER")); err == nil && after > 0 {
+		return after
+	}
+	return defaultCartReminderAfter
+}
+
+// CartReminderInterval is how often idle carts are looked for, from
+// CART_REMINDER_INTERVAL. Zero turns cart reminders off.
+func CartReminderInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("CART_REMINDER_INTERVAL"))
+	if err != nil || interval < 0 {
+		return defaultCartReminderInterval
+	}
+	return interval
+}
+
+// CartRecoveryWindow is how long after a reminder a checkout still counts as
+// recovering the cart, from CART_RECOVERY_WINDOW.
+func CartRecoveryWindow() time.Duration {
+	if window, err := time.ParseDuration(os.Getenv("CART_RECOVERY_WINDOW")); err == nil && window > 0 {
+		return window
+	}
+	return defaultCartRecoveryWindow
+}
@@ -0,0 +1,197 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// RequestIDKey is the context key the request ID middleware stores the ID
+// of the current request under.
+const RequestIDKey = "request_id"
+
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeInvalidID        = "invalid_id"
+	CodeInvalidJSON      = "invalid_json"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeInternal         = "internal"
+)
+
+// Error is the body of every error response.
+type Error struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []Detail `json:"details,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+// Detail is one problem with one field of the request.
+type Detail struct {
+	Field   string `json:"field,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// Abort ends the request with an error response.
+func Abort(c *gin.Context, status int, code, message string, details ...Detail) {
+	c.AbortWithStatusJSON(status, Error{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(RequestIDKey),
+	})
+}
+
+// InvalidID rejects a malformed identifier, such as an ObjectID, named by
+// what it identifies.
+func InvalidID(c *gin.Context, what string) {
+	Abort(c, http.StatusBadRequest, CodeInvalidID, "invalid "+what+" id")
+}
+
+// BadRequest rejects a request whose parameters make no sense.
+func BadRequest(c *gin.Context, message string) {
+	Abort(c, http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+// Respond ends the request with the response registered for err, or a 500
+// for errors nobody registered. Unregistered errors are logged but never
+// shown to the client.
+func Respond(c *gin.Context, err error) {
+	if m, ok := lookup(err); ok {
+		if m.status >= http.StatusInternalServerError {
+			logger.Error("request failed", slog.String("path", c.FullPath()), slog.Any("error", err))
+		}
+		Abort(c, m.status, m.code, err.Error())
+		return
+	}
+
+	logger.Error("unexpected error", slog.String("path", c.FullPath()), slog.Any("error", err))
+	Abort(c, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+// Bind decodes the JSON body into obj. On failure it responds with the
+// problem and returns false.
+func Bind(c *gin.Context, obj any) bool {
+	err := c.ShouldBindJSON(obj)
+
+	if err == nil {
+		return true
+	}
+
+	logger.Warn("Error binding JSON", slog.String("path", c.FullPath()), slog.Any("error", err))
+
+	var typeErr *json.UnmarshalTypeError
+
+	if errors.As(err, &typeErr) {
+		Abort(c, http.StatusBadRequest, CodeInvalidJSON, "request body has fields of the wrong type", Detail{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "must be " + typeErr.Type.String(),
+		})
+		return false
+	}
+
+	Abort(c, http.StatusBadRequest, CodeInvalidJSON, "request body is not valid JSON")
+	return false
+}
+
+// Validation responds to the result of validating a request struct with
+// one detail per failing field.
+func Validation(c *gin.Context, err error) {
+	var fieldErrs validator.ValidationErrors
+
+	if !errors.As(err, &fieldErrs) {
+		Abort(c, http.StatusBadRequest, CodeValidationFailed, err.Error())
+		return
+	}
+
+	details := make([]Detail, 0, len(fieldErrs))
+
+	for _, fe := range fieldErrs {
+		details = append(details, Detail{Field: fieldPath(fe), Rule: fe.Tag(), Message: ruleMessage(fe)})
+	}
+
+	Abort(c, http.StatusBadRequest, CodeValidationFailed, "request is invalid", details...)
+}
+
+// fieldPath is the field's JSON path without the name of the top level
+// struct, e.g. "tiers[0].min_subtotal".
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		return namespace[i+1:]
+	}
+
+	return namespace
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return "must be at least " + fe.Param() + unit(fe)
+	case "max":
+		return "must be at most " + fe.Param() + unit(fe)
+	case "alphanum":
+		return "must contain only letters and digits"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	case "lte":
+		return "must be less than or equal to " + fe.Param()
+	}
+
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
+
+// unit names what min and max count for strings and lists.
+func unit(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.String:
+		return " characters long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	}
+	return ""
+}
+
+// NewValidator returns a validator that names fields by their JSON names,
+// so Validation reports them as clients send them.
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonFieldName)
+	return v
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+
+	if name == "-" {
+		return ""
+	}
+
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
@@ -0,0 +1,157 @@
+package apierror
+
+import (
+	"errors"
+	"github.com/maksimulitin/internal/catalog"
+	"github.com/maksimulitin/internal/database"
+	token "github.com/maksimulitin/internal/tokens"
+	"github.com/maksimulitin/lib/imaging"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/payment"
+	"github.com/maksimulitin/lib/storage"
+	"github.com/maksimulitin/lib/tax"
+	"net/http"
+)
+
+type mapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// mappings is the one place domain errors get their HTTP status and error
+// code. Errors are matched with errors.Is in order, so an error listed
+// earlier wins.
+var mappings = []mapping{
+	// Lookups of things that do not exist.
+	{database.ErrCantFindProduct, http.StatusNotFound, "product_not_found"},
+	{database.ErrUserIDIsNotValid, http.StatusNotFound, "user_not_found"},
+	{database.ErrCouponNotFound, http.StatusNotFound, "coupon_not_found"},
+	{database.ErrPromotionNotFound, http.StatusNotFound, "promotion_not_found"},
+	{database.ErrShippingMethodNotFound, http.StatusNotFound, "shipping_method_not_found"},
+	{database.ErrImageNotFound, http.StatusNotFound, "image_not_found"},
+	{database.ErrReviewNotFound, http.StatusNotFound, "review_not_found"},
+	{database.ErrPaymentNotFound, http.StatusNotFound, "payment_not_found"},
+	{database.ErrOrderNotFound, http.StatusNotFound, "order_not_found"},
+	{database.ErrWishlistNotFound, http.StatusNotFound, "wishlist_not_found"},
+	{database.ErrWishlistItemNotFound, http.StatusNotFound, "wishlist_item_not_found"},
+	{database.ErrCartItemNotFound, http.StatusNotFound, "cart_item_not_found"},
+	{database.ErrGuestCartNotFound, http.StatusNotFound, "cart_not_found"},
+	{payment.ErrUnknownReference, http.StatusNotFound, "payment_not_found"},
+
+	// Requests that are well formed but invalid.
+	{token.ErrInvalidCartToken, http.StatusBadRequest, "invalid_cart_token"},
+	{database.ErrInvalidPageToken, http.StatusBadRequest, "invalid_page_token"},
+	{database.ErrInvalidSort, http.StatusBadRequest, "invalid_sort"},
+	{database.ErrEmptySearchQuery, http.StatusBadRequest, "empty_search_query"},
+	{database.ErrInvalidReviewStatus, http.StatusBadRequest, "invalid_review_status"},
+	{database.ErrInvalidImageOrder, http.StatusBadRequest, "invalid_image_order"},
+	{database.ErrTooManyImages, http.StatusBadRequest, "too_many_images"},
+	{database.ErrInvalidRefund, http.StatusBadRequest, "invalid_refund"},
+	{database.ErrCouponPercent, http.StatusBadRequest, "invalid_coupon"},
+	{database.ErrCouponAmount, http.StatusBadRequest, "invalid_coupon"},
+	{database.ErrCouponMinimum, http.StatusBadRequest, "invalid_coupon"},
+	{database.ErrCouponWindow, http.StatusBadRequest, "invalid_coupon"},
+	{database.ErrPromotionBuyXGetY, http.StatusBadRequest, "invalid_promotion"},
+	{database.ErrPromotionBundle, http.StatusBadRequest, "invalid_promotion"},
+	{database.ErrPromotionTiers, http.StatusBadRequest, "invalid_promotion"},
+	{database.ErrPromotionWindow, http.StatusBadRequest, "invalid_promotion"},
+	{database.ErrShippingFlat, http.StatusBadRequest, "invalid_shipping_method"},
+	{database.ErrShippingWeight, http.StatusBadRequest, "invalid_shipping_method"},
+	{database.ErrShippingThreshold, http.StatusBadRequest, "invalid_shipping_method"},
+	{money.ErrCurrencyMismatch, http.StatusBadRequest, "currency_mismatch"},
+	{money.ErrInvalidCurrency, http.StatusBadRequest, "invalid_currency"},
+	{money.ErrInvalidAmount, http.StatusBadRequest, "invalid_amount"},
+	{money.ErrUnknownCurrency, http.StatusBadRequest, "unknown_currency"},
+	{money.ErrInvalidRate, http.StatusBadRequest, "invalid_exchange_rate"},
+	{money.ErrOverflow, http.StatusBadRequest, "amount_overflow"},
+	{tax.ErrInvalidMode, http.StatusBadRequest, "invalid_tax_rules"},
+	{tax.ErrInvalidRate, http.StatusBadRequest, "invalid_tax_rules"},
+	{tax.ErrInvalidRegion, http.StatusBadRequest, "invalid_tax_rules"},
+	{catalog.ErrMissingHeader, http.StatusBadRequest, "invalid_import"},
+	{catalog.ErrUnknownFormat, http.StatusBadRequest, "invalid_import"},
+	{storage.ErrInvalidKey, http.StatusBadRequest, "invalid_storage_key"},
+	{imaging.ErrTooLarge, http.StatusRequestEntityTooLarge, "image_too_large"},
+	{imaging.ErrUnsupportedType, http.StatusUnsupportedMediaType, "unsupported_image_type"},
+
+	// Valid requests the current state does not allow.
+	{database.ErrCartIsEmpty, http.StatusUnprocessableEntity, "cart_empty"},
+	{database.ErrCartItemsGone, http.StatusUnprocessableEntity, "cart_items_unavailable"},
+	{database.ErrAddressNotFound, http.StatusUnprocessableEntity, "address_not_found"},
+	{database.ErrAddressRequired, http.StatusUnprocessableEntity, "address_required"},
+	{database.ErrAddressIncomplete, http.StatusUnprocessableEntity, "address_incomplete"},
+	{database.ErrAddressLimit, http.StatusUnprocessableEntity, "address_limit"},
+	{database.ErrCouponInactive, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrCouponNotStarted, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrCouponExpired, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrCouponUsedUp, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrCouponUserLimit, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrCouponMinimumNotMet, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrCouponNotApplicable, http.StatusUnprocessableEntity, "coupon_rejected"},
+	{database.ErrNoShippingMethod, http.StatusUnprocessableEntity, "shipping_unavailable"},
+	{database.ErrShippingUnavailable, http.StatusUnprocessableEntity, "shipping_unavailable"},
+	{database.ErrPaymentDeclined, http.StatusPaymentRequired, "payment_declined"},
+	{payment.ErrUnknownProvider, http.StatusUnprocessableEntity, "unknown_payment_method"},
+	{payment.ErrTokenRequired, http.StatusUnprocessableEntity, "payment_token_required"},
+	{payment.ErrInvalidAmount, http.StatusUnprocessableEntity, "invalid_payment_amount"},
+	{database.ErrPaymentMismatch, http.StatusUnprocessableEntity, "payment_amount_mismatch"},
+	{payment.ErrWebhooksNotEnabled, http.StatusNotFound, "webhooks_not_enabled"},
+	{payment.ErrInvalidSignature, http.StatusUnauthorized, "invalid_signature"},
+	{database.ErrReviewNotAllowed, http.StatusForbidden, "review_not_allowed"},
+
+	// Conflicts with what is already stored.
+	{database.ErrCouponExists, http.StatusConflict, "coupon_exists"},
+	{database.ErrReviewAlreadyExists, http.StatusConflict, "review_exists"},
+	{database.ErrWishlistNameTaken, http.StatusConflict, "wishlist_exists"},
+	{database.ErrCartPricesChanged, http.StatusConflict, "cart_prices_changed"},
+	{database.ErrPaymentState, http.StatusConflict, "payment_state"},
+	{payment.ErrInvalidTransition, http.StatusConflict, "payment_state"},
+	{database.ErrIdempotencyMismatch, http.StatusConflict, "idempotency_key_reused"},
+	{database.ErrIdempotencyInProgress, http.StatusConflict, "idempotency_key_in_progress"},
+
+	// Failures of the store or a provider. The message is safe to show.
+	{database.ErrPaymentFailed, http.StatusBadGateway, "payment_failed"},
+	{database.ErrCantDecodeProducts, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantUpdateUser, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantRemoveItem, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantBuyCartItem, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantPriceCart, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantComputeTax, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListProducts, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantUpsertProducts, http.StatusInternalServerError, CodeInternal},
+	{database.ErrProductCursorState, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSearch, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantUpdateImages, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSaveCoupon, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListCoupons, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantRedeemCoupon, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSavePromotion, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListPromotions, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantApplyPromotions, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSaveShipping, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListShipping, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSaveReview, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantUpdateRating, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListReviews, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSaveWishlist, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListWishlists, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSaveGuestCart, http.StatusInternalServerError, CodeInternal},
+	{token.ErrCartTokensDisabled, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantMergeGuestCart, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListTransaction, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantSaveIdempotency, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantUpdateAddress, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantLoadOrders, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantListReminders, http.StatusInternalServerError, CodeInternal},
+	{database.ErrCantLoadRole, http.StatusInternalServerError, CodeInternal},
+}
+
+func lookup(err error) (mapping, bool) {
+	for _, m := range mappings {
+		if errors.Is(err, m.err) {
+			return m, true
+		}
+	}
+
+	return mapping{}, false
+}
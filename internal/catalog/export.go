@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log/slog"
+)
+
+// flushEvery controls how many rows are buffered before they are pushed to
+// the writer, keeping memory flat for large catalogs.
+const flushEvery = 200
+
+type flusher interface {
+	Flush()
+}
+
+// Export streams the whole catalog to w. When w can be flushed (for example
+// an HTTP response) it is flushed periodically so the client sees progress.
+func Export(ctx context.Context, prodCollection *mongo.Collection, w io.Writer, format string) (int, error) {
+	var (
+		count int
+		write func(Record) error
+		done  func() error
+	)
+
+	outer, _ := w.(flusher)
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+
+		if err := cw.Write(Columns); err != nil {
+			return 0, err
+		}
+
+		write = func(rec Record) error { return cw.Write(recordToCSV(rec)) }
+		done = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		write = func(rec Record) error { return encoder.Encode(rec) }
+		done = func() error { return nil }
+	default:
+		return 0, ErrUnknownFormat
+	}
+
+	err := database.StreamProducts(ctx, prodCollection, func(product models.Product) error {
+		if err := write(RecordFromProduct(product)); err != nil {
+			return err
+		}
+
+		count++
+
+		if count%flushEvery == 0 {
+			if err := done(); err != nil {
+				return err
+			}
+			if outer != nil {
+				outer.Flush()
+			}
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = done()
+	}
+
+	if err != nil {
+		logger.Error("catalog export failed", slog.String("format", format), slog.Int("exported", count), slog.Any("error", err))
+		return count, err
+	}
+
+	logger.Info("catalog export finished", slog.String("format", format), slog.Int("exported", count))
+	return count, nil
+}
@@ -0,0 +1,234 @@
+package catalog
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	batchSize = 500
+
+	// MaxReportedErrors bounds the report size for badly broken files; the
+	// Invalid counter still covers every row.
+	MaxReportedErrors = 1000
+
+	maxLineBytes = 1 << 20
+)
+
+var ErrMissingHeader = errors.New("csv file is missing a header row with sku, product_name and price")
+
+type Report struct {
+	Format  string     `json:"format"`
+	DryRun  bool       `json:"dry_run"`
+	Rows    int        `json:"rows"`
+	Valid   int        `json:"valid"`
+	Invalid int        `json:"invalid"`
+	Created int64      `json:"created"`
+	Updated int64      `json:"updated"`
+	Errors  []RowError `json:"errors"`
+}
+
+func (r *Report) reject(row int, sku string, errs []FieldError) {
+	r.Invalid++
+
+	if len(r.Errors) < MaxReportedErrors {
+		r.Errors = append(r.Errors, RowError{Row: row, SKU: sku, Errors: errs})
+	}
+}
+
+type importer struct {
+	ctx            context.Context
+	prodCollection *mongo.Collection
+	report         *Report
+	seen           map[string]int
+	batch          []models.Product
+}
+
+// Import reads products in the given format and upserts them by SKU in
+// batches. Invalid rows are reported and skipped; with dryRun nothing is
+// written but the report still says which SKUs would be created or updated.
+func Import(ctx context.Context, prodCollection *mongo.Collection, r io.Reader, format string, dryRun bool) (*Report, error) {
+	imp := &importer{
+		ctx:            ctx,
+		prodCollection: prodCollection,
+		report:         &Report{Format: format, DryRun: dryRun, Errors: make([]RowError, 0)},
+		seen:           make(map[string]int),
+	}
+
+	var err error
+
+	switch format {
+	case FormatCSV:
+		err = imp.readCSV(r)
+	case FormatJSONL:
+		err = imp.readJSONL(r)
+	default:
+		err = ErrUnknownFormat
+	}
+
+	if err == nil {
+		err = imp.flush()
+	}
+
+	if err != nil {
+		logger.Error("catalog import failed", slog.String("format", format), slog.Int("rows", imp.report.Rows), slog.Any("error", err))
+		return imp.report, err
+	}
+
+	logger.Info("catalog import finished",
+		slog.String("format", format),
+		slog.Bool("dryRun", dryRun),
+		slog.Int("rows", imp.report.Rows),
+		slog.Int("invalid", imp.report.Invalid),
+		slog.Int64("created", imp.report.Created),
+		slog.Int64("updated", imp.report.Updated),
+	)
+	return imp.report, nil
+}
+
+func (imp *importer) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return ErrMissingHeader
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+
+	for _, required := range []string{"sku", "product_name", "price"} {
+		if _, ok := columns[required]; !ok {
+			return ErrMissingHeader
+		}
+	}
+
+	// Row numbers count the header as row 1, matching what spreadsheets show.
+	for row := 2; ; row++ {
+		fields, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		imp.report.Rows++
+
+		if err != nil {
+			imp.report.reject(row, "", []FieldError{{Message: err.Error()}})
+			continue
+		}
+
+		rec, errs := recordFromCSV(columns, fields)
+
+		if err := imp.add(row, rec, errs); err != nil {
+			return err
+		}
+	}
+}
+
+func (imp *importer) readJSONL(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+
+	for row := 1; scanner.Scan(); row++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		imp.report.Rows++
+
+		var rec Record
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&rec); err != nil {
+			imp.report.reject(row, "", []FieldError{{Message: fmt.Sprintf("invalid JSON: %s", err.Error())}})
+			continue
+		}
+
+		rec.SKU = strings.TrimSpace(rec.SKU)
+		rec.ProductName = strings.TrimSpace(rec.ProductName)
+
+		if err := imp.add(row, rec, nil); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (imp *importer) add(row int, rec Record, errs []FieldError) error {
+	errs = append(errs, rec.Validate()...)
+
+	if first, ok := imp.seen[rec.SKU]; ok && rec.SKU != "" {
+		errs = append(errs, FieldError{Field: "sku", Message: fmt.Sprintf("duplicates row %d", first)})
+	}
+
+	if len(errs) > 0 {
+		imp.report.reject(row, rec.SKU, errs)
+		return nil
+	}
+
+	imp.seen[rec.SKU] = row
+	imp.report.Valid++
+	imp.batch = append(imp.batch, rec.Product())
+
+	if len(imp.batch) >= batchSize {
+		return imp.flush()
+	}
+
+	return nil
+}
+
+func (imp *importer) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+
+	defer func() { imp.batch = imp.batch[:0] }()
+
+	if imp.report.DryRun {
+		skus := make([]string, 0, len(imp.batch))
+		for _, product := range imp.batch {
+			skus = append(skus, *product.SKU)
+		}
+
+		existing, err := database.ExistingSKUs(imp.ctx, imp.prodCollection, skus)
+
+		if err != nil {
+			return err
+		}
+
+		imp.report.Updated += int64(len(existing))
+		imp.report.Created += int64(len(skus) - len(existing))
+		return nil
+	}
+
+	created, updated, err := database.UpsertProductsBySKU(imp.ctx, imp.prodCollection, imp.batch)
+
+	if err != nil {
+		return err
+	}
+
+	imp.report.Created += created
+	imp.report.Updated += updated
+	return nil
+}
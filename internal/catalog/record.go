@@ -0,0 +1,246 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+var ErrUnknownFormat = errors.New("unknown format, expected csv or jsonl")
+
+// Columns is the CSV header used for export. Import only requires the
+// writable columns and ignores the rest.
+var Columns = []string{"sku", "product_name", "description", "price", "currency", "category", "tax_class", "weight_grams", "stock", "image", "rating", "rating_count", "product_id"}
+
+// Record is one catalog row in its interchange form.
+type Record struct {
+	SKU         string       `json:"sku"`
+	ProductName string       `json:"product_name"`
+	Description string       `json:"description,omitempty"`
+	Price       *money.Money `json:"price"`
+	Category    string       `json:"category,omitempty"`
+	TaxClass    string       `json:"tax_class,omitempty"`
+	WeightGrams *int64       `json:"weight_grams,omitempty"`
+	Stock       *int64       `json:"stock,omitempty"`
+	Image       string       `json:"image,omitempty"`
+	Rating      float64      `json:"rating"`
+	RatingCount int64        `json:"rating_count"`
+	ProductID   string       `json:"product_id,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type RowError struct {
+	Row    int          `json:"row"`
+	SKU    string       `json:"sku,omitempty"`
+	Errors []FieldError `json:"errors"`
+}
+
+// ParseFormat accepts an explicit format name or falls back to the file
+// extension of name.
+func ParseFormat(format, name string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+
+	switch strings.ToLower(format) {
+	case "csv":
+		return FormatCSV, nil
+	case "jsonl", "ndjson":
+		return FormatJSONL, nil
+	}
+
+	return "", ErrUnknownFormat
+}
+
+// recordFromCSV converts one CSV row using the column positions in header.
+func recordFromCSV(header map[string]int, row []string) (Record, []FieldError) {
+	var (
+		rec  Record
+		errs []FieldError
+	)
+
+	get := func(column string) string {
+		if i, ok := header[column]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	rec.SKU = get("sku")
+	rec.ProductName = get("product_name")
+	rec.Description = get("description")
+	rec.Category = get("category")
+	rec.TaxClass = strings.ToLower(get("tax_class"))
+	rec.Image = get("image")
+
+	currency := strings.ToUpper(get("currency"))
+	if currency == "" {
+		currency = config.StoreCurrency()
+	}
+
+	if raw := get("price"); raw != "" {
+		price, err := money.Parse(raw, currency)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "price", Message: fmt.Sprintf("%q is not a valid %s amount", raw, currency)})
+		} else {
+			rec.Price = &price
+		}
+	}
+
+	if raw := get("weight_grams"); raw != "" {
+		grams, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "weight_grams", Message: fmt.Sprintf("%q is not an integer", raw)})
+		} else {
+			rec.WeightGrams = &grams
+		}
+	}
+
+	if raw := get("stock"); raw != "" {
+		stock, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "stock", Message: fmt.Sprintf("%q is not an integer", raw)})
+		} else {
+			rec.Stock = &stock
+		}
+	}
+
+	return rec, errs
+}
+
+func recordToCSV(rec Record) []string {
+	var price, currency, weight, stock string
+
+	if rec.Price != nil {
+		price, currency = rec.Price.Decimal(), rec.Price.Currency
+	}
+
+	if rec.WeightGrams != nil {
+		weight = strconv.FormatInt(*rec.WeightGrams, 10)
+	}
+
+	if rec.Stock != nil {
+		stock = strconv.FormatInt(*rec.Stock, 10)
+	}
+
+	return []string{
+		rec.SKU,
+		rec.ProductName,
+		rec.Description,
+		price,
+		currency,
+		rec.Category,
+		rec.TaxClass,
+		weight,
+		stock,
+		rec.Image,
+		strconv.FormatFloat(rec.Rating, 'f', -1, 64),
+		strconv.FormatInt(rec.RatingCount, 10),
+		rec.ProductID,
+	}
+}
+
+// Validate checks the writable fields of a record.
+func (rec Record) Validate() []FieldError {
+	var errs []FieldError
+
+	switch {
+	case rec.SKU == "":
+		errs = append(errs, FieldError{Field: "sku", Message: "is required"})
+	case len(rec.SKU) > 64:
+		errs = append(errs, FieldError{Field: "sku", Message: "must be at most 64 characters"})
+	case strings.ContainsAny(rec.SKU, " \t\r\n"):
+		errs = append(errs, FieldError{Field: "sku", Message: "must not contain whitespace"})
+	}
+
+	if rec.ProductName == "" {
+		errs = append(errs, FieldError{Field: "product_name", Message: "is required"})
+	} else if len(rec.ProductName) > 200 {
+		errs = append(errs, FieldError{Field: "product_name", Message: "must be at most 200 characters"})
+	}
+
+	switch {
+	case rec.Price == nil:
+		errs = append(errs, FieldError{Field: "price", Message: "is required"})
+	case rec.Price.IsNegative():
+		errs = append(errs, FieldError{Field: "price", Message: "must not be negative"})
+	case rec.Price.Currency != config.StoreCurrency():
+		errs = append(errs, FieldError{Field: "currency", Message: fmt.Sprintf("must be the store currency %s", config.StoreCurrency())})
+	}
+
+	if rec.WeightGrams != nil && *rec.WeightGrams < 0 {
+		errs = append(errs, FieldError{Field: "weight_grams", Message: "must not be negative"})
+	}
+
+	if rec.Stock != nil && *rec.Stock < 0 {
+		errs = append(errs, FieldError{Field: "stock", Message: "must not be negative"})
+	}
+
+	return errs
+}
+
+func (rec Record) Product() models.Product {
+	product := models.Product{
+		SKU:         &rec.SKU,
+		ProductName: &rec.ProductName,
+		Price:       rec.Price,
+		WeightGrams: rec.WeightGrams,
+		Stock:       rec.Stock,
+	}
+
+	if rec.Description != "" {
+		product.Description = &rec.Description
+	}
+
+	if rec.Category != "" {
+		product.Category = &rec.Category
+	}
+
+	if rec.TaxClass != "" {
+		product.TaxClass = &rec.TaxClass
+	}
+
+	if rec.Image != "" {
+		product.Image = &rec.Image
+	}
+
+	return product
+}
+
+func RecordFromProduct(product models.Product) Record {
+	return Record{
+		SKU:         deref(product.SKU),
+		ProductName: deref(product.ProductName),
+		Description: deref(product.Description),
+		Price:       product.Price,
+		Category:    deref(product.Category),
+		TaxClass:    deref(product.TaxClass),
+		WeightGrams: product.WeightGrams,
+		Stock:       product.Stock,
+		Image:       deref(product.Image),
+		Rating:      product.Rating,
+		RatingCount: product.RatingCount,
+		ProductID:   product.ProductID.Hex(),
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
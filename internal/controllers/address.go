@@ -3,11 +3,14 @@ package controllers
 import (
 	"context"
 	"errors"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
 	"github.com/maksimulitin/internal/models"
 	"github.com/maksimulitin/lib/logger"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"log/slog"
 	"net/http"
 	"time"
@@ -15,221 +18,262 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func AddAddress() gin.HandlerFunc {
+func ListAddresses() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Query("id")
 
 		if userID == "" {
 			logger.Error("User ID is empty")
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid ID"})
-			c.Abort()
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
-		address, err := primitive.ObjectIDFromHex(userID)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		addresses, err := database.ListAddresses(ctx, UserCollection, userID)
 
 		if err != nil {
-			logger.Error("Invalid user ID format", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
+			logger.Error("Failed to list addresses", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
 			return
 		}
 
-		var addresses models.Address
-		addresses.AddressId = primitive.NewObjectID()
+		c.JSON(http.StatusOK, dto.NewAddressResponses(addresses))
+	}
+}
 
-		if err := c.BindJSON(&addresses); err != nil {
-			logger.Error("Failed to bind JSON to address struct", slog.Any("error", err))
-			c.IndentedJSON(http.StatusNotAcceptable, err.Error())
+func AddAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("id")
+
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		matchFilter := bson.D{{Key: "$match",
-			Value: bson.D{{Key: "_id",
-				Value: address,
-			}}}}
+		var req dto.AddressRequest
 
-		unwind := bson.D{{Key: "$unwind",
-			Value: bson.D{{Key: "path",
-				Value: "$address",
-			}}}}
-
-		group := bson.D{{Key: "$group",
-			Value: bson.D{{Key: "_id",
-				Value: "$address_id"},
-				{Key: "count",
-					Value: bson.D{{Key: "$sum",
-						Value: 1,
-					}}}}}}
-
-		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{matchFilter, unwind, group})
+		if !apierror.Bind(c, &req) {
+			return
+		}
 
-		if err != nil {
-			logger.Error("Failed to aggregate address data", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
+		if err := Validate.Struct(req); err != nil {
+			apierror.Validation(c, err)
 			return
 		}
 
-		var addressInfo []bson.M
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		address := req.Merge(models.Address{})
 
-		if err = pointCursor.All(ctx, &addressInfo); err != nil {
-			logger.Error("Failed to decode aggregation results", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
+		if !normalizeAddress(c, &address) {
 			return
 		}
 
-		var size int32
-		for _, addressNo := range addressInfo {
-			count := addressNo["count"]
-			size = count.(int32)
+		if err := database.AddAddress(ctx, UserCollection, userID, &address, config.MaxAddresses()); err != nil {
+			logger.Warn("Failed to add address", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
 		}
 
-		if size < 2 {
-			filter := bson.D{{Key: "_id",
-				Value: address,
-			}}
-
-			update := bson.D{{Key: "$push",
-				Value: bson.D{{Key: "address",
-					Value: addresses,
-				}}}}
+		logger.Info("Address added successfully", slog.String("userID", userID), slog.String("addressID", address.AddressId.Hex()))
+		c.JSON(http.StatusCreated, dto.NewAddressResponse(address))
+	}
+}
 
-			_, err := UserCollection.UpdateOne(ctx, filter, update)
+func UpdateAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("id")
 
-			if err != nil {
-				logger.Error("Failed to update user address", slog.Any("error", err))
-				c.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
-				return
-			}
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
 
-			logger.Info("Address added successfully", slog.String("userID", userID))
-			c.IndentedJSON(http.StatusCreated, "Address added successfully")
+		addressID, err := primitive.ObjectIDFromHex(c.Query("address_id"))
 
-		} else {
-			logger.Warn("Address limit exceeded for user", slog.String("userID", userID))
-			c.IndentedJSON(http.StatusBadRequest, "Address limit exceeded")
+		if err != nil {
+			apierror.InvalidID(c, "address")
+			return
 		}
+
+		updateAddress(c, userID, addressID)
 	}
 }
 
-func EditHomeAddress() gin.HandlerFunc {
+func DeleteAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.Query("id")
 
 		if userID == "" {
 			logger.Error("User ID is empty")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid ID"})
-			c.Abort()
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
-		userObjID, err := primitive.ObjectIDFromHex(userID)
+		addressID, err := primitive.ObjectIDFromHex(c.Query("address_id"))
 
 		if err != nil {
-			logger.Error("Invalid user ID format", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, "Internal Server Error")
-			return
-		}
-
-		var editAddress models.Address
-
-		if err := c.BindJSON(&editAddress); err != nil {
-			logger.Error("Failed to bind JSON to address struct", slog.Any("error", err))
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			apierror.InvalidID(c, "address")
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		filter := bson.D{{Key: "_id", Value: userObjID}}
-		update := bson.D{{Key: "$set", Value: bson.D{
-			{Key: "address.0.house_name", Value: editAddress.House},
-			{Key: "address.0.street_name", Value: editAddress.Street},
-			{Key: "address.0.city_name", Value: editAddress.City},
-			{Key: "address.0.pin_code", Value: editAddress.PinCode},
-		}}}
+		err = database.DeleteAddress(ctx, UserCollection, userID, addressID)
 
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		if errors.Is(err, database.ErrAddressNotFound) {
+			apierror.Abort(c, http.StatusNotFound, "address_not_found", err.Error())
+			return
+		}
 
 		if err != nil {
-			logger.Error("Failed to update home address", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, "Something went wrong")
+			logger.Error("Failed to delete address", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
 			return
 		}
 
-		logger.Info("Home address updated successfully", slog.String("userID", userID))
-		c.IndentedJSON(http.StatusOK, "Home address updated successfully")
+		logger.Info("Address deleted", slog.String("userID", userID), slog.String("addressID", addressID.Hex()))
+		c.JSON(http.StatusOK, "Address deleted")
+	}
+}
+
+// EditHomeAddress updates the first address, which legacy clients treat as
+// the home address.
+func EditHomeAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		editAddressAt(c, 0)
 	}
 }
 
+// EditWorkAddress updates the second address, which legacy clients treat as
+// the work address.
 func EditWorkAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		logger.Info("EditWorkAddress handler invoked")
-		userId := c.Query("id")
+		editAddressAt(c, 1)
+	}
+}
 
-		if userId == "" {
-			logger.Error("User ID not provided")
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Wrong id not provided"})
-			c.Abort()
-			return
-		}
+func editAddressAt(c *gin.Context, index int) {
+	userID := c.Query("id")
 
-		usertId, err := primitive.ObjectIDFromHex(userId)
+	if userID == "" {
+		logger.Error("User ID is empty")
+		apierror.BadRequest(c, "user id is required")
+		return
+	}
 
-		if err != nil {
-			logger.Error("Invalid User ID", slog.Any("error", err))
-			c.IndentedJSON(500, err)
-			return
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-		var editAddress models.Address
+	addresses, err := database.ListAddresses(ctx, UserCollection, userID)
 
-		if err := c.BindJSON(&editAddress); err != nil {
-			logger.Error("Failed to bind JSON", slog.Any("error", err))
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
-			return
-		}
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
+	if index >= len(addresses) {
+		apierror.Abort(c, http.StatusNotFound, "address_not_found", database.ErrAddressNotFound.Error())
+		return
+	}
 
-		filter := bson.D{primitive.E{Key: "_id", Value: usertId}}
-		update := bson.D{{Key: "$set", Value: bson.D{
-			primitive.E{Key: "address.1.house_name", Value: editAddress.House},
-			{Key: "address.1.street_name", Value: editAddress.Street},
-			{Key: "address.1.city_name", Value: editAddress.City},
-			{Key: "address.1.pin_code", Value: editAddress.PinCode},
-		}}}
+	updateAddress(c, userID, addresses[index].AddressId)
+}
 
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+func updateAddress(c *gin.Context, userID string, addressID primitive.ObjectID) {
+	var req dto.AddressRequest
 
-		if err != nil {
-			logger.Error("Failed to update work address", slog.Any("error", err))
-			c.IndentedJSON(500, "Something went wrong")
-			return
-		}
+	if !apierror.Bind(c, &req) {
+		return
+	}
+
+	if err := Validate.Struct(req); err != nil {
+		apierror.Validation(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addresses, err := database.ListAddresses(ctx, UserCollection, userID)
 
-		logger.Info("Successfully updated the work address", slog.String("userID", userId))
-		c.IndentedJSON(200, "Successfully updated the Work Address")
+	if err != nil {
+		apierror.Respond(c, err)
+		return
 	}
+
+	current := database.FindAddress(addresses, addressID)
+
+	if current == nil {
+		apierror.Abort(c, http.StatusNotFound, "address_not_found", database.ErrAddressNotFound.Error())
+		return
+	}
+
+	merged := req.Merge(*current)
+
+	if !normalizeAddress(c, &merged) {
+		return
+	}
+
+	address, err := database.UpdateAddress(ctx, UserCollection, userID, addressID, req.Update(merged))
+
+	if errors.Is(err, database.ErrAddressNotFound) {
+		apierror.Abort(c, http.StatusNotFound, "address_not_found", err.Error())
+		return
+	}
+
+	if err != nil {
+		logger.Error("Failed to update address", slog.String("userID", userID), slog.Any("error", err))
+		apierror.Respond(c, err)
+		return
+	}
+
+	logger.Info("Address updated", slog.String("userID", userID), slog.String("addressID", addressID.Hex()))
+	c.JSON(http.StatusOK, dto.NewAddressResponse(*address))
 }
 
-func DeleteAddress() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		logger.Info("DeleteAddress handler invoked")
+// normalizeAddress tidies the postal fields of a in place and checks them
+// against the rules of its country. It responds with one detail per invalid
+// field and returns false when the address is rejected.
+func normalizeAddress(c *gin.Context, a *models.Address) bool {
+	details := addressDetails("", a)
+
+	if len(details) == 0 {
+		return true
+	}
+
+	apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "address is invalid", details...)
+	return false
+}
+
+// addressDetails normalizes a and describes its invalid fields, naming them
+// with prefix.
+func addressDetails(prefix string, a *models.Address) []apierror.Detail {
+	fieldErrs := database.NormalizeAddress(a)
+	details := make([]apierror.Detail, 0, len(fieldErrs))
 
+	for _, fe := range fieldErrs {
+		details = append(details, apierror.Detail{Field: prefix + fe.Field, Rule: fe.Rule, Message: fe.Message})
+	}
+
+	return details
+}
+
+// DeleteAllAddresses empties the address book. Only the legacy routes
+// expose it.
+func DeleteAllAddresses() gin.HandlerFunc {
+	return func(c *gin.Context) {
 		userId := c.Query("id")
 
 		if userId == "" {
-			logger.Error("Invalid Search Index", slog.Any("error", errors.New("Invalid ID")))
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			c.Abort()
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
@@ -238,7 +282,7 @@ func DeleteAddress() gin.HandlerFunc {
 
 		if err != nil {
 			logger.Error("Invalid User ID", slog.Any("error", err))
-			c.IndentedJSON(500, "Internal Server Error")
+			apierror.InvalidID(c, "user")
 			return
 		}
 
@@ -251,7 +295,7 @@ func DeleteAddress() gin.HandlerFunc {
 
 		if err != nil {
 			logger.Error("Failed to delete address", slog.Any("error", err))
-			c.IndentedJSON(404, "Wrong")
+			apierror.Respond(c, err)
 			return
 		}
 
@@ -3,9 +3,12 @@ package controllers
 import (
 	"context"
 	"errors"
+	"github.com/maksimulitin/internal/apierror"
 	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
 	"github.com/maksimulitin/internal/models"
 	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/storage"
 	"log/slog"
 	"net/http"
 	"time"
@@ -19,12 +22,14 @@ import (
 type Application struct {
 	prodCollection *mongo.Collection
 	userCollection *mongo.Collection
+	storage        storage.Storage
 }
 
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
+func NewApplication(prodCollection, userCollection *mongo.Collection, imageStorage storage.Storage) *Application {
 	return &Application{
 		prodCollection: prodCollection,
 		userCollection: userCollection,
+		storage:        imageStorage,
 	}
 }
 
@@ -34,7 +39,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 
 		if productQueryID == "" {
 			logger.Error("Product ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			apierror.BadRequest(c, "product id is required")
 			return
 		}
 
@@ -42,7 +47,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 
 		if userQueryID == "" {
 			logger.Error("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
@@ -50,7 +55,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 
 		if err != nil {
 			logger.Error("Invalid product ID", slog.Any("error", err))
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierror.InvalidID(c, "product")
 			return
 		}
 
@@ -61,7 +66,7 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 
 		if err != nil {
 			logger.Error("Failed to add product to cart", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			apierror.Respond(c, err)
 			return
 		}
 
@@ -76,7 +81,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 
 		if productQueryID == "" {
 			logger.Error("Product ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			apierror.BadRequest(c, "product id is required")
 			return
 		}
 
@@ -84,7 +89,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 
 		if userQueryID == "" {
 			logger.Error("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
@@ -92,7 +97,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 
 		if err != nil {
 			logger.Error("Invalid product ID", slog.Any("error", err))
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierror.InvalidID(c, "product")
 			return
 		}
 
@@ -102,7 +107,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, ProductID, userQueryID)
 		if err != nil {
 			logger.Error("Failed to remove product from cart", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			apierror.Respond(c, err)
 			return
 		}
 
@@ -111,68 +116,177 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 	}
 }
 
+// requestAddressID reads the optional address_id query parameter naming the
+// address an order ships to.
+func requestAddressID(c *gin.Context) (primitive.ObjectID, error) {
+	return requestAddressParam(c, "address_id")
+}
+
+func requestAddressParam(c *gin.Context, name string) (primitive.ObjectID, error) {
+	raw := c.Query(name)
+
+	if raw == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(raw)
+
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid address id")
+	}
+
+	return id, nil
+}
+
+// checkoutDetails fills in the addresses of a checkout, saved ones named by
+// address_id and billing_address_id or ones entered in the body, which are
+// normalized and validated here, and the payment token from the body. It
+// responds to invalid input and returns false.
+func checkoutDetails(c *gin.Context, req *database.CheckoutRequest) bool {
+	var err error
+
+	if req.AddressID, err = requestAddressID(c); err != nil {
+		apierror.InvalidID(c, "address")
+		return false
+	}
+
+	if req.BillingAddressID, err = requestAddressParam(c, "billing_address_id"); err != nil {
+		apierror.InvalidID(c, "billing address")
+		return false
+	}
+
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+
+	var body dto.CheckoutRequest
+
+	if !apierror.Bind(c, &body) {
+		return false
+	}
+
+	req.PaymentToken = body.PaymentToken
+
+	var details []apierror.Detail
+
+	if body.ShippingAddress != nil {
+		shipping := body.ShippingAddress.Merge(models.Address{})
+		details = append(details, addressDetails("shipping_address.", &shipping)...)
+		req.ShippingAddress = &shipping
+	}
+
+	if body.BillingAddress != nil {
+		billing := body.BillingAddress.Merge(models.Address{})
+		details = append(details, addressDetails("billing_address.", &billing)...)
+		req.BillingAddress = &billing
+	}
+
+	if len(details) > 0 {
+		apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "address is invalid", details...)
+		return false
+	}
+
+	return true
+}
+
+// requestShippingMethodID reads the optional shipping_method query parameter.
+// Without it the cheapest available method is used.
+func requestShippingMethodID(c *gin.Context) (primitive.ObjectID, error) {
+	raw := c.Query("shipping_method")
+
+	if raw == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(raw)
+
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid shipping method id")
+	}
+
+	return id, nil
+}
+
 func GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userId := c.Query("id")
 
 		if userId == "" {
 			logger.Error("User ID is empty")
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid id"})
-			c.Abort()
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		usertId, err := primitive.ObjectIDFromHex(userId)
+
+		if err != nil {
+			logger.Error("Invalid user ID", slog.Any("error", err))
+			apierror.InvalidID(c, "user")
 			return
 		}
 
-		usertId, _ := primitive.ObjectIDFromHex(userId)
+		currency, err := requestCurrency(c)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
 		var filledCart models.User
-		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usertId}}).Decode(&filledCart)
+		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usertId}}).Decode(&filledCart)
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			apierror.Respond(c, database.ErrUserIDIsNotValid)
+			return
+		}
 
 		if err != nil {
 			logger.Error("Failed to find user cart", slog.Any("error", err))
-			c.IndentedJSON(500, "not id found")
+			apierror.Respond(c, err)
 			return
 		}
 
-		filterMatch := bson.D{{Key: "$match",
-			Value: bson.D{primitive.E{Key: "_id",
-				Value: usertId,
-			}}}}
-
-		unwind := bson.D{{Key: "$unwind",
-			Value: bson.D{primitive.E{Key: "path",
-				Value: "$usercart",
-			}}}}
+		addressID, err := requestAddressID(c)
 
-		grouping := bson.D{{Key: "$group",
-			Value: bson.D{primitive.E{Key: "_id",
-				Value: "$_id"},
-				{Key: "total",
-					Value: bson.D{primitive.E{Key: "$sum",
-						Value: "$usercart.price",
-					}}}}}}
+		if err != nil {
+			apierror.InvalidID(c, "address")
+			return
+		}
 
-		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filterMatch, unwind, grouping})
+		address, err := database.ShippingAddress(&filledCart, addressID)
 
 		if err != nil {
-			logger.Error("Failed to aggregate cart data", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
 		}
-		var listing []bson.M
 
-		if err = pointCursor.All(ctx, &listing); err != nil {
-			logger.Error("Failed to decode aggregated data", slog.Any("error", err))
-			c.AbortWithStatus(http.StatusInternalServerError)
+		methodID, err := requestShippingMethodID(c)
+
+		if err != nil {
+			apierror.InvalidID(c, "shipping method")
 			return
 		}
-		for _, json := range listing {
-			logger.Info("Cart data retrieved successfully", slog.String("userID", userId))
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledCart.UserCart)
+
+		in := database.QuoteInput{Items: filledCart.UserCart, UserID: userId, Address: address, ShippingMethodID: methodID}
+		if filledCart.CartCoupon != nil {
+			in.CouponCode = *filledCart.CartCoupon
+		}
+
+		quote, err := database.QuoteStoredCart(ctx, ProductCollection, in, checkoutPricing(currency))
+
+		if err != nil {
+			logger.Error("Failed to total cart", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
 		}
-		ctx.Done()
+
+		quote.UpdatedAt = filledCart.CartUpdatedAt
+
+		logger.Info("Cart data retrieved successfully", slog.String("userID", userId))
+		c.IndentedJSON(200, dto.NewCartQuoteResponse(quote))
 	}
 }
 
@@ -182,23 +296,50 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 
 		if userQueryID == "" {
 			logger.Error("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		currency, err := requestCurrency(c)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		methodID, err := requestShippingMethodID(c)
+
+		if err != nil {
+			apierror.InvalidID(c, "shipping method")
+			return
+		}
+
+		req := database.CheckoutRequest{
+			UserID:             userQueryID,
+			ShippingMethodID:   methodID,
+			PaymentMethod:      c.Query("payment_method"),
+			AcceptPriceChanges: c.Query("accept_price_changes"),
+		}
+
+		if !checkoutDetails(c, &req) {
 			return
 		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryID)
+		order, err := database.BuyItemFromCart(ctx, app.prodCollection, app.userCollection, req, checkoutPricing(currency), checkoutPayments())
 
 		if err != nil {
-			logger.Error("Failed to buy items from cart", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			logger.Warn("Failed to buy items from cart", slog.String("userID", userQueryID), slog.Any("error", err))
+			apierror.Respond(c, err)
 			return
 		}
 
+		recordCartRecovery(ctx, userQueryID, order)
+
 		logger.Info("Items successfully purchased from cart", slog.String("userID", userQueryID))
-		c.IndentedJSON(200, "Successfully placed the order")
+		c.IndentedJSON(200, dto.NewOrderResponse(*order))
 	}
 }
 
@@ -208,7 +349,7 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 
 		if UserQueryID == "" {
 			logger.Error("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+			apierror.BadRequest(c, "user id is required")
 			return
 		}
 
@@ -216,7 +357,7 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 
 		if ProductQueryID == "" {
 			logger.Error("Product ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
+			apierror.BadRequest(c, "product id is required")
 			return
 		}
 
@@ -224,22 +365,46 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 
 		if err != nil {
 			logger.Error("Invalid product ID", slog.Any("error", err))
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		currency, err := requestCurrency(c)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		methodID, err := requestShippingMethodID(c)
+
+		if err != nil {
+			apierror.InvalidID(c, "shipping method")
+			return
+		}
+
+		req := database.CheckoutRequest{
+			UserID:           UserQueryID,
+			ShippingMethodID: methodID,
+			PaymentMethod:    c.Query("payment_method"),
+		}
+
+		if !checkoutDetails(c, &req) {
 			return
 		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productID, UserQueryID)
+		order, err := database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productID, req, checkoutPricing(currency), checkoutPayments())
 
 		if err != nil {
-			logger.Error("Failed to place instant buy order", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			logger.Warn("Failed to place instant buy order", slog.String("userID", UserQueryID), slog.Any("error", err))
+			apierror.Respond(c, err)
 			return
 		}
 
 		logger.Info("Instant buy order placed successfully", slog.String("productID", ProductQueryID), slog.String("userID", UserQueryID))
-		c.IndentedJSON(200, "Successfully placed the order")
+		c.IndentedJSON(200, dto.NewOrderResponse(*order))
 	}
 }
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/notify"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultReminderLimit = 50
+	maxReminderLimit     = 500
+)
+
+var CartReminderCollection *mongo.Collection = database.CartReminderData(database.Client, "CartReminders")
+
+// Notifier delivers messages to customers, such as cart reminders.
+var Notifier notify.Notifier = notify.Log{}
+
+// LoadNotifier picks the notifier named by NOTIFIER. Only "log", which
+// writes messages to the service log, exists so far and is the default.
+func LoadNotifier() error {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("NOTIFIER")))
+	notifier, err := notify.New(name)
+
+	if err != nil {
+		logger.Error("Unknown notifier", slog.String("notifier", name))
+		return err
+	}
+
+	Notifier = notifier
+	logger.Info("Notifier configured", slog.String("notifier", notifier.Name()))
+	return nil
+}
+
+// RemindIdleCarts is the background job that reminds customers of carts
+// idle for longer than CART_REMINDER_AFTER.
+func RemindIdleCarts(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	_, err := database.RemindIdleCarts(ctx, database.CartReminders{
+		UserCollection:     UserCollection,
+		ReminderCollection: CartReminderCollection,
+		Notifier:           Notifier,
+		IdleAfter:          config.CartReminderAfter(),
+		Currency:           ExchangeRates.Base(),
+	})
+
+	return err
+}
+
+// recordCartRecovery credits an order to the reminder that brought its
+// customer back, if any. The order is already placed, so a failure is only
+// logged.
+func recordCartRecovery(ctx context.Context, userID string, order *models.Order) {
+	if err := database.RecordCartRecovery(ctx, CartReminderCollection, userID, order, config.CartRecoveryWindow()); err != nil {
+		logger.Warn("Failed to record cart recovery", slog.String("userID", userID), slog.Any("error", err))
+	}
+}
+
+func ListCartReminders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultReminderLimit
+
+		if raw := c.Query("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				apierror.BadRequest(c, "invalid limit")
+				return
+			}
+			limit = min(parsed, maxReminderLimit)
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		reminders, stats, err := database.ListCartReminders(ctx, CartReminderCollection, limit)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewCartRemindersResponse(reminders, stats))
+	}
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/catalog"
+	"github.com/maksimulitin/lib/logger"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxImportBytes = 64 << 20
+
+func ImportProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+		var (
+			body     io.Reader = c.Request.Body
+			filename string
+		)
+
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			file, header, err := c.Request.FormFile("file")
+
+			if err != nil {
+				logger.Error("Import file missing from form", slog.Any("error", err))
+				apierror.BadRequest(c, "multipart form must contain a file field")
+				return
+			}
+
+			defer file.Close()
+			body, filename = file, header.Filename
+		}
+
+		format, err := catalog.ParseFormat(c.Query("format"), filename)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		report, err := catalog.Import(ctx, ProductCollection, body, format, dryRun)
+
+		if err != nil {
+			logger.Error("Product import failed", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		if !dryRun && report.Valid > 0 {
+			go RefreshSuggestions()
+		}
+
+		status := http.StatusOK
+		if report.Valid == 0 && report.Invalid > 0 {
+			status = http.StatusUnprocessableEntity
+		}
+
+		logger.Info("Products imported", slog.Int("rows", report.Rows), slog.Bool("dryRun", dryRun))
+		c.JSON(status, report)
+	}
+}
+
+func ExportProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, err := catalog.ParseFormat(c.DefaultQuery("format", catalog.FormatCSV), "")
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		contentType := "text/csv; charset=utf-8"
+		if format == catalog.FormatJSONL {
+			contentType = "application/x-ndjson"
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=products.%s", format))
+		c.Status(http.StatusOK)
+
+		count, err := catalog.Export(ctx, ProductCollection, c.Writer, format)
+
+		// Headers are already sent, so a failure can only be logged; the
+		// truncated body tells the client the export is incomplete.
+		if err != nil {
+			logger.Error("Product export failed", slog.Int("exported", count), slog.Any("error", err))
+			return
+		}
+
+		logger.Info("Products exported", slog.Int("count", count), slog.String("format", format))
+	}
+}
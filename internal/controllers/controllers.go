@@ -2,25 +2,32 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
 	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
 	"github.com/maksimulitin/internal/models"
 	generate "github.com/maksimulitin/internal/tokens"
 	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 var (
-	UserCollection    *mongo.Collection = database.UserData(database.Client, "Users")
-	ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
-	Validate                            = validator.New()
+	UserCollection        *mongo.Collection = database.UserData(database.Client, "Users")
+	ProductCollection     *mongo.Collection = database.ProductData(database.Client, "Products")
+	IdempotencyCollection *mongo.Collection = database.IdempotencyData(database.Client, "IdempotencyKeys")
+	Validate                                = apierror.NewValidator()
 )
 
 func HashPassword(password string) string {
@@ -53,62 +60,61 @@ func SignUp() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
+		var req dto.SignUpRequest
 
-		if err := c.BindJSON(&user); err != nil {
-			logger.Error("Error binding JSON", slog.Any("error", err))
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if !apierror.Bind(c, &req) {
 			return
 		}
 
-		validationErr := Validate.Struct(user)
+		validationErr := Validate.Struct(req)
 
 		if validationErr != nil {
 			logger.Error("Validation failed", slog.Any("error", validationErr))
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
+			apierror.Validation(c, validationErr)
 			return
 		}
 
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": req.Email})
 
 		if err != nil {
-			logger.Error("Error counting documents", slog.Any("error", err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			apierror.Respond(c, err)
 			return
 		}
 
 		if count > 0 {
-			logger.Info("User already exists", slog.String("email", *user.Email))
-			c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
+			logger.Info("User already exists", slog.String("email", req.Email))
+			apierror.Abort(c, http.StatusConflict, "email_taken", "user already exists")
 			return
 		}
 
-		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": req.Phone})
 
 		if err != nil {
-			logger.Error("Error counting documents by phone", slog.Any("error", err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			apierror.Respond(c, err)
 			return
 		}
 
 		if count > 0 {
-			logger.Info("Phone is already in use", slog.String("phone", *user.Phone))
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone is already in use"})
+			logger.Info("Phone is already in use", slog.String("phone", req.Phone))
+			apierror.Abort(c, http.StatusConflict, "phone_taken", "phone is already in use")
 			return
 		}
 
+		user := req.User()
+
 		hashedPassword := HashPassword(*user.Password)
 		user.Password = &hashedPassword
 
-		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.FirstName, *user.LastName, user.UserID)
-		user.Token = &token
-		user.RefreshToken = &refreshToken
-
 		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
 		user.ID = primitive.NewObjectID()
 		user.UserID = user.ID.Hex()
+		user.Role = models.RoleCustomer
+
+		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.FirstName, *user.LastName, user.UserID, models.RoleCustomer)
+		user.Token = &token
+		user.RefreshToken = &refreshToken
 
 		user.UserCart = make([]models.ProductUser, 0)
 		user.AddressDetails = make([]models.Address, 0)
@@ -118,12 +124,14 @@ func SignUp() gin.HandlerFunc {
 
 		if insertErr != nil {
 			logger.Error("Error inserting user", slog.Any("error", insertErr))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "not created"})
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "user not created")
 			return
 		}
 
+		merged := mergeGuestCart(ctx, c, user.UserID)
+
 		logger.Info("User successfully signed up", slog.String("userID", user.UserID))
-		c.JSON(http.StatusCreated, "Successfully Signed Up!!")
+		c.JSON(http.StatusCreated, dto.NewAuthResponse(user, token, refreshToken, merged))
 	}
 }
 
@@ -133,37 +141,46 @@ func Login() gin.HandlerFunc {
 		defer cancel()
 
 		var (
-			user      models.User
+			req       dto.LoginRequest
 			foundUser models.User
 		)
 
-		if err := c.BindJSON(&user); err != nil {
-			logger.Error("Error binding JSON", slog.Any("error", err))
-			c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			apierror.Validation(c, err)
 			return
 		}
 
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		err := UserCollection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&foundUser)
 
 		if err != nil {
-			logger.Error("Error finding user", slog.Any("email", user.Email), slog.Any("error", err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
+			logger.Error("Error finding user", slog.String("email", req.Email), slog.Any("error", err))
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				apierror.Respond(c, err)
+				return
+			}
+			apierror.Abort(c, http.StatusUnauthorized, "invalid_credentials", "login or password incorrect")
 			return
 		}
 
-		PasswordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+		PasswordIsValid, msg := VerifyPassword(req.Password, *foundUser.Password)
 
 		if !PasswordIsValid {
-			logger.Error("Invalid password", slog.Any("email", user.Email))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			logger.Error("Invalid password", slog.String("email", req.Email))
+			apierror.Abort(c, http.StatusUnauthorized, "invalid_credentials", msg)
 			return
 		}
 
-		token, refreshToken, _ := generate.TokenGenerator(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, foundUser.UserID)
+		token, refreshToken, _ := generate.TokenGenerator(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, foundUser.UserID, foundUser.Role)
 		generate.UpdateAllTokens(token, refreshToken, foundUser.UserID)
 
+		merged := mergeGuestCart(ctx, c, foundUser.UserID)
+
 		logger.Info("User logged in successfully", slog.String("userID", foundUser.UserID))
-		c.JSON(http.StatusFound, foundUser)
+		c.JSON(http.StatusOK, dto.NewAuthResponse(foundUser, token, refreshToken, merged))
 	}
 }
 
@@ -172,23 +189,56 @@ func ProductViewerAdmin() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var products models.Product
+		var req dto.ProductRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			logger.Error("Product validation failed", slog.Any("error", err))
+			apierror.Validation(c, err)
+			return
+		}
+
+		products := req.Product()
+
+		if products.Price.Currency == "" {
+			products.Price.Currency = config.StoreCurrency()
+		}
 
-		if err := c.BindJSON(&products); err != nil {
-			logger.Error("Error binding JSON", slog.Any("error", err))
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if products.Price.IsNegative() || products.Price.Currency != config.StoreCurrency() {
+			logger.Error("Invalid product price", slog.String("price", products.Price.String()))
+			apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "invalid price", apierror.Detail{
+				Field: "price", Message: "must be a non-negative amount in " + config.StoreCurrency(),
+			})
 			return
 		}
 
+		for _, price := range products.PriceList {
+			if err := price.Validate(); err != nil || price.IsNegative() {
+				apierror.Abort(c, http.StatusBadRequest, apierror.CodeValidationFailed, "invalid price list", apierror.Detail{
+					Field: "price_list", Message: "entries must be non-negative amounts with a valid currency",
+				})
+				return
+			}
+		}
+
 		products.ProductID = primitive.NewObjectID()
+		products.CreatedAt = time.Now()
+		products.Images = make([]models.ProductImage, 0)
+		products.SearchTerms = database.ProductSearchTerms(products)
+		products.SearchGrams = database.ProductSearchGrams(products.SearchTerms)
 		_, anyErr := ProductCollection.InsertOne(ctx, products)
 
 		if anyErr != nil {
 			logger.Error("Error inserting product", slog.Any("error", anyErr))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Not Created"})
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "product not created")
 			return
 		}
 
+		go RefreshSuggestions()
+
 		logger.Info("Product successfully added", slog.String("productID", products.ProductID.Hex()))
 		c.JSON(http.StatusOK, "Successfully added our Product Admin!!")
 	}
@@ -196,82 +246,178 @@ func ProductViewerAdmin() gin.HandlerFunc {
 
 func SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var (
-			productList []models.Product
-			ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		)
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		cursor, err := ProductCollection.Find(ctx, bson.D{{}})
+		currency, err := requestCurrency(c)
 
 		if err != nil {
-			logger.Error("Error finding products", slog.Any("error", err))
-			c.IndentedJSON(http.StatusInternalServerError, "Something went wrong, please try again later")
+			apierror.Respond(c, err)
 			return
 		}
 
-		err = cursor.All(ctx, &productList)
+		query, err := parseProductQuery(c, currency)
 
 		if err != nil {
-			logger.Error("Error reading cursor", slog.Any("error", err))
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger.Warn("Invalid product listing parameters", slog.Any("error", err))
+			apierror.BadRequest(c, err.Error())
 			return
 		}
 
-		defer cursor.Close(ctx)
+		page, err := database.ListProducts(ctx, ProductCollection, query)
 
-		if err := cursor.Err(); err != nil {
-			logger.Error("Cursor error", slog.Any("error", err))
-			c.IndentedJSON(http.StatusBadRequest, "Invalid")
+		if err != nil {
+			logger.Warn("Error listing products", slog.Any("error", err))
+			apierror.Respond(c, err)
 			return
 		}
 
-		logger.Info("Products fetched successfully", slog.Int("count", len(productList)))
-		c.IndentedJSON(http.StatusOK, productList)
+		for i := range page.Items {
+			setDisplayPrice(&page.Items[i], currency)
+		}
+
+		logger.Info("Products fetched successfully", slog.Int("count", len(page.Items)), slog.Bool("hasMore", page.HasMore))
+		c.IndentedJSON(http.StatusOK, dto.NewProductPageResponse(*page))
+	}
+}
+
+// setDisplayPrice fills in the product price in the customer's currency. A
+// product that cannot be priced in it simply keeps only its base price.
+func setDisplayPrice(product *models.Product, currency string) {
+	if product.Price == nil {
+		return
+	}
+
+	price, err := ExchangeRates.PriceIn(*product.Price, product.PriceList, currency)
+
+	if err != nil {
+		logger.Warn("Cannot price product", slog.String("productID", product.ProductID.Hex()), slog.String("currency", currency), slog.Any("error", err))
+		return
+	}
+
+	product.DisplayPrice = &price
+}
+
+// parsePriceBound reads a price filter given in the customer's currency and
+// converts it into the base currency prices are stored and indexed in.
+func parsePriceBound(raw, currency string) (*int64, error) {
+	price, err := money.Parse(raw, currency)
+
+	if err != nil {
+		return nil, err
+	}
+
+	base, _, err := ExchangeRates.Convert(price, ExchangeRates.Base())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &base.Amount, nil
+}
+
+func parseProductQuery(c *gin.Context, currency string) (database.ProductQuery, error) {
+	query := database.ProductQuery{
+		Sort:      c.Query("sort"),
+		PageToken: c.Query("page_token"),
+		Category:  c.Query("category"),
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit %q", raw)
+		}
+		query.Limit = limit
+	}
+
+	if raw := c.Query("min_price"); raw != "" {
+		price, err := parsePriceBound(raw, currency)
+		if err != nil {
+			return query, fmt.Errorf("invalid min_price %q", raw)
+		}
+		query.MinPrice = price
+	}
+
+	if raw := c.Query("max_price"); raw != "" {
+		price, err := parsePriceBound(raw, currency)
+		if err != nil {
+			return query, fmt.Errorf("invalid max_price %q", raw)
+		}
+		query.MaxPrice = price
 	}
+
+	if raw := c.Query("min_rating"); raw != "" {
+		rating, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil || rating > 5 {
+			return query, fmt.Errorf("invalid min_rating %q", raw)
+		}
+		minRating := uint8(rating)
+		query.MinRating = &minRating
+	}
+
+	if raw := c.Query("in_stock"); raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			return query, fmt.Errorf("invalid in_stock %q", raw)
+		}
+		query.InStock = &inStock
+	}
+
+	return query, nil
 }
 
 func SearchProductByQuery() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var searchProducts []models.Product
 		queryParam := c.Query("name")
 
 		if queryParam == "" {
 			logger.Warn("Empty query parameter")
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			c.Abort()
+			apierror.BadRequest(c, "name is required")
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		searchDbQuery, err := ProductCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": queryParam}})
+		currency, err := requestCurrency(c)
 
 		if err != nil {
-			logger.Error("Error querying database", slog.Any("query", queryParam), slog.Any("error", err))
-			c.IndentedJSON(http.StatusNotFound, "Something went wrong in fetching the database query")
+			apierror.Respond(c, err)
 			return
 		}
 
-		err = searchDbQuery.All(ctx, &searchProducts)
+		limit := database.DefaultSearchLimit
+
+		if raw := c.Query("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				logger.Warn("Invalid search limit", slog.String("limit", raw))
+				apierror.BadRequest(c, fmt.Sprintf("invalid limit %q", raw))
+				return
+			}
+			limit = parsed
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		results, err := database.SearchProducts(ctx, ProductCollection, queryParam, limit)
 
 		if err != nil {
-			logger.Error("Error reading query results", slog.Any("error", err))
-			c.IndentedJSON(http.StatusBadRequest, "Invalid")
+			logger.Warn("Error searching products", slog.String("query", queryParam), slog.Any("error", err))
+			apierror.Respond(c, err)
 			return
 		}
 
-		defer searchDbQuery.Close(ctx)
+		for i := range results {
+			setDisplayPrice(&results[i].Product, currency)
+		}
 
-		if err := searchDbQuery.Err(); err != nil {
-			logger.Error("Query cursor error", slog.Any("error", err))
-			c.IndentedJSON(http.StatusBadRequest, "Invalid request")
-			return
+		response := dto.NewSearchResponse(queryParam, results)
+
+		if len(results) == 0 {
+			response.DidYouMean = SuggestionIndex.DidYouMean(queryParam)
 		}
 
-		logger.Info("Products fetched successfully by query", slog.String("query", queryParam), slog.Int("count", len(searchProducts)))
-		c.IndentedJSON(http.StatusOK, searchProducts)
+		logger.Info("Products fetched successfully by query", slog.String("query", queryParam), slog.Int("count", len(results)))
+		c.IndentedJSON(http.StatusOK, response)
 	}
 }
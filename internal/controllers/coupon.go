@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	CouponCollection     *mongo.Collection = database.CouponData(database.Client, "Coupons")
+	RedemptionCollection *mongo.Collection = database.CouponData(database.Client, "CouponRedemptions")
+)
+
+// checkoutPricing bundles what the database layer needs to price a cart in
+// currency.
+func checkoutPricing(currency string) database.Pricing {
+	return database.Pricing{
+		Rates:                ExchangeRates,
+		Currency:             currency,
+		Taxes:                TaxRules,
+		CouponCollection:     CouponCollection,
+		RedemptionCollection: RedemptionCollection,
+		UserCollection:       UserCollection,
+		PromotionCollection:  PromotionCollection,
+		ShippingCollection:   ShippingCollection,
+	}
+}
+
+func CreateCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.CouponRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		req.Code = database.NormalizeCouponCode(req.Code)
+
+		if err := Validate.Struct(req); err != nil {
+			logger.Error("Coupon validation failed", slog.Any("error", err))
+			apierror.Validation(c, err)
+			return
+		}
+
+		coupon := req.Coupon()
+
+		if err := database.ValidateCoupon(&coupon, config.StoreCurrency()); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := database.CreateCoupon(ctx, CouponCollection, &coupon)
+
+		if err != nil {
+			logger.Error("Failed to create coupon", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, dto.NewCouponResponse(coupon))
+	}
+}
+
+func ListCoupons() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		coupons, err := database.ListCoupons(ctx, CouponCollection)
+
+		if err != nil {
+			logger.Error("Failed to list coupons", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewCouponResponses(coupons))
+	}
+}
+
+func SetCouponActive() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code := c.Query("code")
+
+		if code == "" {
+			apierror.BadRequest(c, "coupon code is required")
+			return
+		}
+
+		var body dto.SetActiveRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		if err := Validate.Struct(body); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		coupon, err := database.SetCouponActive(ctx, CouponCollection, code, *body.Active)
+
+		if err != nil {
+			logger.Error("Failed to update coupon", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewCouponResponse(*coupon))
+	}
+}
+
+// ApplyCoupon checks a code against the current cart and, when it
+// qualifies, keeps it on the cart until checkout or removal.
+func ApplyCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			logger.Error("Invalid user ID", slog.Any("error", err))
+			apierror.InvalidID(c, "user")
+			return
+		}
+
+		var body dto.ApplyCouponRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		if err := Validate.Struct(body); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		currency, err := requestCurrency(c)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userID}}).Decode(&user)
+
+		if err != nil {
+			logger.Error("Failed to find user cart", slog.Any("error", err))
+			apierror.Respond(c, database.ErrUserIDIsNotValid)
+			return
+		}
+
+		if len(user.UserCart) == 0 {
+			apierror.Respond(c, database.ErrCartIsEmpty)
+			return
+		}
+
+		addressID, err := requestAddressID(c)
+
+		if err != nil {
+			apierror.InvalidID(c, "address")
+			return
+		}
+
+		address, err := database.ShippingAddress(&user, addressID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		methodID, err := requestShippingMethodID(c)
+
+		if err != nil {
+			apierror.InvalidID(c, "shipping method")
+			return
+		}
+
+		in := database.QuoteInput{Items: user.UserCart, UserID: userID.Hex(), CouponCode: body.Code, Address: address, ShippingMethodID: methodID}
+		quote, err := database.QuoteStoredCart(ctx, ProductCollection, in, checkoutPricing(currency))
+
+		if err != nil {
+			logger.Error("Failed to quote cart", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		if quote.Coupon.Error != "" {
+			logger.Info("Coupon rejected", slog.String("code", quote.Coupon.Code), slog.String("reason", quote.Coupon.Error))
+			apierror.Abort(c, http.StatusUnprocessableEntity, "coupon_rejected", quote.Coupon.Error)
+			return
+		}
+
+		if err := database.SetCartCoupon(ctx, UserCollection, userID.Hex(), quote.Coupon.Code); err != nil {
+			logger.Error("Failed to save cart coupon", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Coupon applied to cart", slog.String("code", quote.Coupon.Code), slog.String("userID", userID.Hex()))
+		c.JSON(http.StatusOK, dto.NewCartQuoteResponse(quote))
+	}
+}
+
+func RemoveCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("id")
+
+		if userID == "" {
+			apierror.InvalidID(c, "user")
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := database.SetCartCoupon(ctx, UserCollection, userID, "")
+
+		if err != nil {
+			logger.Error("Failed to remove cart coupon", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, "Coupon removed from cart")
+	}
+}
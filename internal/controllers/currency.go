@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultRatesFile = "./rates.json"
+
+var ExchangeRates = money.NewRateTable(config.StoreCurrency())
+
+func ratesFile() string {
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+		return path
+	}
+	return defaultRatesFile
+}
+
+// LoadExchangeRates reads the rates file. A missing file is not an error:
+// the store then only sells in its base currency.
+func LoadExchangeRates() error {
+	snapshot, err := money.LoadRates(ratesFile())
+
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("No exchange rates file, only the base currency is available", slog.String("path", ratesFile()))
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := ExchangeRates.Replace(snapshot); err != nil {
+		return err
+	}
+
+	logger.Info("Exchange rates loaded", slog.String("base", snapshot.Base), slog.Int("currencies", len(snapshot.Rates)))
+	return nil
+}
+
+// requestCurrency returns the currency the customer asked for with the
+// currency query parameter, defaulting to the store base currency.
+func requestCurrency(c *gin.Context) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(c.Query("currency")))
+
+	if currency == "" {
+		return ExchangeRates.Base(), nil
+	}
+
+	if !ExchangeRates.Supports(currency) {
+		return "", money.ErrUnknownCurrency
+	}
+
+	return currency, nil
+}
+
+func ListCurrencies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"base": ExchangeRates.Base(), "currencies": ExchangeRates.Currencies()})
+	}
+}
+
+func GetExchangeRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, dto.NewExchangeRatesResponse(ExchangeRates.Snapshot()))
+	}
+}
+
+func UpdateExchangeRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body dto.ExchangeRatesRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		snapshot := body.Snapshot()
+		snapshot.UpdatedAt = time.Now().UTC()
+
+		if err := ExchangeRates.Replace(snapshot); err != nil {
+			logger.Warn("Rejected exchange rates", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		current := ExchangeRates.Snapshot()
+
+		if err := money.SaveRates(ratesFile(), current); err != nil {
+			logger.Error("Failed to persist exchange rates", slog.String("path", ratesFile()), slog.Any("error", err))
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "rates applied but could not be saved")
+			return
+		}
+
+		logger.Info("Exchange rates updated", slog.Int("currencies", len(current.Rates)))
+		c.JSON(http.StatusOK, dto.NewExchangeRatesResponse(current))
+	}
+}
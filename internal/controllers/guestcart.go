@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	generate "github.com/maksimulitin/internal/tokens"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var GuestCartCollection *mongo.Collection = database.GuestCartData(database.Client, "GuestCarts")
+
+// LoadGuestCartSecret sets the key guest cart tokens are signed with from
+// GUEST_CART_SECRET. Starting without one would make every token forgeable,
+// so it is an error.
+func LoadGuestCartSecret() error {
+	return generate.SetGuestCartSecret(config.GuestCartSecret())
+}
+
+// guestCartParam reads the cart a guest request acts on from its signed
+// token. It responds to a missing or forged token and returns false.
+func guestCartParam(c *gin.Context) (primitive.ObjectID, bool) {
+	cartID, err := generate.ParseGuestCartToken(c.GetHeader(generate.GuestCartHeader))
+
+	if err != nil {
+		apierror.Respond(c, err)
+		return primitive.NilObjectID, false
+	}
+
+	return cartID, true
+}
+
+// respondGuestCart answers with the cart priced in the currency the request
+// asked for.
+func respondGuestCart(c *gin.Context, cart *models.GuestCart) {
+	currency, err := requestCurrency(c)
+
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	subtotal, err := database.PriceCart(cart.Items, ExchangeRates, currency)
+
+	if err != nil {
+		logger.Error("Failed to price guest cart", slog.Any("error", err))
+		apierror.Respond(c, database.ErrCantPriceCart)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.GuestCartResponse{Currency: currency, Items: dto.NewCartItemResponses(cart.Items), Subtotal: subtotal, ExpiresAt: cart.ExpiresAt})
+}
+
+func CreateGuestCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cart, err := database.CreateGuestCart(ctx, GuestCartCollection, config.GuestCartTTL())
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		cartToken, err := generate.GuestCartToken(cart.CartID)
+
+		if err != nil {
+			logger.Error("Failed to sign guest cart token", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, dto.GuestCartTokenResponse{CartToken: cartToken, ExpiresAt: cart.ExpiresAt})
+	}
+}
+
+func GetGuestCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cartID, ok := guestCartParam(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cart, err := database.GetGuestCart(ctx, GuestCartCollection, cartID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondGuestCart(c, cart)
+	}
+}
+
+func AddGuestCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cartID, ok := guestCartParam(c)
+
+		if !ok {
+			return
+		}
+
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cart, err := database.AddGuestCartItem(ctx, GuestCartCollection, ProductCollection, cartID, productID, config.GuestCartTTL())
+
+		if err != nil {
+			logger.Warn("Failed to add product to guest cart", slog.Any("cartID", cartID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondGuestCart(c, cart)
+	}
+}
+
+func RemoveGuestCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cartID, ok := guestCartParam(c)
+
+		if !ok {
+			return
+		}
+
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cart, err := database.RemoveGuestCartItem(ctx, GuestCartCollection, cartID, productID, config.GuestCartTTL())
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondGuestCart(c, cart)
+	}
+}
+
+// mergeGuestCart moves the guest cart named by the request's cart token into
+// the user's cart. Signing in still succeeds when the merge does not, so a
+// failure is only logged.
+func mergeGuestCart(ctx context.Context, c *gin.Context, userID string) int {
+	header := c.GetHeader(generate.GuestCartHeader)
+
+	if header == "" {
+		return 0
+	}
+
+	cartID, err := generate.ParseGuestCartToken(header)
+
+	if err != nil {
+		logger.Warn("Ignoring invalid cart token on sign in", slog.String("userID", userID))
+		return 0
+	}
+
+	added, err := database.MergeGuestCart(ctx, GuestCartCollection, UserCollection, cartID, userID, config.CartMergeStrategy())
+
+	if err != nil {
+		logger.Warn("Failed to merge guest cart", slog.String("userID", userID), slog.Any("error", err))
+		return 0
+	}
+
+	return added
+}
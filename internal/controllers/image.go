@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/imaging"
+	"github.com/maksimulitin/lib/logger"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultMaxImageBytes = 5 << 20
+
+// maxImageBytes is the per-file upload limit, configurable via IMAGE_MAX_BYTES.
+func maxImageBytes() int64 {
+	if raw := os.Getenv("IMAGE_MAX_BYTES"); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxImageBytes
+}
+
+func (app *Application) UploadProductImages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("pid"))
+
+		if err != nil {
+			logger.Error("Invalid product ID", slog.Any("error", err))
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		limit := maxImageBytes()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit*database.MaxImagesPerProduct+1<<20)
+
+		form, err := c.MultipartForm()
+
+		if err != nil {
+			logger.Error("Failed to parse multipart form", slog.Any("error", err))
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, "invalid_multipart", "invalid or too large multipart body")
+			return
+		}
+
+		files := form.File["images"]
+
+		if len(files) == 0 {
+			apierror.BadRequest(c, "no files in form field images")
+			return
+		}
+
+		if len(files) > database.MaxImagesPerProduct {
+			apierror.Respond(c, database.ErrTooManyImages)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		images := make([]models.ProductImage, 0, len(files))
+		var storedKeys []string
+
+		cleanup := func() {
+			for _, key := range storedKeys {
+				if err := app.storage.Delete(ctx, key); err != nil {
+					logger.Warn("Failed to delete stored image", slog.String("key", key), slog.Any("error", err))
+				}
+			}
+		}
+
+		for _, file := range files {
+			img, keys, status, err := app.storeProductImage(ctx, productID, file, limit)
+			storedKeys = append(storedKeys, keys...)
+
+			if err != nil {
+				cleanup()
+				logger.Warn("Rejected product image", slog.String("file", file.Filename), slog.Any("error", err))
+				code := "invalid_image"
+				if status >= http.StatusInternalServerError {
+					code = apierror.CodeInternal
+				}
+				apierror.Abort(c, status, code, "image "+file.Filename+" was not stored", apierror.Detail{Field: "images", Message: err.Error()})
+				return
+			}
+
+			images = append(images, *img)
+		}
+
+		err = database.AddProductImages(ctx, app.prodCollection, productID, images)
+
+		if err != nil {
+			cleanup()
+			logger.Error("Failed to save product images", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Product images uploaded", slog.String("productID", productID.Hex()), slog.Int("count", len(images)))
+		c.JSON(http.StatusCreated, dto.NewProductImageResponses(images))
+	}
+}
+
+// storeProductImage validates one uploaded file, stores it with its
+// thumbnails and returns the keys written so far even on failure.
+func (app *Application) storeProductImage(ctx context.Context, productID primitive.ObjectID, file *multipart.FileHeader, limit int64) (*models.ProductImage, []string, int, error) {
+	if file.Size > limit {
+		return nil, nil, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds %d bytes", limit)
+	}
+
+	src, err := file.Open()
+
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, limit+1))
+
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, nil, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds %d bytes", limit)
+	}
+
+	contentType, ext, err := imaging.Sniff(data)
+
+	if err != nil {
+		return nil, nil, http.StatusUnsupportedMediaType, err
+	}
+
+	decoded, err := imaging.Decode(data)
+
+	if err != nil {
+		return nil, nil, http.StatusUnprocessableEntity, err
+	}
+
+	imageID := primitive.NewObjectID()
+	prefix := fmt.Sprintf("products/%s/%s", productID.Hex(), imageID.Hex())
+	key := fmt.Sprintf("%s/original.%s", prefix, ext)
+	var keys []string
+
+	if err := app.storage.Put(ctx, key, bytes.NewReader(data), contentType); err != nil {
+		logger.Error("Failed to store image", slog.String("key", key), slog.Any("error", err))
+		return nil, keys, http.StatusInternalServerError, errors.New("cannot store image")
+	}
+
+	keys = append(keys, key)
+	bounds := decoded.Bounds()
+
+	img := &models.ProductImage{
+		ImageID:     imageID,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		URL:         app.storage.URL(key),
+		Key:         key,
+		Thumbnails:  make([]models.Thumbnail, 0, len(imaging.ThumbnailSizes)),
+	}
+
+	for _, size := range imaging.ThumbnailSizes {
+		thumb := imaging.Thumbnail(decoded, size.MaxDim)
+		thumbKey := fmt.Sprintf("%s/%s.%s", prefix, size.Name, ext)
+
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, thumb, contentType); err != nil {
+			logger.Error("Failed to encode thumbnail", slog.String("size", size.Name), slog.Any("error", err))
+			return nil, keys, http.StatusInternalServerError, errors.New("cannot generate thumbnail")
+		}
+
+		if err := app.storage.Put(ctx, thumbKey, &buf, contentType); err != nil {
+			logger.Error("Failed to store thumbnail", slog.String("key", thumbKey), slog.Any("error", err))
+			return nil, keys, http.StatusInternalServerError, errors.New("cannot store thumbnail")
+		}
+
+		keys = append(keys, thumbKey)
+		img.Thumbnails = append(img.Thumbnails, models.Thumbnail{
+			Name:   size.Name,
+			Width:  thumb.Bounds().Dx(),
+			Height: thumb.Bounds().Dy(),
+			URL:    app.storage.URL(thumbKey),
+			Key:    thumbKey,
+		})
+	}
+
+	return img, keys, http.StatusCreated, nil
+}
+
+func (app *Application) DeleteProductImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("pid"))
+
+		if err != nil {
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		imageID, err := primitive.ObjectIDFromHex(c.Query("image_id"))
+
+		if err != nil {
+			apierror.InvalidID(c, "image")
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		removed, err := database.RemoveProductImage(ctx, app.prodCollection, productID, imageID)
+
+		if err != nil && removed == nil {
+			logger.Error("Failed to delete product image", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		keys := []string{removed.Key}
+		for _, thumb := range removed.Thumbnails {
+			keys = append(keys, thumb.Key)
+		}
+
+		for _, key := range keys {
+			if err := app.storage.Delete(ctx, key); err != nil {
+				logger.Warn("Failed to delete stored image", slog.String("key", key), slog.Any("error", err))
+			}
+		}
+
+		logger.Info("Product image deleted", slog.String("productID", productID.Hex()), slog.String("imageID", imageID.Hex()))
+		c.JSON(http.StatusOK, "Successfully deleted the image")
+	}
+}
+
+func (app *Application) ReorderProductImages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("pid"))
+
+		if err != nil {
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		var body dto.ReorderImagesRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		images, err := database.ReorderProductImages(ctx, app.prodCollection, productID, body.ImageIDs)
+
+		if err != nil {
+			logger.Error("Failed to reorder product images", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Product images reordered", slog.String("productID", productID.Hex()))
+		c.JSON(http.StatusOK, dto.NewProductImageResponses(images))
+	}
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("userid")
+
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		orders, err := database.ListOrders(ctx, UserCollection, userID)
+
+		if err != nil {
+			logger.Error("Failed to list orders", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewOrderResponses(orders))
+	}
+}
+
+// GetOrder shows one order of the user, with the shipping and billing
+// addresses as they were when it was placed.
+func GetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("userid")
+
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		orderID, ok := orderIDParam(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.UserOrder(ctx, UserCollection, userID, orderID)
+
+		if err != nil {
+			logger.Warn("Failed to load order", slog.String("userID", userID), slog.String("orderID", orderID.Hex()), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewOrderResponse(*order))
+	}
+}
+
+// GetOrderAdmin shows any order together with the user who placed it.
+func GetOrderAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, ok := orderIDParam(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, userID, err := database.OrderByID(ctx, UserCollection, orderID)
+
+		if err != nil {
+			logger.Warn("Failed to load order", slog.String("orderID", orderID.Hex()), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.AdminOrderResponse{UserID: userID, OrderResponse: dto.NewOrderResponse(*order)})
+	}
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/payment"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultMockSecret       = "local-mock-secret"
+	defaultMockWebhookDelay = 5 * time.Second
+)
+
+var (
+	TransactionCollection *mongo.Collection = database.PaymentData(database.Client, "PaymentTransactions")
+	PaymentProviders                        = payment.NewRegistry(payment.COD{})
+)
+
+// LoadPaymentProviders registers the card gateway chosen with
+// PAYMENT_CARD_GATEWAY. Only "mock", the local test gateway, exists so far;
+// without it customers can only pay cash on delivery.
+func LoadPaymentProviders() error {
+	switch gateway := strings.ToLower(os.Getenv("PAYMENT_CARD_GATEWAY")); gateway {
+	case "":
+		logger.Warn("No card gateway configured, only cash on delivery is available")
+		return nil
+	case "mock":
+	default:
+		return errors.New("unknown card gateway " + gateway)
+	}
+
+	secret := os.Getenv("PAYMENT_MOCK_SECRET")
+	if secret == "" {
+		secret = defaultMockSecret
+	}
+
+	delay := defaultMockWebhookDelay
+	if raw := os.Getenv("PAYMENT_MOCK_WEBHOOK_DELAY"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		delay = parsed
+	}
+
+	webhookURL := os.Getenv("PAYMENT_WEBHOOK_URL")
+	if webhookURL == "" {
+		port := os.Getenv("SERVER_PORT")
+		if port == "" {
+			port = "8084"
+		}
+		webhookURL = "http://localhost:" + port + "/api/v1/payments/webhooks/" + payment.ProviderCard
+	}
+
+	PaymentProviders = payment.NewRegistry(payment.COD{}, payment.NewMockGateway(secret, webhookURL, delay))
+	logger.Info("Mock card gateway enabled", slog.String("webhookURL", webhookURL), slog.Duration("delay", delay))
+	return nil
+}
+
+func checkoutPayments() database.Payments {
+	return database.Payments{Providers: PaymentProviders, TransactionCollection: TransactionCollection}
+}
+
+func ListPaymentMethods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"payment_methods": PaymentProviders.Names()})
+	}
+}
+
+func orderIDParam(c *gin.Context) (primitive.ObjectID, bool) {
+	orderID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+	if err != nil {
+		logger.Error("Invalid order ID", slog.Any("error", err))
+		apierror.InvalidID(c, "order")
+		return primitive.NilObjectID, false
+	}
+
+	return orderID, true
+}
+
+func CapturePayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, ok := orderIDParam(c)
+
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		paid, err := database.CapturePayment(ctx, UserCollection, checkoutPayments(), orderID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewPaymentResponse(*paid))
+	}
+}
+
+func VoidPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, ok := orderIDParam(c)
+
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		paid, err := database.VoidPayment(ctx, UserCollection, checkoutPayments(), orderID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewPaymentResponse(*paid))
+	}
+}
+
+// RefundPayment refunds body.amount, or everything not refunded yet when the
+// body is empty.
+func RefundPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, ok := orderIDParam(c)
+
+		if !ok {
+			return
+		}
+
+		var body dto.RefundRequest
+
+		if c.Request.ContentLength != 0 {
+			if !apierror.Bind(c, &body) {
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		paid, err := database.RefundPayment(ctx, UserCollection, checkoutPayments(), orderID, body.Amount)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewPaymentResponse(*paid))
+	}
+}
+
+func ListPaymentTransactions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, ok := orderIDParam(c)
+
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		transactions, err := database.ListPaymentTransactions(ctx, TransactionCollection, orderID)
+
+		if err != nil {
+			logger.Error("Failed to list payment transactions", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewPaymentTransactionResponses(transactions))
+	}
+}
+
+// PaymentWebhook receives asynchronous payment outcomes. It is public, so
+// every delivery must carry the provider's signature.
+func PaymentWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		providerName := c.Param("provider")
+		provider, err := PaymentProviders.Get(providerName)
+
+		if err != nil {
+			apierror.Abort(c, http.StatusNotFound, apierror.CodeNotFound, err.Error())
+			return
+		}
+
+		verifier, ok := provider.(payment.WebhookVerifier)
+
+		if !ok {
+			apierror.Respond(c, payment.ErrWebhooksNotEnabled)
+			return
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+
+		if err != nil {
+			apierror.BadRequest(c, "cannot read request body")
+			return
+		}
+
+		event, err := verifier.VerifyWebhook(payload, c.GetHeader(payment.SignatureHeader))
+
+		if err != nil {
+			logger.Warn("Rejected payment webhook", slog.String("provider", providerName), slog.Any("error", err))
+			apierror.Respond(c, payment.ErrInvalidSignature)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.HandlePaymentEvent(ctx, UserCollection, checkoutPayments(), provider.Name(), event); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	}
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var PromotionCollection *mongo.Collection = database.PromotionData(database.Client, "Promotions")
+
+func CreatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.PromotionRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			logger.Error("Promotion validation failed", slog.Any("error", err))
+			apierror.Validation(c, err)
+			return
+		}
+
+		promotion := req.Promotion()
+
+		if err := database.ValidatePromotion(&promotion, config.StoreCurrency()); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.CreatePromotion(ctx, PromotionCollection, &promotion); err != nil {
+			logger.Error("Failed to create promotion", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, dto.NewPromotionResponse(promotion))
+	}
+}
+
+func ListPromotions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		promotions, err := database.ListPromotions(ctx, PromotionCollection)
+
+		if err != nil {
+			logger.Error("Failed to list promotions", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewPromotionResponses(promotions))
+	}
+}
+
+func SetPromotionActive() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		promotionID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			logger.Error("Invalid promotion ID", slog.Any("error", err))
+			apierror.InvalidID(c, "promotion")
+			return
+		}
+
+		var body dto.SetActiveRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		if err := Validate.Struct(body); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		promotion, err := database.SetPromotionActive(ctx, PromotionCollection, promotionID, *body.Active)
+
+		if err != nil {
+			logger.Error("Failed to update promotion", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewPromotionResponse(*promotion))
+	}
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultReviewLimit = 20
+	maxReviewLimit     = 100
+)
+
+var ReviewCollection *mongo.Collection = database.ReviewData(database.Client, "Reviews")
+
+func AddReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("pid"))
+
+		if err != nil {
+			logger.Error("Invalid product ID", slog.Any("error", err))
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		var req dto.ReviewRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			logger.Error("Review validation failed", slog.Any("error", err))
+			apierror.Validation(c, err)
+			return
+		}
+
+		review := req.Review(productID, c.GetString("uid"))
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err = database.AddReview(ctx, ReviewCollection, ProductCollection, UserCollection, &review)
+
+		if err != nil {
+			logger.Warn("Failed to add review", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Review submitted for moderation", slog.String("reviewID", review.ReviewID.Hex()))
+		c.JSON(http.StatusCreated, dto.NewReviewResponse(review))
+	}
+}
+
+func ProductReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("pid"))
+
+		if err != nil {
+			logger.Error("Invalid product ID", slog.Any("error", err))
+			apierror.InvalidID(c, "product")
+			return
+		}
+
+		listReviews(c, productID, models.ReviewApproved)
+	}
+}
+
+func PendingReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listReviews(c, primitive.NilObjectID, models.ReviewPending)
+	}
+}
+
+func listReviews(c *gin.Context, productID primitive.ObjectID, status string) {
+	limit := defaultReviewLimit
+
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			apierror.BadRequest(c, "invalid limit")
+			return
+		}
+		limit = min(parsed, maxReviewLimit)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reviews, err := database.ListReviews(ctx, ReviewCollection, productID, status, limit)
+
+	if err != nil {
+		logger.Error("Failed to list reviews", slog.Any("error", err))
+		apierror.Respond(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.NewReviewResponses(reviews))
+}
+
+func ModerateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			logger.Error("Invalid review ID", slog.Any("error", err))
+			apierror.InvalidID(c, "review")
+			return
+		}
+
+		var body dto.ModerateReviewRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		if err := Validate.Struct(body); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		review, err := database.ModerateReview(ctx, ReviewCollection, ProductCollection, reviewID, body.Status)
+
+		if err != nil {
+			logger.Error("Failed to moderate review", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Review moderated", slog.String("reviewID", review.ReviewID.Hex()), slog.String("status", review.Status))
+		c.JSON(http.StatusOK, dto.NewReviewResponse(*review))
+	}
+}
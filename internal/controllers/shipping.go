@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/config"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ShippingCollection *mongo.Collection = database.ShippingData(database.Client, "ShippingMethods")
+
+func CreateShippingMethod() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.ShippingMethodRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			logger.Error("Shipping method validation failed", slog.Any("error", err))
+			apierror.Validation(c, err)
+			return
+		}
+
+		method := req.ShippingMethod()
+
+		if err := database.ValidateShippingMethod(&method, config.StoreCurrency()); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.CreateShippingMethod(ctx, ShippingCollection, &method); err != nil {
+			logger.Error("Failed to create shipping method", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, dto.NewShippingMethodResponse(method))
+	}
+}
+
+func ListShippingMethods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		methods, err := database.ListShippingMethods(ctx, ShippingCollection, false)
+
+		if err != nil {
+			logger.Error("Failed to list shipping methods", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewShippingMethodResponses(methods))
+	}
+}
+
+func SetShippingMethodActive() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		methodID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			logger.Error("Invalid shipping method ID", slog.Any("error", err))
+			apierror.InvalidID(c, "shipping method")
+			return
+		}
+
+		var body dto.SetActiveRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		if err := Validate.Struct(body); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		method, err := database.SetShippingMethodActive(ctx, ShippingCollection, methodID, *body.Active)
+
+		if err != nil {
+			logger.Error("Failed to update shipping method", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewShippingMethodResponse(*method))
+	}
+}
+
+// ShippingQuote lists the shipping methods that can deliver the user's cart
+// to the chosen address, priced in the requested currency.
+func ShippingQuote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := primitive.ObjectIDFromHex(c.Query("id"))
+
+		if err != nil {
+			logger.Error("Invalid user ID", slog.Any("error", err))
+			apierror.InvalidID(c, "user")
+			return
+		}
+
+		currency, err := requestCurrency(c)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		addressID, err := requestAddressID(c)
+
+		if err != nil {
+			apierror.InvalidID(c, "address")
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userID}}).Decode(&user)
+
+		if err != nil {
+			logger.Error("Failed to find user cart", slog.Any("error", err))
+			apierror.Respond(c, database.ErrUserIDIsNotValid)
+			return
+		}
+
+		address, err := database.ShippingAddress(&user, addressID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		in := database.QuoteInput{Items: user.UserCart, UserID: userID.Hex(), Address: address}
+		if user.CartCoupon != nil {
+			in.CouponCode = *user.CartCoupon
+		}
+
+		quote, err := database.QuoteCart(ctx, in, checkoutPricing(currency))
+
+		if err != nil {
+			logger.Error("Failed to quote shipping", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewShippingQuoteResponse(quote))
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/search"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 20
+)
+
+var (
+	SuggestionIndex = search.NewPrefixIndex()
+	refreshMu       sync.Mutex
+)
+
+// RefreshSuggestions rebuilds the suggestion index from the product catalog.
+// Refreshes are serialized so an older snapshot never replaces a newer one.
+func RefreshSuggestions() {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	started := time.Now()
+	entries, err := database.SuggestionEntries(ctx, ProductCollection)
+
+	if err != nil {
+		logger.Error("Failed to rebuild suggestion index", slog.Any("error", err))
+		return
+	}
+
+	SuggestionIndex.Build(entries)
+	logger.Info("Suggestion index rebuilt", slog.Int("entries", len(entries)), slog.Duration("took", time.Since(started)))
+}
+
+func SearchSuggest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		prefix := c.Query("q")
+
+		if prefix == "" {
+			logger.Warn("Empty suggestion prefix")
+			apierror.BadRequest(c, "query parameter q is required")
+			return
+		}
+
+		limit := defaultSuggestLimit
+
+		if raw := c.Query("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				apierror.BadRequest(c, "invalid limit")
+				return
+			}
+			limit = min(parsed, maxSuggestLimit)
+		}
+
+		suggestions := SuggestionIndex.Lookup(prefix, limit)
+
+		logger.Info("Suggestions served", slog.String("prefix", prefix), slog.Int("count", len(suggestions)), slog.Duration("took", time.Since(started)))
+		c.JSON(http.StatusOK, gin.H{"query": prefix, "suggestions": suggestions})
+	}
+}
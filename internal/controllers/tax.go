@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/tax"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultTaxRulesFile = "./taxes.json"
+
+var TaxRules = tax.NewTable()
+
+func taxRulesFile() string {
+	if path := os.Getenv("TAX_RULES_FILE"); path != "" {
+		return path
+	}
+	return defaultTaxRulesFile
+}
+
+// LoadTaxRules reads the tax rules file. Without one no tax is charged.
+func LoadTaxRules() error {
+	rules, err := tax.LoadRules(taxRulesFile())
+
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("No tax rules file, orders are not taxed", slog.String("path", taxRulesFile()))
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := TaxRules.Replace(rules); err != nil {
+		return err
+	}
+
+	logger.Info("Tax rules loaded", slog.String("mode", rules.Mode), slog.Int("regions", len(rules.Regions)))
+	return nil
+}
+
+func GetTaxRules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, dto.NewTaxRulesResponse(TaxRules.Rules()))
+	}
+}
+
+func UpdateTaxRules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body dto.TaxRulesRequest
+
+		if !apierror.Bind(c, &body) {
+			return
+		}
+
+		rules := body.Rules()
+		rules.UpdatedAt = time.Now().UTC()
+
+		if err := TaxRules.Replace(rules); err != nil {
+			logger.Warn("Rejected tax rules", slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		current := TaxRules.Rules()
+
+		if err := tax.SaveRules(taxRulesFile(), current); err != nil {
+			logger.Error("Failed to persist tax rules", slog.String("path", taxRulesFile()), slog.Any("error", err))
+			apierror.Abort(c, http.StatusInternalServerError, apierror.CodeInternal, "tax rules applied but could not be saved")
+			return
+		}
+
+		logger.Info("Tax rules updated", slog.String("mode", current.Mode), slog.Int("regions", len(current.Regions)))
+		c.JSON(http.StatusOK, dto.NewTaxRulesResponse(current))
+	}
+}
@@ -0,0 +1,408 @@
+package controllers
+
+import (
+	"context"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SharedWishlistPath is where a shared wishlist is read with its token.
+const SharedWishlistPath = "/api/v1/wishlists/shared/"
+
+var WishlistCollection *mongo.Collection = database.WishlistData(database.Client, "Wishlists")
+
+// wishlistParams reads the user and the wishlist a request acts on. It
+// responds to missing or invalid IDs and returns false.
+func wishlistParams(c *gin.Context) (string, primitive.ObjectID, bool) {
+	userID := c.Query("userid")
+
+	if userID == "" {
+		logger.Error("User ID is empty")
+		apierror.BadRequest(c, "user id is required")
+		return "", primitive.NilObjectID, false
+	}
+
+	wishlistID, err := primitive.ObjectIDFromHex(c.Query("wishlist_id"))
+
+	if err != nil {
+		apierror.InvalidID(c, "wishlist")
+		return "", primitive.NilObjectID, false
+	}
+
+	return userID, wishlistID, true
+}
+
+func wishlistProductParam(c *gin.Context) (primitive.ObjectID, bool) {
+	productID, err := primitive.ObjectIDFromHex(c.Query("pid"))
+
+	if err != nil {
+		apierror.InvalidID(c, "product")
+		return primitive.NilObjectID, false
+	}
+
+	return productID, true
+}
+
+// respondWishlist answers with the wishlist and the current prices of its
+// products.
+func respondWishlist(ctx context.Context, c *gin.Context, status int, wishlist *models.Wishlist) {
+	products, err := database.LoadWishlistProducts(ctx, ProductCollection, *wishlist)
+
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+
+	c.JSON(status, dto.NewWishlistResponse(*wishlist, products))
+}
+
+func ListWishlists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("userid")
+
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlists, err := database.ListWishlists(ctx, WishlistCollection, userID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		products, err := database.LoadWishlistProducts(ctx, ProductCollection, wishlists...)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewWishlistResponses(wishlists, products))
+	}
+}
+
+func CreateWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("userid")
+
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		var req dto.WishlistRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.CreateWishlist(ctx, WishlistCollection, userID, req.Name)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, dto.NewWishlistResponse(*wishlist, nil))
+	}
+}
+
+func GetWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.GetWishlist(ctx, WishlistCollection, userID, wishlistID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondWishlist(ctx, c, http.StatusOK, wishlist)
+	}
+}
+
+func RenameWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		var req dto.WishlistRequest
+
+		if !apierror.Bind(c, &req) {
+			return
+		}
+
+		if err := Validate.Struct(req); err != nil {
+			apierror.Validation(c, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.RenameWishlist(ctx, WishlistCollection, userID, wishlistID, req.Name)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondWishlist(ctx, c, http.StatusOK, wishlist)
+	}
+}
+
+func DeleteWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.DeleteWishlist(ctx, WishlistCollection, userID, wishlistID); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, "Wishlist deleted")
+	}
+}
+
+func AddWishlistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		productID, ok := wishlistProductParam(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.AddWishlistItem(ctx, WishlistCollection, ProductCollection, userID, wishlistID, productID)
+
+		if err != nil {
+			logger.Warn("Failed to add product to wishlist", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondWishlist(ctx, c, http.StatusOK, wishlist)
+	}
+}
+
+func RemoveWishlistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		productID, ok := wishlistProductParam(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.RemoveWishlistItem(ctx, WishlistCollection, userID, wishlistID, productID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		respondWishlist(ctx, c, http.StatusOK, wishlist)
+	}
+}
+
+// MoveWishlistItemToCart puts a saved product in the cart and takes it off
+// the wishlist.
+func MoveWishlistItemToCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		productID, ok := wishlistProductParam(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.MoveWishlistItemToCart(ctx, WishlistCollection, ProductCollection, UserCollection, userID, wishlistID, productID)
+
+		if err != nil {
+			logger.Warn("Failed to move wishlist item to cart", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Wishlist item moved to cart", slog.String("userID", userID), slog.String("productID", productID.Hex()))
+		respondWishlist(ctx, c, http.StatusOK, wishlist)
+	}
+}
+
+// SaveForLater moves a cart item to a wishlist, the default one unless the
+// body names another.
+func SaveForLater() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("userid")
+
+		if userID == "" {
+			logger.Error("User ID is empty")
+			apierror.BadRequest(c, "user id is required")
+			return
+		}
+
+		productID, ok := wishlistProductParam(c)
+
+		if !ok {
+			return
+		}
+
+		var req dto.SaveForLaterRequest
+
+		if c.Request.ContentLength != 0 && !apierror.Bind(c, &req) {
+			return
+		}
+
+		wishlistID := primitive.NilObjectID
+		if req.WishlistID != nil {
+			wishlistID = *req.WishlistID
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.MoveCartItemToWishlist(ctx, WishlistCollection, ProductCollection, UserCollection, userID, wishlistID, productID)
+
+		if err != nil {
+			logger.Warn("Failed to save cart item for later", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		}
+
+		logger.Info("Cart item saved for later", slog.String("userID", userID), slog.String("productID", productID.Hex()))
+		respondWishlist(ctx, c, http.StatusOK, wishlist)
+	}
+}
+
+func ShareWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		token, err := database.ShareWishlist(ctx, WishlistCollection, userID, wishlistID)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.ShareWishlistResponse{ShareToken: token, SharePath: SharedWishlistPath + token})
+	}
+}
+
+func UnshareWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, wishlistID, ok := wishlistParams(c)
+
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.UnshareWishlist(ctx, WishlistCollection, userID, wishlistID); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, "Wishlist is no longer shared")
+	}
+}
+
+// SharedWishlist shows a shared wishlist to anyone with its token.
+func SharedWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		wishlist, err := database.SharedWishlist(ctx, WishlistCollection, c.Query("token"))
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		products, err := database.LoadWishlistProducts(ctx, ProductCollection, *wishlist)
+
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewSharedWishlistResponse(*wishlist, products))
+	}
+}
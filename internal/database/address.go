@@ -0,0 +1,385 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/address"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"strconv"
+)
+
+var (
+	ErrAddressLimit      = errors.New("address limit reached")
+	ErrCantUpdateAddress = errors.New("can't update addresses")
+	ErrAddressRequired   = errors.New("a shipping address is required")
+	ErrAddressIncomplete = errors.New("address is incomplete")
+)
+
+// AddressUpdate holds the changes to an address. A nil Address keeps the
+// stored content and nil flags are left as they are.
+type AddressUpdate struct {
+	Address         *models.Address
+	DefaultShipping *bool
+	DefaultBilling  *bool
+}
+
+func ListAddresses(ctx context.Context, userCollection *mongo.Collection, userID string) ([]models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return nil, ErrUserIDIsNotValid
+	}
+
+	var user models.User
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "address", Value: 1}})
+	err = userCollection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, opts).Decode(&user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserIDIsNotValid
+	}
+
+	if err != nil {
+		logger.Error("error loading addresses", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantUpdateAddress
+	}
+
+	if user.AddressDetails == nil {
+		return []models.Address{}, nil
+	}
+
+	return user.AddressDetails, nil
+}
+
+// AddAddress appends address to the user's address book unless it already
+// holds limit addresses. The first address becomes the default for shipping
+// and billing; a later one flagged as default takes the flag over.
+func AddAddress(ctx context.Context, userCollection *mongo.Collection, userID string, address *models.Address, limit int) error {
+	existing, err := ListAddresses(ctx, userCollection, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if len(existing) >= limit {
+		return ErrAddressLimit
+	}
+
+	id, _ := primitive.ObjectIDFromHex(userID)
+	address.AddressId = primitive.NewObjectID()
+
+	if len(existing) == 0 {
+		address.DefaultShipping, address.DefaultBilling = true, true
+	}
+
+	// The filter keeps the limit when two requests add at the same time.
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "address." + strconv.Itoa(limit-1), Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "address", Value: address}}}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error adding address", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantUpdateAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAddressLimit
+	}
+
+	if len(existing) > 0 && (address.DefaultShipping || address.DefaultBilling) {
+		return clearOtherDefaults(ctx, userCollection, id, address.AddressId, address.DefaultShipping, address.DefaultBilling)
+	}
+
+	return nil
+}
+
+// UpdateAddress applies update to one address and returns it as stored.
+// Making an address a default clears that flag on every other address.
+func UpdateAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID, update AddressUpdate) (*models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return nil, ErrUserIDIsNotValid
+	}
+
+	set := bson.D{}
+	target := "address.$[target]."
+
+	if a := update.Address; a != nil {
+		for _, field := range []struct {
+			name  string
+			value string
+		}{
+			{"label", a.Label},
+			{"recipient_name", a.RecipientName},
+			{"line1", a.Line1},
+			{"line2", a.Line2},
+			{"city", a.City},
+			{"region", a.Region},
+			{"postal_code", a.PostalCode},
+			{"country", a.Country},
+			{"phone", a.Phone},
+		} {
+			set = append(set, bson.E{Key: target + field.name, Value: field.value})
+		}
+	}
+
+	clearsOthers := false
+
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{
+		{"default_shipping", update.DefaultShipping},
+		{"default_billing", update.DefaultBilling},
+	} {
+		if flag.value == nil {
+			continue
+		}
+
+		set = append(set, bson.E{Key: target + flag.name, Value: *flag.value})
+
+		if *flag.value {
+			set = append(set, bson.E{Key: "address.$[other]." + flag.name, Value: false})
+			clearsOthers = true
+		}
+	}
+
+	if len(set) > 0 {
+		filters := []interface{}{bson.D{{Key: "target._id", Value: addressID}}}
+		if clearsOthers {
+			filters = append(filters, bson.D{{Key: "other._id", Value: bson.D{{Key: "$ne", Value: addressID}}}})
+		}
+
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "address._id", Value: addressID}}
+		opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters})
+
+		result, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}}, opts)
+
+		if err != nil {
+			logger.Error("error updating address", slog.String("userID", userID), slog.Any("error", err))
+			return nil, ErrCantUpdateAddress
+		}
+
+		if result.MatchedCount == 0 {
+			return nil, ErrAddressNotFound
+		}
+	}
+
+	addresses, err := ListAddresses(ctx, userCollection, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if address := FindAddress(addresses, addressID); address != nil {
+		return address, nil
+	}
+
+	return nil, ErrAddressNotFound
+}
+
+// DeleteAddress removes one address. When it was a default, the first
+// remaining address inherits the flag.
+func DeleteAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return ErrUserIDIsNotValid
+	}
+
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "address", Value: bson.D{{Key: "_id", Value: addressID}}}}}}
+	result, err := userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "address._id", Value: addressID}}, update)
+
+	if err != nil {
+		logger.Error("error deleting address", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantUpdateAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAddressNotFound
+	}
+
+	remaining, err := ListAddresses(ctx, userCollection, userID)
+
+	if err != nil || len(remaining) == 0 {
+		return err
+	}
+
+	set := bson.D{}
+	if DefaultAddress(remaining, false) == nil {
+		set = append(set, bson.E{Key: "address.0.default_shipping", Value: true})
+	}
+	if DefaultAddress(remaining, true) == nil {
+		set = append(set, bson.E{Key: "address.0.default_billing", Value: true})
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: set}}); err != nil {
+		logger.Error("error moving default address", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantUpdateAddress
+	}
+
+	return nil
+}
+
+// DefaultAddress returns the default billing address when billing is set,
+// otherwise the default shipping address, or nil when none is flagged.
+func DefaultAddress(addresses []models.Address, billing bool) *models.Address {
+	for i := range addresses {
+		if (billing && addresses[i].DefaultBilling) || (!billing && addresses[i].DefaultShipping) {
+			return &addresses[i]
+		}
+	}
+
+	return nil
+}
+
+// FindAddress returns the address with the given ID, or nil.
+func FindAddress(addresses []models.Address, addressID primitive.ObjectID) *models.Address {
+	for i := range addresses {
+		if addresses[i].AddressId == addressID {
+			return &addresses[i]
+		}
+	}
+
+	return nil
+}
+
+func clearOtherDefaults(ctx context.Context, userCollection *mongo.Collection, userID, keep primitive.ObjectID, shipping, billing bool) error {
+	set := bson.D{}
+	if shipping {
+		set = append(set, bson.E{Key: "address.$[other].default_shipping", Value: false})
+	}
+	if billing {
+		set = append(set, bson.E{Key: "address.$[other].default_billing", Value: false})
+	}
+
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+		bson.D{{Key: "other._id", Value: bson.D{{Key: "$ne", Value: keep}}}},
+	}})
+
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: userID}}, bson.D{{Key: "$set", Value: set}}, opts); err != nil {
+		logger.Error("error clearing default addresses", slog.String("userID", userID.Hex()), slog.Any("error", err))
+		return ErrCantUpdateAddress
+	}
+
+	return nil
+}
+
+// AddressFields returns the postal fields of a, for normalizing and
+// validating.
+func AddressFields(a models.Address) address.Fields {
+	return address.Fields{
+		RecipientName: a.RecipientName,
+		Line1:         a.Line1,
+		Line2:         a.Line2,
+		City:          a.City,
+		Region:        a.Region,
+		PostalCode:    a.PostalCode,
+		Country:       a.Country,
+		Phone:         a.Phone,
+	}
+}
+
+// NormalizeAddress tidies the postal fields of a in place and returns what
+// is still wrong with them.
+func NormalizeAddress(a *models.Address) []address.FieldError {
+	f := address.Normalize(AddressFields(*a))
+
+	a.RecipientName = f.RecipientName
+	a.Line1 = f.Line1
+	a.Line2 = f.Line2
+	a.City = f.City
+	a.Region = f.Region
+	a.PostalCode = f.PostalCode
+	a.Country = f.Country
+	a.Phone = f.Phone
+
+	return address.Validate(f)
+}
+
+// SnapshotAddress copies a into the form an order keeps, so later edits to
+// the address book never change the order. Inline addresses have no ID.
+func SnapshotAddress(a *models.Address) *models.OrderAddress {
+	snapshot := &models.OrderAddress{
+		RecipientName: a.RecipientName,
+		Line1:         a.Line1,
+		Line2:         a.Line2,
+		City:          a.City,
+		Region:        a.Region,
+		PostalCode:    a.PostalCode,
+		Country:       a.Country,
+		Phone:         a.Phone,
+	}
+
+	if !a.AddressId.IsZero() {
+		id := a.AddressId
+		snapshot.AddressID = &id
+	}
+
+	return snapshot
+}
+
+// checkoutAddresses picks the addresses an order ships to and is billed to.
+// Shipping needs an inline address or a saved one named by ID; billing
+// falls back to the shipping address. Saved addresses that no longer pass
+// validation, such as migrated ones without a country, are refused.
+func checkoutAddresses(user *models.User, req CheckoutRequest) (shipping, billing *models.Address, err error) {
+	pick := func(inline *models.Address, id primitive.ObjectID) (*models.Address, error) {
+		if inline != nil {
+			return inline, nil
+		}
+
+		if id.IsZero() {
+			return nil, nil
+		}
+
+		saved := FindAddress(user.AddressDetails, id)
+
+		if saved == nil {
+			return nil, ErrAddressNotFound
+		}
+
+		checked := *saved
+		if len(NormalizeAddress(&checked)) > 0 {
+			return nil, ErrAddressIncomplete
+		}
+
+		return &checked, nil
+	}
+
+	if shipping, err = pick(req.ShippingAddress, req.AddressID); err != nil {
+		return nil, nil, err
+	}
+
+	if shipping == nil {
+		return nil, nil, ErrAddressRequired
+	}
+
+	if billing, err = pick(req.BillingAddress, req.BillingAddressID); err != nil {
+		return nil, nil, err
+	}
+
+	if billing == nil {
+		billing = shipping
+	}
+
+	return shipping, billing, nil
+}
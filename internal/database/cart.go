@@ -2,9 +2,13 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"github.com/maksimulitin/internal/models"
 	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -19,8 +23,32 @@ var (
 	ErrCantUpdateUser     = errors.New("cannot add product to cart")
 	ErrCantRemoveItem     = errors.New("cannot remove item from cart")
 	ErrCantBuyCartItem    = errors.New("cannot update the purchase")
+	ErrCartIsEmpty        = errors.New("cart is empty")
+	ErrCantPriceCart      = errors.New("cannot price cart")
+	ErrCartPricesChanged  = errors.New("prices in the cart have changed, review the cart and check out with its price_changes_token as accept_price_changes")
+	ErrCartItemsGone      = errors.New("the cart has products that are no longer available, remove them to check out")
 )
 
+// Kinds of CartIssue.
+const (
+	CartPriceChanged    = "price_changed"
+	CartItemUnavailable = "unavailable"
+)
+
+// CartIssue flags a product whose cart lines no longer match the store:
+// either its price changed since it was added or it was removed from the
+// catalogue. Prices are in the store currency.
+type CartIssue struct {
+	ProductID   primitive.ObjectID `json:"product_id"`
+	ProductName *string            `json:"product_name"`
+	Issue       string             `json:"issue"`
+	Quantity    int                `json:"quantity"`
+	CartPrice   money.Money        `json:"cart_price"`
+	Price       *money.Money       `json:"price,omitempty"`
+
+	priceList []money.Money
+}
+
 func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
 	logger.Info("adding product to cart", slog.Any("productID", productID), slog.String("userID", userID))
 
@@ -39,6 +67,11 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 		return ErrCantDecodeProducts
 	}
 
+	if len(productCart) == 0 {
+		logger.Warn("product to add to cart not found", slog.Any("productID", productID))
+		return ErrCantFindProduct
+	}
+
 	id, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
@@ -47,15 +80,23 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 	}
 
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{{Key: "$each", Value: productCart}}}}}}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "user_cart", Value: bson.D{{Key: "$each", Value: productCart}}}}},
+		{Key: "$set", Value: bson.D{cartTouched()}},
+	}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	result, err := userCollection.UpdateOne(ctx, filter, update)
 
 	if err != nil {
 		logger.Error("error updating user cart", slog.Any("productID", productID), slog.String("userID", userID))
 		return ErrCantUpdateUser
 	}
 
+	if result.MatchedCount == 0 {
+		logger.Warn("cart of unknown user", slog.String("userID", userID))
+		return ErrUserIDIsNotValid
+	}
+
 	logger.Info("product added to cart successfully", slog.Any("productID", productID), slog.String("userID", userID))
 	return nil
 }
@@ -71,7 +112,10 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 	}
 
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}}
+	update := bson.D{
+		{Key: "$pull", Value: bson.M{"user_cart": bson.M{"_id": productID}}},
+		{Key: "$set", Value: bson.D{cartTouched()}},
+	}
 	_, err = userCollection.UpdateMany(ctx, filter, update)
 
 	if err != nil {
@@ -83,132 +127,390 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 	return nil
 }
 
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error {
-	logger.Info("buying items from cart", slog.String("userID", userID))
-	id, err := primitive.ObjectIDFromHex(userID)
+// cartTouched marks a cart as changed now, which restarts the time it has
+// been idle for reminders.
+func cartTouched() bson.E {
+	return bson.E{Key: "cart_updated_at", Value: time.Now()}
+}
+
+// CartTotal sums the stored base currency prices of cart items with
+// overflow checks.
+func CartTotal(items []models.ProductUser, currency string) (money.Money, error) {
+	prices := make([]money.Money, 0, len(items))
+
+	for _, item := range items {
+		prices = append(prices, item.Price)
+	}
+
+	return money.Sum(currency, prices...)
+}
+
+// PriceCart sets each item's display price in currency and returns their
+// sum. Items are converted one by one so the total always equals what the
+// customer sees line by line.
+func PriceCart(items []models.ProductUser, rates *money.RateTable, currency string) (money.Money, error) {
+	prices := make([]money.Money, 0, len(items))
+
+	for i := range items {
+		price, err := rates.PriceIn(items[i].Price, items[i].PriceList, currency)
+
+		if err != nil {
+			return money.Money{}, err
+		}
+
+		items[i].DisplayPrice = &price
+		prices = append(prices, price)
+	}
+
+	return money.Sum(currency, prices...)
+}
+
+// RevalidateCart checks cart lines against the current products. It returns
+// the lines that can still be bought, refreshed to the current product, and
+// an issue for every product whose price changed or that is gone.
+func RevalidateCart(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) ([]models.ProductUser, []CartIssue, error) {
+	ids := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+
+	cursor, err := prodCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
 
 	if err != nil {
-		logger.Error("invalid user ID", slog.String("userID", userID))
-		return ErrUserIDIsNotValid
+		logger.Error("error loading cart products", slog.Any("error", err))
+		return nil, nil, ErrCantDecodeProducts
+	}
+
+	var products []models.ProductUser
+
+	if err := cursor.All(ctx, &products); err != nil {
+		logger.Error("error decoding cart products", slog.Any("error", err))
+		return nil, nil, ErrCantDecodeProducts
 	}
 
-	var (
-		getCartItems models.User
-		orderCart    models.Order
-	)
+	current := make(map[primitive.ObjectID]models.ProductUser, len(products))
+	for _, product := range products {
+		current[product.ProductID] = product
+	}
+
+	lines := make([]models.ProductUser, 0, len(items))
+	issues := []CartIssue{}
+	flagged := make(map[primitive.ObjectID]int)
+
+	for _, item := range items {
+		product, ok := current[item.ProductID]
 
-	orderCart.OrderID = primitive.NewObjectID()
-	orderCart.OrderedAt = time.Now()
-	orderCart.OrderCart = make([]models.ProductUser, 0)
-	orderCart.PaymentMethod.COD = true
+		if ok {
+			lines = append(lines, product)
+		}
 
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
+		if ok && samePrice(item, product) {
+			continue
+		}
 
-	currentResults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
+		if i, seen := flagged[item.ProductID]; seen {
+			issues[i].Quantity++
+			continue
+		}
+
+		issue := CartIssue{ProductID: item.ProductID, ProductName: item.ProductName, Issue: CartItemUnavailable, Quantity: 1, CartPrice: item.Price}
+
+		if ok {
+			price := product.Price
+			issue.Issue, issue.Price, issue.priceList = CartPriceChanged, &price, product.PriceList
+		}
+
+		flagged[item.ProductID] = len(issues)
+		issues = append(issues, issue)
+	}
+
+	return lines, issues, nil
+}
+
+// QuoteStoredCart prices a saved cart at the current product prices and
+// lists on the quote the lines that changed since they were added.
+func QuoteStoredCart(ctx context.Context, prodCollection *mongo.Collection, in QuoteInput, pricing Pricing) (*CartQuote, error) {
+	lines, issues, err := RevalidateCart(ctx, prodCollection, in.Items)
 
 	if err != nil {
-		logger.Error("error aggregating cart items", slog.String("userID", userID))
-		return ErrCantBuyCartItem
+		return nil, err
 	}
 
-	var getUserCart []bson.M
+	in.Items = lines
+	quote, err := QuoteCart(ctx, in, pricing)
 
-	if err = currentResults.All(ctx, &getUserCart); err != nil {
-		logger.Error("error decoding aggregated results", slog.String("userID", userID))
-		return ErrCantBuyCartItem
+	if err != nil {
+		return nil, err
 	}
 
-	var totalPrice int32
+	quote.Issues = issues
+	quote.PriceChangesToken = PriceChangesToken(issues)
+	return quote, nil
+}
 
-	for _, userItem := range getUserCart {
-		price := userItem["total"]
-		totalPrice = price.(int32)
+// PriceChangesToken fingerprints the price changes among issues: which
+// products changed, how many units, and their old and new prices. Checkout
+// only accepts changed prices with the token of the same changes, so a price
+// that moves again after the customer reviewed the cart needs a new review.
+// It is empty when no price changed.
+func PriceChangesToken(issues []CartIssue) string {
+	h := sha256.New()
+	changed := false
+
+	for _, issue := range issues {
+		if issue.Issue != CartPriceChanged {
+			continue
+		}
+
+		changed = true
+		fmt.Fprintf(h, "%s|%d|%s|%s", issue.ProductID.Hex(), issue.Quantity, issue.CartPrice, issue.Price)
+		for _, price := range issue.priceList {
+			fmt.Fprintf(h, "|%s", price)
+		}
+		h.Write([]byte{'\n'})
 	}
-	orderCart.Price = int(totalPrice)
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderCart}}}}
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	if !changed {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18])
+}
+
+func samePrice(a, b models.ProductUser) bool {
+	if a.Price != b.Price || len(a.PriceList) != len(b.PriceList) {
+		return false
+	}
+
+	for i := range a.PriceList {
+		if a.PriceList[i] != b.PriceList[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BuyItemFromCart places an order for the whole cart at current prices,
+// redeeming the coupon applied to it. A coupon that stopped qualifying fails
+// the checkout with the reason instead of silently dropping the discount, and
+// so do products that are gone or, unless the request accepts them, changed
+// prices.
+func BuyItemFromCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, req CheckoutRequest, pricing Pricing, payments Payments) (*models.Order, error) {
+	userID := req.UserID
+	logger.Info("buying items from cart", slog.String("userID", userID))
+	id, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
-		logger.Error("error updating user orders", slog.String("userID", userID))
-		return ErrCantBuyCartItem
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return nil, ErrUserIDIsNotValid
 	}
 
+	var getCartItems models.User
+
 	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getCartItems)
 
 	if err != nil {
 		logger.Error("error fetching user cart items", slog.String("userID", userID))
-		return ErrCantBuyCartItem
+		return nil, ErrCantBuyCartItem
+	}
+
+	if len(getCartItems.UserCart) == 0 {
+		logger.Warn("checkout of empty cart", slog.String("userID", userID))
+		return nil, ErrCartIsEmpty
+	}
+
+	address, billing, err := checkoutAddresses(&getCartItems, req)
+
+	if err != nil {
+		return nil, err
 	}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getCartItems.UserCart}}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	lines, issues, err := RevalidateCart(ctx, prodCollection, getCartItems.UserCart)
 
 	if err != nil {
-		logger.Error("error updating order list", slog.String("userID", userID))
-		return ErrCantBuyCartItem
+		return nil, err
+	}
+
+	if err := checkCartIssues(issues, req.AcceptPriceChanges); err != nil {
+		logger.Warn("checkout of a stale cart", slog.String("userID", userID), slog.Int("issues", len(issues)), slog.Any("error", err))
+		return nil, err
+	}
+
+	orderCart := models.Order{
+		OrderID:         primitive.NewObjectID(),
+		OrderedAt:       time.Now(),
+		Status:          models.OrderPlaced,
+		OrderCart:       lines,
+		ShippingAddress: SnapshotAddress(address),
+		BillingAddress:  SnapshotAddress(billing),
+	}
+
+	in := QuoteInput{Items: orderCart.OrderCart, UserID: userID, Address: address, ShippingMethodID: req.ShippingMethodID}
+	if getCartItems.CartCoupon != nil {
+		in.CouponCode = *getCartItems.CartCoupon
+	}
+
+	quote, err := QuoteCart(ctx, in, pricing)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if quote.Coupon != nil && quote.Coupon.err != nil {
+		logger.Warn("checkout with rejected coupon", slog.String("userID", userID), slog.String("code", quote.Coupon.Code), slog.Any("error", quote.Coupon.err))
+		return nil, quote.Coupon.err
+	}
+
+	if quote.shippingErr != nil {
+		logger.Warn("checkout without a shipping method", slog.String("userID", userID), slog.Any("error", quote.shippingErr))
+		return nil, quote.shippingErr
 	}
 
-	userCartEmpty := make([]models.ProductUser, 0)
-	filtered := bson.D{primitive.E{Key: "_id", Value: id}}
-	updated := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: userCartEmpty}}}}
-	_, err = userCollection.UpdateOne(ctx, filtered, updated)
+	if err := chargeOrder(&orderCart, quote, pricing.Rates); err != nil {
+		logger.Error("error pricing order", slog.String("userID", userID), slog.String("currency", pricing.Currency), slog.Any("error", err))
+		return nil, ErrCantBuyCartItem
+	}
+
+	release := func() {}
+
+	if quote.Coupon != nil {
+		if release, err = redeemCoupon(ctx, pricing, quote.Coupon, userID, orderCart.OrderID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := authorizeOrder(ctx, payments, req, &orderCart); err != nil {
+		release()
+		return nil, err
+	}
+
+	// The cart filter makes the order and the emptied cart a single atomic
+	// step: a concurrent checkout of the same cart matches nothing.
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "user_cart", Value: getCartItems.UserCart},
+	}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderCart}}},
+		{Key: "$set", Value: bson.D{primitive.E{Key: "user_cart", Value: make([]models.ProductUser, 0)}, cartTouched()}},
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "cart_coupon", Value: ""}}},
+	}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
 
 	if err != nil {
-		logger.Error("error clearing user cart", slog.String("userID", userID))
-		return ErrCantBuyCartItem
+		release()
+		cancelOrderPayment(ctx, payments, &orderCart, userID)
+		logger.Error("error updating user orders", slog.String("userID", userID))
+		return nil, ErrCantBuyCartItem
+	}
+
+	if result.ModifiedCount == 0 {
+		release()
+		cancelOrderPayment(ctx, payments, &orderCart, userID)
+		logger.Warn("cart changed during checkout", slog.String("userID", userID))
+		return nil, ErrCantBuyCartItem
+	}
+
+	logger.Info("cart items purchased successfully", slog.String("userID", userID), slog.String("total", orderCart.Price.String()))
+	return &orderCart, nil
+}
+
+// checkCartIssues decides whether a cart with issues may be checked out.
+// Removed products always block it; changed prices until accepted with the
+// token of exactly these changes.
+func checkCartIssues(issues []CartIssue, acceptPriceChanges string) error {
+	priceChanged := false
+
+	for _, issue := range issues {
+		if issue.Issue == CartItemUnavailable {
+			return ErrCartItemsGone
+		}
+
+		priceChanged = true
+	}
+
+	if priceChanged && acceptPriceChanges != PriceChangesToken(issues) {
+		return ErrCartPricesChanged
 	}
 
-	logger.Info("cart items purchased successfully", slog.String("userID", userID))
 	return nil
 }
 
-func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, req CheckoutRequest, pricing Pricing, payments Payments) (*models.Order, error) {
+	userID := req.UserID
 	logger.Info("instant buying product", slog.Any("productID", productID), slog.String("userID", userID))
 	id, err := primitive.ObjectIDFromHex(userID)
 
 	if err != nil {
 		logger.Error("Invalid user ID", slog.String("userID", userID))
-		return ErrUserIDIsNotValid
+		return nil, ErrUserIDIsNotValid
 	}
 
-	var (
-		productDetails models.ProductUser
-		ordersDetail   models.Order
-	)
-	ordersDetail.OrderID = primitive.NewObjectID()
-	ordersDetail.OrderedAt = time.Now()
-	ordersDetail.OrderCart = make([]models.ProductUser, 0)
-	ordersDetail.PaymentMethod.COD = true
+	var buyer models.User
+
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&buyer)
+
+	if err != nil {
+		logger.Error("error fetching buyer", slog.String("userID", userID))
+		return nil, ErrCantBuyCartItem
+	}
+
+	address, billing, err := checkoutAddresses(&buyer, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var productDetails models.ProductUser
 
 	err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&productDetails)
 	if err != nil {
 		logger.Error("error fetching product details", slog.Any("productID", productID))
-		return ErrCantFindProduct
+		return nil, ErrCantFindProduct
 	}
 
-	ordersDetail.Price = productDetails.Price
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordersDetail}}}}
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	ordersDetail := models.Order{
+		OrderID:         primitive.NewObjectID(),
+		OrderedAt:       time.Now(),
+		Status:          models.OrderPlaced,
+		OrderCart:       []models.ProductUser{productDetails},
+		ShippingAddress: SnapshotAddress(address),
+		BillingAddress:  SnapshotAddress(billing),
+	}
+
+	in := QuoteInput{Items: ordersDetail.OrderCart, UserID: userID, Address: address, ShippingMethodID: req.ShippingMethodID}
+	quote, err := QuoteCart(ctx, in, pricing)
 
 	if err != nil {
-		logger.Error("error updating user orders", slog.Any("productID", productID), slog.String("userID", userID))
-		return ErrCantUpdateUser
+		return nil, err
 	}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": productDetails}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	if quote.shippingErr != nil {
+		logger.Warn("instant buy without a shipping method", slog.String("userID", userID), slog.Any("error", quote.shippingErr))
+		return nil, quote.shippingErr
+	}
+
+	if err := chargeOrder(&ordersDetail, quote, pricing.Rates); err != nil {
+		logger.Error("error pricing order", slog.Any("productID", productID), slog.String("currency", pricing.Currency), slog.Any("error", err))
+		return nil, ErrCantBuyCartItem
+	}
+
+	if err := authorizeOrder(ctx, payments, req, &ordersDetail); err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordersDetail}}}}
+	_, err = userCollection.UpdateOne(ctx, filter, update)
 
 	if err != nil {
-		logger.Error("error updating order list", slog.Any("productID", productID), slog.String("userID", userID))
-		return ErrCantUpdateUser
+		cancelOrderPayment(ctx, payments, &ordersDetail, userID)
+		logger.Error("error updating user orders", slog.Any("productID", productID), slog.String("userID", userID))
+		return nil, ErrCantUpdateUser
 	}
 
 	logger.Info("product purchased instantly", slog.Any("productID", productID), slog.String("userID", userID))
-	return nil
+	return &ordersDetail, nil
 }
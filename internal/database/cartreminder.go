@@ -0,0 +1,285 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/notify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const (
+	cartReminderKind  = "cart_reminder"
+	cartReminderBatch = 100
+)
+
+var (
+	ErrCantRemindCarts     = errors.New("cannot send cart reminders")
+	ErrCantListReminders   = errors.New("cannot list cart reminders")
+	ErrCantRecordRecovery  = errors.New("cannot record cart recovery")
+	ErrCantMigrateCartTime = errors.New("cannot migrate cart timestamps")
+)
+
+// CartReminders carries what the abandoned cart job needs: where carts and
+// reminders are stored, how reminders are delivered and how long a cart has
+// to be idle before its owner is reminded.
+type CartReminders struct {
+	UserCollection     *mongo.Collection
+	ReminderCollection *mongo.Collection
+	Notifier           notify.Notifier
+	IdleAfter          time.Duration
+	Currency           string
+}
+
+// CartReminderStats sums up how reminders did.
+type CartReminderStats struct {
+	Sent      int64 `json:"sent"`
+	Recovered int64 `json:"recovered"`
+}
+
+// idleCarts matches carts with items that have not changed since cutoff and
+// whose owner has not been reminded since their last change, so every idle
+// spell gets one reminder.
+func idleCarts(cutoff time.Time) bson.D {
+	return bson.D{
+		{Key: "user_cart.0", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "cart_updated_at", Value: bson.D{{Key: "$lte", Value: cutoff}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "cart_reminded_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$cart_reminded_at", "$cart_updated_at"}}}}},
+		}},
+	}
+}
+
+// RemindIdleCarts sends one reminder for each cart idle longer than
+// IdleAfter and records it. A cart is claimed before its reminder goes out,
+// so several instances of the service never remind the same cart twice. It
+// returns how many reminders were sent.
+func RemindIdleCarts(ctx context.Context, r CartReminders) (int, error) {
+	now := time.Now()
+	filter := idleCarts(now.Add(-r.IdleAfter))
+	opts := options.Find().
+		SetLimit(cartReminderBatch).
+		SetProjection(bson.D{{Key: "email", Value: 1}, {Key: "firstname", Value: 1}, {Key: "user_cart", Value: 1}, {Key: "cart_updated_at", Value: 1}})
+
+	cursor, err := r.UserCollection.Find(ctx, filter, opts)
+
+	if err != nil {
+		logger.Error("error finding idle carts", slog.Any("error", err))
+		return 0, ErrCantRemindCarts
+	}
+
+	var users []models.User
+
+	if err := cursor.All(ctx, &users); err != nil {
+		logger.Error("error decoding idle carts", slog.Any("error", err))
+		return 0, ErrCantRemindCarts
+	}
+
+	sent := 0
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+
+		if remindCart(ctx, r, user, filter, now) {
+			sent++
+		}
+	}
+
+	if sent > 0 {
+		logger.Info("cart reminders sent", slog.Int("sent", sent), slog.Int("idle", len(users)))
+	}
+
+	return sent, nil
+}
+
+// remindCart claims one idle cart, notifies its owner and records the
+// reminder. A failed notification releases the claim so the next run tries
+// again.
+func remindCart(ctx context.Context, r CartReminders, user models.User, idle bson.D, now time.Time) bool {
+	userID := user.ID.Hex()
+	claim := append(bson.D{{Key: "_id", Value: user.ID}}, idle...)
+
+	result, err := r.UserCollection.UpdateOne(ctx, claim, bson.D{{Key: "$set", Value: bson.D{{Key: "cart_reminded_at", Value: now}}}})
+
+	if err != nil {
+		logger.Error("error claiming idle cart", slog.String("userID", userID), slog.Any("error", err))
+		return false
+	}
+
+	if result.ModifiedCount == 0 {
+		return false
+	}
+
+	value, err := CartTotal(user.UserCart, r.Currency)
+
+	if err != nil {
+		logger.Warn("error totalling idle cart", slog.String("userID", userID), slog.Any("error", err))
+		value = money.Zero(r.Currency)
+	}
+
+	reminder := models.CartReminder{
+		ReminderID:    primitive.NewObjectID(),
+		UserID:        userID,
+		Items:         len(user.UserCart),
+		CartValue:     value,
+		CartUpdatedAt: *user.CartUpdatedAt,
+		Notifier:      r.Notifier.Name(),
+		SentAt:        now,
+	}
+
+	if err := r.Notifier.Notify(ctx, cartReminderMessage(user, reminder)); err != nil {
+		logger.Warn("error sending cart reminder", slog.String("userID", userID), slog.String("notifier", reminder.Notifier), slog.Any("error", err))
+
+		release := bson.D{{Key: "_id", Value: user.ID}, {Key: "cart_reminded_at", Value: now}}
+		if _, err := r.UserCollection.UpdateOne(ctx, release, bson.D{{Key: "$unset", Value: bson.D{{Key: "cart_reminded_at", Value: ""}}}}); err != nil {
+			logger.Error("error releasing idle cart", slog.String("userID", userID), slog.Any("error", err))
+		}
+
+		return false
+	}
+
+	if _, err := r.ReminderCollection.InsertOne(ctx, reminder); err != nil {
+		logger.Error("error recording cart reminder", slog.String("userID", userID), slog.Any("error", err))
+	}
+
+	return true
+}
+
+func cartReminderMessage(user models.User, reminder models.CartReminder) notify.Message {
+	msg := notify.Message{
+		Kind:    cartReminderKind,
+		UserID:  reminder.UserID,
+		Subject: "You left something in your cart",
+		Body:    "Your cart still has " + strconv.Itoa(reminder.Items) + " item(s) worth " + reminder.CartValue.String() + " waiting for you.",
+		Data: map[string]string{
+			"reminder_id": reminder.ReminderID.Hex(),
+			"items":       strconv.Itoa(reminder.Items),
+			"cart_value":  reminder.CartValue.String(),
+		},
+	}
+
+	if user.Email != nil {
+		msg.Email = *user.Email
+	}
+
+	if user.FirstName != nil {
+		msg.Data["first_name"] = *user.FirstName
+	}
+
+	return msg
+}
+
+// RecordCartRecovery marks the user's latest reminder sent within window as
+// recovered by order. Checkouts without such a reminder record nothing.
+func RecordCartRecovery(ctx context.Context, reminderCollection *mongo.Collection, userID string, order *models.Order, window time.Duration) error {
+	now := time.Now()
+	filter := bson.D{
+		{Key: "user_id", Value: userID},
+		{Key: "recovered_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "sent_at", Value: bson.D{{Key: "$gte", Value: now.Add(-window)}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "recovered_at", Value: now},
+		{Key: "order_id", Value: order.OrderID},
+		{Key: "order_total", Value: order.Price},
+	}}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "sent_at", Value: -1}})
+
+	var reminder models.CartReminder
+
+	err := reminderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reminder)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+
+	if err != nil {
+		logger.Error("error recording cart recovery", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantRecordRecovery
+	}
+
+	logger.Info("reminded cart recovered", slog.String("userID", userID), slog.String("reminderID", reminder.ReminderID.Hex()), slog.String("orderID", order.OrderID.Hex()))
+	return nil
+}
+
+// ListCartReminders returns the newest reminders first, with totals over all
+// of them.
+func ListCartReminders(ctx context.Context, reminderCollection *mongo.Collection, limit int) ([]models.CartReminder, CartReminderStats, error) {
+	var stats CartReminderStats
+	var err error
+
+	if stats.Sent, err = reminderCollection.CountDocuments(ctx, bson.D{}); err == nil {
+		stats.Recovered, err = reminderCollection.CountDocuments(ctx, bson.D{{Key: "recovered_at", Value: bson.D{{Key: "$exists", Value: true}}}})
+	}
+
+	if err != nil {
+		logger.Error("error counting cart reminders", slog.Any("error", err))
+		return nil, stats, ErrCantListReminders
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "sent_at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := reminderCollection.Find(ctx, bson.D{}, opts)
+
+	if err != nil {
+		logger.Error("error listing cart reminders", slog.Any("error", err))
+		return nil, stats, ErrCantListReminders
+	}
+
+	reminders := []models.CartReminder{}
+
+	if err := cursor.All(ctx, &reminders); err != nil {
+		logger.Error("error decoding cart reminders", slog.Any("error", err))
+		return nil, stats, ErrCantListReminders
+	}
+
+	return reminders, stats, nil
+}
+
+// MigrateCartTimestamps starts the idle clock of carts saved before carts
+// had a last-modified time, so they are not all reminded at once right after
+// an upgrade. It is idempotent.
+func MigrateCartTimestamps(ctx context.Context, userCollection *mongo.Collection) error {
+	filter := bson.D{
+		{Key: "user_cart.0", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "cart_updated_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+
+	users, err := userCollection.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.D{cartTouched()}}})
+
+	if err != nil {
+		logger.Error("error migrating cart timestamps", slog.Any("error", err))
+		return ErrCantMigrateCartTime
+	}
+
+	if users.ModifiedCount > 0 {
+		logger.Info("cart timestamps migrated", slog.Int64("users", users.ModifiedCount))
+	}
+
+	return nil
+}
+
+func EnsureCartReminderIndexes(ctx context.Context, reminderCollection *mongo.Collection) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "sent_at", Value: -1}}},
+		{Keys: bson.D{{Key: "sent_at", Value: -1}}},
+	}
+
+	if _, err := reminderCollection.Indexes().CreateMany(ctx, indexes); err != nil {
+		logger.Error("error creating cart reminder indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("cart reminder indexes ensured")
+	return nil
+}
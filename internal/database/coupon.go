@@ -0,0 +1,497 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponExists        = errors.New("coupon code already exists")
+	ErrCouponPercent       = errors.New("percentage coupons need percent_off between 1 and 100")
+	ErrCouponAmount        = errors.New("fixed amount coupons need a positive amount_off in the store currency")
+	ErrCouponMinimum       = errors.New("min_cart_value must be a positive amount in the store currency")
+	ErrCouponWindow        = errors.New("ends_at must be after starts_at")
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponNotStarted    = errors.New("coupon is not valid yet")
+	ErrCouponExpired       = errors.New("coupon has expired")
+	ErrCouponUsedUp        = errors.New("coupon usage limit reached")
+	ErrCouponUserLimit     = errors.New("coupon already used the maximum number of times")
+	ErrCouponMinimumNotMet = errors.New("cart total is below the coupon minimum")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to any item in the cart")
+	ErrCantSaveCoupon      = errors.New("cannot save coupon")
+	ErrCantListCoupons     = errors.New("cannot list coupons")
+	ErrCantRedeemCoupon    = errors.New("cannot redeem coupon")
+)
+
+// couponRejections are the errors that explain to a customer why a code
+// cannot be used, as opposed to storage failures.
+var couponRejections = []error{
+	ErrCouponNotFound,
+	ErrCouponInactive,
+	ErrCouponNotStarted,
+	ErrCouponExpired,
+	ErrCouponUsedUp,
+	ErrCouponUserLimit,
+	ErrCouponMinimumNotMet,
+	ErrCouponNotApplicable,
+}
+
+func IsCouponRejection(err error) bool {
+	for _, rejection := range couponRejections {
+		if errors.Is(err, rejection) {
+			return true
+		}
+	}
+	return false
+}
+
+// AppliedCoupon is the outcome of evaluating a coupon against a cart. A
+// rejected coupon is still reported, with the reason in Error and no
+// discount, so the cart can tell the customer why.
+type AppliedCoupon struct {
+	Code         string      `json:"code"`
+	Type         string      `json:"type,omitempty"`
+	Discount     money.Money `json:"discount"`
+	FreeShipping bool        `json:"free_shipping"`
+	Error        string      `json:"error,omitempty"`
+
+	coupon *models.Coupon
+	err    error
+}
+
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidateCoupon checks the rules that depend on the coupon type, which the
+// struct tags cannot express.
+func ValidateCoupon(coupon *models.Coupon, currency string) error {
+	switch coupon.Type {
+	case models.CouponPercentage:
+		if coupon.PercentOff < 1 || coupon.PercentOff > 100 {
+			return ErrCouponPercent
+		}
+	case models.CouponFixedAmount:
+		if coupon.AmountOff == nil || coupon.AmountOff.Currency != currency || coupon.AmountOff.Amount <= 0 {
+			return ErrCouponAmount
+		}
+	}
+
+	if coupon.MinCartValue != nil && (coupon.MinCartValue.Currency != currency || coupon.MinCartValue.Amount <= 0) {
+		return ErrCouponMinimum
+	}
+
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt) {
+		return ErrCouponWindow
+	}
+
+	return nil
+}
+
+func CreateCoupon(ctx context.Context, couponCollection *mongo.Collection, coupon *models.Coupon) error {
+	coupon.CouponID = primitive.NewObjectID()
+	coupon.Code = NormalizeCouponCode(coupon.Code)
+	coupon.Uses = 0
+	coupon.CreatedAt = time.Now()
+
+	_, err := couponCollection.InsertOne(ctx, coupon)
+
+	if mongo.IsDuplicateKeyError(err) {
+		logger.Warn("duplicate coupon code", slog.String("code", coupon.Code))
+		return ErrCouponExists
+	}
+
+	if err != nil {
+		logger.Error("error inserting coupon", slog.String("code", coupon.Code), slog.Any("error", err))
+		return ErrCantSaveCoupon
+	}
+
+	logger.Info("coupon created", slog.String("code", coupon.Code), slog.String("type", coupon.Type))
+	return nil
+}
+
+func FindCoupon(ctx context.Context, couponCollection *mongo.Collection, code string) (*models.Coupon, error) {
+	var coupon models.Coupon
+
+	err := couponCollection.FindOne(ctx, bson.D{{Key: "code", Value: NormalizeCouponCode(code)}}).Decode(&coupon)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCouponNotFound
+	}
+
+	if err != nil {
+		logger.Error("error finding coupon", slog.String("code", code), slog.Any("error", err))
+		return nil, ErrCantListCoupons
+	}
+
+	return &coupon, nil
+}
+
+func ListCoupons(ctx context.Context, couponCollection *mongo.Collection) ([]models.Coupon, error) {
+	cursor, err := couponCollection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+
+	if err != nil {
+		logger.Error("error listing coupons", slog.Any("error", err))
+		return nil, ErrCantListCoupons
+	}
+
+	coupons := make([]models.Coupon, 0)
+
+	if err := cursor.All(ctx, &coupons); err != nil {
+		logger.Error("error decoding coupons", slog.Any("error", err))
+		return nil, ErrCantListCoupons
+	}
+
+	return coupons, nil
+}
+
+func SetCouponActive(ctx context.Context, couponCollection *mongo.Collection, code string, active bool) (*models.Coupon, error) {
+	var coupon models.Coupon
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: active}}}}
+
+	err := couponCollection.FindOneAndUpdate(ctx, bson.D{{Key: "code", Value: NormalizeCouponCode(code)}}, update, opts).Decode(&coupon)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCouponNotFound
+	}
+
+	if err != nil {
+		logger.Error("error updating coupon", slog.String("code", code), slog.Any("error", err))
+		return nil, ErrCantSaveCoupon
+	}
+
+	logger.Info("coupon updated", slog.String("code", coupon.Code), slog.Bool("active", active))
+	return &coupon, nil
+}
+
+// SetCartCoupon stores the code the user applied to their cart, or clears it
+// when code is empty.
+func SetCartCoupon(ctx context.Context, userCollection *mongo.Collection, userID, code string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return ErrUserIDIsNotValid
+	}
+
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "cart_coupon", Value: ""}}}}
+
+	if code != "" {
+		update = bson.D{{Key: "$set", Value: bson.D{{Key: "cart_coupon", Value: NormalizeCouponCode(code)}}}}
+	}
+
+	_, err = userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+
+	if err != nil {
+		logger.Error("error updating cart coupon", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantUpdateUser
+	}
+
+	return nil
+}
+
+// EvaluateCoupon works out the discount a coupon gives on items, where net
+// holds each line's price in currency after promotions. Restrictions narrow
+// the discount to matching lines; the minimum is compared with the base
+// currency subtotal so it does not move with exchange rates.
+func EvaluateCoupon(coupon *models.Coupon, items []models.ProductUser, net []money.Money, baseSubtotal money.Money, rates *money.RateTable, currency string, now time.Time) (*AppliedCoupon, error) {
+	switch {
+	case !coupon.Active:
+		return nil, ErrCouponInactive
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return nil, ErrCouponNotStarted
+	case coupon.EndsAt != nil && !now.Before(*coupon.EndsAt):
+		return nil, ErrCouponExpired
+	case coupon.MaxUses > 0 && coupon.Uses >= coupon.MaxUses:
+		return nil, ErrCouponUsedUp
+	}
+
+	if coupon.MinCartValue != nil {
+		if coupon.MinCartValue.Currency != baseSubtotal.Currency {
+			return nil, money.ErrCurrencyMismatch
+		}
+		if baseSubtotal.Amount < coupon.MinCartValue.Amount {
+			return nil, ErrCouponMinimumNotMet
+		}
+	}
+
+	eligible := make([]money.Money, 0, len(items))
+
+	for i, item := range items {
+		if couponCovers(coupon, item) && net[i].Amount > 0 {
+			eligible = append(eligible, net[i])
+		}
+	}
+
+	if len(eligible) == 0 {
+		return nil, ErrCouponNotApplicable
+	}
+
+	eligibleTotal, err := money.Sum(currency, eligible...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied := &AppliedCoupon{
+		Code:     coupon.Code,
+		Type:     coupon.Type,
+		Discount: money.Zero(currency),
+		coupon:   coupon,
+	}
+
+	switch coupon.Type {
+	case models.CouponPercentage:
+		applied.Discount, err = eligibleTotal.Percent(coupon.PercentOff)
+	case models.CouponFixedAmount:
+		var amountOff money.Money
+
+		amountOff, _, err = rates.Convert(*coupon.AmountOff, currency)
+		if err == nil {
+			applied.Discount, err = money.Min(amountOff, eligibleTotal)
+		}
+	case models.CouponFreeShipping:
+		applied.FreeShipping = true
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+func couponCovers(coupon *models.Coupon, item models.ProductUser) bool {
+	if len(coupon.ProductIDs) == 0 && len(coupon.Categories) == 0 {
+		return true
+	}
+
+	for _, id := range coupon.ProductIDs {
+		if id == item.ProductID {
+			return true
+		}
+	}
+
+	if item.Category != nil {
+		for _, category := range coupon.Categories {
+			if strings.EqualFold(category, *item.Category) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// applyCoupon looks up and evaluates code for a cart. Rejections end up on
+// the returned coupon; only storage failures are returned as errors.
+func applyCoupon(ctx context.Context, pricing Pricing, code, userID string, items []models.ProductUser, net []money.Money, baseSubtotal money.Money) (*AppliedCoupon, error) {
+	coupon, err := FindCoupon(ctx, pricing.CouponCollection, code)
+
+	if err == nil && coupon.MaxUsesPerUser > 0 && userID != "" {
+		var used int64
+
+		used, err = couponUsesByUser(ctx, pricing.UserCollection, coupon.CouponID, userID)
+		if err == nil && used >= coupon.MaxUsesPerUser {
+			err = ErrCouponUserLimit
+		}
+	}
+
+	var applied *AppliedCoupon
+
+	if err == nil {
+		applied, err = EvaluateCoupon(coupon, items, net, baseSubtotal, pricing.Rates, pricing.Currency, time.Now())
+	}
+
+	if IsCouponRejection(err) {
+		return &AppliedCoupon{
+			Code:     NormalizeCouponCode(code),
+			Discount: money.Zero(pricing.Currency),
+			Error:    err.Error(),
+			err:      err,
+		}, nil
+	}
+
+	return applied, err
+}
+
+// couponUsesKey is the User.CouponUses entry counting a user's uses of a
+// coupon.
+func couponUsesKey(couponID primitive.ObjectID) string {
+	return "coupon_uses." + couponID.Hex()
+}
+
+// couponUsesByUser reads the counter redeemCoupon enforces the per user
+// limit with, so the cart and checkout agree on whether a code is used up.
+func couponUsesByUser(ctx context.Context, userCollection *mongo.Collection, couponID primitive.ObjectID, userID string) (int64, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return 0, ErrUserIDIsNotValid
+	}
+
+	var user models.User
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: couponUsesKey(couponID), Value: 1}})
+	err = userCollection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, opts).Decode(&user)
+
+	if err != nil {
+		logger.Error("error loading coupon uses", slog.Any("couponID", couponID), slog.String("userID", userID), slog.Any("error", err))
+		return 0, ErrCantRedeemCoupon
+	}
+
+	return user.CouponUses[couponID.Hex()], nil
+}
+
+// redeemCoupon takes one use of the coupon for an order. The coupon's usage
+// counter is only incremented while below max_uses and the user's while
+// below max_uses_per_user, each in a single conditional update, so
+// concurrent checkouts cannot overspend a code. The returned release func
+// undoes the redemption if the order is not placed.
+func redeemCoupon(ctx context.Context, pricing Pricing, applied *AppliedCoupon, userID string, orderID primitive.ObjectID) (func(), error) {
+	coupon := applied.coupon
+
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return nil, ErrUserIDIsNotValid
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: coupon.CouponID},
+		{Key: "active", Value: true},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "max_uses", Value: 0}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$uses", "$max_uses"}}}}},
+		}},
+	}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "uses", Value: 1}}}}
+
+	result, err := pricing.CouponCollection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error reserving coupon use", slog.String("code", coupon.Code), slog.Any("error", err))
+		return nil, ErrCantRedeemCoupon
+	}
+
+	if result.MatchedCount == 0 {
+		logger.Warn("coupon use rejected at checkout", slog.String("code", coupon.Code))
+		return nil, ErrCouponUsedUp
+	}
+
+	unreserve := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := pricing.CouponCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: coupon.CouponID}}, bson.D{{Key: "$inc", Value: bson.D{{Key: "uses", Value: -1}}}})
+		if err != nil {
+			logger.Error("error releasing coupon use", slog.String("code", coupon.Code), slog.Any("error", err))
+		}
+	}
+
+	// Uses are counted even without a per user limit, so a limit added later
+	// sees them.
+	usesKey := couponUsesKey(coupon.CouponID)
+	userFilter := bson.D{{Key: "_id", Value: id}}
+
+	if coupon.MaxUsesPerUser > 0 {
+		userFilter = append(userFilter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: usesKey, Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: usesKey, Value: bson.D{{Key: "$lt", Value: coupon.MaxUsesPerUser}}}},
+		}})
+	}
+
+	result, err = pricing.UserCollection.UpdateOne(ctx, userFilter, bson.D{{Key: "$inc", Value: bson.D{{Key: usesKey, Value: 1}}}})
+
+	if err != nil {
+		unreserve()
+		logger.Error("error reserving coupon use for user", slog.String("code", coupon.Code), slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantRedeemCoupon
+	}
+
+	if result.MatchedCount == 0 {
+		unreserve()
+		logger.Warn("coupon use over the per user limit", slog.String("code", coupon.Code), slog.String("userID", userID))
+		return nil, ErrCouponUserLimit
+	}
+
+	unreserveUser := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := pricing.UserCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$inc", Value: bson.D{{Key: usesKey, Value: -1}}}})
+		if err != nil {
+			logger.Error("error releasing user coupon use", slog.String("code", coupon.Code), slog.String("userID", userID), slog.Any("error", err))
+		}
+
+		unreserve()
+	}
+
+	redemption := models.CouponRedemption{
+		RedemptionID: primitive.NewObjectID(),
+		CouponID:     coupon.CouponID,
+		Code:         coupon.Code,
+		UserID:       userID,
+		OrderID:      orderID,
+		Discount:     applied.Discount,
+		RedeemedAt:   time.Now(),
+	}
+
+	_, err = pricing.RedemptionCollection.InsertOne(ctx, redemption)
+
+	if err != nil {
+		unreserveUser()
+		logger.Error("error recording coupon redemption", slog.String("code", coupon.Code), slog.Any("error", err))
+		return nil, ErrCantRedeemCoupon
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := pricing.RedemptionCollection.DeleteOne(ctx, bson.D{{Key: "_id", Value: redemption.RedemptionID}})
+		if err != nil {
+			logger.Error("error deleting coupon redemption", slog.Any("redemptionID", redemption.RedemptionID), slog.Any("error", err))
+		}
+
+		unreserveUser()
+	}
+
+	logger.Info("coupon redeemed", slog.String("code", coupon.Code), slog.String("userID", userID), slog.String("discount", applied.Discount.String()))
+	return release, nil
+}
+
+func EnsureCouponIndexes(ctx context.Context, couponCollection, redemptionCollection *mongo.Collection) error {
+	_, err := couponCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	if err == nil {
+		_, err = redemptionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "coupon_id", Value: 1}, {Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		})
+	}
+
+	if err != nil {
+		logger.Error("error creating coupon indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("coupon indexes ensured")
+	return nil
+}
@@ -43,7 +43,7 @@ func DBSet() *mongo.Client {
 		log.Fatal(err)
 	}
 
-	err = client.Ping(context.TODO(), nil)
+	err = client.Ping(ctx, nil)
 
 	if err != nil {
 		logger.Error("failed to ping mongo", slog.Any("error", err))
@@ -75,3 +75,93 @@ func ProductData(client *mongo.Client, CollectionName string) *mongo.Collection
 	var productCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
 	return productCollection
 }
+
+func ReviewData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var reviewCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return reviewCollection
+}
+
+func CouponData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var couponCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return couponCollection
+}
+
+func PromotionData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var promotionCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return promotionCollection
+}
+
+func ShippingData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var shippingCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return shippingCollection
+}
+
+func PaymentData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var paymentCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return paymentCollection
+}
+
+func IdempotencyData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var idempotencyCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return idempotencyCollection
+}
+
+func WishlistData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var wishlistCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return wishlistCollection
+}
+
+func GuestCartData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var guestCartCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return guestCartCollection
+}
+
+func CartReminderData(client *mongo.Client, CollectionName string) *mongo.Collection {
+	if client == nil {
+		logger.Error("mongo client is nil", slog.Any("error", errors.New("mongo client is nil")))
+		return nil
+	}
+
+	var cartReminderCollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
+	return cartReminderCollection
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+// Strategies for merging a guest cart into a user's cart. The cart holds one
+// entry per unit, so a product's quantity is how often it appears.
+const (
+	CartMergeMax   = "max"
+	CartMergeSum   = "sum"
+	CartMergeGuest = "guest"
+)
+
+var (
+	ErrGuestCartNotFound  = errors.New("guest cart not found or expired")
+	ErrCantSaveGuestCart  = errors.New("cannot save guest cart")
+	ErrCantMergeGuestCart = errors.New("cannot merge guest cart")
+)
+
+func CreateGuestCart(ctx context.Context, guestCartCollection *mongo.Collection, ttl time.Duration) (*models.GuestCart, error) {
+	now := time.Now()
+	cart := &models.GuestCart{
+		CartID:    primitive.NewObjectID(),
+		Items:     []models.ProductUser{},
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	if _, err := guestCartCollection.InsertOne(ctx, cart); err != nil {
+		logger.Error("error creating guest cart", slog.Any("error", err))
+		return nil, ErrCantSaveGuestCart
+	}
+
+	logger.Info("guest cart created", slog.Any("cartID", cart.CartID))
+	return cart, nil
+}
+
+// GetGuestCart returns a guest cart that has not expired. MongoDB removes
+// expired carts only periodically, so expiry is checked here as well.
+func GetGuestCart(ctx context.Context, guestCartCollection *mongo.Collection, cartID primitive.ObjectID) (*models.GuestCart, error) {
+	var cart models.GuestCart
+
+	err := guestCartCollection.FindOne(ctx, liveGuestCart(cartID)).Decode(&cart)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGuestCartNotFound
+	}
+
+	if err != nil {
+		logger.Error("error loading guest cart", slog.Any("cartID", cartID), slog.Any("error", err))
+		return nil, ErrCantSaveGuestCart
+	}
+
+	return &cart, nil
+}
+
+// AddGuestCartItem adds one unit of a product to a guest cart at its current
+// price and keeps the cart for another ttl.
+func AddGuestCartItem(ctx context.Context, guestCartCollection, prodCollection *mongo.Collection, cartID, productID primitive.ObjectID, ttl time.Duration) (*models.GuestCart, error) {
+	var product models.ProductUser
+
+	err := prodCollection.FindOne(ctx, bson.D{{Key: "_id", Value: productID}}).Decode(&product)
+
+	if err != nil {
+		logger.Error("error finding product for guest cart", slog.Any("productID", productID), slog.Any("error", err))
+		return nil, ErrCantFindProduct
+	}
+
+	return updateGuestCart(ctx, guestCartCollection, cartID, ttl, bson.E{Key: "$push", Value: bson.D{{Key: "items", Value: product}}})
+}
+
+// RemoveGuestCartItem takes every unit of a product out of a guest cart.
+func RemoveGuestCartItem(ctx context.Context, guestCartCollection *mongo.Collection, cartID, productID primitive.ObjectID, ttl time.Duration) (*models.GuestCart, error) {
+	return updateGuestCart(ctx, guestCartCollection, cartID, ttl, bson.E{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "_id", Value: productID}}}}})
+}
+
+func updateGuestCart(ctx context.Context, guestCartCollection *mongo.Collection, cartID primitive.ObjectID, ttl time.Duration, change bson.E) (*models.GuestCart, error) {
+	now := time.Now()
+	update := bson.D{
+		change,
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "expires_at", Value: now.Add(ttl)}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var cart models.GuestCart
+
+	err := guestCartCollection.FindOneAndUpdate(ctx, liveGuestCart(cartID), update, opts).Decode(&cart)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGuestCartNotFound
+	}
+
+	if err != nil {
+		logger.Error("error updating guest cart", slog.Any("cartID", cartID), slog.Any("error", err))
+		return nil, ErrCantSaveGuestCart
+	}
+
+	return &cart, nil
+}
+
+func liveGuestCart(cartID primitive.ObjectID) bson.D {
+	return bson.D{{Key: "_id", Value: cartID}, {Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}}}
+}
+
+// MergeGuestCart moves the items of a guest cart into a user's cart with the
+// given strategy and deletes the guest cart. It returns how many units were
+// added. An expired or unknown guest cart merges nothing.
+func MergeGuestCart(ctx context.Context, guestCartCollection, userCollection *mongo.Collection, cartID primitive.ObjectID, userID, strategy string) (int, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return 0, ErrUserIDIsNotValid
+	}
+
+	var guest models.GuestCart
+
+	// Deleting first means two logins with the same token cannot both merge it.
+	err = guestCartCollection.FindOneAndDelete(ctx, liveGuestCart(cartID)).Decode(&guest)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+
+	if err != nil {
+		logger.Error("error loading guest cart for merge", slog.Any("cartID", cartID), slog.Any("error", err))
+		return 0, ErrCantMergeGuestCart
+	}
+
+	if len(guest.Items) == 0 {
+		return 0, nil
+	}
+
+	var user models.User
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "user_cart", Value: 1}})
+	err = userCollection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, opts).Decode(&user)
+
+	if err == nil {
+		merged, added := MergeCartItems(user.UserCart, guest.Items, strategy)
+
+		// Matching the cart as read keeps a concurrent change from being
+		// overwritten; the merge then fails and the guest cart is restored.
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "user_cart", Value: user.UserCart}}
+		if user.UserCart == nil {
+			filter = bson.D{{Key: "_id", Value: id}, {Key: "user_cart", Value: bson.D{{Key: "$in", Value: bson.A{nil, bson.A{}}}}}}
+		}
+
+		var result *mongo.UpdateResult
+
+		result, err = userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "user_cart", Value: merged}, cartTouched()}}})
+
+		if err == nil && result.MatchedCount == 1 {
+			logger.Info("guest cart merged", slog.String("userID", userID), slog.Any("cartID", cartID), slog.String("strategy", strategy), slog.Int("added", added))
+			return added, nil
+		}
+
+		if err == nil {
+			err = errors.New("cart changed during merge")
+		}
+	}
+
+	logger.Error("error merging guest cart", slog.String("userID", userID), slog.Any("cartID", cartID), slog.Any("error", err))
+
+	if _, restoreErr := guestCartCollection.InsertOne(ctx, guest); restoreErr != nil {
+		logger.Error("error restoring guest cart", slog.Any("cartID", cartID), slog.Any("error", restoreErr))
+	}
+
+	return 0, ErrCantMergeGuestCart
+}
+
+// MergeCartItems combines a user's cart with a guest cart. Products only in
+// one cart keep their quantity. For products in both, CartMergeMax keeps the
+// larger quantity, CartMergeSum adds them up and CartMergeGuest takes the
+// guest cart's quantity. The user's lines come first, in their order. It
+// also returns how many guest units were appended; with CartMergeGuest the
+// cart can shrink, so that is not the change in length.
+func MergeCartItems(userItems, guestItems []models.ProductUser, strategy string) ([]models.ProductUser, int) {
+	userCount := make(map[primitive.ObjectID]int)
+	for _, item := range userItems {
+		userCount[item.ProductID]++
+	}
+
+	guestCount := make(map[primitive.ObjectID]int)
+	for _, item := range guestItems {
+		guestCount[item.ProductID]++
+	}
+
+	merged := make([]models.ProductUser, 0, len(userItems)+len(guestItems))
+	kept := make(map[primitive.ObjectID]int)
+
+	for _, item := range userItems {
+		if guestQty, inGuest := guestCount[item.ProductID]; strategy == CartMergeGuest && inGuest && kept[item.ProductID] >= guestQty {
+			continue
+		}
+
+		kept[item.ProductID]++
+		merged = append(merged, item)
+	}
+
+	added := make(map[primitive.ObjectID]int)
+	appended := 0
+
+	for _, item := range guestItems {
+		extra := guestCount[item.ProductID]
+
+		switch strategy {
+		case CartMergeSum:
+		case CartMergeGuest:
+			extra -= kept[item.ProductID]
+		default:
+			extra -= userCount[item.ProductID]
+		}
+
+		if added[item.ProductID] < extra {
+			added[item.ProductID]++
+			appended++
+			merged = append(merged, item)
+		}
+	}
+
+	return merged, appended
+}
+
+func EnsureGuestCartIndexes(ctx context.Context, guestCartCollection *mongo.Collection) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	if _, err := guestCartCollection.Indexes().CreateOne(ctx, index); err != nil {
+		logger.Error("error creating guest cart indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("guest cart indexes ensured")
+	return nil
+}
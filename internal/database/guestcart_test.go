@@ -0,0 +1,54 @@
+package database
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+)
+
+func TestMergeCartItems(t *testing.T) {
+	a, b, c := testID(1), testID(2), testID(3)
+
+	cart := func(ids ...primitive.ObjectID) []models.ProductUser {
+		items := make([]models.ProductUser, 0, len(ids))
+		for _, id := range ids {
+			items = append(items, testUnit(id, 100))
+		}
+		return items
+	}
+
+	tests := []struct {
+		name     string
+		user     []models.ProductUser
+		guest    []models.ProductUser
+		strategy string
+		want     []primitive.ObjectID
+		appended int
+	}{
+		{"max, more in guest", cart(a, a, b), cart(b, b, b, c), CartMergeMax, []primitive.ObjectID{a, a, b, b, b, c}, 3},
+		{"max, more in user", cart(a, b, b, b), cart(b, c, c), CartMergeMax, []primitive.ObjectID{a, b, b, b, c, c}, 2},
+		{"sum, more in guest", cart(a, a, b), cart(b, b, b, c), CartMergeSum, []primitive.ObjectID{a, a, b, b, b, b, c}, 4},
+		{"sum, more in user", cart(a, b, b, b), cart(b, c, c), CartMergeSum, []primitive.ObjectID{a, b, b, b, b, c, c}, 3},
+		{"guest, more in guest", cart(a, a, b), cart(b, b, b, c), CartMergeGuest, []primitive.ObjectID{a, a, b, b, b, c}, 3},
+		{"guest, more in user", cart(a, b, b, b), cart(b, c, c), CartMergeGuest, []primitive.ObjectID{a, b, c, c}, 2},
+		{"unknown strategy merges as max", cart(a, b, b, b), cart(b, c, c), "", []primitive.ObjectID{a, b, b, b, c, c}, 2},
+		{"empty guest cart", cart(a, b), nil, CartMergeSum, []primitive.ObjectID{a, b}, 0},
+		{"empty user cart", nil, cart(c, c), CartMergeGuest, []primitive.ObjectID{c, c}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, appended := MergeCartItems(tt.user, tt.guest, tt.strategy)
+
+			got := make([]primitive.ObjectID, 0, len(merged))
+			for _, item := range merged {
+				got = append(got, item.ProductID)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) || appended != tt.appended {
+				t.Errorf("MergeCartItems() = %v, %d; want %v, %d", got, appended, tt.want, tt.appended)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+// idempotencyLock is how long a request may hold its key before a retry is
+// allowed to take over, in case the first one never finished.
+const idempotencyLock = time.Minute
+
+var (
+	ErrIdempotencyMismatch   = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still being processed")
+	ErrCantSaveIdempotency   = errors.New("cannot save idempotency key")
+)
+
+// ReserveIdempotencyKey claims key for userID and the request identified by
+// fingerprint. It returns the stored record when the key already answered
+// the same request, ErrIdempotencyMismatch when it was used for another one
+// and ErrIdempotencyInProgress while the first request is still running.
+// Keys expire after window.
+func ReserveIdempotencyKey(ctx context.Context, idempotencyCollection *mongo.Collection, key, userID, fingerprint string, window time.Duration) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+
+	// An expired key, or one whose request was abandoned mid-way, matches the
+	// filter and is taken over; a live one does not, so the upsert collides
+	// with it on the unique index.
+	filter := bson.D{
+		{Key: "key", Value: key},
+		{Key: "user_id", Value: userID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+			bson.D{
+				{Key: "status", Value: models.IdempotencyProcessing},
+				{Key: "fingerprint", Value: fingerprint},
+				{Key: "locked_until", Value: bson.D{{Key: "$lte", Value: now}}},
+			},
+		}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "fingerprint", Value: fingerprint},
+			{Key: "status", Value: models.IdempotencyProcessing},
+			{Key: "created_at", Value: now},
+			{Key: "locked_until", Value: now.Add(idempotencyLock)},
+			{Key: "expires_at", Value: now.Add(window)},
+		}},
+		{Key: "$unset", Value: bson.D{
+			{Key: "response_status", Value: ""},
+			{Key: "content_type", Value: ""},
+			{Key: "response_body", Value: ""},
+		}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID()}}},
+	}
+
+	_, err := idempotencyCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+
+	if err == nil {
+		return nil, nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		logger.Error("error reserving idempotency key", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantSaveIdempotency
+	}
+
+	var record models.IdempotencyRecord
+	err = idempotencyCollection.FindOne(ctx, bson.D{{Key: "key", Value: key}, {Key: "user_id", Value: userID}}).Decode(&record)
+
+	if err != nil {
+		logger.Error("error loading idempotency key", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantSaveIdempotency
+	}
+
+	if record.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+
+	if record.Status != models.IdempotencyCompleted {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for key.
+func CompleteIdempotencyKey(ctx context.Context, idempotencyCollection *mongo.Collection, key, userID string, status int, contentType string, body []byte) error {
+	filter := bson.D{
+		{Key: "key", Value: key},
+		{Key: "user_id", Value: userID},
+		{Key: "status", Value: models.IdempotencyProcessing},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.IdempotencyCompleted},
+		{Key: "response_status", Value: status},
+		{Key: "content_type", Value: contentType},
+		{Key: "response_body", Value: body},
+	}}}
+
+	if _, err := idempotencyCollection.UpdateOne(ctx, filter, update); err != nil {
+		logger.Error("error saving idempotent response", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantSaveIdempotency
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a key whose request failed on the server, so
+// a retry runs it again.
+func ReleaseIdempotencyKey(ctx context.Context, idempotencyCollection *mongo.Collection, key, userID string) error {
+	filter := bson.D{
+		{Key: "key", Value: key},
+		{Key: "user_id", Value: userID},
+		{Key: "status", Value: models.IdempotencyProcessing},
+	}
+
+	if _, err := idempotencyCollection.DeleteOne(ctx, filter); err != nil {
+		logger.Error("error releasing idempotency key", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantSaveIdempotency
+	}
+
+	return nil
+}
+
+func EnsureIdempotencyIndexes(ctx context.Context, idempotencyCollection *mongo.Collection) error {
+	_, err := idempotencyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+
+	if err != nil {
+		logger.Error("error creating idempotency indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("idempotency indexes ensured")
+	return nil
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+)
+
+const MaxImagesPerProduct = 20
+
+var (
+	ErrImageNotFound     = errors.New("image not found")
+	ErrInvalidImageOrder = errors.New("image order must list every image of the product exactly once")
+	ErrCantUpdateImages  = errors.New("cannot update product images")
+	ErrTooManyImages     = errors.New("too many images for product")
+	ErrCantMigrateImages = errors.New("cannot migrate product images")
+)
+
+// MigrateProductImages gives products stored with images null, or without
+// the field, an empty list, so the image updates that push to it or filter
+// on its size work on them too.
+func MigrateProductImages(ctx context.Context, prodCollection *mongo.Collection) error {
+	filter := bson.D{{Key: "images", Value: nil}}
+
+	products, err := prodCollection.UpdateMany(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "images", Value: bson.A{}}}}})
+
+	if err != nil {
+		logger.Error("error migrating product images", slog.Any("error", err))
+		return ErrCantMigrateImages
+	}
+
+	if products.ModifiedCount > 0 {
+		logger.Info("product images migrated", slog.Int64("products", products.ModifiedCount))
+	}
+
+	return nil
+}
+
+func FindProduct(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+
+	err := prodCollection.FindOne(ctx, bson.D{{Key: "_id", Value: productID}}).Decode(&product)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCantFindProduct
+	}
+
+	if err != nil {
+		logger.Error("error fetching product", slog.Any("productID", productID), slog.Any("error", err))
+		return nil, ErrCantDecodeProducts
+	}
+
+	return &product, nil
+}
+
+// AddProductImages appends images after the existing ones. The size guard is
+// part of the filter so concurrent uploads cannot exceed the limit.
+func AddProductImages(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, images []models.ProductImage) error {
+	maxExisting := MaxImagesPerProduct - len(images)
+
+	if maxExisting < 0 {
+		return ErrTooManyImages
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: productID},
+		{Key: "$expr", Value: bson.D{{Key: "$lte", Value: bson.A{
+			bson.D{{Key: "$size", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$images", bson.A{}}}}}},
+			maxExisting,
+		}}}},
+	}
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "images", Value: bson.D{{Key: "$each", Value: images}}}}}}
+
+	result, err := prodCollection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error adding product images", slog.Any("productID", productID), slog.Any("error", err))
+		return ErrCantUpdateImages
+	}
+
+	if result.MatchedCount == 0 {
+		if _, err := FindProduct(ctx, prodCollection, productID); err != nil {
+			return err
+		}
+		return ErrTooManyImages
+	}
+
+	return syncCoverImage(ctx, prodCollection, productID, "")
+}
+
+// RemoveProductImage pulls one image and returns it so the caller can delete
+// the stored files.
+func RemoveProductImage(ctx context.Context, prodCollection *mongo.Collection, productID, imageID primitive.ObjectID) (*models.ProductImage, error) {
+	product, err := FindProduct(ctx, prodCollection, productID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var removed *models.ProductImage
+
+	for i := range product.Images {
+		if product.Images[i].ImageID == imageID {
+			removed = &product.Images[i]
+			break
+		}
+	}
+
+	if removed == nil {
+		return nil, ErrImageNotFound
+	}
+
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "images", Value: bson.D{{Key: "_id", Value: imageID}}}}}}
+
+	if _, err := prodCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: productID}}, update); err != nil {
+		logger.Error("error removing product image", slog.Any("productID", productID), slog.Any("error", err))
+		return nil, ErrCantUpdateImages
+	}
+
+	return removed, syncCoverImage(ctx, prodCollection, productID, removed.URL)
+}
+
+func ReorderProductImages(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, order []primitive.ObjectID) ([]models.ProductImage, error) {
+	product, err := FindProduct(ctx, prodCollection, productID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(order) != len(product.Images) {
+		return nil, ErrInvalidImageOrder
+	}
+
+	if len(order) == 0 {
+		return product.Images, nil
+	}
+
+	byID := make(map[primitive.ObjectID]models.ProductImage, len(product.Images))
+	for _, img := range product.Images {
+		byID[img.ImageID] = img
+	}
+
+	reordered := make([]models.ProductImage, 0, len(order))
+
+	for _, id := range order {
+		img, ok := byID[id]
+		if !ok {
+			return nil, ErrInvalidImageOrder
+		}
+		delete(byID, id)
+		reordered = append(reordered, img)
+	}
+
+	// Matching on the current ids guards against uploads or deletions that
+	// happened between the read above and this write.
+	currentIDs := make(bson.A, 0, len(order))
+	for _, img := range product.Images {
+		currentIDs = append(currentIDs, img.ImageID)
+	}
+
+	filter := bson.D{
+		{Key: "_id", Value: productID},
+		{Key: "images._id", Value: bson.D{{Key: "$all", Value: currentIDs}}},
+		{Key: "images", Value: bson.D{{Key: "$size", Value: len(currentIDs)}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "images", Value: reordered}}}}
+
+	result, err := prodCollection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error reordering product images", slog.Any("productID", productID), slog.Any("error", err))
+		return nil, ErrCantUpdateImages
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, ErrInvalidImageOrder
+	}
+
+	return reordered, syncCoverImage(ctx, prodCollection, productID, "")
+}
+
+// syncCoverImage keeps the legacy single image field pointing at the first
+// image so existing clients keep showing a picture. Without images the old
+// value is kept unless it is staleURL, the URL of an image just removed.
+func syncCoverImage(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, staleURL string) error {
+	cover := bson.D{{Key: "$ifNull", Value: bson.A{
+		bson.D{{Key: "$arrayElemAt", Value: bson.A{"$images.url", 0}}},
+		bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{"$image", staleURL}}}, nil, "$image"}}},
+	}}}
+	pipeline := mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "image", Value: cover}}}}}
+
+	if _, err := prodCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: productID}}, pipeline); err != nil {
+		logger.Error("error syncing cover image", slog.Any("productID", productID), slog.Any("error", err))
+		return ErrCantUpdateImages
+	}
+
+	return nil
+}
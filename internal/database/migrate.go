@@ -0,0 +1,301 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"math"
+)
+
+var (
+	ErrCantMigratePrices    = errors.New("cannot migrate legacy prices")
+	ErrCantMigrateAddresses = errors.New("cannot migrate legacy addresses")
+	ErrCantMigrateRatings   = errors.New("cannot migrate legacy ratings")
+	ErrCantMigrateSearch    = errors.New("cannot migrate product search terms")
+)
+
+// searchMigrationBatch is how many products MigrateSearchTerms updates per
+// bulk write.
+const searchMigrationBatch = 500
+
+// legacyPrice converts a plain number, stored before prices carried a
+// currency, from major units into a money document. Values that already are
+// documents are passed through unchanged.
+func legacyPrice(field, currency string) bson.D {
+	scale := int64(math.Pow10(money.Exponent(currency)))
+
+	return bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$isNumber", Value: field}},
+		bson.D{
+			{Key: "amount", Value: bson.D{{Key: "$multiply", Value: bson.A{bson.D{{Key: "$toLong", Value: field}}, scale}}}},
+			{Key: "currency", Value: currency},
+		},
+		field,
+	}}}
+}
+
+func legacyItems(field, currency string) bson.D {
+	return bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{field, bson.A{}}}}},
+		{Key: "as", Value: "item"},
+		{Key: "in", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+			"$$item",
+			bson.D{{Key: "price", Value: legacyPrice("$$item.price", currency)}},
+		}}}},
+	}}}
+}
+
+// MigrateLegacyPrices rewrites numeric prices on products, carts and orders
+// into money documents in the store currency. It is idempotent and only
+// touches documents that still hold a numeric price.
+func MigrateLegacyPrices(ctx context.Context, prodCollection, userCollection *mongo.Collection, currency string) error {
+	productFilter := bson.D{{Key: "price", Value: bson.D{{Key: "$type", Value: "number"}}}}
+	productUpdate := mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "price", Value: legacyPrice("$price", currency)}}}}}
+
+	products, err := prodCollection.UpdateMany(ctx, productFilter, productUpdate)
+
+	if err != nil {
+		logger.Error("error migrating product prices", slog.Any("error", err))
+		return ErrCantMigratePrices
+	}
+
+	userFilter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "user_cart.price", Value: bson.D{{Key: "$type", Value: "number"}}}},
+		bson.D{{Key: "orders.total_price", Value: bson.D{{Key: "$type", Value: "number"}}}},
+		bson.D{{Key: "orders.discount", Value: bson.D{{Key: "$type", Value: "number"}}}},
+		bson.D{{Key: "orders.order_list.price", Value: bson.D{{Key: "$type", Value: "number"}}}},
+	}}}
+
+	order := bson.D{{Key: "$mergeObjects", Value: bson.A{
+		"$$order",
+		bson.D{
+			{Key: "total_price", Value: legacyPrice("$$order.total_price", currency)},
+			{Key: "discount", Value: legacyPrice("$$order.discount", currency)},
+			{Key: "order_list", Value: legacyItems("$$order.order_list", currency)},
+		},
+	}}}
+
+	userUpdate := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "user_cart", Value: legacyItems("$user_cart", currency)},
+		{Key: "orders", Value: bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$orders", bson.A{}}}}},
+			{Key: "as", Value: "order"},
+			{Key: "in", Value: order},
+		}}}},
+	}}}}
+
+	users, err := userCollection.UpdateMany(ctx, userFilter, userUpdate)
+
+	if err != nil {
+		logger.Error("error migrating user prices", slog.Any("error", err))
+		return ErrCantMigratePrices
+	}
+
+	if products.ModifiedCount > 0 || users.ModifiedCount > 0 {
+		logger.Info("legacy prices migrated",
+			slog.Int64("products", products.ModifiedCount),
+			slog.Int64("users", users.ModifiedCount),
+			slog.String("currency", currency),
+		)
+	}
+
+	return nil
+}
+
+// MigrateLegacyAddresses moves addresses saved as house, street, city and pin
+// code into the structured fields: house and street become line1 and the pin
+// code becomes the postal code. The country is unknown and stays empty, so
+// the address has to be completed on its next update. It is idempotent and
+// only touches users that still hold a legacy address.
+func MigrateLegacyAddresses(ctx context.Context, userCollection *mongo.Collection) error {
+	filter := bson.D{{Key: "address.house_name", Value: bson.D{{Key: "$exists", Value: true}}}}
+
+	line1 := bson.D{{Key: "$trim", Value: bson.D{{Key: "input", Value: bson.D{{Key: "$concat", Value: bson.A{
+		bson.D{{Key: "$ifNull", Value: bson.A{"$$address.house_name", ""}}},
+		" ",
+		bson.D{{Key: "$ifNull", Value: bson.A{"$$address.street_name", ""}}},
+	}}}}}}}
+
+	address := bson.D{{Key: "$mergeObjects", Value: bson.A{
+		"$$address",
+		bson.D{
+			{Key: "line1", Value: line1},
+			{Key: "city", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$address.city_name", ""}}}},
+			{Key: "postal_code", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$$address.pin_code", ""}}}},
+		},
+	}}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "address", Value: bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: "$address"},
+			{Key: "as", Value: "address"},
+			{Key: "in", Value: address},
+		}}}}}}},
+		{{Key: "$unset", Value: bson.A{"address.house_name", "address.street_name", "address.city_name", "address.pin_code"}}},
+	}
+
+	users, err := userCollection.UpdateMany(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error migrating addresses", slog.Any("error", err))
+		return ErrCantMigrateAddresses
+	}
+
+	if users.ModifiedCount > 0 {
+		logger.Info("legacy addresses migrated", slog.Int64("users", users.ModifiedCount))
+	}
+
+	return nil
+}
+
+// MigrateLegacyRatings gives products stored before ratings were aggregated
+// the sum, count and average of their approved reviews, or zeroes when they
+// have none, replacing whatever rating they held. It is idempotent and only
+// touches products without a rating_count.
+func MigrateLegacyRatings(ctx context.Context, prodCollection, reviewCollection *mongo.Collection) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "status", Value: models.ReviewApproved}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$product_id"},
+			{Key: "sum", Value: bson.D{{Key: "$sum", Value: "$rating"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cursor, err := reviewCollection.Aggregate(ctx, pipeline)
+
+	if err != nil {
+		logger.Error("error aggregating approved reviews", slog.Any("error", err))
+		return ErrCantMigrateRatings
+	}
+
+	var totals []struct {
+		ProductID primitive.ObjectID `bson:"_id"`
+		Sum       int64              `bson:"sum"`
+		Count     int64              `bson:"count"`
+	}
+
+	if err := cursor.All(ctx, &totals); err != nil {
+		logger.Error("error decoding approved review totals", slog.Any("error", err))
+		return ErrCantMigrateRatings
+	}
+
+	unmigrated := bson.E{Key: "rating_count", Value: bson.D{{Key: "$exists", Value: false}}}
+	writes := make([]mongo.WriteModel, 0, len(totals)+1)
+
+	for _, total := range totals {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: total.ProductID}, unmigrated}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: "rating", Value: float64(total.Sum) / float64(total.Count)},
+				{Key: "rating_count", Value: total.Count},
+				{Key: "rating_sum", Value: total.Sum},
+			}}}))
+	}
+
+	// Runs last, so it only reaches products without approved reviews.
+	writes = append(writes, mongo.NewUpdateManyModel().
+		SetFilter(bson.D{unmigrated}).
+		SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+			{Key: "rating", Value: 0},
+			{Key: "rating_count", Value: 0},
+			{Key: "rating_sum", Value: 0},
+		}}}))
+
+	result, err := prodCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(true))
+
+	if err != nil {
+		logger.Error("error migrating product ratings", slog.Any("error", err))
+		return ErrCantMigrateRatings
+	}
+
+	if result.ModifiedCount > 0 {
+		logger.Info("legacy ratings migrated", slog.Int64("products", result.ModifiedCount))
+	}
+
+	return nil
+}
+
+// MigrateSearchTerms indexes products stored before search existed under
+// their search terms and trigrams. It is idempotent and only touches products
+// without search_terms.
+func MigrateSearchTerms(ctx context.Context, prodCollection *mongo.Collection) error {
+	filter := bson.D{{Key: "search_terms", Value: bson.D{{Key: "$exists", Value: false}}}}
+	opts := options.Find().SetProjection(bson.D{{Key: "product_name", Value: 1}, {Key: "description", Value: 1}})
+
+	cursor, err := prodCollection.Find(ctx, filter, opts)
+
+	if err != nil {
+		logger.Error("error loading products to index", slog.Any("error", err))
+		return ErrCantMigrateSearch
+	}
+
+	defer cursor.Close(ctx)
+
+	var (
+		writes  []mongo.WriteModel
+		indexed int64
+	)
+
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+
+		result, err := prodCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+
+		if err != nil {
+			logger.Error("error indexing product search terms", slog.Any("error", err))
+			return ErrCantMigrateSearch
+		}
+
+		indexed += result.ModifiedCount
+		writes = writes[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var product models.Product
+
+		if err := cursor.Decode(&product); err != nil {
+			logger.Error("error decoding product to index", slog.Any("error", err))
+			return ErrCantMigrateSearch
+		}
+
+		terms := ProductSearchTerms(product)
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: product.ProductID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{
+				{Key: "search_terms", Value: terms},
+				{Key: "search_grams", Value: ProductSearchGrams(terms)},
+			}}}))
+
+		if len(writes) == searchMigrationBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		logger.Error("error iterating products to index", slog.Any("error", err))
+		return ErrCantMigrateSearch
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if indexed > 0 {
+		logger.Info("product search terms migrated", slog.Int64("products", indexed))
+	}
+
+	return nil
+}
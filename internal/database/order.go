@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+)
+
+var (
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrCantLoadOrders = errors.New("cannot load orders")
+)
+
+// ListOrders returns the orders a user placed, newest first.
+func ListOrders(ctx context.Context, userCollection *mongo.Collection, userID string) ([]models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return nil, ErrUserIDIsNotValid
+	}
+
+	var user models.User
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "orders", Value: 1}})
+	err = userCollection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, opts).Decode(&user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserIDIsNotValid
+	}
+
+	if err != nil {
+		logger.Error("error loading orders", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantLoadOrders
+	}
+
+	orders := make([]models.Order, 0, len(user.OrderStatus))
+
+	for i := len(user.OrderStatus) - 1; i >= 0; i-- {
+		orders = append(orders, user.OrderStatus[i])
+	}
+
+	return orders, nil
+}
+
+// UserOrder returns one order of a user. Orders of other users are not
+// found.
+func UserOrder(ctx context.Context, userCollection *mongo.Collection, userID string, orderID primitive.ObjectID) (*models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return nil, ErrUserIDIsNotValid
+	}
+
+	order, _, err := findOrder(ctx, userCollection, bson.D{{Key: "_id", Value: id}, {Key: "orders._id", Value: orderID}})
+
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil, ErrOrderNotFound
+	}
+
+	if err != nil {
+		return nil, ErrCantLoadOrders
+	}
+
+	return order, nil
+}
+
+// OrderByID returns any order together with the ID of the user who placed
+// it, for admins.
+func OrderByID(ctx context.Context, userCollection *mongo.Collection, orderID primitive.ObjectID) (*models.Order, string, error) {
+	order, userID, err := FindOrder(ctx, userCollection, orderID)
+
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil, "", ErrOrderNotFound
+	}
+
+	if err != nil {
+		return nil, "", ErrCantLoadOrders
+	}
+
+	return order, userID, nil
+}
@@ -0,0 +1,486 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/payment"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrPaymentDeclined     = errors.New("payment declined")
+	ErrPaymentFailed       = errors.New("payment could not be processed")
+	ErrPaymentNotFound     = errors.New("order payment not found")
+	ErrPaymentState        = errors.New("payment is not in a state that allows this")
+	ErrInvalidRefund       = errors.New("refund must be positive, in the order currency and at most what is left to refund")
+	ErrCantListTransaction = errors.New("cannot list payment transactions")
+	ErrPaymentMismatch     = errors.New("payment amount does not match the order total")
+)
+
+// Payments holds the providers checkout can charge with and the collection
+// every provider call is recorded in.
+type Payments struct {
+	Providers             *payment.Registry
+	TransactionCollection *mongo.Collection
+}
+
+// IsPaymentRejection reports whether err means the customer's payment was
+// refused or incomplete, as opposed to a provider or storage failure.
+func IsPaymentRejection(err error) bool {
+	return errors.Is(err, ErrPaymentDeclined) ||
+		errors.Is(err, payment.ErrUnknownProvider) ||
+		errors.Is(err, payment.ErrTokenRequired)
+}
+
+func recordTransaction(ctx context.Context, payments Payments, tx models.PaymentTransaction) error {
+	tx.TransactionID = primitive.NewObjectID()
+	tx.CreatedAt = time.Now()
+
+	_, err := payments.TransactionCollection.InsertOne(ctx, tx)
+
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		logger.Error("error recording payment transaction", slog.Any("orderID", tx.OrderID), slog.String("operation", tx.Operation), slog.Any("error", err))
+		return ErrPaymentFailed
+	}
+
+	return err
+}
+
+func transaction(order *models.Order, userID, operation string, result payment.Result, callErr error) models.PaymentTransaction {
+	tx := models.PaymentTransaction{
+		OrderID:       order.OrderID,
+		UserID:        userID,
+		Provider:      order.PaymentMethod.Provider,
+		Reference:     result.Reference,
+		Operation:     operation,
+		Status:        result.Status,
+		Amount:        result.Amount,
+		DeclineReason: result.DeclineReason,
+	}
+
+	if tx.Reference == "" {
+		tx.Reference = order.PaymentMethod.Reference
+	}
+
+	if callErr != nil {
+		tx.Error = callErr.Error()
+	}
+
+	return tx
+}
+
+// authorizeOrder charges an order with the method the customer chose,
+// cash on delivery when none was. Card payments are captured straight away;
+// cash is captured once it is collected.
+func authorizeOrder(ctx context.Context, payments Payments, req CheckoutRequest, order *models.Order) error {
+	method := req.PaymentMethod
+	if method == "" {
+		method = payment.ProviderCOD
+	}
+
+	provider, err := payments.Providers.Get(method)
+
+	if err != nil {
+		return err
+	}
+
+	amount, refunded := order.Price, money.Zero(order.Price.Currency)
+	order.PaymentMethod = models.Payment{
+		Digital:  provider.Name() != payment.ProviderCOD,
+		COD:      provider.Name() == payment.ProviderCOD,
+		Provider: provider.Name(),
+		Amount:   &amount,
+		Refunded: &refunded,
+	}
+
+	result, err := provider.Authorize(ctx, payment.Request{OrderID: order.OrderID.Hex(), Amount: order.Price, Token: req.PaymentToken})
+
+	if errors.Is(err, payment.ErrTokenRequired) {
+		return err
+	}
+
+	_ = recordTransaction(ctx, payments, transaction(order, req.UserID, models.TransactionAuthorize, result, err))
+
+	if err != nil {
+		logger.Error("error authorizing payment", slog.Any("orderID", order.OrderID), slog.String("provider", provider.Name()), slog.Any("error", err))
+		return ErrPaymentFailed
+	}
+
+	if result.Status == payment.StatusDeclined {
+		logger.Warn("payment declined", slog.Any("orderID", order.OrderID), slog.String("provider", provider.Name()), slog.String("reason", result.DeclineReason))
+		return ErrPaymentDeclined
+	}
+
+	order.PaymentMethod.Reference = result.Reference
+	order.PaymentMethod.Status = result.Status
+
+	if !order.PaymentMethod.Digital || result.Status != payment.StatusAuthorized {
+		return nil
+	}
+
+	if order.Price.IsZero() {
+		order.PaymentMethod.Status = payment.StatusCaptured
+		return nil
+	}
+
+	captured, err := provider.Capture(ctx, result.Reference, order.Price)
+	_ = recordTransaction(ctx, payments, transaction(order, req.UserID, models.TransactionCapture, captured, err))
+
+	if err != nil {
+		// The authorization stands; an admin can capture it later.
+		logger.Warn("error capturing payment", slog.Any("orderID", order.OrderID), slog.Any("error", err))
+		return nil
+	}
+
+	order.PaymentMethod.Status = payment.StatusCaptured
+	return nil
+}
+
+// cancelOrderPayment gives the money back for an order that could not be
+// placed after it was paid for.
+func cancelOrderPayment(ctx context.Context, payments Payments, order *models.Order, userID string) {
+	provider, err := payments.Providers.Get(order.PaymentMethod.Provider)
+
+	if err != nil {
+		return
+	}
+
+	var (
+		result    payment.Result
+		operation string
+	)
+
+	switch order.PaymentMethod.Status {
+	case payment.StatusAuthorized, payment.StatusPending:
+		operation = models.TransactionVoid
+		result, err = provider.Void(ctx, order.PaymentMethod.Reference)
+	case payment.StatusCaptured:
+		if order.Price.IsZero() {
+			return
+		}
+		operation = models.TransactionRefund
+		result, err = provider.Refund(ctx, order.PaymentMethod.Reference, order.Price)
+	default:
+		return
+	}
+
+	_ = recordTransaction(ctx, payments, transaction(order, userID, operation, result, err))
+
+	if err != nil {
+		logger.Error("error cancelling payment of unplaced order", slog.Any("orderID", order.OrderID), slog.String("operation", operation), slog.Any("error", err))
+	}
+}
+
+// findOrder loads the order with orderID, or the order paid with reference,
+// together with the ID of the user who placed it.
+func findOrder(ctx context.Context, userCollection *mongo.Collection, filter bson.D) (*models.Order, string, error) {
+	var user models.User
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "orders.$", Value: 1}})
+	err := userCollection.FindOne(ctx, filter, opts).Decode(&user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && len(user.OrderStatus) == 0) {
+		return nil, "", ErrPaymentNotFound
+	}
+
+	if err != nil {
+		logger.Error("error finding order", slog.Any("error", err))
+		return nil, "", ErrPaymentFailed
+	}
+
+	return &user.OrderStatus[0], user.ID.Hex(), nil
+}
+
+func FindOrder(ctx context.Context, userCollection *mongo.Collection, orderID primitive.ObjectID) (*models.Order, string, error) {
+	return findOrder(ctx, userCollection, bson.D{{Key: "orders._id", Value: orderID}})
+}
+
+// swapPayment replaces an order's payment with to, provided it still is
+// from, and sets the other order fields in also along with it. It is the
+// lock every payment state change goes through.
+func swapPayment(ctx context.Context, userCollection *mongo.Collection, orderID primitive.ObjectID, from, to models.Payment, also ...bson.E) error {
+	filter := bson.D{{Key: "orders", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "_id", Value: orderID},
+		{Key: "payment_method", Value: from},
+	}}}}}
+	update := bson.D{{Key: "$set", Value: append(bson.D{{Key: "orders.$.payment_method", Value: to}}, also...)}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error updating order payment", slog.Any("orderID", orderID), slog.Any("error", err))
+		return ErrPaymentFailed
+	}
+
+	if result.ModifiedCount == 0 {
+		return ErrPaymentState
+	}
+
+	return nil
+}
+
+// changePayment moves an order's payment to a new state and then asks the
+// provider to do the same, rolling the order back if the provider refuses.
+func changePayment(ctx context.Context, userCollection *mongo.Collection, payments Payments, order *models.Order, userID string, to models.Payment, operation string, call func(payment.Provider) (payment.Result, error)) (*models.Payment, error) {
+	provider, err := payments.Providers.Get(order.PaymentMethod.Provider)
+
+	if err != nil {
+		return nil, ErrPaymentState
+	}
+
+	if err := swapPayment(ctx, userCollection, order.OrderID, order.PaymentMethod, to); err != nil {
+		return nil, err
+	}
+
+	result, err := call(provider)
+	_ = recordTransaction(ctx, payments, transaction(order, userID, operation, result, err))
+
+	if err != nil {
+		logger.Error("payment provider refused change", slog.Any("orderID", order.OrderID), slog.String("operation", operation), slog.Any("error", err))
+
+		if err := swapPayment(ctx, userCollection, order.OrderID, to, order.PaymentMethod); err != nil {
+			logger.Error("error rolling back order payment", slog.Any("orderID", order.OrderID), slog.Any("error", err))
+		}
+
+		if errors.Is(err, payment.ErrInvalidTransition) {
+			return nil, ErrPaymentState
+		}
+
+		return nil, ErrPaymentFailed
+	}
+
+	logger.Info("order payment updated", slog.Any("orderID", order.OrderID), slog.String("operation", operation), slog.String("status", to.Status))
+	return &to, nil
+}
+
+// CapturePayment collects the authorized amount of an order, for cash on
+// delivery once the courier has it.
+func CapturePayment(ctx context.Context, userCollection *mongo.Collection, payments Payments, orderID primitive.ObjectID) (*models.Payment, error) {
+	order, userID, err := FindOrder(ctx, userCollection, orderID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	current := order.PaymentMethod
+
+	if current.Status != payment.StatusAuthorized || current.Amount == nil {
+		return nil, ErrPaymentState
+	}
+
+	to := current
+	to.Status = payment.StatusCaptured
+
+	return changePayment(ctx, userCollection, payments, order, userID, to, models.TransactionCapture, func(p payment.Provider) (payment.Result, error) {
+		return p.Capture(ctx, current.Reference, *current.Amount)
+	})
+}
+
+// VoidPayment releases an authorization that has not been captured.
+func VoidPayment(ctx context.Context, userCollection *mongo.Collection, payments Payments, orderID primitive.ObjectID) (*models.Payment, error) {
+	order, userID, err := FindOrder(ctx, userCollection, orderID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	current := order.PaymentMethod
+
+	if current.Status != payment.StatusAuthorized && current.Status != payment.StatusPending {
+		return nil, ErrPaymentState
+	}
+
+	to := current
+	to.Status = payment.StatusVoided
+
+	return changePayment(ctx, userCollection, payments, order, userID, to, models.TransactionVoid, func(p payment.Provider) (payment.Result, error) {
+		return p.Void(ctx, current.Reference)
+	})
+}
+
+// RefundPayment pays back amount of a captured order, or everything not yet
+// refunded when amount is nil.
+func RefundPayment(ctx context.Context, userCollection *mongo.Collection, payments Payments, orderID primitive.ObjectID, amount *money.Money) (*models.Payment, error) {
+	order, userID, err := FindOrder(ctx, userCollection, orderID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	current := order.PaymentMethod
+
+	if (current.Status != payment.StatusCaptured && current.Status != payment.StatusPartiallyRefunded) || current.Amount == nil || current.Refunded == nil {
+		return nil, ErrPaymentState
+	}
+
+	left, err := current.Amount.Sub(*current.Refunded)
+
+	if err != nil {
+		return nil, ErrPaymentFailed
+	}
+
+	refund := left
+	if amount != nil {
+		refund = *amount
+	}
+
+	if refund.Currency != left.Currency || refund.Amount <= 0 || refund.Amount > left.Amount {
+		return nil, ErrInvalidRefund
+	}
+
+	refunded, err := current.Refunded.Add(refund)
+
+	if err != nil {
+		return nil, ErrPaymentFailed
+	}
+
+	to := current
+	to.Refunded = &refunded
+	to.Status = payment.StatusPartiallyRefunded
+
+	if refunded.Amount == current.Amount.Amount {
+		to.Status = payment.StatusRefunded
+	}
+
+	return changePayment(ctx, userCollection, payments, order, userID, to, models.TransactionRefund, func(p payment.Provider) (payment.Result, error) {
+		return p.Refund(ctx, current.Reference, refund)
+	})
+}
+
+// HandlePaymentEvent settles a pending payment from a provider webhook.
+// Deliveries of an event already handled are ignored. The event is recorded
+// first, which claims it, and the record is deleted again when the order
+// cannot be updated, so the provider's redelivery is handled instead of
+// ignored. An authorization must be for the order's charged total. A
+// declined payment cancels the order in the same update, so an order that
+// was never paid for is not fulfilled.
+func HandlePaymentEvent(ctx context.Context, userCollection *mongo.Collection, payments Payments, providerName string, event payment.Event) error {
+	order, userID, err := findOrder(ctx, userCollection, bson.D{{Key: "orders.payment_method.reference", Value: event.Reference}})
+
+	if err != nil {
+		return err
+	}
+
+	if order.PaymentMethod.Provider != providerName {
+		return ErrPaymentNotFound
+	}
+
+	if event.Status == payment.StatusAuthorized && event.Amount != order.Price {
+		logger.Error("payment webhook amount does not match order", slog.Any("orderID", order.OrderID), slog.String("eventID", event.ID),
+			slog.String("amount", event.Amount.String()), slog.String("total", order.Price.String()))
+		return ErrPaymentMismatch
+	}
+
+	result := payment.Result{Reference: event.Reference, Status: event.Status, Amount: event.Amount, DeclineReason: event.DeclineReason}
+	tx := transaction(order, userID, models.TransactionWebhook, result, nil)
+	tx.EventID = event.ID
+
+	if err := recordTransaction(ctx, payments, tx); mongo.IsDuplicateKeyError(err) {
+		logger.Info("payment webhook already handled", slog.String("eventID", event.ID))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	current := order.PaymentMethod
+
+	if current.Status != payment.StatusPending {
+		logger.Info("payment webhook for settled payment", slog.Any("orderID", order.OrderID), slog.String("status", current.Status))
+		return nil
+	}
+
+	to := current
+
+	var also []bson.E
+
+	switch event.Status {
+	case payment.StatusAuthorized:
+		to.Status = payment.StatusAuthorized
+	case payment.StatusDeclined:
+		to.Status = payment.StatusDeclined
+		also = []bson.E{
+			{Key: "orders.$.status", Value: models.OrderCancelled},
+			{Key: "orders.$.cancel_reason", Value: models.CancelPaymentDeclined},
+		}
+	default:
+		return nil
+	}
+
+	if err := swapPayment(ctx, userCollection, order.OrderID, current, to, also...); err != nil {
+		forgetEvent(payments, event.ID)
+		return err
+	}
+
+	logger.Info("pending payment settled", slog.Any("orderID", order.OrderID), slog.String("status", to.Status))
+
+	if to.Status == payment.StatusDeclined {
+		logger.Warn("order cancelled after payment was declined", slog.Any("orderID", order.OrderID), slog.String("reason", event.DeclineReason))
+	}
+
+	if to.Status == payment.StatusAuthorized && to.Digital {
+		if _, err := CapturePayment(ctx, userCollection, payments, order.OrderID); err != nil {
+			logger.Warn("error capturing settled payment", slog.Any("orderID", order.OrderID), slog.Any("error", err))
+		}
+	}
+
+	return nil
+}
+
+// forgetEvent deletes the record of a webhook event whose state change
+// failed, so a redelivery of it is handled again.
+func forgetEvent(payments Payments, eventID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := payments.TransactionCollection.DeleteOne(ctx, bson.D{{Key: "event_id", Value: eventID}}); err != nil {
+		logger.Error("error forgetting payment webhook", slog.String("eventID", eventID), slog.Any("error", err))
+	}
+}
+
+func ListPaymentTransactions(ctx context.Context, transactionCollection *mongo.Collection, orderID primitive.ObjectID) ([]models.PaymentTransaction, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := transactionCollection.Find(ctx, bson.D{{Key: "order_id", Value: orderID}}, opts)
+
+	if err != nil {
+		logger.Error("error listing payment transactions", slog.Any("orderID", orderID), slog.Any("error", err))
+		return nil, ErrCantListTransaction
+	}
+
+	transactions := make([]models.PaymentTransaction, 0)
+
+	if err := cursor.All(ctx, &transactions); err != nil {
+		logger.Error("error decoding payment transactions", slog.Any("orderID", orderID), slog.Any("error", err))
+		return nil, ErrCantListTransaction
+	}
+
+	return transactions, nil
+}
+
+func EnsurePaymentIndexes(ctx context.Context, transactionCollection *mongo.Collection) error {
+	_, err := transactionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "event_id", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "event_id", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+		},
+	})
+
+	if err != nil {
+		logger.Error("error creating payment indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("payment indexes ensured")
+	return nil
+}
@@ -0,0 +1,422 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+	DefaultSort      = "newest"
+)
+
+var (
+	ErrInvalidPageToken   = errors.New("invalid page token")
+	ErrInvalidSort        = errors.New("invalid sort option")
+	ErrCantListProducts   = errors.New("can't list products")
+	ErrCantCreateIndexes  = errors.New("can't create indexes")
+	ErrProductCursorState = errors.New("product cursor is in a bad state")
+	ErrCantUpsertProducts = errors.New("can't upsert products")
+)
+
+type productSort struct {
+	field string
+	order int
+}
+
+var productSorts = map[string]productSort{
+	"price_asc":  {field: "price.amount", order: 1},
+	"price_desc": {field: "price.amount", order: -1},
+	"rating":     {field: "rating", order: -1},
+	"newest":     {field: "created_at", order: -1},
+}
+
+// ProductQuery describes a single page request against the product catalog.
+type ProductQuery struct {
+	Sort      string
+	Limit     int
+	PageToken string
+	MinPrice  *int64
+	MaxPrice  *int64
+	MinRating *uint8
+	Category  string
+	InStock   *bool
+}
+
+type ProductPage struct {
+	Items         []models.Product `json:"items"`
+	NextPageToken string           `json:"next_page_token,omitempty"`
+	HasMore       bool             `json:"has_more"`
+}
+
+// pageCursor is the decoded form of an opaque page token: the sort it was
+// issued for and the sort key and _id of the last product on the page.
+type pageCursor struct {
+	Sort  string             `bson:"s"`
+	Value bson.RawValue      `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func encodePageToken(cur pageCursor) (string, error) {
+	raw, err := bson.Marshal(cur)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodePageToken(token string) (pageCursor, error) {
+	var cur pageCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+
+	if err != nil {
+		return cur, ErrInvalidPageToken
+	}
+
+	if err := bson.Unmarshal(raw, &cur); err != nil {
+		return cur, ErrInvalidPageToken
+	}
+
+	return cur, nil
+}
+
+func productFilter(q ProductQuery) bson.D {
+	filter := bson.D{}
+
+	price := bson.D{}
+	if q.MinPrice != nil {
+		price = append(price, bson.E{Key: "$gte", Value: *q.MinPrice})
+	}
+	if q.MaxPrice != nil {
+		price = append(price, bson.E{Key: "$lte", Value: *q.MaxPrice})
+	}
+	if len(price) > 0 {
+		filter = append(filter, bson.E{Key: "price.amount", Value: price})
+	}
+
+	if q.MinRating != nil {
+		filter = append(filter, bson.E{Key: "rating", Value: bson.D{{Key: "$gte", Value: *q.MinRating}}})
+	}
+
+	if q.Category != "" {
+		filter = append(filter, bson.E{Key: "category", Value: q.Category})
+	}
+
+	if q.InStock != nil {
+		if *q.InStock {
+			filter = append(filter, bson.E{Key: "stock", Value: bson.D{{Key: "$gt", Value: 0}}})
+		} else {
+			filter = append(filter, bson.E{Key: "stock", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 0}}}}})
+		}
+	}
+
+	return filter
+}
+
+// keysetFilter restricts the result to products strictly after the cursor in
+// the given sort order, using _id as the tie breaker. Products without the
+// sort field sort before every value, so they come first in ascending order
+// and last in descending order; comparison operators never match them, so
+// they get their own branches.
+func keysetFilter(sort productSort, cur pageCursor) bson.D {
+	op := "$gt"
+	if sort.order < 0 {
+		op = "$lt"
+	}
+
+	missing := bson.D{{Key: sort.field, Value: nil}}
+	tie := bson.D{{Key: sort.field, Value: cur.Value}, {Key: "_id", Value: bson.D{{Key: op, Value: cur.ID}}}}
+
+	if cur.Value.Type == bson.TypeNull || cur.Value.Type == bson.TypeUndefined || cur.Value.Type == 0 {
+		tie = bson.D{{Key: "$and", Value: bson.A{missing, bson.D{{Key: "_id", Value: bson.D{{Key: op, Value: cur.ID}}}}}}}
+
+		if sort.order < 0 {
+			return tie
+		}
+
+		return bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: sort.field, Value: bson.D{{Key: "$ne", Value: nil}}}},
+			tie,
+		}}}
+	}
+
+	after := bson.A{
+		bson.D{{Key: sort.field, Value: bson.D{{Key: op, Value: cur.Value}}}},
+		tie,
+	}
+
+	if sort.order < 0 {
+		after = append(after, missing)
+	}
+
+	return bson.D{{Key: "$or", Value: after}}
+}
+
+func ListProducts(ctx context.Context, prodCollection *mongo.Collection, q ProductQuery) (*ProductPage, error) {
+	if q.Sort == "" {
+		q.Sort = DefaultSort
+	}
+
+	sort, ok := productSorts[q.Sort]
+
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageLimit
+	}
+	if q.Limit > MaxPageLimit {
+		q.Limit = MaxPageLimit
+	}
+
+	filter := productFilter(q)
+
+	if q.PageToken != "" {
+		cur, err := decodePageToken(q.PageToken)
+
+		if err != nil || cur.Sort != q.Sort {
+			logger.Warn("rejected page token", slog.String("sort", q.Sort))
+			return nil, ErrInvalidPageToken
+		}
+
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, keysetFilter(sort, cur)}}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: sort.field, Value: sort.order}, {Key: "_id", Value: sort.order}}).
+		SetLimit(int64(q.Limit + 1))
+
+	cursor, err := prodCollection.Find(ctx, filter, opts)
+
+	if err != nil {
+		logger.Error("error listing products", slog.Any("error", err))
+		return nil, ErrCantListProducts
+	}
+
+	defer cursor.Close(ctx)
+
+	page := &ProductPage{Items: make([]models.Product, 0, q.Limit)}
+	var last bson.Raw
+
+	for cursor.Next(ctx) {
+		if len(page.Items) == q.Limit {
+			page.HasMore = true
+			break
+		}
+
+		var product models.Product
+
+		if err := cursor.Decode(&product); err != nil {
+			logger.Error("error decoding product", slog.Any("error", err))
+			return nil, ErrCantDecodeProducts
+		}
+
+		page.Items = append(page.Items, product)
+		last = append(last[:0], cursor.Current...)
+	}
+
+	if err := cursor.Err(); err != nil {
+		logger.Error("product cursor error", slog.Any("error", err))
+		return nil, ErrProductCursorState
+	}
+
+	if page.HasMore {
+		value, err := last.LookupErr(strings.Split(sort.field, ".")...)
+
+		if err != nil {
+			value = bson.RawValue{Type: bson.TypeNull}
+		}
+
+		token, err := encodePageToken(pageCursor{
+			Sort:  q.Sort,
+			Value: value,
+			ID:    page.Items[len(page.Items)-1].ProductID,
+		})
+
+		if err != nil {
+			logger.Error("error encoding page token", slog.Any("error", err))
+			return nil, ErrCantListProducts
+		}
+
+		page.NextPageToken = token
+	}
+
+	return page, nil
+}
+
+// EnsureProductIndexes creates the indexes backing every supported sort and
+// filter of ListProducts.
+func EnsureProductIndexes(ctx context.Context, prodCollection *mongo.Collection) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "price.amount", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "rating", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "category", Value: 1}, {Key: "price.amount", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "stock", Value: 1}}},
+		{Keys: bson.D{{Key: "search_grams", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "sku", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "sku", Value: bson.D{{Key: "$type", Value: "string"}}}}),
+		},
+		{
+			Keys: bson.D{{Key: "product_name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().
+				SetName("product_text").
+				SetWeights(bson.D{{Key: "product_name", Value: 10}, {Key: "description", Value: 2}}),
+		},
+	}
+
+	_, err := prodCollection.Indexes().CreateMany(ctx, indexes)
+
+	if err != nil {
+		logger.Error("error creating product indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("product indexes ensured")
+	return nil
+}
+
+// UpsertProductsBySKU writes products in one unordered bulk operation, keyed by
+// SKU. Catalog fields are overwritten; identity, ratings and images are only
+// initialised when a product is created.
+func UpsertProductsBySKU(ctx context.Context, prodCollection *mongo.Collection, products []models.Product) (created, updated int64, err error) {
+	if len(products) == 0 {
+		return 0, 0, nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(products))
+	now := time.Now()
+
+	for _, product := range products {
+		terms := ProductSearchTerms(product)
+		set := bson.D{
+			{Key: "product_name", Value: product.ProductName},
+			{Key: "description", Value: product.Description},
+			{Key: "price", Value: product.Price},
+			{Key: "category", Value: product.Category},
+			{Key: "stock", Value: product.Stock},
+			{Key: "search_terms", Value: terms},
+			{Key: "search_grams", Value: ProductSearchGrams(terms)},
+		}
+
+		if product.TaxClass != nil {
+			set = append(set, bson.E{Key: "tax_class", Value: product.TaxClass})
+		}
+
+		if product.WeightGrams != nil {
+			set = append(set, bson.E{Key: "weight_grams", Value: product.WeightGrams})
+		}
+
+		if product.Image != nil {
+			set = append(set, bson.E{Key: "image", Value: product.Image})
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "sku", Value: *product.SKU}}).
+			SetUpdate(bson.D{
+				{Key: "$set", Value: set},
+				{Key: "$setOnInsert", Value: bson.D{
+					{Key: "_id", Value: primitive.NewObjectID()},
+					{Key: "created_at", Value: now},
+					{Key: "rating", Value: 0},
+					{Key: "rating_count", Value: 0},
+					{Key: "rating_sum", Value: 0},
+					{Key: "images", Value: bson.A{}},
+				}},
+			}).
+			SetUpsert(true))
+	}
+
+	result, err := prodCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+
+	if err != nil {
+		logger.Error("error upserting products", slog.Any("error", err))
+		return 0, 0, ErrCantUpsertProducts
+	}
+
+	return result.UpsertedCount, result.MatchedCount, nil
+}
+
+// ExistingSKUs reports which of the given SKUs are already in the catalog.
+func ExistingSKUs(ctx context.Context, prodCollection *mongo.Collection, skus []string) (map[string]bool, error) {
+	existing := make(map[string]bool, len(skus))
+
+	if len(skus) == 0 {
+		return existing, nil
+	}
+
+	opts := options.Find().SetProjection(bson.D{{Key: "sku", Value: 1}})
+	cursor, err := prodCollection.Find(ctx, bson.D{{Key: "sku", Value: bson.D{{Key: "$in", Value: skus}}}}, opts)
+
+	if err != nil {
+		logger.Error("error looking up SKUs", slog.Any("error", err))
+		return nil, ErrCantFindProduct
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if sku, ok := cursor.Current.Lookup("sku").StringValueOK(); ok {
+			existing[sku] = true
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		logger.Error("SKU cursor error", slog.Any("error", err))
+		return nil, ErrProductCursorState
+	}
+
+	return existing, nil
+}
+
+// StreamProducts calls fn for every product in _id order without loading
+// the catalog into memory. It stops at the first error returned by fn.
+func StreamProducts(ctx context.Context, prodCollection *mongo.Collection, fn func(models.Product) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(500)
+	cursor, err := prodCollection.Find(ctx, bson.D{}, opts)
+
+	if err != nil {
+		logger.Error("error streaming products", slog.Any("error", err))
+		return ErrCantListProducts
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product models.Product
+
+		if err := cursor.Decode(&product); err != nil {
+			logger.Error("error decoding product", slog.Any("error", err))
+			return ErrCantDecodeProducts
+		}
+
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		logger.Error("product cursor error", slog.Any("error", err))
+		return ErrProductCursorState
+	}
+
+	return nil
+}
@@ -0,0 +1,504 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPromotionNotFound   = errors.New("promotion not found")
+	ErrPromotionBuyXGetY   = errors.New("buy_x_get_y promotions need buy_quantity, get_quantity and get_percent_off between 1 and 100")
+	ErrPromotionBundle     = errors.New("bundle promotions need at least two distinct bundle_product_ids and a positive bundle_price in the store currency")
+	ErrPromotionTiers      = errors.New("spend_threshold promotions need tiers with a positive min_subtotal in the store currency and either percent_off or amount_off")
+	ErrPromotionWindow     = errors.New("ends_at must be after starts_at")
+	ErrCantSavePromotion   = errors.New("cannot save promotion")
+	ErrCantListPromotions  = errors.New("cannot list promotions")
+	ErrCantApplyPromotions = errors.New("cannot apply promotions")
+)
+
+// PromotionResult is the outcome of running the promotion rules over a cart.
+// Net holds each line's price after item level promotions, which is what
+// coupons are applied to.
+type PromotionResult struct {
+	Applied  []models.AppliedPromotion
+	Net      []money.Money
+	Discount money.Money
+}
+
+// ValidatePromotion checks the fields required by the promotion type.
+func ValidatePromotion(promotion *models.Promotion, currency string) error {
+	switch promotion.Type {
+	case models.PromotionBuyXGetY:
+		if promotion.BuyQuantity < 1 || promotion.GetQuantity < 1 || promotion.GetPercentOff < 1 {
+			return ErrPromotionBuyXGetY
+		}
+	case models.PromotionBundle:
+		distinct := make(map[primitive.ObjectID]bool, len(promotion.BundleProductIDs))
+		for _, id := range promotion.BundleProductIDs {
+			distinct[id] = true
+		}
+
+		price := promotion.BundlePrice
+		if len(distinct) < 2 || len(distinct) != len(promotion.BundleProductIDs) || price == nil || price.Currency != currency || price.Amount <= 0 {
+			return ErrPromotionBundle
+		}
+	case models.PromotionSpendThreshold:
+		if len(promotion.Tiers) == 0 {
+			return ErrPromotionTiers
+		}
+
+		for _, tier := range promotion.Tiers {
+			hasPercent := tier.PercentOff > 0
+			hasAmount := tier.AmountOff != nil
+
+			if tier.MinSubtotal.Currency != currency || tier.MinSubtotal.Amount <= 0 || hasPercent == hasAmount {
+				return ErrPromotionTiers
+			}
+
+			if hasAmount && (tier.AmountOff.Currency != currency || tier.AmountOff.Amount <= 0) {
+				return ErrPromotionTiers
+			}
+		}
+	}
+
+	if promotion.StartsAt != nil && promotion.EndsAt != nil && !promotion.EndsAt.After(*promotion.StartsAt) {
+		return ErrPromotionWindow
+	}
+
+	return nil
+}
+
+func CreatePromotion(ctx context.Context, promotionCollection *mongo.Collection, promotion *models.Promotion) error {
+	promotion.PromotionID = primitive.NewObjectID()
+	promotion.CreatedAt = time.Now()
+
+	_, err := promotionCollection.InsertOne(ctx, promotion)
+
+	if err != nil {
+		logger.Error("error inserting promotion", slog.String("name", promotion.Name), slog.Any("error", err))
+		return ErrCantSavePromotion
+	}
+
+	logger.Info("promotion created", slog.Any("promotionID", promotion.PromotionID), slog.String("type", promotion.Type))
+	return nil
+}
+
+func ListPromotions(ctx context.Context, promotionCollection *mongo.Collection) ([]models.Promotion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}})
+	cursor, err := promotionCollection.Find(ctx, bson.D{}, opts)
+
+	if err != nil {
+		logger.Error("error listing promotions", slog.Any("error", err))
+		return nil, ErrCantListPromotions
+	}
+
+	promotions := make([]models.Promotion, 0)
+
+	if err := cursor.All(ctx, &promotions); err != nil {
+		logger.Error("error decoding promotions", slog.Any("error", err))
+		return nil, ErrCantListPromotions
+	}
+
+	return promotions, nil
+}
+
+func SetPromotionActive(ctx context.Context, promotionCollection *mongo.Collection, promotionID primitive.ObjectID, active bool) (*models.Promotion, error) {
+	var promotion models.Promotion
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: active}}}}
+
+	err := promotionCollection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: promotionID}}, update, opts).Decode(&promotion)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrPromotionNotFound
+	}
+
+	if err != nil {
+		logger.Error("error updating promotion", slog.Any("promotionID", promotionID), slog.Any("error", err))
+		return nil, ErrCantSavePromotion
+	}
+
+	logger.Info("promotion updated", slog.Any("promotionID", promotionID), slog.Bool("active", active))
+	return &promotion, nil
+}
+
+// ActivePromotions returns the promotions running at now.
+func ActivePromotions(ctx context.Context, promotionCollection *mongo.Collection, now time.Time) ([]models.Promotion, error) {
+	filter := bson.D{
+		{Key: "active", Value: true},
+		{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "starts_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "starts_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+			}}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "ends_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "ends_at", Value: bson.D{{Key: "$gt", Value: now}}}},
+			}}},
+		}},
+	}
+
+	cursor, err := promotionCollection.Find(ctx, filter)
+
+	if err != nil {
+		logger.Error("error loading active promotions", slog.Any("error", err))
+		return nil, ErrCantApplyPromotions
+	}
+
+	promotions := make([]models.Promotion, 0)
+
+	if err := cursor.All(ctx, &promotions); err != nil {
+		logger.Error("error decoding active promotions", slog.Any("error", err))
+		return nil, ErrCantApplyPromotions
+	}
+
+	return promotions, nil
+}
+
+// EvaluatePromotions applies promotions to items whose display prices are
+// set in currency. The stacking rules are fixed so the same cart always gets
+// the same result:
+//
+//   - promotions run by priority, highest first, then oldest first, then by id;
+//   - every cart unit takes part in at most one buy_x_get_y or bundle promotion;
+//   - spend thresholds apply to the subtotal left after earlier promotions and
+//     only the highest tier reached counts;
+//   - an exclusive promotion that applies stops all later ones.
+func EvaluatePromotions(promotions []models.Promotion, items []models.ProductUser, rates *money.RateTable, currency string) (*PromotionResult, error) {
+	ordered := append([]models.Promotion(nil), promotions...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.PromotionID.Hex() < b.PromotionID.Hex()
+	})
+
+	ev := &promotionEvaluator{
+		items:    items,
+		rates:    rates,
+		currency: currency,
+		used:     make([]bool, len(items)),
+		net:      make([]money.Money, len(items)),
+		result:   &PromotionResult{Applied: make([]models.AppliedPromotion, 0), Discount: money.Zero(currency)},
+
+		orderDiscount: money.Zero(currency),
+	}
+
+	for i, item := range items {
+		if item.DisplayPrice == nil {
+			return nil, ErrCantApplyPromotions
+		}
+		ev.net[i] = *item.DisplayPrice
+	}
+
+	for i := range ordered {
+		promotion := &ordered[i]
+
+		var (
+			applied *models.AppliedPromotion
+			err     error
+		)
+
+		switch promotion.Type {
+		case models.PromotionBuyXGetY:
+			applied, err = ev.buyXGetY(promotion)
+		case models.PromotionBundle:
+			applied, err = ev.bundle(promotion)
+		case models.PromotionSpendThreshold:
+			applied, err = ev.spendThreshold(promotion)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if applied == nil || applied.Discount.IsZero() {
+			continue
+		}
+
+		applied.PromotionID = promotion.PromotionID
+		applied.Name = promotion.Name
+		applied.Type = promotion.Type
+		ev.result.Applied = append(ev.result.Applied, *applied)
+
+		if ev.result.Discount, err = ev.result.Discount.Add(applied.Discount); err != nil {
+			return nil, err
+		}
+
+		if promotion.Exclusive {
+			break
+		}
+	}
+
+	ev.result.Net = ev.net
+	return ev.result, nil
+}
+
+type promotionEvaluator struct {
+	items    []models.ProductUser
+	rates    *money.RateTable
+	currency string
+	used     []bool
+	net      []money.Money
+	result   *PromotionResult
+
+	// orderDiscount is what spend thresholds took off the cart as a whole.
+	orderDiscount money.Money
+}
+
+func promotionCovers(promotion *models.Promotion, item models.ProductUser) bool {
+	if len(promotion.ProductIDs) == 0 && len(promotion.Categories) == 0 {
+		return true
+	}
+
+	for _, id := range promotion.ProductIDs {
+		if id == item.ProductID {
+			return true
+		}
+	}
+
+	if item.Category != nil {
+		for _, category := range promotion.Categories {
+			if strings.EqualFold(category, *item.Category) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// discountLine takes amount off line i and records the product.
+func (ev *promotionEvaluator) discountLine(applied *models.AppliedPromotion, i int, amount money.Money) error {
+	var err error
+
+	if ev.net[i], err = ev.net[i].Sub(amount); err != nil {
+		return err
+	}
+
+	if applied.Discount, err = applied.Discount.Add(amount); err != nil {
+		return err
+	}
+
+	for _, id := range applied.ProductIDs {
+		if id == ev.items[i].ProductID {
+			return nil
+		}
+	}
+
+	applied.ProductIDs = append(applied.ProductIDs, ev.items[i].ProductID)
+	return nil
+}
+
+// buyXGetY sorts matching units from most to least expensive and groups them
+// by buy plus get; the cheapest get units of every full group are discounted.
+func (ev *promotionEvaluator) buyXGetY(promotion *models.Promotion) (*models.AppliedPromotion, error) {
+	group := int(promotion.BuyQuantity + promotion.GetQuantity)
+	if group <= 0 {
+		return nil, nil
+	}
+
+	var candidates []int
+
+	for i, item := range ev.items {
+		if !ev.used[i] && promotionCovers(promotion, item) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return ev.net[candidates[a]].Amount > ev.net[candidates[b]].Amount
+	})
+
+	applied := &models.AppliedPromotion{Discount: money.Zero(ev.currency)}
+
+	for start := 0; start+group <= len(candidates); start += group {
+		for k, i := range candidates[start : start+group] {
+			ev.used[i] = true
+
+			if k < int(promotion.BuyQuantity) {
+				continue
+			}
+
+			amount, err := ev.net[i].Percent(promotion.GetPercentOff)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := ev.discountLine(applied, i, amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return applied, nil
+}
+
+// bundle prices every complete set of the bundle products at the bundle
+// price. The saving is spread over the set in proportion to line prices.
+func (ev *promotionEvaluator) bundle(promotion *models.Promotion) (*models.AppliedPromotion, error) {
+	bundlePrice, _, err := ev.rates.Convert(*promotion.BundlePrice, ev.currency)
+
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([][]int, len(promotion.BundleProductIDs))
+	sets := len(ev.items)
+
+	for p, id := range promotion.BundleProductIDs {
+		for i, item := range ev.items {
+			if !ev.used[i] && item.ProductID == id {
+				units[p] = append(units[p], i)
+			}
+		}
+		sets = min(sets, len(units[p]))
+	}
+
+	applied := &models.AppliedPromotion{Discount: money.Zero(ev.currency)}
+
+	for s := 0; s < sets; s++ {
+		set := make([]int, len(units))
+		prices := make([]money.Money, len(units))
+
+		for p := range units {
+			set[p] = units[p][s]
+			prices[p] = ev.net[set[p]]
+		}
+
+		setTotal, err := money.Sum(ev.currency, prices...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		saving, err := setTotal.Sub(bundlePrice)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if saving.Amount <= 0 {
+			break
+		}
+
+		remaining := saving.Amount
+
+		for p, i := range set {
+			share := remaining
+			if p < len(set)-1 {
+				share = proportion(saving.Amount, prices[p].Amount, setTotal.Amount)
+			}
+			remaining -= share
+
+			ev.used[i] = true
+
+			if err := ev.discountLine(applied, i, money.New(share, ev.currency)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return applied, nil
+}
+
+// proportion returns amount * part / whole, rounded down, without
+// overflowing on large amounts.
+func proportion(amount, part, whole int64) int64 {
+	if whole == 0 {
+		return 0
+	}
+
+	n := new(big.Int).Mul(big.NewInt(amount), big.NewInt(part))
+	return n.Quo(n, big.NewInt(whole)).Int64()
+}
+
+// spendThreshold applies the highest tier whose minimum the remaining
+// subtotal reaches. It discounts the order, not individual lines.
+func (ev *promotionEvaluator) spendThreshold(promotion *models.Promotion) (*models.AppliedPromotion, error) {
+	remaining, err := money.Sum(ev.currency, ev.net...)
+
+	if err == nil {
+		remaining, err = remaining.Sub(ev.orderDiscount)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		best    *models.PromotionTier
+		bestMin money.Money
+	)
+
+	for t := range promotion.Tiers {
+		tier := &promotion.Tiers[t]
+		minimum, _, err := ev.rates.Convert(tier.MinSubtotal, ev.currency)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if remaining.Amount >= minimum.Amount && (best == nil || minimum.Amount > bestMin.Amount) {
+			best, bestMin = tier, minimum
+		}
+	}
+
+	if best == nil {
+		return nil, nil
+	}
+
+	var discount money.Money
+
+	if best.AmountOff != nil {
+		discount, _, err = ev.rates.Convert(*best.AmountOff, ev.currency)
+	} else {
+		discount, err = remaining.Percent(best.PercentOff)
+	}
+
+	if err == nil {
+		discount, err = money.Min(discount, remaining)
+	}
+
+	if err == nil {
+		ev.orderDiscount, err = ev.orderDiscount.Add(discount)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AppliedPromotion{Discount: discount}, nil
+}
+
+func EnsurePromotionIndexes(ctx context.Context, promotionCollection *mongo.Collection) error {
+	_, err := promotionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}, {Key: "priority", Value: -1}},
+	})
+
+	if err != nil {
+		logger.Error("error creating promotion indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("promotion indexes ensured")
+	return nil
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testID(n byte) primitive.ObjectID {
+	return primitive.ObjectID{11: n}
+}
+
+func testUnit(productID primitive.ObjectID, amount int64) models.ProductUser {
+	price := money.New(amount, "USD")
+	return models.ProductUser{ProductID: productID, Price: price, DisplayPrice: &price}
+}
+
+func usd(amount int64) *money.Money {
+	m := money.New(amount, "USD")
+	return &m
+}
+
+func TestEvaluatePromotions(t *testing.T) {
+	p, q, r := testID(1), testID(2), testID(3)
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	bogo := func(name string, priority int, percent int64) models.Promotion {
+		return models.Promotion{PromotionID: testID(byte(10 + priority)), Name: name, Type: models.PromotionBuyXGetY,
+			Priority: priority, BuyQuantity: 1, GetQuantity: 1, GetPercentOff: percent, CreatedAt: older}
+	}
+	tiers := func(name string, priority int, tiers ...models.PromotionTier) models.Promotion {
+		return models.Promotion{PromotionID: testID(byte(20 + priority)), Name: name, Type: models.PromotionSpendThreshold,
+			Priority: priority, Tiers: tiers, CreatedAt: older}
+	}
+	bundle := func(name string, priority int, price int64, ids ...primitive.ObjectID) models.Promotion {
+		return models.Promotion{PromotionID: testID(byte(30 + priority)), Name: name, Type: models.PromotionBundle,
+			Priority: priority, BundleProductIDs: ids, BundlePrice: usd(price), CreatedAt: older}
+	}
+	with := func(promotion models.Promotion, edit func(*models.Promotion)) models.Promotion {
+		edit(&promotion)
+		return promotion
+	}
+
+	tests := []struct {
+		name       string
+		promotions []models.Promotion
+		items      []models.ProductUser
+		applied    []string
+		discount   int64
+		net        []int64
+	}{
+		{
+			name:       "highest priority first",
+			promotions: []models.Promotion{bogo("half", 1, 50), bogo("free", 2, 100)},
+			items:      []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000)},
+			applied:    []string{"free"},
+			discount:   1000,
+			net:        []int64{1000, 0},
+		},
+		{
+			name: "oldest first on equal priority",
+			promotions: []models.Promotion{
+				with(bogo("newer", 1, 100), func(promotion *models.Promotion) { promotion.CreatedAt = newer }),
+				with(bogo("older", 1, 50), func(promotion *models.Promotion) { promotion.PromotionID = testID(99) }),
+			},
+			items:    []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000)},
+			applied:  []string{"older"},
+			discount: 500,
+			net:      []int64{1000, 500},
+		},
+		{
+			name: "lowest id on equal priority and age",
+			promotions: []models.Promotion{
+				with(bogo("second", 1, 100), func(promotion *models.Promotion) { promotion.PromotionID = testID(51) }),
+				with(bogo("first", 1, 50), func(promotion *models.Promotion) { promotion.PromotionID = testID(50) }),
+			},
+			items:    []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000)},
+			applied:  []string{"first"},
+			discount: 500,
+			net:      []int64{1000, 500},
+		},
+		{
+			name:       "buy_x_get_y discounts the cheapest units of each group",
+			promotions: []models.Promotion{bogo("half", 1, 50)},
+			items:      []models.ProductUser{testUnit(p, 400), testUnit(q, 1000), testUnit(r, 600), testUnit(p, 400), testUnit(q, 1000)},
+			applied:    []string{"half"},
+			discount:   700,
+			net:        []int64{200, 1000, 600, 400, 500},
+		},
+		{
+			name:       "units taken by buy_x_get_y are not bundled",
+			promotions: []models.Promotion{bogo("half", 2, 50), bundle("set", 1, 1200, p, q)},
+			items:      []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000), testUnit(q, 500), testUnit(q, 500)},
+			applied:    []string{"half"},
+			discount:   750,
+			net:        []int64{1000, 500, 500, 250},
+		},
+		{
+			name:       "bundled units are not used by buy_x_get_y",
+			promotions: []models.Promotion{bogo("half", 1, 50), bundle("set", 2, 1200, p, q)},
+			items:      []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000), testUnit(q, 500)},
+			applied:    []string{"set"},
+			discount:   300,
+			net:        []int64{800, 1000, 400},
+		},
+		{
+			name:       "bundle leaves the rounding remainder on the last product",
+			promotions: []models.Promotion{bundle("set", 1, 2000, p, q, r)},
+			items:      []models.ProductUser{testUnit(p, 1000), testUnit(q, 1000), testUnit(r, 1000)},
+			applied:    []string{"set"},
+			discount:   1000,
+			net:        []int64{667, 667, 666},
+		},
+		{
+			name:       "bundle that saves nothing",
+			promotions: []models.Promotion{bundle("set", 1, 2000, p, q)},
+			items:      []models.ProductUser{testUnit(p, 1000), testUnit(q, 500)},
+			applied:    []string{},
+			discount:   0,
+			net:        []int64{1000, 500},
+		},
+		{
+			name: "only the highest spend tier reached counts",
+			promotions: []models.Promotion{tiers("spend", 1,
+				models.PromotionTier{MinSubtotal: money.New(5000, "USD"), PercentOff: 20},
+				models.PromotionTier{MinSubtotal: money.New(1000, "USD"), PercentOff: 5},
+				models.PromotionTier{MinSubtotal: money.New(2000, "USD"), PercentOff: 10},
+			)},
+			items:    []models.ProductUser{testUnit(p, 1000), testUnit(q, 2000)},
+			applied:  []string{"spend"},
+			discount: 300,
+			net:      []int64{1000, 2000},
+		},
+		{
+			name: "spend tiers see the subtotal after earlier promotions",
+			promotions: []models.Promotion{bogo("half", 2, 50), tiers("spend", 1,
+				models.PromotionTier{MinSubtotal: money.New(1000, "USD"), AmountOff: usd(100)},
+				models.PromotionTier{MinSubtotal: money.New(2000, "USD"), PercentOff: 10},
+			)},
+			items:    []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000)},
+			applied:  []string{"half", "spend"},
+			discount: 600,
+			net:      []int64{1000, 500},
+		},
+		{
+			name: "exclusive promotion stops later ones",
+			promotions: []models.Promotion{
+				with(bogo("half", 2, 50), func(promotion *models.Promotion) { promotion.Exclusive = true }),
+				tiers("spend", 1, models.PromotionTier{MinSubtotal: money.New(1000, "USD"), AmountOff: usd(100)}),
+			},
+			items:    []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000)},
+			applied:  []string{"half"},
+			discount: 500,
+			net:      []int64{1000, 500},
+		},
+		{
+			name: "exclusive promotion that does not apply",
+			promotions: []models.Promotion{
+				with(bundle("set", 2, 1200, p, q), func(promotion *models.Promotion) { promotion.Exclusive = true }),
+				tiers("spend", 1, models.PromotionTier{MinSubtotal: money.New(1000, "USD"), AmountOff: usd(100)}),
+			},
+			items:    []models.ProductUser{testUnit(p, 1000), testUnit(p, 1000)},
+			applied:  []string{"spend"},
+			discount: 100,
+			net:      []int64{1000, 1000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvaluatePromotions(tt.promotions, tt.items, money.NewRateTable("USD"), "USD")
+
+			if err != nil {
+				t.Fatalf("EvaluatePromotions() error = %v", err)
+			}
+
+			applied := make([]string, 0, len(got.Applied))
+			for _, promotion := range got.Applied {
+				applied = append(applied, promotion.Name)
+			}
+
+			net := make([]int64, 0, len(got.Net))
+			for _, m := range got.Net {
+				net = append(net, m.Amount)
+			}
+
+			if !reflect.DeepEqual(applied, tt.applied) {
+				t.Errorf("applied = %q, want %q", applied, tt.applied)
+			}
+
+			if got.Discount != money.New(tt.discount, "USD") {
+				t.Errorf("discount = %v, want %d", got.Discount, tt.discount)
+			}
+
+			if !reflect.DeepEqual(net, tt.net) {
+				t.Errorf("net = %v, want %v", net, tt.net)
+			}
+		})
+	}
+}
+
+func TestProportion(t *testing.T) {
+	tests := []struct {
+		amount, part, whole int64
+		want                int64
+	}{
+		{300, 1000, 1500, 200},
+		{1000, 1000, 3000, 333},
+		{10, 2, 3, 6},
+		{1, 1, 2, 0},
+		{500, 0, 1000, 0},
+		{500, 1000, 0, 0},
+		{math.MaxInt64, math.MaxInt64 - 1, math.MaxInt64, math.MaxInt64 - 1},
+	}
+
+	for _, tt := range tests {
+		if got := proportion(tt.amount, tt.part, tt.whole); got != tt.want {
+			t.Errorf("proportion(%d, %d, %d) = %d, want %d", tt.amount, tt.part, tt.whole, got, tt.want)
+		}
+	}
+}
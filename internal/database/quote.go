@@ -0,0 +1,421 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/tax"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+var (
+	ErrAddressNotFound = errors.New("address not found")
+	ErrCantComputeTax  = errors.New("cannot compute tax")
+)
+
+// Pricing carries everything besides the cart itself that decides what an
+// order costs.
+type Pricing struct {
+	Rates                *money.RateTable
+	Currency             string
+	Taxes                *tax.Table
+	CouponCollection     *mongo.Collection
+	RedemptionCollection *mongo.Collection
+	UserCollection       *mongo.Collection
+	PromotionCollection  *mongo.Collection
+	ShippingCollection   *mongo.Collection
+}
+
+// CheckoutRequest holds the customer's choices for an order. The shipping
+// address is ShippingAddress when set, otherwise the saved address AddressID;
+// billing works the same way and defaults to the shipping address. Inline
+// addresses must already be normalized and valid.
+type CheckoutRequest struct {
+	UserID           string
+	AddressID        primitive.ObjectID
+	BillingAddressID primitive.ObjectID
+	ShippingAddress  *models.Address
+	BillingAddress   *models.Address
+	ShippingMethodID primitive.ObjectID
+	PaymentMethod    string
+	PaymentToken     string
+	// AcceptPriceChanges is the PriceChangesToken of the cart the customer
+	// reviewed; it confirms checking out at prices changed since its
+	// products were added.
+	AcceptPriceChanges string
+}
+
+// QuoteInput is a cart to price together with the customer's choices for it.
+type QuoteInput struct {
+	Items            []models.ProductUser
+	UserID           string
+	CouponCode       string
+	Address          *models.Address
+	ShippingMethodID primitive.ObjectID
+}
+
+// CartQuote is a priced cart: line prices in the requested currency, the
+// subtotal, the promotions and coupon that apply, the combined discount, tax,
+// shipping and what the customer pays. Shipping is only quoted once the store
+// has shipping methods; when none can deliver, ShippingError says why.
+type CartQuote struct {
+	Currency        string                    `json:"currency"`
+	Items           []models.ProductUser      `json:"items"`
+	Subtotal        money.Money               `json:"subtotal"`
+	Promotions      []models.AppliedPromotion `json:"promotions"`
+	Coupon          *AppliedCoupon            `json:"coupon,omitempty"`
+	Discount        money.Money               `json:"discount"`
+	TaxMode         string                    `json:"tax_mode"`
+	TaxRegion       string                    `json:"tax_region"`
+	Tax             money.Money               `json:"tax"`
+	TaxLines        []models.TaxLine          `json:"tax_lines"`
+	WeightGrams     int64                     `json:"weight_grams"`
+	ShippingOptions []ShippingOption          `json:"shipping_options,omitempty"`
+	Shipping        *ShippingOption           `json:"shipping,omitempty"`
+	ShippingError   string                    `json:"shipping_error,omitempty"`
+	Total           money.Money               `json:"total"`
+	Issues          []CartIssue               `json:"issues,omitempty"`
+	// PriceChangesToken accepts the price changes among Issues at checkout.
+	PriceChangesToken string     `json:"price_changes_token,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+
+	shippingErr error
+}
+
+// converted reports whether charging the quote converted any amount from
+// the store currency: a line without a price list entry in the currency, a
+// shipping cost, or a discount set as a fixed amount, whose converted value
+// also decides when spend thresholds apply.
+func (quote *CartQuote) converted(rates *money.RateTable) bool {
+	if quote.Currency == rates.Base() {
+		return false
+	}
+
+	for _, item := range quote.Items {
+		if !slices.ContainsFunc(item.PriceList, func(price money.Money) bool { return price.Currency == quote.Currency }) {
+			return true
+		}
+	}
+
+	if quote.Shipping != nil && !quote.Shipping.Cost.IsZero() {
+		return true
+	}
+
+	if quote.Coupon != nil && quote.Coupon.Type == models.CouponFixedAmount && !quote.Coupon.Discount.IsZero() {
+		return true
+	}
+
+	for _, promotion := range quote.Promotions {
+		if promotion.Type == models.PromotionBundle || promotion.Type == models.PromotionSpendThreshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ShippingAddress picks the address an order ships to: the one with
+// addressID when it is set, otherwise the default shipping address, or the
+// first address on file when none is flagged. A user without addresses gets
+// nil.
+func ShippingAddress(user *models.User, addressID primitive.ObjectID) (*models.Address, error) {
+	if addressID.IsZero() {
+		if len(user.AddressDetails) == 0 {
+			return nil, nil
+		}
+		if address := DefaultAddress(user.AddressDetails, false); address != nil {
+			return address, nil
+		}
+		return &user.AddressDetails[0], nil
+	}
+
+	for i := range user.AddressDetails {
+		if user.AddressDetails[i].AddressId == addressID {
+			return &user.AddressDetails[i], nil
+		}
+	}
+
+	return nil, ErrAddressNotFound
+}
+
+// QuoteCart prices a cart: automatic promotions first, then the coupon code
+// the customer applied, if any, on what the promotions left, then tax for the
+// shipping address and finally shipping. A coupon that no longer qualifies,
+// or a cart that cannot ship, is reported on the quote rather than failing it.
+func QuoteCart(ctx context.Context, in QuoteInput, pricing Pricing) (*CartQuote, error) {
+	subtotal, err := PriceCart(in.Items, pricing.Rates, pricing.Currency)
+
+	if err != nil {
+		logger.Error("error pricing cart", slog.String("currency", pricing.Currency), slog.Any("error", err))
+		return nil, ErrCantPriceCart
+	}
+
+	base, err := CartTotal(in.Items, pricing.Rates.Base())
+
+	if err != nil {
+		logger.Error("error totalling cart", slog.Any("error", err))
+		return nil, ErrCantPriceCart
+	}
+
+	promotions, err := ActivePromotions(ctx, pricing.PromotionCollection, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	promoted, err := EvaluatePromotions(promotions, in.Items, pricing.Rates, pricing.Currency)
+
+	if err != nil {
+		logger.Error("error applying promotions", slog.Any("error", err))
+		return nil, ErrCantApplyPromotions
+	}
+
+	quote := &CartQuote{
+		Currency:   pricing.Currency,
+		Items:      in.Items,
+		Subtotal:   subtotal,
+		Promotions: promoted.Applied,
+		Discount:   promoted.Discount,
+	}
+
+	if in.CouponCode != "" {
+		quote.Coupon, err = applyCoupon(ctx, pricing, in.CouponCode, in.UserID, in.Items, promoted.Net, base)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if quote.Coupon != nil && quote.Coupon.err == nil {
+		// Spend thresholds may already have taken off more than the coupon's
+		// lines are worth net of them, so the coupon never pushes the total
+		// below zero.
+		left, err := subtotal.Sub(quote.Discount)
+
+		if err == nil {
+			quote.Coupon.Discount, err = money.Min(quote.Coupon.Discount, left)
+		}
+
+		if err == nil {
+			quote.Discount, err = quote.Discount.Add(quote.Coupon.Discount)
+		}
+
+		if err != nil {
+			return nil, ErrCantPriceCart
+		}
+	}
+
+	net, err := subtotal.Sub(quote.Discount)
+
+	if err != nil {
+		return nil, ErrCantPriceCart
+	}
+
+	if err := quote.applyTax(pricing.Taxes, in.Address, promoted.Net, net); err != nil {
+		logger.Error("error computing tax", slog.Any("error", err))
+		return nil, ErrCantComputeTax
+	}
+
+	quote.Total = net
+
+	if quote.TaxMode == tax.ModeExclusive {
+		if quote.Total, err = net.Add(quote.Tax); err != nil {
+			return nil, ErrCantPriceCart
+		}
+	}
+
+	if err := quote.applyShipping(ctx, in, pricing, net); err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// applyShipping offers the methods that can deliver the cart and adds the
+// chosen, or else the cheapest, one to the total.
+func (quote *CartQuote) applyShipping(ctx context.Context, in QuoteInput, pricing Pricing, merchandise money.Money) error {
+	quote.WeightGrams = CartWeight(in.Items)
+
+	if pricing.ShippingCollection == nil {
+		return nil
+	}
+
+	methods, err := ListShippingMethods(ctx, pricing.ShippingCollection, true)
+
+	if err != nil {
+		return err
+	}
+
+	if len(methods) == 0 {
+		return nil
+	}
+
+	freeShipping := quote.Coupon != nil && quote.Coupon.err == nil && quote.Coupon.FreeShipping
+	quote.ShippingOptions, err = ShippingOptions(methods, in.Items, in.Address, merchandise, freeShipping, pricing.Rates, pricing.Currency)
+
+	if err != nil {
+		logger.Error("error pricing shipping", slog.Any("error", err))
+		return ErrCantPriceCart
+	}
+
+	quote.Shipping, quote.shippingErr = chooseShipping(quote.ShippingOptions, in.ShippingMethodID)
+
+	if quote.shippingErr != nil {
+		quote.ShippingError = quote.shippingErr.Error()
+		return nil
+	}
+
+	if quote.Total, err = quote.Total.Add(quote.Shipping.Cost); err != nil {
+		return ErrCantPriceCart
+	}
+
+	return nil
+}
+
+// applyTax taxes every line on its price after discounts. Line prices are
+// already net of item promotions; discounts on the order as a whole (spend
+// thresholds and coupons) are spread over the lines in proportion to them.
+// Tax is rounded per line and the order tax is the sum of the lines.
+func (quote *CartQuote) applyTax(taxes *tax.Table, address *models.Address, lines []money.Money, net money.Money) error {
+	var city, postalCode string
+
+	if address != nil {
+		city, postalCode = address.City, address.PostalCode
+	}
+
+	lineTotal, err := money.Sum(quote.Currency, lines...)
+
+	if err != nil {
+		return err
+	}
+
+	orderDiscount, err := lineTotal.Sub(net)
+
+	if err != nil {
+		return err
+	}
+
+	quote.TaxMode = taxes.Mode()
+	quote.TaxRegion = taxes.Lookup(city, postalCode, "").Region
+	quote.TaxLines = make([]models.TaxLine, 0, len(lines))
+	taxed := make([]money.Money, 0, len(lines))
+
+	// The rounding remainder goes to the largest line, which can always
+	// absorb it.
+	largest := 0
+	for i, line := range lines {
+		if line.Amount > lines[largest].Amount {
+			largest = i
+		}
+	}
+
+	shares := make([]int64, len(lines))
+	remaining := orderDiscount.Amount
+
+	for i, line := range lines {
+		if i != largest {
+			shares[i] = proportion(orderDiscount.Amount, line.Amount, lineTotal.Amount)
+			remaining -= shares[i]
+		}
+	}
+
+	if len(lines) > 0 {
+		shares[largest] = remaining
+	}
+
+	for i, line := range lines {
+		taxable, err := line.Sub(money.New(shares[i], quote.Currency))
+
+		if err != nil {
+			return err
+		}
+
+		var class string
+		if quote.Items[i].TaxClass != nil {
+			class = *quote.Items[i].TaxClass
+		}
+
+		rate := taxes.Lookup(city, postalCode, class)
+		amount, err := tax.Amount(taxable, rate.Value, quote.TaxMode)
+
+		if err != nil {
+			return err
+		}
+
+		quote.TaxLines = append(quote.TaxLines, models.TaxLine{
+			ProductID: quote.Items[i].ProductID,
+			TaxClass:  rate.Class,
+			Rate:      money.FormatRate(rate.Value),
+			Taxable:   taxable,
+			Tax:       amount,
+		})
+		taxed = append(taxed, amount)
+	}
+
+	quote.Tax, err = money.Sum(quote.Currency, taxed...)
+	return err
+}
+
+// chargeOrder fills in the charged total, base total, rate, discounts and
+// tax of an order from its quote. BaseTotal is the charged total in the
+// store currency at the current rate; ExchangeRate is only recorded when an
+// amount was converted to charge the order.
+func chargeOrder(order *models.Order, quote *CartQuote, rates *money.RateTable) error {
+	rate, err := rates.Rate(quote.Currency)
+
+	if err != nil {
+		return err
+	}
+
+	baseTotal, _, err := rates.Convert(quote.Total, rates.Base())
+
+	if err != nil {
+		return err
+	}
+
+	order.Price = quote.Total
+	order.BaseTotal = baseTotal
+	order.Currency = quote.Currency
+
+	if quote.converted(rates) {
+		order.ExchangeRate = money.FormatRate(rate)
+	}
+
+	if !quote.Discount.IsZero() {
+		discount := quote.Discount
+		order.Discount = &discount
+	}
+
+	if len(quote.Promotions) > 0 {
+		order.Promotions = quote.Promotions
+	}
+
+	if quote.Coupon != nil && quote.Coupon.err == nil {
+		order.CouponCode = quote.Coupon.Code
+		order.FreeShipping = quote.Coupon.FreeShipping
+	}
+
+	orderTax := quote.Tax
+	order.TaxMode = quote.TaxMode
+	order.TaxRegion = quote.TaxRegion
+	order.Tax = &orderTax
+	order.TaxLines = quote.TaxLines
+
+	if quote.Shipping != nil {
+		order.Shipping = &models.OrderShipping{
+			MethodID:    quote.Shipping.MethodID,
+			Name:        quote.Shipping.Name,
+			Type:        quote.Shipping.Type,
+			Cost:        quote.Shipping.Cost,
+			WeightGrams: quote.WeightGrams,
+		}
+	}
+
+	return nil
+}
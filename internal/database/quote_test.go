@@ -0,0 +1,157 @@
+package database
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/tax"
+	"reflect"
+	"testing"
+)
+
+func TestApplyTax(t *testing.T) {
+	flat := func(mode, rate string) tax.Rules {
+		return tax.Rules{Mode: mode, Default: tax.Region{Name: "default", Rates: map[string]string{tax.DefaultClass: rate}}}
+	}
+	regional := flat(tax.ModeExclusive, "0.1")
+	regional.Regions = []tax.Region{
+		{Name: "county", PostalPrefixes: []string{"941"}, Rates: map[string]string{tax.DefaultClass: "0.08", "food": "0.02"}},
+	}
+	food := "food"
+
+	tests := []struct {
+		name    string
+		rules   tax.Rules
+		address *models.Address
+		classes []*string
+		lines   []int64
+		net     int64
+		region  string
+		taxable []int64
+		tax     []int64
+		total   int64
+	}{
+		{
+			name:    "no order discount",
+			rules:   flat(tax.ModeExclusive, "0.1"),
+			lines:   []int64{1000, 2000},
+			net:     3000,
+			region:  "default",
+			taxable: []int64{1000, 2000},
+			tax:     []int64{100, 200},
+			total:   300,
+		},
+		{
+			name:    "order discount spread in proportion to lines",
+			rules:   flat(tax.ModeExclusive, "0.1"),
+			lines:   []int64{1000, 3000, 2000},
+			net:     5000,
+			region:  "default",
+			taxable: []int64{834, 2499, 1667},
+			tax:     []int64{83, 250, 167},
+			total:   500,
+		},
+		{
+			name:    "remainder goes to the first largest line",
+			rules:   flat(tax.ModeExclusive, "0.1"),
+			lines:   []int64{1000, 1000, 1000},
+			net:     2900,
+			region:  "default",
+			taxable: []int64{966, 967, 967},
+			tax:     []int64{97, 97, 97},
+			total:   291,
+		},
+		{
+			name:    "discount covering the order",
+			rules:   flat(tax.ModeExclusive, "0.1"),
+			lines:   []int64{500, 1500},
+			net:     0,
+			region:  "default",
+			taxable: []int64{0, 0},
+			tax:     []int64{0, 0},
+			total:   0,
+		},
+		{
+			name:    "inclusive",
+			rules:   flat(tax.ModeInclusive, "0.25"),
+			lines:   []int64{1250, 2500},
+			net:     3750,
+			region:  "default",
+			taxable: []int64{1250, 2500},
+			tax:     []int64{250, 500},
+			total:   750,
+		},
+		{
+			name:    "inclusive with order discount",
+			rules:   flat(tax.ModeInclusive, "0.25"),
+			lines:   []int64{1250, 2500},
+			net:     3000,
+			region:  "default",
+			taxable: []int64{1000, 2000},
+			tax:     []int64{200, 400},
+			total:   600,
+		},
+		{
+			name:    "region and class per line",
+			rules:   regional,
+			address: &models.Address{City: "San Francisco", PostalCode: "94105"},
+			classes: []*string{&food, nil},
+			lines:   []int64{1000, 1000},
+			net:     2000,
+			region:  "county",
+			taxable: []int64{1000, 1000},
+			tax:     []int64{20, 80},
+			total:   100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxes := tax.NewTable()
+
+			if err := taxes.Replace(tt.rules); err != nil {
+				t.Fatalf("Replace() error = %v", err)
+			}
+
+			quote := &CartQuote{Currency: "USD"}
+			lines := make([]money.Money, 0, len(tt.lines))
+
+			for i, amount := range tt.lines {
+				item := testUnit(testID(byte(i+1)), amount)
+				if i < len(tt.classes) {
+					item.TaxClass = tt.classes[i]
+				}
+
+				quote.Items = append(quote.Items, item)
+				lines = append(lines, money.New(amount, "USD"))
+			}
+
+			if err := quote.applyTax(taxes, tt.address, lines, money.New(tt.net, "USD")); err != nil {
+				t.Fatalf("applyTax() error = %v", err)
+			}
+
+			taxable := make([]int64, 0, len(quote.TaxLines))
+			amounts := make([]int64, 0, len(quote.TaxLines))
+
+			for _, line := range quote.TaxLines {
+				taxable = append(taxable, line.Taxable.Amount)
+				amounts = append(amounts, line.Tax.Amount)
+			}
+
+			if quote.TaxRegion != tt.region {
+				t.Errorf("region = %q, want %q", quote.TaxRegion, tt.region)
+			}
+
+			if !reflect.DeepEqual(taxable, tt.taxable) {
+				t.Errorf("taxable = %v, want %v", taxable, tt.taxable)
+			}
+
+			if !reflect.DeepEqual(amounts, tt.tax) {
+				t.Errorf("tax = %v, want %v", amounts, tt.tax)
+			}
+
+			if quote.Tax != money.New(tt.total, "USD") {
+				t.Errorf("total tax = %v, want %d", quote.Tax, tt.total)
+			}
+		})
+	}
+}
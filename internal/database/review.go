@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrReviewNotAllowed    = errors.New("only customers who bought the product can review it")
+	ErrReviewAlreadyExists = errors.New("product already reviewed by this user")
+	ErrReviewNotFound      = errors.New("review not found")
+	ErrInvalidReviewStatus = errors.New("invalid review status")
+	ErrCantSaveReview      = errors.New("cannot save review")
+	ErrCantUpdateRating    = errors.New("cannot update product rating")
+	ErrCantListReviews     = errors.New("cannot list reviews")
+)
+
+func AddReview(ctx context.Context, reviewCollection, prodCollection, userCollection *mongo.Collection, review *models.Review) error {
+	logger.Info("adding review", slog.Any("productID", review.ProductID), slog.String("userID", review.UserID))
+
+	userID, err := primitive.ObjectIDFromHex(review.UserID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", review.UserID))
+		return ErrUserIDIsNotValid
+	}
+
+	count, err := prodCollection.CountDocuments(ctx, bson.D{{Key: "_id", Value: review.ProductID}})
+
+	if err != nil || count == 0 {
+		logger.Error("review target product not found", slog.Any("productID", review.ProductID))
+		return ErrCantFindProduct
+	}
+
+	purchased, err := userCollection.CountDocuments(ctx, bson.D{
+		{Key: "_id", Value: userID},
+		{Key: "orders.order_list._id", Value: review.ProductID},
+	})
+
+	if err != nil {
+		logger.Error("error checking purchase history", slog.Any("error", err))
+		return ErrCantSaveReview
+	}
+
+	if purchased == 0 {
+		logger.Warn("review rejected, product not purchased", slog.Any("productID", review.ProductID), slog.String("userID", review.UserID))
+		return ErrReviewNotAllowed
+	}
+
+	review.ReviewID = primitive.NewObjectID()
+	review.Status = models.ReviewPending
+	review.CreatedAt = time.Now()
+	review.UpdatedAt = review.CreatedAt
+
+	_, err = reviewCollection.InsertOne(ctx, review)
+
+	if mongo.IsDuplicateKeyError(err) {
+		logger.Warn("duplicate review", slog.Any("productID", review.ProductID), slog.String("userID", review.UserID))
+		return ErrReviewAlreadyExists
+	}
+
+	if err != nil {
+		logger.Error("error inserting review", slog.Any("error", err))
+		return ErrCantSaveReview
+	}
+
+	logger.Info("review added", slog.Any("reviewID", review.ReviewID))
+	return nil
+}
+
+// ModerateReview moves a review to the given status and applies the
+// difference to the product's rating aggregate, so only approved reviews
+// ever count towards the average. When the aggregate cannot be updated the
+// review goes back to its previous status, keeping the two in step.
+func ModerateReview(ctx context.Context, reviewCollection, prodCollection *mongo.Collection, reviewID primitive.ObjectID, status string) (*models.Review, error) {
+	if status != models.ReviewPending && status != models.ReviewApproved && status != models.ReviewRejected {
+		return nil, ErrInvalidReviewStatus
+	}
+
+	var previous models.Review
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: status},
+		{Key: "updated_at", Value: time.Now()},
+	}}}
+
+	err := reviewCollection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: reviewID}}, update, opts).Decode(&previous)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrReviewNotFound
+	}
+
+	if err != nil {
+		logger.Error("error moderating review", slog.Any("reviewID", reviewID), slog.Any("error", err))
+		return nil, ErrCantSaveReview
+	}
+
+	wasApproved := previous.Status == models.ReviewApproved
+	isApproved := status == models.ReviewApproved
+
+	switch {
+	case !wasApproved && isApproved:
+		err = applyRatingDelta(ctx, prodCollection, previous.ProductID, int64(previous.Rating), 1)
+	case wasApproved && !isApproved:
+		err = applyRatingDelta(ctx, prodCollection, previous.ProductID, -int64(previous.Rating), -1)
+	}
+
+	if err != nil {
+		// The request context may be what failed, so the restore gets its own.
+		restoreCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		restore := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: previous.Status},
+			{Key: "updated_at", Value: previous.UpdatedAt},
+		}}}
+
+		if _, restoreErr := reviewCollection.UpdateOne(restoreCtx, bson.D{{Key: "_id", Value: reviewID}, {Key: "status", Value: status}}, restore); restoreErr != nil {
+			logger.Error("error restoring review status", slog.Any("reviewID", reviewID), slog.Any("error", restoreErr))
+		}
+
+		return nil, err
+	}
+
+	previous.Status = status
+	logger.Info("review moderated", slog.Any("reviewID", reviewID), slog.String("status", status))
+	return &previous, nil
+}
+
+// applyRatingDelta adjusts the stored sum and count in a single pipeline
+// update and recomputes the average from them, avoiding a full rescan.
+func applyRatingDelta(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, sumDelta, countDelta int64) error {
+	sum := bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$rating_sum", 0}}}, sumDelta}}}
+	count := bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$rating_count", 0}}}, countDelta}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "rating_sum", Value: sum}, {Key: "rating_count", Value: count}}}},
+		{{Key: "$set", Value: bson.D{{Key: "rating", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$gt", Value: bson.A{"$rating_count", 0}}},
+			bson.D{{Key: "$divide", Value: bson.A{"$rating_sum", "$rating_count"}}},
+			0,
+		}}}}}}},
+	}
+
+	_, err := prodCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: productID}}, pipeline)
+
+	if err != nil {
+		logger.Error("error updating product rating", slog.Any("productID", productID), slog.Any("error", err))
+		return ErrCantUpdateRating
+	}
+
+	return nil
+}
+
+func ListReviews(ctx context.Context, reviewCollection *mongo.Collection, productID primitive.ObjectID, status string, limit int) ([]models.Review, error) {
+	filter := bson.D{{Key: "status", Value: status}}
+
+	if !productID.IsZero() {
+		filter = append(filter, bson.E{Key: "product_id", Value: productID})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := reviewCollection.Find(ctx, filter, opts)
+
+	if err != nil {
+		logger.Error("error listing reviews", slog.Any("error", err))
+		return nil, ErrCantListReviews
+	}
+
+	reviews := make([]models.Review, 0)
+
+	if err := cursor.All(ctx, &reviews); err != nil {
+		logger.Error("error decoding reviews", slog.Any("error", err))
+		return nil, ErrCantListReviews
+	}
+
+	return reviews, nil
+}
+
+func EnsureReviewIndexes(ctx context.Context, reviewCollection *mongo.Collection) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+
+	_, err := reviewCollection.Indexes().CreateMany(ctx, indexes)
+
+	if err != nil {
+		logger.Error("error creating review indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("review indexes ensured")
+	return nil
+}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+
+	// fuzzyCandidates bounds how many candidates are loaded per term before
+	// the typo-tolerant comparison runs in process. The ones sharing the most
+	// trigrams with the term are kept, ties broken by _id.
+	fuzzyCandidates = 200
+)
+
+var (
+	ErrEmptySearchQuery = errors.New("search query has no searchable terms")
+	ErrCantSearch       = errors.New("can't search products")
+)
+
+type SearchResult struct {
+	Product    models.Product    `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type scoredProduct struct {
+	models.Product `bson:",inline"`
+	TextScore      float64 `bson:"text_score"`
+}
+
+// ProductSearchTerms returns the lowercased words a product is indexed under
+// for prefix and typo-tolerant lookups.
+func ProductSearchTerms(product models.Product) []string {
+	var text string
+
+	if product.ProductName != nil {
+		text += *product.ProductName
+	}
+
+	if product.Description != nil {
+		text += " " + *product.Description
+	}
+
+	return search.Tokenize(text)
+}
+
+// ProductSearchGrams returns the trigrams of the search terms, which fuzzy
+// lookups use to find candidates for prefix and typo-tolerant matches.
+func ProductSearchGrams(terms []string) []string {
+	return search.WordTrigrams(terms)
+}
+
+func SearchProducts(ctx context.Context, prodCollection *mongo.Collection, query string, limit int) ([]SearchResult, error) {
+	terms := search.QueryTerms(query)
+
+	if len(terms) == 0 {
+		return nil, ErrEmptySearchQuery
+	}
+
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	candidates := make(map[primitive.ObjectID]*scoredProduct)
+
+	textOpts := options.Find().
+		SetProjection(bson.D{{Key: "text_score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetSort(bson.D{{Key: "text_score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetLimit(int64(limit))
+
+	// Terms hold only letters and digits, so $text never sees negations or phrases.
+	textFilter := bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: strings.Join(terms, " ")}}}}
+
+	cursor, err := prodCollection.Find(ctx, textFilter, textOpts)
+
+	if err != nil {
+		logger.Error("error searching products", slog.Any("error", err))
+		return nil, ErrCantSearch
+	}
+
+	if err := collectCandidates(ctx, cursor, candidates); err != nil {
+		return nil, err
+	}
+
+	for _, term := range terms {
+		cursor, err := prodCollection.Aggregate(ctx, fuzzyCandidatePipeline(term))
+
+		if err != nil {
+			logger.Error("error finding fuzzy search candidates", slog.String("term", term), slog.Any("error", err))
+			return nil, ErrCantSearch
+		}
+
+		if err := collectCandidates(ctx, cursor, candidates); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]SearchResult, 0, len(candidates))
+
+	for _, candidate := range candidates {
+		var name, description string
+
+		if candidate.ProductName != nil {
+			name = *candidate.ProductName
+		}
+		if candidate.Description != nil {
+			description = *candidate.Description
+		}
+
+		lexical := 3*search.Score(name, terms) + search.Score(description, terms)
+
+		if lexical == 0 && candidate.TextScore == 0 {
+			continue
+		}
+
+		results = append(results, SearchResult{
+			Product: candidate.Product,
+			Score:   lexical + candidate.TextScore,
+			Highlights: map[string]string{
+				"product_name": search.Highlight(name, terms),
+				"description":  search.Highlight(description, terms),
+			},
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Product.ProductID.Hex() < results[j].Product.ProductID.Hex()
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	logger.Info("product search completed", slog.Any("terms", terms), slog.Int("candidates", len(candidates)), slog.Int("results", len(results)))
+	return results, nil
+}
+
+// fuzzyCandidatePipeline finds the products with a word that may match term:
+// those sharing at least search.MinSharedTrigrams of its trigrams. They are
+// ranked by how many they share, so the closest are kept when there are more
+// than fuzzyCandidates, and the same ones on every search.
+func fuzzyCandidatePipeline(term string) mongo.Pipeline {
+	grams := search.Trigrams(term)
+	shared := bson.D{{Key: "$size", Value: bson.D{{Key: "$setIntersection", Value: bson.A{"$search_grams", grams}}}}}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "search_grams", Value: bson.D{{Key: "$in", Value: grams}}}}}},
+		{{Key: "$set", Value: bson.D{{Key: "shared_grams", Value: shared}}}},
+		{{Key: "$match", Value: bson.D{{Key: "shared_grams", Value: bson.D{{Key: "$gte", Value: search.MinSharedTrigrams(term)}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "shared_grams", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: fuzzyCandidates}},
+	}
+}
+
+func collectCandidates(ctx context.Context, cursor *mongo.Cursor, into map[primitive.ObjectID]*scoredProduct) error {
+	var found []scoredProduct
+
+	if err := cursor.All(ctx, &found); err != nil {
+		logger.Error("error decoding search results", slog.Any("error", err))
+		return ErrCantDecodeProducts
+	}
+
+	for i := range found {
+		if existing, ok := into[found[i].ProductID]; ok {
+			existing.TextScore = max(existing.TextScore, found[i].TextScore)
+			continue
+		}
+		into[found[i].ProductID] = &found[i]
+	}
+
+	return nil
+}
+
+// SuggestionEntries loads every product name, weighted by rating, and every
+// category, weighted by how many products it holds, for the suggestion index.
+func SuggestionEntries(ctx context.Context, prodCollection *mongo.Collection) ([]search.Suggestion, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "product_name", Value: 1}, {Key: "rating", Value: 1}})
+	cursor, err := prodCollection.Find(ctx, bson.D{}, opts)
+
+	if err != nil {
+		logger.Error("error loading product names", slog.Any("error", err))
+		return nil, ErrCantSearch
+	}
+
+	var products []models.Product
+
+	if err := cursor.All(ctx, &products); err != nil {
+		logger.Error("error decoding product names", slog.Any("error", err))
+		return nil, ErrCantDecodeProducts
+	}
+
+	entries := make([]search.Suggestion, 0, len(products))
+
+	for _, product := range products {
+		if product.ProductName == nil || *product.ProductName == "" {
+			continue
+		}
+
+		entries = append(entries, search.Suggestion{Text: *product.ProductName, Kind: "product", Weight: 1 + product.Rating})
+	}
+
+	match := bson.D{{Key: "$match", Value: bson.D{{Key: "category", Value: bson.D{{Key: "$nin", Value: bson.A{nil, ""}}}}}}}
+	group := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$category"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}}
+
+	categoryCursor, err := prodCollection.Aggregate(ctx, mongo.Pipeline{match, group})
+
+	if err != nil {
+		logger.Error("error aggregating categories", slog.Any("error", err))
+		return nil, ErrCantSearch
+	}
+
+	var categories []struct {
+		Name  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+
+	if err := categoryCursor.All(ctx, &categories); err != nil {
+		logger.Error("error decoding categories", slog.Any("error", err))
+		return nil, ErrCantDecodeProducts
+	}
+
+	for _, category := range categories {
+		entries = append(entries, search.Suggestion{Text: category.Name, Kind: "category", Weight: float64(category.Count)})
+	}
+
+	return entries, nil
+}
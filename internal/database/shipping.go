@@ -0,0 +1,261 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/tax"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrShippingMethodNotFound = errors.New("shipping method not found")
+	ErrShippingUnavailable    = errors.New("shipping method is not available for this cart and address")
+	ErrNoShippingMethod       = errors.New("no shipping method delivers this cart to this address")
+	ErrShippingFlat           = errors.New("flat rate methods need a rate of zero or more in the store currency")
+	ErrShippingWeight         = errors.New("weight based methods need a rate of zero or more and a positive per_kg in the store currency")
+	ErrShippingThreshold      = errors.New("free threshold methods need a positive min_subtotal in the store currency")
+	ErrCantSaveShipping       = errors.New("cannot save shipping method")
+	ErrCantListShipping       = errors.New("cannot list shipping methods")
+)
+
+// IsShippingRejection reports whether err means the cart cannot ship as
+// requested, as opposed to a storage failure.
+func IsShippingRejection(err error) bool {
+	return errors.Is(err, ErrNoShippingMethod) || errors.Is(err, ErrShippingUnavailable)
+}
+
+// ShippingOption is a shipping method available for a cart, priced in the
+// cart currency.
+type ShippingOption struct {
+	MethodID primitive.ObjectID `json:"method_id"`
+	Name     string             `json:"name"`
+	Type     string             `json:"type"`
+	Cost     money.Money        `json:"cost"`
+}
+
+func ValidateShippingMethod(method *models.ShippingMethod, currency string) error {
+	nonNegative := func(m *money.Money) bool { return m != nil && m.Currency == currency && m.Amount >= 0 }
+	positive := func(m *money.Money) bool { return m != nil && m.Currency == currency && m.Amount > 0 }
+
+	switch method.Type {
+	case models.ShippingFlat:
+		if !nonNegative(method.Rate) {
+			return ErrShippingFlat
+		}
+	case models.ShippingWeightBased:
+		if !nonNegative(method.Rate) || !positive(method.PerKg) {
+			return ErrShippingWeight
+		}
+	case models.ShippingFreeThreshold:
+		if !positive(method.MinSubtotal) {
+			return ErrShippingThreshold
+		}
+	}
+
+	return nil
+}
+
+func CreateShippingMethod(ctx context.Context, shippingCollection *mongo.Collection, method *models.ShippingMethod) error {
+	method.MethodID = primitive.NewObjectID()
+	method.CreatedAt = time.Now()
+
+	_, err := shippingCollection.InsertOne(ctx, method)
+
+	if err != nil {
+		logger.Error("error inserting shipping method", slog.String("name", method.Name), slog.Any("error", err))
+		return ErrCantSaveShipping
+	}
+
+	logger.Info("shipping method created", slog.Any("methodID", method.MethodID), slog.String("type", method.Type))
+	return nil
+}
+
+// ListShippingMethods returns every method, or only active ones.
+func ListShippingMethods(ctx context.Context, shippingCollection *mongo.Collection, activeOnly bool) ([]models.ShippingMethod, error) {
+	filter := bson.D{}
+
+	if activeOnly {
+		filter = bson.D{{Key: "active", Value: true}}
+	}
+
+	cursor, err := shippingCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+
+	if err != nil {
+		logger.Error("error listing shipping methods", slog.Any("error", err))
+		return nil, ErrCantListShipping
+	}
+
+	methods := make([]models.ShippingMethod, 0)
+
+	if err := cursor.All(ctx, &methods); err != nil {
+		logger.Error("error decoding shipping methods", slog.Any("error", err))
+		return nil, ErrCantListShipping
+	}
+
+	return methods, nil
+}
+
+func SetShippingMethodActive(ctx context.Context, shippingCollection *mongo.Collection, methodID primitive.ObjectID, active bool) (*models.ShippingMethod, error) {
+	var method models.ShippingMethod
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: active}}}}
+
+	err := shippingCollection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: methodID}}, update, opts).Decode(&method)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrShippingMethodNotFound
+	}
+
+	if err != nil {
+		logger.Error("error updating shipping method", slog.Any("methodID", methodID), slog.Any("error", err))
+		return nil, ErrCantSaveShipping
+	}
+
+	logger.Info("shipping method updated", slog.Any("methodID", methodID), slog.Bool("active", active))
+	return &method, nil
+}
+
+// CartWeight sums the weight of all cart units in grams. Products without a
+// weight count as weightless.
+func CartWeight(items []models.ProductUser) int64 {
+	var grams int64
+
+	for _, item := range items {
+		if item.WeightGrams != nil {
+			grams += *item.WeightGrams
+		}
+	}
+
+	return grams
+}
+
+func shipsTo(method *models.ShippingMethod, address *models.Address) bool {
+	if len(method.PostalPrefixes) == 0 && len(method.Cities) == 0 {
+		return true
+	}
+
+	if address == nil {
+		return false
+	}
+
+	if address.PostalCode != "" {
+		postal := tax.NormalizePostalCode(address.PostalCode)
+
+		for _, prefix := range method.PostalPrefixes {
+			if prefix = tax.NormalizePostalCode(prefix); prefix != "" && strings.HasPrefix(postal, prefix) {
+				return true
+			}
+		}
+	}
+
+	if address.City != "" {
+		for _, city := range method.Cities {
+			if strings.EqualFold(strings.TrimSpace(city), address.City) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ShippingOptions prices every method that can deliver items to address,
+// cheapest first. merchandise is the cart value after discounts, which free
+// threshold methods are compared with; freeShipping zeroes every cost.
+func ShippingOptions(methods []models.ShippingMethod, items []models.ProductUser, address *models.Address, merchandise money.Money, freeShipping bool, rates *money.RateTable, currency string) ([]ShippingOption, error) {
+	grams := CartWeight(items)
+	available := make([]ShippingOption, 0, len(methods))
+
+	for i := range methods {
+		method := &methods[i]
+
+		if !shipsTo(method, address) {
+			continue
+		}
+
+		cost := money.Zero(rates.Base())
+		var err error
+
+		switch method.Type {
+		case models.ShippingFlat:
+			cost = *method.Rate
+		case models.ShippingWeightBased:
+			kilograms := (grams + 999) / 1000
+
+			var perWeight money.Money
+			if perWeight, err = method.PerKg.Mul(kilograms); err == nil {
+				cost, err = method.Rate.Add(perWeight)
+			}
+		case models.ShippingFreeThreshold:
+			var minimum money.Money
+			if minimum, _, err = rates.Convert(*method.MinSubtotal, currency); err == nil && merchandise.Amount < minimum.Amount {
+				continue
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if freeShipping {
+			cost = money.Zero(cost.Currency)
+		}
+
+		if cost, _, err = rates.Convert(cost, currency); err != nil {
+			return nil, err
+		}
+
+		available = append(available, ShippingOption{MethodID: method.MethodID, Name: method.Name, Type: method.Type, Cost: cost})
+	}
+
+	sort.SliceStable(available, func(i, j int) bool {
+		return available[i].Cost.Amount < available[j].Cost.Amount
+	})
+
+	return available, nil
+}
+
+// chooseShipping returns the option with methodID, or the cheapest one when
+// methodID is not set.
+func chooseShipping(available []ShippingOption, methodID primitive.ObjectID) (*ShippingOption, error) {
+	if len(available) == 0 {
+		return nil, ErrNoShippingMethod
+	}
+
+	if methodID.IsZero() {
+		return &available[0], nil
+	}
+
+	for i := range available {
+		if available[i].MethodID == methodID {
+			return &available[i], nil
+		}
+	}
+
+	return nil, ErrShippingUnavailable
+}
+
+func EnsureShippingIndexes(ctx context.Context, shippingCollection *mongo.Collection) error {
+	_, err := shippingCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+
+	if err != nil {
+		logger.Error("error creating shipping indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("shipping indexes ensured")
+	return nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("no user with this email")
+	ErrCantSetRole  = errors.New("cannot set user role")
+	ErrCantLoadRole = errors.New("cannot load user role")
+)
+
+// SetUserRole gives the user with email the role. Admin routes check the
+// stored role on every request, so it takes effect at once; tokens carry
+// the new role from the user's next login.
+func SetUserRole(ctx context.Context, userCollection *mongo.Collection, email, role string) error {
+	filter := bson.D{{Key: "email", Value: email}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}, {Key: "updatedat", Value: time.Now()}}}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		logger.Error("error setting user role", slog.String("email", email), slog.Any("error", err))
+		return ErrCantSetRole
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	logger.Info("user role set", slog.String("email", email), slog.String("role", role))
+	return nil
+}
+
+// UserRole returns the role stored for the user, which a token issued before
+// the role was granted or revoked no longer reflects.
+func UserRole(ctx context.Context, userCollection *mongo.Collection, userID string) (string, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		return "", ErrUserIDIsNotValid
+	}
+
+	var user models.User
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "role", Value: 1}})
+	err = userCollection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, opts).Decode(&user)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", ErrUserIDIsNotValid
+	}
+
+	if err != nil {
+		logger.Error("error loading user role", slog.String("userID", userID), slog.Any("error", err))
+		return "", ErrCantLoadRole
+	}
+
+	return user.Role, nil
+}
@@ -0,0 +1,417 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+// DefaultWishlistName names the list that items saved from the cart go to
+// when no list is chosen. It is created on first use.
+const DefaultWishlistName = "Saved for later"
+
+var (
+	ErrWishlistNotFound     = errors.New("wishlist not found")
+	ErrWishlistNameTaken    = errors.New("a wishlist with this name already exists")
+	ErrWishlistItemNotFound = errors.New("product is not on the wishlist")
+	ErrCartItemNotFound     = errors.New("product is not in the cart")
+	ErrCantSaveWishlist     = errors.New("cannot save wishlist")
+	ErrCantListWishlists    = errors.New("cannot list wishlists")
+)
+
+// WishlistProduct is the current state of a product on a wishlist. Products
+// deleted since they were saved are missing from the map LoadWishlistProducts
+// returns.
+type WishlistProduct struct {
+	Price money.Money
+	Stock *int64
+}
+
+func CreateWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID, name string) (*models.Wishlist, error) {
+	now := time.Now()
+	wishlist := &models.Wishlist{
+		WishlistID: primitive.NewObjectID(),
+		UserID:     userID,
+		Name:       name,
+		Items:      []models.WishlistItem{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	_, err := wishlistCollection.InsertOne(ctx, wishlist)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrWishlistNameTaken
+	}
+
+	if err != nil {
+		logger.Error("error creating wishlist", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantSaveWishlist
+	}
+
+	logger.Info("wishlist created", slog.String("userID", userID), slog.Any("wishlistID", wishlist.WishlistID))
+	return wishlist, nil
+}
+
+// ListWishlists returns a user's wishlists, oldest first.
+func ListWishlists(ctx context.Context, wishlistCollection *mongo.Collection, userID string) ([]models.Wishlist, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := wishlistCollection.Find(ctx, bson.D{{Key: "user_id", Value: userID}}, opts)
+
+	if err != nil {
+		logger.Error("error listing wishlists", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantListWishlists
+	}
+
+	wishlists := make([]models.Wishlist, 0)
+
+	if err := cursor.All(ctx, &wishlists); err != nil {
+		logger.Error("error decoding wishlists", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantListWishlists
+	}
+
+	return wishlists, nil
+}
+
+// GetWishlist returns one of the user's wishlists. Lists of other users are
+// not found.
+func GetWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) (*models.Wishlist, error) {
+	return findWishlist(ctx, wishlistCollection, bson.D{{Key: "_id", Value: wishlistID}, {Key: "user_id", Value: userID}})
+}
+
+// SharedWishlist returns the wishlist shared with token.
+func SharedWishlist(ctx context.Context, wishlistCollection *mongo.Collection, token string) (*models.Wishlist, error) {
+	if token == "" {
+		return nil, ErrWishlistNotFound
+	}
+
+	return findWishlist(ctx, wishlistCollection, bson.D{{Key: "share_token", Value: token}})
+}
+
+func findWishlist(ctx context.Context, wishlistCollection *mongo.Collection, filter bson.D) (*models.Wishlist, error) {
+	var wishlist models.Wishlist
+
+	err := wishlistCollection.FindOne(ctx, filter).Decode(&wishlist)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWishlistNotFound
+	}
+
+	if err != nil {
+		logger.Error("error loading wishlist", slog.Any("error", err))
+		return nil, ErrCantListWishlists
+	}
+
+	return &wishlist, nil
+}
+
+func RenameWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID, name string) (*models.Wishlist, error) {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}, {Key: "updated_at", Value: time.Now()}}}}
+
+	return updateWishlist(ctx, wishlistCollection, userID, wishlistID, bson.D{}, update)
+}
+
+func DeleteWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) error {
+	result, err := wishlistCollection.DeleteOne(ctx, bson.D{{Key: "_id", Value: wishlistID}, {Key: "user_id", Value: userID}})
+
+	if err != nil {
+		logger.Error("error deleting wishlist", slog.String("userID", userID), slog.Any("error", err))
+		return ErrCantSaveWishlist
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrWishlistNotFound
+	}
+
+	logger.Info("wishlist deleted", slog.String("userID", userID), slog.Any("wishlistID", wishlistID))
+	return nil
+}
+
+// AddWishlistItem saves a product on a wishlist at its current price. A
+// product already on the list keeps the price it was first saved at.
+func AddWishlistItem(ctx context.Context, wishlistCollection, prodCollection *mongo.Collection, userID string, wishlistID, productID primitive.ObjectID) (*models.Wishlist, error) {
+	var product models.ProductUser
+
+	err := prodCollection.FindOne(ctx, bson.D{{Key: "_id", Value: productID}}).Decode(&product)
+
+	if err != nil {
+		logger.Error("error finding product for wishlist", slog.Any("productID", productID), slog.Any("error", err))
+		return nil, ErrCantFindProduct
+	}
+
+	item := models.WishlistItem{
+		ProductID:   product.ProductID,
+		ProductName: product.ProductName,
+		Image:       product.Image,
+		SavedPrice:  product.Price,
+		AddedAt:     time.Now(),
+	}
+
+	filter := bson.D{{Key: "items.product_id", Value: bson.D{{Key: "$ne", Value: product.ProductID}}}}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{{Key: "items", Value: item}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: item.AddedAt}}},
+	}
+
+	wishlist, err := updateWishlist(ctx, wishlistCollection, userID, wishlistID, filter, update)
+
+	if errors.Is(err, ErrWishlistNotFound) {
+		// Either the list does not exist or the product is already on it.
+		return GetWishlist(ctx, wishlistCollection, userID, wishlistID)
+	}
+
+	return wishlist, err
+}
+
+func RemoveWishlistItem(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID, productID primitive.ObjectID) (*models.Wishlist, error) {
+	filter := bson.D{{Key: "items.product_id", Value: productID}}
+	update := bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "items", Value: bson.D{{Key: "product_id", Value: productID}}}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+	}
+
+	wishlist, err := updateWishlist(ctx, wishlistCollection, userID, wishlistID, filter, update)
+
+	if errors.Is(err, ErrWishlistNotFound) {
+		if _, err := GetWishlist(ctx, wishlistCollection, userID, wishlistID); err != nil {
+			return nil, err
+		}
+		return nil, ErrWishlistItemNotFound
+	}
+
+	return wishlist, err
+}
+
+// MoveWishlistItemToCart puts a wishlist item in the cart and takes it off
+// the list. The product is added to the cart first, so a failure in between
+// leaves it in both places rather than in neither. An item whose product was
+// deleted fails with ErrCantFindProduct and stays on the list, where it can
+// be removed on purpose.
+func MoveWishlistItemToCart(ctx context.Context, wishlistCollection, prodCollection, userCollection *mongo.Collection, userID string, wishlistID, productID primitive.ObjectID) (*models.Wishlist, error) {
+	wishlist, err := GetWishlist(ctx, wishlistCollection, userID, wishlistID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !wishlistHas(wishlist, productID) {
+		return nil, ErrWishlistItemNotFound
+	}
+
+	if err := AddProductToCart(ctx, prodCollection, userCollection, productID, userID); err != nil {
+		return nil, err
+	}
+
+	return RemoveWishlistItem(ctx, wishlistCollection, userID, wishlistID, productID)
+}
+
+// MoveCartItemToWishlist saves a cart item for later on a wishlist, at the
+// product's current price, and takes it out of the cart. Without a wishlist
+// ID it goes to the list named DefaultWishlistName.
+func MoveCartItemToWishlist(ctx context.Context, wishlistCollection, prodCollection, userCollection *mongo.Collection, userID string, wishlistID, productID primitive.ObjectID) (*models.Wishlist, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+
+	if err != nil {
+		logger.Error("invalid user ID", slog.String("userID", userID))
+		return nil, ErrUserIDIsNotValid
+	}
+
+	count, err := userCollection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}, {Key: "user_cart._id", Value: productID}})
+
+	if err != nil {
+		logger.Error("error checking cart", slog.String("userID", userID), slog.Any("error", err))
+		return nil, ErrCantSaveWishlist
+	}
+
+	if count == 0 {
+		return nil, ErrCartItemNotFound
+	}
+
+	if wishlistID.IsZero() {
+		if wishlistID, err = defaultWishlist(ctx, wishlistCollection, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	wishlist, err := AddWishlistItem(ctx, wishlistCollection, prodCollection, userID, wishlistID, productID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RemoveCartItem(ctx, prodCollection, userCollection, productID, userID); err != nil {
+		return nil, err
+	}
+
+	return wishlist, nil
+}
+
+func defaultWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string) (primitive.ObjectID, error) {
+	var wishlist models.Wishlist
+
+	err := wishlistCollection.FindOne(ctx, bson.D{{Key: "user_id", Value: userID}, {Key: "name", Value: DefaultWishlistName}}).Decode(&wishlist)
+
+	if err == nil {
+		return wishlist.WishlistID, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Error("error loading default wishlist", slog.String("userID", userID), slog.Any("error", err))
+		return primitive.NilObjectID, ErrCantListWishlists
+	}
+
+	created, err := CreateWishlist(ctx, wishlistCollection, userID, DefaultWishlistName)
+
+	if errors.Is(err, ErrWishlistNameTaken) {
+		// Created by a concurrent request in the meantime.
+		return defaultWishlist(ctx, wishlistCollection, userID)
+	}
+
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return created.WishlistID, nil
+}
+
+// ShareWishlist gives a wishlist a new random share token, which replaces
+// any earlier one, and returns it.
+func ShareWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		logger.Error("error generating share token", slog.Any("error", err))
+		return "", ErrCantSaveWishlist
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "share_token", Value: token}, {Key: "updated_at", Value: time.Now()}}}}
+
+	if _, err := updateWishlist(ctx, wishlistCollection, userID, wishlistID, bson.D{}, update); err != nil {
+		return "", err
+	}
+
+	logger.Info("wishlist shared", slog.String("userID", userID), slog.Any("wishlistID", wishlistID))
+	return token, nil
+}
+
+// UnshareWishlist revokes the share token, so the old link stops working.
+func UnshareWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) error {
+	update := bson.D{
+		{Key: "$unset", Value: bson.D{{Key: "share_token", Value: ""}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now()}}},
+	}
+
+	_, err := updateWishlist(ctx, wishlistCollection, userID, wishlistID, bson.D{}, update)
+	return err
+}
+
+// updateWishlist applies update to one of the user's wishlists, provided it
+// also matches filter, and returns the list as stored afterwards.
+func updateWishlist(ctx context.Context, wishlistCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID, filter, update bson.D) (*models.Wishlist, error) {
+	filter = append(bson.D{{Key: "_id", Value: wishlistID}, {Key: "user_id", Value: userID}}, filter...)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var wishlist models.Wishlist
+
+	err := wishlistCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&wishlist)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWishlistNotFound
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrWishlistNameTaken
+	}
+
+	if err != nil {
+		logger.Error("error updating wishlist", slog.String("userID", userID), slog.Any("wishlistID", wishlistID), slog.Any("error", err))
+		return nil, ErrCantSaveWishlist
+	}
+
+	return &wishlist, nil
+}
+
+func wishlistHas(wishlist *models.Wishlist, productID primitive.ObjectID) bool {
+	for _, item := range wishlist.Items {
+		if item.ProductID == productID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadWishlistProducts looks up the current price and stock of every
+// product on the wishlists.
+func LoadWishlistProducts(ctx context.Context, prodCollection *mongo.Collection, wishlists ...models.Wishlist) (map[primitive.ObjectID]WishlistProduct, error) {
+	ids := make([]primitive.ObjectID, 0)
+
+	for _, wishlist := range wishlists {
+		for _, item := range wishlist.Items {
+			ids = append(ids, item.ProductID)
+		}
+	}
+
+	products := make(map[primitive.ObjectID]WishlistProduct, len(ids))
+
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	opts := options.Find().SetProjection(bson.D{{Key: "price", Value: 1}, {Key: "stock", Value: 1}})
+	cursor, err := prodCollection.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, opts)
+
+	if err != nil {
+		logger.Error("error loading wishlist products", slog.Any("error", err))
+		return nil, ErrCantListWishlists
+	}
+
+	var found []models.Product
+
+	if err := cursor.All(ctx, &found); err != nil {
+		logger.Error("error decoding wishlist products", slog.Any("error", err))
+		return nil, ErrCantListWishlists
+	}
+
+	for _, product := range found {
+		if product.Price != nil {
+			products[product.ProductID] = WishlistProduct{Price: *product.Price, Stock: product.Stock}
+		}
+	}
+
+	return products, nil
+}
+
+func EnsureWishlistIndexes(ctx context.Context, wishlistCollection *mongo.Collection) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "share_token", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	}
+
+	_, err := wishlistCollection.Indexes().CreateMany(ctx, indexes)
+
+	if err != nil {
+		logger.Error("error creating wishlist indexes", slog.Any("error", err))
+		return ErrCantCreateIndexes
+	}
+
+	logger.Info("wishlist indexes ensured")
+	return nil
+}
@@ -0,0 +1,103 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AddressRequest creates an address or, on update, changes the fields it
+// sets. Country is an ISO 3166-1 alpha-2 code; postal code rules depend on
+// it.
+type AddressRequest struct {
+	Label           *string `json:"label"            validate:"omitempty,max=50"`
+	RecipientName   *string `json:"recipient_name"`
+	Line1           *string `json:"line1"`
+	Line2           *string `json:"line2"`
+	City            *string `json:"city"`
+	Region          *string `json:"region"`
+	PostalCode      *string `json:"postal_code"`
+	Country         *string `json:"country"`
+	Phone           *string `json:"phone"`
+	DefaultShipping *bool   `json:"default_shipping"`
+	DefaultBilling  *bool   `json:"default_billing"`
+}
+
+// Merge returns base with the fields set on the request applied. Adding an
+// address merges onto the zero address.
+func (r AddressRequest) Merge(base models.Address) models.Address {
+	for _, field := range []struct {
+		value *string
+		into  *string
+	}{
+		{r.Label, &base.Label},
+		{r.RecipientName, &base.RecipientName},
+		{r.Line1, &base.Line1},
+		{r.Line2, &base.Line2},
+		{r.City, &base.City},
+		{r.Region, &base.Region},
+		{r.PostalCode, &base.PostalCode},
+		{r.Country, &base.Country},
+		{r.Phone, &base.Phone},
+	} {
+		if field.value != nil {
+			*field.into = *field.value
+		}
+	}
+
+	if r.DefaultShipping != nil {
+		base.DefaultShipping = *r.DefaultShipping
+	}
+	if r.DefaultBilling != nil {
+		base.DefaultBilling = *r.DefaultBilling
+	}
+
+	return base
+}
+
+// Update stores merged as the new content of the address, together with the
+// default flags the request sets.
+func (r AddressRequest) Update(merged models.Address) database.AddressUpdate {
+	return database.AddressUpdate{
+		Address:         &merged,
+		DefaultShipping: r.DefaultShipping,
+		DefaultBilling:  r.DefaultBilling,
+	}
+}
+
+type AddressResponse struct {
+	AddressID       primitive.ObjectID `json:"address_id"`
+	Label           string             `json:"label"`
+	RecipientName   string             `json:"recipient_name"`
+	Line1           string             `json:"line1"`
+	Line2           string             `json:"line2,omitempty"`
+	City            string             `json:"city"`
+	Region          string             `json:"region,omitempty"`
+	PostalCode      string             `json:"postal_code,omitempty"`
+	Country         string             `json:"country"`
+	Phone           string             `json:"phone,omitempty"`
+	DefaultShipping bool               `json:"default_shipping"`
+	DefaultBilling  bool               `json:"default_billing"`
+}
+
+func NewAddressResponse(a models.Address) AddressResponse {
+	return AddressResponse{
+		AddressID:       a.AddressId,
+		Label:           a.Label,
+		RecipientName:   a.RecipientName,
+		Line1:           a.Line1,
+		Line2:           a.Line2,
+		City:            a.City,
+		Region:          a.Region,
+		PostalCode:      a.PostalCode,
+		Country:         a.Country,
+		Phone:           a.Phone,
+		DefaultShipping: a.DefaultShipping,
+		DefaultBilling:  a.DefaultBilling,
+	}
+}
+
+func NewAddressResponses(addresses []models.Address) []AddressResponse {
+	return mapAll(addresses, NewAddressResponse)
+}
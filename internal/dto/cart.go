@@ -0,0 +1,165 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CartItemResponse is one unit of a product in a cart or order, at the price
+// it was added or ordered at.
+type CartItemResponse struct {
+	ProductID    primitive.ObjectID `json:"product_id"`
+	ProductName  *string            `json:"product_name"`
+	Price        money.Money        `json:"price"`
+	PriceList    []money.Money      `json:"price_list,omitempty"`
+	DisplayPrice *money.Money       `json:"display_price,omitempty"`
+	Rating       float64            `json:"rating"`
+	Image        *string            `json:"image"`
+	Category     *string            `json:"category,omitempty"`
+	TaxClass     *string            `json:"tax_class,omitempty"`
+	WeightGrams  *int64             `json:"weight_grams,omitempty"`
+}
+
+func NewCartItemResponse(p models.ProductUser) CartItemResponse {
+	return CartItemResponse{
+		ProductID:    p.ProductID,
+		ProductName:  p.ProductName,
+		Price:        p.Price,
+		PriceList:    p.PriceList,
+		DisplayPrice: p.DisplayPrice,
+		Rating:       p.Rating,
+		Image:        p.Image,
+		Category:     p.Category,
+		TaxClass:     p.TaxClass,
+		WeightGrams:  p.WeightGrams,
+	}
+}
+
+func NewCartItemResponses(items []models.ProductUser) []CartItemResponse {
+	return mapAll(items, NewCartItemResponse)
+}
+
+type AppliedCouponResponse struct {
+	Code         string      `json:"code"`
+	Type         string      `json:"type,omitempty"`
+	Discount     money.Money `json:"discount"`
+	FreeShipping bool        `json:"free_shipping"`
+	Error        string      `json:"error,omitempty"`
+}
+
+type ShippingOptionResponse struct {
+	MethodID primitive.ObjectID `json:"method_id"`
+	Name     string             `json:"name"`
+	Type     string             `json:"type"`
+	Cost     money.Money        `json:"cost"`
+}
+
+func NewShippingOptionResponse(o database.ShippingOption) ShippingOptionResponse {
+	return ShippingOptionResponse{MethodID: o.MethodID, Name: o.Name, Type: o.Type, Cost: o.Cost}
+}
+
+type CartIssueResponse struct {
+	ProductID   primitive.ObjectID `json:"product_id"`
+	ProductName *string            `json:"product_name"`
+	Issue       string             `json:"issue"`
+	Quantity    int                `json:"quantity"`
+	CartPrice   money.Money        `json:"cart_price"`
+	Price       *money.Money       `json:"price,omitempty"`
+}
+
+// CartQuoteResponse is the cart priced in one currency, with its discounts,
+// tax and shipping, and the issues checkout would stop at.
+type CartQuoteResponse struct {
+	Currency        string                    `json:"currency"`
+	Items           []CartItemResponse        `json:"items"`
+	Subtotal        money.Money               `json:"subtotal"`
+	Promotions      []models.AppliedPromotion `json:"promotions"`
+	Coupon          *AppliedCouponResponse    `json:"coupon,omitempty"`
+	Discount        money.Money               `json:"discount"`
+	TaxMode         string                    `json:"tax_mode"`
+	TaxRegion       string                    `json:"tax_region"`
+	Tax             money.Money               `json:"tax"`
+	TaxLines        []models.TaxLine          `json:"tax_lines"`
+	WeightGrams     int64                     `json:"weight_grams"`
+	ShippingOptions []ShippingOptionResponse  `json:"shipping_options,omitempty"`
+	Shipping        *ShippingOptionResponse   `json:"shipping,omitempty"`
+	ShippingError   string                    `json:"shipping_error,omitempty"`
+	Total           money.Money               `json:"total"`
+	Issues          []CartIssueResponse       `json:"issues,omitempty"`
+	// PriceChangesToken is sent back as accept_price_changes to check out
+	// at the changed prices listed in Issues.
+	PriceChangesToken string     `json:"price_changes_token,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+}
+
+func NewCartQuoteResponse(q *database.CartQuote) CartQuoteResponse {
+	response := CartQuoteResponse{
+		Currency:          q.Currency,
+		Items:             NewCartItemResponses(q.Items),
+		Subtotal:          q.Subtotal,
+		Promotions:        q.Promotions,
+		Discount:          q.Discount,
+		TaxMode:           q.TaxMode,
+		TaxRegion:         q.TaxRegion,
+		Tax:               q.Tax,
+		TaxLines:          q.TaxLines,
+		WeightGrams:       q.WeightGrams,
+		ShippingError:     q.ShippingError,
+		Total:             q.Total,
+		PriceChangesToken: q.PriceChangesToken,
+		UpdatedAt:         q.UpdatedAt,
+	}
+
+	if q.Coupon != nil {
+		response.Coupon = &AppliedCouponResponse{
+			Code:         q.Coupon.Code,
+			Type:         q.Coupon.Type,
+			Discount:     q.Coupon.Discount,
+			FreeShipping: q.Coupon.FreeShipping,
+			Error:        q.Coupon.Error,
+		}
+	}
+
+	if len(q.ShippingOptions) > 0 {
+		response.ShippingOptions = mapAll(q.ShippingOptions, NewShippingOptionResponse)
+	}
+
+	if q.Shipping != nil {
+		shipping := NewShippingOptionResponse(*q.Shipping)
+		response.Shipping = &shipping
+	}
+
+	if len(q.Issues) > 0 {
+		response.Issues = mapAll(q.Issues, func(i database.CartIssue) CartIssueResponse {
+			return CartIssueResponse{
+				ProductID:   i.ProductID,
+				ProductName: i.ProductName,
+				Issue:       i.Issue,
+				Quantity:    i.Quantity,
+				CartPrice:   i.CartPrice,
+				Price:       i.Price,
+			}
+		})
+	}
+
+	return response
+}
+
+// ShippingQuoteResponse lists the shipping options for a cart.
+type ShippingQuoteResponse struct {
+	Currency    string                   `json:"currency"`
+	WeightGrams int64                    `json:"weight_grams"`
+	Options     []ShippingOptionResponse `json:"options"`
+}
+
+func NewShippingQuoteResponse(q *database.CartQuote) ShippingQuoteResponse {
+	return ShippingQuoteResponse{
+		Currency:    q.Currency,
+		WeightGrams: q.WeightGrams,
+		Options:     mapAll(q.ShippingOptions, NewShippingOptionResponse),
+	}
+}
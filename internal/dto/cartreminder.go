@@ -0,0 +1,49 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CartReminderResponse struct {
+	ReminderID  primitive.ObjectID  `json:"reminder_id"`
+	UserID      string              `json:"user_id"`
+	Items       int                 `json:"items"`
+	CartValue   money.Money         `json:"cart_value"`
+	Notifier    string              `json:"notifier"`
+	SentAt      time.Time           `json:"sent_at"`
+	Recovered   bool                `json:"recovered"`
+	RecoveredAt *time.Time          `json:"recovered_at,omitempty"`
+	OrderID     *primitive.ObjectID `json:"order_id,omitempty"`
+	OrderTotal  *money.Money        `json:"order_total,omitempty"`
+}
+
+// CartRemindersResponse lists recent reminders together with how many were
+// sent and recovered in total.
+type CartRemindersResponse struct {
+	database.CartReminderStats
+	Reminders []CartReminderResponse `json:"reminders"`
+}
+
+func NewCartReminderResponse(r models.CartReminder) CartReminderResponse {
+	return CartReminderResponse{
+		ReminderID:  r.ReminderID,
+		UserID:      r.UserID,
+		Items:       r.Items,
+		CartValue:   r.CartValue,
+		Notifier:    r.Notifier,
+		SentAt:      r.SentAt,
+		Recovered:   r.RecoveredAt != nil,
+		RecoveredAt: r.RecoveredAt,
+		OrderID:     r.OrderID,
+		OrderTotal:  r.OrderTotal,
+	}
+}
+
+func NewCartRemindersResponse(reminders []models.CartReminder, stats database.CartReminderStats) CartRemindersResponse {
+	return CartRemindersResponse{CartReminderStats: stats, Reminders: mapAll(reminders, NewCartReminderResponse)}
+}
@@ -0,0 +1,95 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductRequest is a product as an admin creates it. Ratings, images and
+// search terms are maintained by the store.
+type ProductRequest struct {
+	SKU         *string       `json:"sku"`
+	ProductName string        `json:"product_name"           validate:"required"`
+	Price       *money.Money  `json:"price"                  validate:"required"`
+	PriceList   []money.Money `json:"price_list,omitempty"`
+	Image       *string       `json:"image"`
+	Description *string       `json:"description"`
+	Category    *string       `json:"category"`
+	TaxClass    *string       `json:"tax_class,omitempty"`
+	WeightGrams *int64        `json:"weight_grams,omitempty" validate:"omitempty,min=0"`
+	Stock       *int64        `json:"stock"`
+}
+
+func (r ProductRequest) Product() models.Product {
+	return models.Product{
+		SKU:         r.SKU,
+		ProductName: &r.ProductName,
+		Price:       r.Price,
+		PriceList:   r.PriceList,
+		Image:       r.Image,
+		Description: r.Description,
+		Category:    r.Category,
+		TaxClass:    r.TaxClass,
+		WeightGrams: r.WeightGrams,
+		Stock:       r.Stock,
+	}
+}
+
+type ReorderImagesRequest struct {
+	ImageIDs []primitive.ObjectID `json:"image_ids"`
+}
+
+type ReviewRequest struct {
+	Rating uint8  `json:"rating" validate:"required,min=1,max=5"`
+	Text   string `json:"text"   validate:"max=2000"`
+}
+
+// Review maps the request onto a new review of productID by userID.
+func (r ReviewRequest) Review(productID primitive.ObjectID, userID string) models.Review {
+	return models.Review{ProductID: productID, UserID: userID, Rating: r.Rating, Text: r.Text}
+}
+
+type ModerateReviewRequest struct {
+	Status string `json:"status" validate:"required,oneof=pending approved rejected"`
+}
+
+type ReviewResponse struct {
+	ReviewID  primitive.ObjectID `json:"review_id"`
+	ProductID primitive.ObjectID `json:"product_id"`
+	UserID    string             `json:"user_id"`
+	Rating    uint8              `json:"rating"`
+	Text      string             `json:"text"`
+	Status    string             `json:"status"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+func NewReviewResponse(r models.Review) ReviewResponse {
+	return ReviewResponse{
+		ReviewID:  r.ReviewID,
+		ProductID: r.ProductID,
+		UserID:    r.UserID,
+		Rating:    r.Rating,
+		Text:      r.Text,
+		Status:    r.Status,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
+
+func NewReviewResponses(reviews []models.Review) []ReviewResponse {
+	return mapAll(reviews, NewReviewResponse)
+}
+
+// mapAll maps every element of in, returning an empty slice rather than nil
+// so lists encode as [].
+func mapAll[M, R any](in []M, f func(M) R) []R {
+	out := make([]R, len(in))
+	for i := range in {
+		out[i] = f(in[i])
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+package dto
+
+import (
+	"github.com/maksimulitin/lib/money"
+	"time"
+)
+
+// GuestCartTokenResponse hands a new guest cart's token to the client, which
+// sends it back in the X-Cart-Token header.
+type GuestCartTokenResponse struct {
+	CartToken string    `json:"cart_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GuestCartResponse is a guest cart priced in the requested currency.
+type GuestCartResponse struct {
+	Currency  string             `json:"currency"`
+	Items     []CartItemResponse `json:"items"`
+	Subtotal  money.Money        `json:"subtotal"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
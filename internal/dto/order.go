@@ -0,0 +1,156 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrderResponse is a placed order. Promotion, tax, shipping and address
+// entries keep their stored shape.
+type OrderResponse struct {
+	OrderID         primitive.ObjectID        `json:"order_id"`
+	Status          string                    `json:"status"`
+	CancelReason    string                    `json:"cancel_reason,omitempty"`
+	OrderCart       []CartItemResponse        `json:"order_list"`
+	OrderedAt       time.Time                 `json:"ordered_on"`
+	Price           money.Money               `json:"total_price"`
+	BaseTotal       money.Money               `json:"base_total"`
+	Currency        string                    `json:"currency"`
+	ExchangeRate    string                    `json:"exchange_rate,omitempty"`
+	Discount        *money.Money              `json:"discount"`
+	CouponCode      string                    `json:"coupon_code"`
+	Promotions      []models.AppliedPromotion `json:"promotions"`
+	TaxMode         string                    `json:"tax_mode"`
+	TaxRegion       string                    `json:"tax_region"`
+	Tax             *money.Money              `json:"tax"`
+	TaxLines        []models.TaxLine          `json:"tax_lines"`
+	Shipping        *models.OrderShipping     `json:"shipping"`
+	FreeShipping    bool                      `json:"free_shipping"`
+	ShippingAddress *models.OrderAddress      `json:"shipping_address"`
+	BillingAddress  *models.OrderAddress      `json:"billing_address"`
+	Payment         PaymentResponse           `json:"payment_method"`
+}
+
+func NewOrderResponse(o models.Order) OrderResponse {
+	return OrderResponse{
+		OrderID:         o.OrderID,
+		Status:          orderStatus(o.Status),
+		CancelReason:    o.CancelReason,
+		OrderCart:       NewCartItemResponses(o.OrderCart),
+		OrderedAt:       o.OrderedAt,
+		Price:           o.Price,
+		BaseTotal:       o.BaseTotal,
+		Currency:        o.Currency,
+		ExchangeRate:    o.ExchangeRate,
+		Discount:        o.Discount,
+		CouponCode:      o.CouponCode,
+		Promotions:      o.Promotions,
+		TaxMode:         o.TaxMode,
+		TaxRegion:       o.TaxRegion,
+		Tax:             o.Tax,
+		TaxLines:        o.TaxLines,
+		Shipping:        o.Shipping,
+		FreeShipping:    o.FreeShipping,
+		ShippingAddress: o.ShippingAddress,
+		BillingAddress:  o.BillingAddress,
+		Payment:         NewPaymentResponse(o.PaymentMethod),
+	}
+}
+
+// orderStatus reports orders placed before statuses were stored as placed.
+func orderStatus(status string) string {
+	if status == "" {
+		return models.OrderPlaced
+	}
+	return status
+}
+
+func NewOrderResponses(orders []models.Order) []OrderResponse {
+	return mapAll(orders, NewOrderResponse)
+}
+
+// AdminOrderResponse is an order as admins see it, with the user who placed
+// it.
+type AdminOrderResponse struct {
+	UserID string `json:"user_id"`
+	OrderResponse
+}
+
+// CheckoutRequest is the optional body of a checkout. Addresses entered here
+// are used instead of saved ones named by address_id and
+// billing_address_id; without a billing address the order is billed to the
+// shipping address. PaymentToken is the card token from the provider; it
+// travels in the body so it never ends up in URLs or access logs.
+type CheckoutRequest struct {
+	ShippingAddress *AddressRequest `json:"shipping_address"`
+	BillingAddress  *AddressRequest `json:"billing_address"`
+	PaymentToken    string          `json:"payment_token"`
+}
+
+// RefundRequest refunds Amount, or the whole captured amount when it is
+// left out.
+type RefundRequest struct {
+	Amount *money.Money `json:"amount"`
+}
+
+// PaymentResponse is how an order is paid for and how far the payment got.
+type PaymentResponse struct {
+	Digital   bool         `json:"digital"`
+	COD       bool         `json:"cod"`
+	Provider  string       `json:"provider,omitempty"`
+	Reference string       `json:"reference,omitempty"`
+	Status    string       `json:"status,omitempty"`
+	Amount    *money.Money `json:"amount,omitempty"`
+	Refunded  *money.Money `json:"refunded,omitempty"`
+}
+
+func NewPaymentResponse(p models.Payment) PaymentResponse {
+	return PaymentResponse{
+		Digital:   p.Digital,
+		COD:       p.COD,
+		Provider:  p.Provider,
+		Reference: p.Reference,
+		Status:    p.Status,
+		Amount:    p.Amount,
+		Refunded:  p.Refunded,
+	}
+}
+
+type PaymentTransactionResponse struct {
+	TransactionID primitive.ObjectID `json:"transaction_id"`
+	OrderID       primitive.ObjectID `json:"order_id"`
+	UserID        string             `json:"user_id"`
+	Provider      string             `json:"provider"`
+	Reference     string             `json:"reference"`
+	Operation     string             `json:"operation"`
+	Status        string             `json:"status"`
+	Amount        money.Money        `json:"amount"`
+	DeclineReason string             `json:"decline_reason,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	EventID       string             `json:"event_id,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+}
+
+func NewPaymentTransactionResponse(tx models.PaymentTransaction) PaymentTransactionResponse {
+	return PaymentTransactionResponse{
+		TransactionID: tx.TransactionID,
+		OrderID:       tx.OrderID,
+		UserID:        tx.UserID,
+		Provider:      tx.Provider,
+		Reference:     tx.Reference,
+		Operation:     tx.Operation,
+		Status:        tx.Status,
+		Amount:        tx.Amount,
+		DeclineReason: tx.DeclineReason,
+		Error:         tx.Error,
+		EventID:       tx.EventID,
+		CreatedAt:     tx.CreatedAt,
+	}
+}
+
+func NewPaymentTransactionResponses(transactions []models.PaymentTransaction) []PaymentTransactionResponse {
+	return mapAll(transactions, NewPaymentTransactionResponse)
+}
@@ -0,0 +1,294 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"github.com/maksimulitin/lib/tax"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetActiveRequest enables or disables a coupon, promotion or shipping
+// method.
+type SetActiveRequest struct {
+	Active *bool `json:"active" validate:"required"`
+}
+
+type ApplyCouponRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type CouponRequest struct {
+	Code           string               `json:"code"                     validate:"required,min=3,max=32,alphanum"`
+	Type           string               `json:"type"                     validate:"required,oneof=percentage fixed_amount free_shipping"`
+	PercentOff     int64                `json:"percent_off,omitempty"    validate:"min=0,max=100"`
+	AmountOff      *money.Money         `json:"amount_off,omitempty"`
+	MinCartValue   *money.Money         `json:"min_cart_value,omitempty"`
+	ProductIDs     []primitive.ObjectID `json:"product_ids,omitempty"`
+	Categories     []string             `json:"categories,omitempty"`
+	StartsAt       *time.Time           `json:"starts_at,omitempty"`
+	EndsAt         *time.Time           `json:"ends_at,omitempty"`
+	MaxUses        int64                `json:"max_uses"                 validate:"min=0"`
+	MaxUsesPerUser int64                `json:"max_uses_per_user"        validate:"min=0"`
+	Active         bool                 `json:"active"`
+}
+
+func (r CouponRequest) Coupon() models.Coupon {
+	return models.Coupon{
+		Code:           r.Code,
+		Type:           r.Type,
+		PercentOff:     r.PercentOff,
+		AmountOff:      r.AmountOff,
+		MinCartValue:   r.MinCartValue,
+		ProductIDs:     r.ProductIDs,
+		Categories:     r.Categories,
+		StartsAt:       r.StartsAt,
+		EndsAt:         r.EndsAt,
+		MaxUses:        r.MaxUses,
+		MaxUsesPerUser: r.MaxUsesPerUser,
+		Active:         r.Active,
+	}
+}
+
+type CouponResponse struct {
+	CouponID       primitive.ObjectID   `json:"coupon_id"`
+	Code           string               `json:"code"`
+	Type           string               `json:"type"`
+	PercentOff     int64                `json:"percent_off,omitempty"`
+	AmountOff      *money.Money         `json:"amount_off,omitempty"`
+	MinCartValue   *money.Money         `json:"min_cart_value,omitempty"`
+	ProductIDs     []primitive.ObjectID `json:"product_ids,omitempty"`
+	Categories     []string             `json:"categories,omitempty"`
+	StartsAt       *time.Time           `json:"starts_at,omitempty"`
+	EndsAt         *time.Time           `json:"ends_at,omitempty"`
+	MaxUses        int64                `json:"max_uses"`
+	MaxUsesPerUser int64                `json:"max_uses_per_user"`
+	Uses           int64                `json:"uses"`
+	Active         bool                 `json:"active"`
+	CreatedAt      time.Time            `json:"created_at"`
+}
+
+func NewCouponResponse(c models.Coupon) CouponResponse {
+	return CouponResponse{
+		CouponID:       c.CouponID,
+		Code:           c.Code,
+		Type:           c.Type,
+		PercentOff:     c.PercentOff,
+		AmountOff:      c.AmountOff,
+		MinCartValue:   c.MinCartValue,
+		ProductIDs:     c.ProductIDs,
+		Categories:     c.Categories,
+		StartsAt:       c.StartsAt,
+		EndsAt:         c.EndsAt,
+		MaxUses:        c.MaxUses,
+		MaxUsesPerUser: c.MaxUsesPerUser,
+		Uses:           c.Uses,
+		Active:         c.Active,
+		CreatedAt:      c.CreatedAt,
+	}
+}
+
+func NewCouponResponses(coupons []models.Coupon) []CouponResponse {
+	return mapAll(coupons, NewCouponResponse)
+}
+
+type PromotionRequest struct {
+	Name             string                 `json:"name"                         validate:"required,max=100"`
+	Type             string                 `json:"type"                         validate:"required,oneof=buy_x_get_y bundle spend_threshold"`
+	Priority         int                    `json:"priority"`
+	Exclusive        bool                   `json:"exclusive"`
+	Active           bool                   `json:"active"`
+	StartsAt         *time.Time             `json:"starts_at,omitempty"`
+	EndsAt           *time.Time             `json:"ends_at,omitempty"`
+	ProductIDs       []primitive.ObjectID   `json:"product_ids,omitempty"`
+	Categories       []string               `json:"categories,omitempty"`
+	BuyQuantity      int64                  `json:"buy_quantity,omitempty"       validate:"min=0"`
+	GetQuantity      int64                  `json:"get_quantity,omitempty"       validate:"min=0"`
+	GetPercentOff    int64                  `json:"get_percent_off,omitempty"    validate:"min=0,max=100"`
+	BundleProductIDs []primitive.ObjectID   `json:"bundle_product_ids,omitempty"`
+	BundlePrice      *money.Money           `json:"bundle_price,omitempty"`
+	Tiers            []models.PromotionTier `json:"tiers,omitempty"              validate:"dive"`
+}
+
+func (r PromotionRequest) Promotion() models.Promotion {
+	return models.Promotion{
+		Name:             r.Name,
+		Type:             r.Type,
+		Priority:         r.Priority,
+		Exclusive:        r.Exclusive,
+		Active:           r.Active,
+		StartsAt:         r.StartsAt,
+		EndsAt:           r.EndsAt,
+		ProductIDs:       r.ProductIDs,
+		Categories:       r.Categories,
+		BuyQuantity:      r.BuyQuantity,
+		GetQuantity:      r.GetQuantity,
+		GetPercentOff:    r.GetPercentOff,
+		BundleProductIDs: r.BundleProductIDs,
+		BundlePrice:      r.BundlePrice,
+		Tiers:            r.Tiers,
+	}
+}
+
+type PromotionResponse struct {
+	PromotionID      primitive.ObjectID     `json:"promotion_id"`
+	Name             string                 `json:"name"`
+	Type             string                 `json:"type"`
+	Priority         int                    `json:"priority"`
+	Exclusive        bool                   `json:"exclusive"`
+	Active           bool                   `json:"active"`
+	StartsAt         *time.Time             `json:"starts_at,omitempty"`
+	EndsAt           *time.Time             `json:"ends_at,omitempty"`
+	ProductIDs       []primitive.ObjectID   `json:"product_ids,omitempty"`
+	Categories       []string               `json:"categories,omitempty"`
+	BuyQuantity      int64                  `json:"buy_quantity,omitempty"`
+	GetQuantity      int64                  `json:"get_quantity,omitempty"`
+	GetPercentOff    int64                  `json:"get_percent_off,omitempty"`
+	BundleProductIDs []primitive.ObjectID   `json:"bundle_product_ids,omitempty"`
+	BundlePrice      *money.Money           `json:"bundle_price,omitempty"`
+	Tiers            []models.PromotionTier `json:"tiers,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+}
+
+func NewPromotionResponse(p models.Promotion) PromotionResponse {
+	return PromotionResponse{
+		PromotionID:      p.PromotionID,
+		Name:             p.Name,
+		Type:             p.Type,
+		Priority:         p.Priority,
+		Exclusive:        p.Exclusive,
+		Active:           p.Active,
+		StartsAt:         p.StartsAt,
+		EndsAt:           p.EndsAt,
+		ProductIDs:       p.ProductIDs,
+		Categories:       p.Categories,
+		BuyQuantity:      p.BuyQuantity,
+		GetQuantity:      p.GetQuantity,
+		GetPercentOff:    p.GetPercentOff,
+		BundleProductIDs: p.BundleProductIDs,
+		BundlePrice:      p.BundlePrice,
+		Tiers:            p.Tiers,
+		CreatedAt:        p.CreatedAt,
+	}
+}
+
+func NewPromotionResponses(promotions []models.Promotion) []PromotionResponse {
+	return mapAll(promotions, NewPromotionResponse)
+}
+
+type ShippingMethodRequest struct {
+	Name           string       `json:"name"                      validate:"required,max=100"`
+	Type           string       `json:"type"                      validate:"required,oneof=flat weight_based free_threshold"`
+	Rate           *money.Money `json:"rate,omitempty"`
+	PerKg          *money.Money `json:"per_kg,omitempty"`
+	MinSubtotal    *money.Money `json:"min_subtotal,omitempty"`
+	PostalPrefixes []string     `json:"postal_prefixes,omitempty"`
+	Cities         []string     `json:"cities,omitempty"`
+	Active         bool         `json:"active"`
+}
+
+func (r ShippingMethodRequest) ShippingMethod() models.ShippingMethod {
+	return models.ShippingMethod{
+		Name:           r.Name,
+		Type:           r.Type,
+		Rate:           r.Rate,
+		PerKg:          r.PerKg,
+		MinSubtotal:    r.MinSubtotal,
+		PostalPrefixes: r.PostalPrefixes,
+		Cities:         r.Cities,
+		Active:         r.Active,
+	}
+}
+
+type ShippingMethodResponse struct {
+	MethodID       primitive.ObjectID `json:"method_id"`
+	Name           string             `json:"name"`
+	Type           string             `json:"type"`
+	Rate           *money.Money       `json:"rate,omitempty"`
+	PerKg          *money.Money       `json:"per_kg,omitempty"`
+	MinSubtotal    *money.Money       `json:"min_subtotal,omitempty"`
+	PostalPrefixes []string           `json:"postal_prefixes,omitempty"`
+	Cities         []string           `json:"cities,omitempty"`
+	Active         bool               `json:"active"`
+	CreatedAt      time.Time          `json:"created_at"`
+}
+
+func NewShippingMethodResponse(m models.ShippingMethod) ShippingMethodResponse {
+	return ShippingMethodResponse{
+		MethodID:       m.MethodID,
+		Name:           m.Name,
+		Type:           m.Type,
+		Rate:           m.Rate,
+		PerKg:          m.PerKg,
+		MinSubtotal:    m.MinSubtotal,
+		PostalPrefixes: m.PostalPrefixes,
+		Cities:         m.Cities,
+		Active:         m.Active,
+		CreatedAt:      m.CreatedAt,
+	}
+}
+
+func NewShippingMethodResponses(methods []models.ShippingMethod) []ShippingMethodResponse {
+	return mapAll(methods, NewShippingMethodResponse)
+}
+
+// ExchangeRatesRequest replaces the exchange rates. Each rate is the price of
+// one unit of Base in the keyed currency, as a decimal string.
+type ExchangeRatesRequest struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+func (r ExchangeRatesRequest) Snapshot() money.RateSnapshot {
+	return money.RateSnapshot{Base: strings.ToUpper(r.Base), Rates: r.Rates}
+}
+
+type ExchangeRatesResponse struct {
+	Base      string            `json:"base"`
+	Rates     map[string]string `json:"rates"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+func NewExchangeRatesResponse(s money.RateSnapshot) ExchangeRatesResponse {
+	return ExchangeRatesResponse{Base: s.Base, Rates: s.Rates, UpdatedAt: s.UpdatedAt}
+}
+
+// TaxRegion is a tax region with its rate per tax class, as decimal
+// fractions such as "0.2".
+type TaxRegion struct {
+	Name           string            `json:"name"`
+	PostalPrefixes []string          `json:"postal_prefixes,omitempty"`
+	Cities         []string          `json:"cities,omitempty"`
+	Rates          map[string]string `json:"rates"`
+}
+
+func (r TaxRegion) region() tax.Region {
+	return tax.Region{Name: r.Name, PostalPrefixes: r.PostalPrefixes, Cities: r.Cities, Rates: r.Rates}
+}
+
+func newTaxRegion(r tax.Region) TaxRegion {
+	return TaxRegion{Name: r.Name, PostalPrefixes: r.PostalPrefixes, Cities: r.Cities, Rates: r.Rates}
+}
+
+type TaxRulesRequest struct {
+	Mode    string      `json:"mode"`
+	Default TaxRegion   `json:"default"`
+	Regions []TaxRegion `json:"regions"`
+}
+
+func (r TaxRulesRequest) Rules() tax.Rules {
+	return tax.Rules{Mode: r.Mode, Default: r.Default.region(), Regions: mapAll(r.Regions, TaxRegion.region)}
+}
+
+type TaxRulesResponse struct {
+	Mode      string      `json:"mode"`
+	Default   TaxRegion   `json:"default"`
+	Regions   []TaxRegion `json:"regions"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+func NewTaxRulesResponse(r tax.Rules) TaxRulesResponse {
+	return TaxRulesResponse{Mode: r.Mode, Default: newTaxRegion(r.Default), Regions: mapAll(r.Regions, newTaxRegion), UpdatedAt: r.UpdatedAt}
+}
@@ -0,0 +1,129 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductResponse is a product as customers see it. The rating sum and
+// search terms the store keeps for aggregation and lookup are left out.
+type ProductResponse struct {
+	ProductID    primitive.ObjectID     `json:"product_id"`
+	SKU          *string                `json:"sku"`
+	ProductName  *string                `json:"product_name"`
+	Price        *money.Money           `json:"price"`
+	PriceList    []money.Money          `json:"price_list,omitempty"`
+	DisplayPrice *money.Money           `json:"display_price,omitempty"`
+	Rating       float64                `json:"rating"`
+	RatingCount  int64                  `json:"rating_count"`
+	Image        *string                `json:"image"`
+	Images       []ProductImageResponse `json:"images"`
+	Description  *string                `json:"description"`
+	Category     *string                `json:"category"`
+	TaxClass     *string                `json:"tax_class,omitempty"`
+	WeightGrams  *int64                 `json:"weight_grams,omitempty"`
+	Stock        *int64                 `json:"stock"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+func NewProductResponse(p models.Product) ProductResponse {
+	return ProductResponse{
+		ProductID:    p.ProductID,
+		SKU:          p.SKU,
+		ProductName:  p.ProductName,
+		Price:        p.Price,
+		PriceList:    p.PriceList,
+		DisplayPrice: p.DisplayPrice,
+		Rating:       p.Rating,
+		RatingCount:  p.RatingCount,
+		Image:        p.Image,
+		Images:       NewProductImageResponses(p.Images),
+		Description:  p.Description,
+		Category:     p.Category,
+		TaxClass:     p.TaxClass,
+		WeightGrams:  p.WeightGrams,
+		Stock:        p.Stock,
+		CreatedAt:    p.CreatedAt,
+	}
+}
+
+func NewProductResponses(products []models.Product) []ProductResponse {
+	return mapAll(products, NewProductResponse)
+}
+
+// ProductImageResponse is a stored image without its storage keys.
+type ProductImageResponse struct {
+	ImageID     primitive.ObjectID  `json:"image_id"`
+	ContentType string              `json:"content_type"`
+	Size        int64               `json:"size"`
+	Width       int                 `json:"width"`
+	Height      int                 `json:"height"`
+	URL         string              `json:"url"`
+	Thumbnails  []ThumbnailResponse `json:"thumbnails"`
+}
+
+type ThumbnailResponse struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+func NewProductImageResponse(img models.ProductImage) ProductImageResponse {
+	return ProductImageResponse{
+		ImageID:     img.ImageID,
+		ContentType: img.ContentType,
+		Size:        img.Size,
+		Width:       img.Width,
+		Height:      img.Height,
+		URL:         img.URL,
+		Thumbnails: mapAll(img.Thumbnails, func(t models.Thumbnail) ThumbnailResponse {
+			return ThumbnailResponse{Name: t.Name, Width: t.Width, Height: t.Height, URL: t.URL}
+		}),
+	}
+}
+
+func NewProductImageResponses(images []models.ProductImage) []ProductImageResponse {
+	return mapAll(images, NewProductImageResponse)
+}
+
+type ProductPageResponse struct {
+	Items         []ProductResponse `json:"items"`
+	NextPageToken string            `json:"next_page_token,omitempty"`
+	HasMore       bool              `json:"has_more"`
+}
+
+func NewProductPageResponse(page database.ProductPage) ProductPageResponse {
+	return ProductPageResponse{
+		Items:         NewProductResponses(page.Items),
+		NextPageToken: page.NextPageToken,
+		HasMore:       page.HasMore,
+	}
+}
+
+type SearchResultResponse struct {
+	Product    ProductResponse   `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// SearchResponse holds the matches for query, and a corrected query in
+// DidYouMean when nothing matched.
+type SearchResponse struct {
+	Query      string                 `json:"query"`
+	Results    []SearchResultResponse `json:"results"`
+	DidYouMean string                 `json:"did_you_mean,omitempty"`
+}
+
+func NewSearchResponse(query string, results []database.SearchResult) SearchResponse {
+	return SearchResponse{
+		Query: query,
+		Results: mapAll(results, func(r database.SearchResult) SearchResultResponse {
+			return SearchResultResponse{Product: NewProductResponse(r.Product), Score: r.Score, Highlights: r.Highlights}
+		}),
+	}
+}
@@ -0,0 +1,84 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/models"
+	"time"
+)
+
+type SignUpRequest struct {
+	FirstName string `json:"first_name" validate:"required,min=2,max=30"`
+	LastName  string `json:"last_name"  validate:"required,min=2,max=30"`
+	Password  string `json:"password"   validate:"required,min=6"`
+	Email     string `json:"email"      validate:"required,email"`
+	Phone     string `json:"phone"      validate:"required"`
+}
+
+// User maps the request onto a new user. The password is copied as given;
+// the handler hashes it before storing.
+func (r SignUpRequest) User() models.User {
+	return models.User{
+		FirstName: &r.FirstName,
+		LastName:  &r.LastName,
+		Password:  &r.Password,
+		Email:     &r.Email,
+		Phone:     &r.Phone,
+	}
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"    validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UserResponse is the public profile of a user. It leaves out the password
+// hash, the stored tokens and the embedded cart, addresses and orders.
+type UserResponse struct {
+	UserID    string    `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Phone     string    `json:"phone"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func NewUserResponse(u models.User) UserResponse {
+	return UserResponse{
+		UserID:    u.UserID,
+		FirstName: deref(u.FirstName),
+		LastName:  deref(u.LastName),
+		Email:     deref(u.Email),
+		Phone:     deref(u.Phone),
+		Role:      role(u.Role),
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+// AuthResponse answers signup and login with the tokens just issued.
+type AuthResponse struct {
+	User         UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	// MergedCartItems counts the units a guest cart added to the user's cart.
+	MergedCartItems int `json:"merged_cart_items,omitempty"`
+}
+
+func NewAuthResponse(u models.User, token, refreshToken string, mergedCartItems int) AuthResponse {
+	return AuthResponse{User: NewUserResponse(u), Token: token, RefreshToken: refreshToken, MergedCartItems: mergedCartItems}
+}
+
+func role(r string) string {
+	if r == "" {
+		return models.RoleCustomer
+	}
+	return r
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,109 @@
+package dto
+
+import (
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
+	"github.com/maksimulitin/lib/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WishlistRequest struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+// SaveForLaterRequest moves a cart item to the wishlist with WishlistID, or
+// to the default list when it is left out.
+type SaveForLaterRequest struct {
+	WishlistID *primitive.ObjectID `json:"wishlist_id"`
+}
+
+// WishlistItemResponse is a saved product with its current price. PriceDrop
+// is how much cheaper it is than when it was saved, and Available is false
+// once the product has been removed from the store. Prices are in the store
+// currency.
+type WishlistItemResponse struct {
+	ProductID    primitive.ObjectID `json:"product_id"`
+	ProductName  *string            `json:"product_name"`
+	Image        *string            `json:"image"`
+	SavedPrice   money.Money        `json:"saved_price"`
+	CurrentPrice *money.Money       `json:"current_price"`
+	PriceDrop    *money.Money       `json:"price_drop,omitempty"`
+	Available    bool               `json:"available"`
+	InStock      bool               `json:"in_stock"`
+	AddedAt      time.Time          `json:"added_at"`
+}
+
+type WishlistResponse struct {
+	WishlistID primitive.ObjectID     `json:"wishlist_id"`
+	Name       string                 `json:"name"`
+	Items      []WishlistItemResponse `json:"items"`
+	Shared     bool                   `json:"shared"`
+	ShareToken string                 `json:"share_token,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+}
+
+// SharedWishlistResponse is a wishlist as anyone with its share link sees
+// it, without its owner or token.
+type SharedWishlistResponse struct {
+	Name  string                 `json:"name"`
+	Items []WishlistItemResponse `json:"items"`
+}
+
+type ShareWishlistResponse struct {
+	ShareToken string `json:"share_token"`
+	SharePath  string `json:"share_path"`
+}
+
+func NewWishlistResponse(w models.Wishlist, products map[primitive.ObjectID]database.WishlistProduct) WishlistResponse {
+	return WishlistResponse{
+		WishlistID: w.WishlistID,
+		Name:       w.Name,
+		Items:      newWishlistItems(w.Items, products),
+		Shared:     w.ShareToken != "",
+		ShareToken: w.ShareToken,
+		CreatedAt:  w.CreatedAt,
+		UpdatedAt:  w.UpdatedAt,
+	}
+}
+
+func NewWishlistResponses(wishlists []models.Wishlist, products map[primitive.ObjectID]database.WishlistProduct) []WishlistResponse {
+	return mapAll(wishlists, func(w models.Wishlist) WishlistResponse {
+		return NewWishlistResponse(w, products)
+	})
+}
+
+func NewSharedWishlistResponse(w models.Wishlist, products map[primitive.ObjectID]database.WishlistProduct) SharedWishlistResponse {
+	return SharedWishlistResponse{Name: w.Name, Items: newWishlistItems(w.Items, products)}
+}
+
+func newWishlistItems(items []models.WishlistItem, products map[primitive.ObjectID]database.WishlistProduct) []WishlistItemResponse {
+	return mapAll(items, func(item models.WishlistItem) WishlistItemResponse {
+		response := WishlistItemResponse{
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			Image:       item.Image,
+			SavedPrice:  item.SavedPrice,
+			AddedAt:     item.AddedAt,
+		}
+
+		product, ok := products[item.ProductID]
+
+		if !ok {
+			return response
+		}
+
+		current := product.Price
+		response.CurrentPrice = &current
+		response.Available = true
+		response.InStock = product.Stock != nil && *product.Stock > 0
+
+		if drop, err := item.SavedPrice.Sub(current); err == nil && !drop.IsZero() && !drop.IsNegative() {
+			response.PriceDrop = &drop
+		}
+
+		return response
+	})
+}
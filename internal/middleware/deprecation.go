@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks a response as coming from a route kept only for old
+// clients and logs every use, so remaining callers can be found.
+func Deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger.Warn("deprecated route called",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.FullPath()),
+			slog.String("successor", successor),
+			slog.String("client", c.ClientIP()),
+		)
+
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/lib/logger"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	defaultIdempotencyWindow  = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+	maxIdempotencyRequestBody = 1 << 20
+)
+
+// idempotencyWindow is how long responses are kept for replay, from
+// IDEMPOTENCY_WINDOW.
+func idempotencyWindow() time.Duration {
+	raw := os.Getenv("IDEMPOTENCY_WINDOW")
+
+	if raw == "" {
+		return defaultIdempotencyWindow
+	}
+
+	window, err := time.ParseDuration(raw)
+
+	if err != nil || window <= 0 {
+		logger.Warn("invalid IDEMPOTENCY_WINDOW, using default", slog.String("value", raw), slog.Duration("default", defaultIdempotencyWindow))
+		return defaultIdempotencyWindow
+	}
+
+	return window
+}
+
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// requestFingerprint identifies a request by method, path, query and body,
+// so a key reused for a different request can be told apart from a retry.
+func requestFingerprint(c *gin.Context, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + "\n" + c.Request.URL.Path + "\n" + c.Request.URL.Query().Encode() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Idempotency makes order-creating requests safe to retry. The first
+// response to a request carrying an Idempotency-Key is stored per key and
+// user and replayed for retries; reusing the key for a different request is
+// a conflict. Server errors are not stored, so those can be retried. Requests
+// without the header pass through untouched. It must run after
+// Authentication.
+func Idempotency(idempotencyCollection *mongo.Collection) gin.HandlerFunc {
+	window := idempotencyWindow()
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			apierror.BadRequest(c, "idempotency key is too long")
+			return
+		}
+
+		// One byte past the limit tells an oversized body from one that
+		// fits exactly; a truncated body must never be fingerprinted or
+		// handled as the request.
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotencyRequestBody+1))
+
+		if err != nil {
+			apierror.BadRequest(c, "cannot read request body")
+			return
+		}
+
+		if len(body) > maxIdempotencyRequestBody {
+			logger.Warn("idempotent request body too large", slog.String("path", c.Request.URL.Path))
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, "request_too_large", "request body is too large")
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		userID := c.GetString("uid")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		record, err := database.ReserveIdempotencyKey(ctx, idempotencyCollection, key, userID, requestFingerprint(c, body), window)
+		cancel()
+
+		switch {
+		case errors.Is(err, database.ErrIdempotencyMismatch), errors.Is(err, database.ErrIdempotencyInProgress):
+			logger.Warn("idempotency key conflict", slog.String("userID", userID), slog.Any("error", err))
+			apierror.Respond(c, err)
+			return
+		case err != nil:
+			apierror.Respond(c, err)
+			return
+		case record != nil:
+			logger.Info("replaying idempotent response", slog.String("userID", userID), slog.String("path", c.Request.URL.Path))
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(record.ResponseStatus, record.ContentType, record.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if status := writer.Status(); status >= http.StatusInternalServerError {
+			_ = database.ReleaseIdempotencyKey(ctx, idempotencyCollection, key, userID)
+		} else {
+			_ = database.CompleteIdempotencyKey(ctx, idempotencyCollection, key, userID, status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/models"
 	token "github.com/maksimulitin/internal/tokens"
 	"github.com/maksimulitin/lib/logger"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func Authentication() gin.HandlerFunc {
@@ -15,8 +22,7 @@ func Authentication() gin.HandlerFunc {
 
 		if ClientToken == "" {
 			logger.Error("no token provided", slog.Any("token", c.Request.Header.Get("token")))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization Header Provided"})
-			c.Abort()
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "no authorization header provided")
 			return
 		}
 
@@ -24,13 +30,38 @@ func Authentication() gin.HandlerFunc {
 
 		if err != "" {
 			logger.Error("invalid token", slog.Any("token", c.Request.Header.Get("token")))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
+			apierror.Abort(c, http.StatusUnauthorized, apierror.CodeUnauthorized, err)
 			return
 		}
 
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
+		c.Set("role", claims.Role)
+		c.Next()
+	}
+}
+
+// RequireAdmin lets only admins through. It runs after Authentication and
+// checks the role stored for the user rather than the one in the token, so a
+// revoked admin is refused before their token expires.
+func RequireAdmin(userCollection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		role, err := database.UserRole(ctx, userCollection, c.GetString("uid"))
+
+		if err != nil && !errors.Is(err, database.ErrUserIDIsNotValid) {
+			apierror.Respond(c, err)
+			return
+		}
+
+		if role != models.RoleAdmin {
+			logger.Warn("admin route refused", slog.String("uid", c.GetString("uid")), slog.String("path", c.FullPath()))
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "admin role required")
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/maksimulitin/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader       = "X-Request-ID"
+	maxRequestIDLength    = 128
+	generatedRequestIDLen = 16
+)
+
+// RequestID tags every request with an ID, the caller's X-Request-ID when
+// it sends a usable one, and echoes it in the response so error reports can
+// be matched to logs.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+
+		c.Set(apierror.RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, generatedRequestIDLen)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
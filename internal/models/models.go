@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"github.com/maksimulitin/lib/money"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -21,42 +22,391 @@ type User struct {
 	UserCart       []ProductUser      `json:"user_cart" bson:"user_cart"`
 	AddressDetails []Address          `json:"address" bson:"address"`
 	OrderStatus    []Order            `json:"orders" bson:"orders"`
+	CartCoupon     *string            `json:"cart_coupon" bson:"cart_coupon,omitempty"`
+	Role           string             `json:"role" bson:"role,omitempty"`
+	CartUpdatedAt  *time.Time         `json:"cart_updated_at" bson:"cart_updated_at,omitempty"`
+	CartRemindedAt *time.Time         `json:"-" bson:"cart_reminded_at,omitempty"`
+	// CouponUses counts the user's redemptions per coupon ID.
+	CouponUses map[string]int64 `json:"-" bson:"coupon_uses,omitempty"`
 }
 
+// Roles a user can have. Users without a role are customers.
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
 type Product struct {
-	ProductID   primitive.ObjectID `bson:"_id"`
-	ProductName *string            `json:"product_name"`
-	Price       *uint64            `json:"price"`
-	Rating      *uint8             `json:"rating"`
-	Image       *string            `json:"image"`
+	ProductID    primitive.ObjectID `json:"product_id"              bson:"_id"`
+	SKU          *string            `json:"sku"                     bson:"sku,omitempty"`
+	ProductName  *string            `json:"product_name"            bson:"product_name"           validate:"required"`
+	Price        *money.Money       `json:"price"                   bson:"price"                  validate:"required"`
+	PriceList    []money.Money      `json:"price_list,omitempty"    bson:"price_list,omitempty"`
+	DisplayPrice *money.Money       `json:"display_price,omitempty" bson:"-"`
+	Rating       float64            `json:"rating"                  bson:"rating"`
+	RatingCount  int64              `json:"rating_count"            bson:"rating_count"`
+	RatingSum    int64              `json:"-"                       bson:"rating_sum"`
+	Image        *string            `json:"image"                   bson:"image"`
+	Images       []ProductImage     `json:"images"                  bson:"images"`
+	Description  *string            `json:"description"             bson:"description"`
+	Category     *string            `json:"category"                bson:"category"`
+	TaxClass     *string            `json:"tax_class,omitempty"     bson:"tax_class,omitempty"`
+	WeightGrams  *int64             `json:"weight_grams,omitempty"  bson:"weight_grams,omitempty" validate:"omitempty,min=0"`
+	Stock        *int64             `json:"stock"                   bson:"stock"`
+	CreatedAt    time.Time          `json:"created_at"              bson:"created_at"`
+	SearchTerms  []string           `json:"-"                       bson:"search_terms"`
+	SearchGrams  []string           `json:"-"                       bson:"search_grams"`
+}
+
+type ProductImage struct {
+	ImageID     primitive.ObjectID `json:"image_id"     bson:"_id"`
+	ContentType string             `json:"content_type" bson:"content_type"`
+	Size        int64              `json:"size"         bson:"size"`
+	Width       int                `json:"width"        bson:"width"`
+	Height      int                `json:"height"       bson:"height"`
+	URL         string             `json:"url"          bson:"url"`
+	Key         string             `json:"-"            bson:"key"`
+	Thumbnails  []Thumbnail        `json:"thumbnails"   bson:"thumbnails"`
+}
+
+type Thumbnail struct {
+	Name   string `json:"name"   bson:"name"`
+	Width  int    `json:"width"  bson:"width"`
+	Height int    `json:"height" bson:"height"`
+	URL    string `json:"url"    bson:"url"`
+	Key    string `json:"-"      bson:"key"`
 }
 
 type ProductUser struct {
-	ProductID   primitive.ObjectID `bson:"_id"`
+	ProductID    primitive.ObjectID `json:"product_id"              bson:"_id"`
+	ProductName  *string            `json:"product_name"            bson:"product_name"`
+	Price        money.Money        `json:"price"                   bson:"price"`
+	PriceList    []money.Money      `json:"price_list,omitempty"    bson:"price_list,omitempty"`
+	DisplayPrice *money.Money       `json:"display_price,omitempty" bson:"-"`
+	Rating       float64            `json:"rating"                  bson:"rating"`
+	Image        *string            `json:"image"                   bson:"image"`
+	Category     *string            `json:"category,omitempty"      bson:"category,omitempty"`
+	TaxClass     *string            `json:"tax_class,omitempty"     bson:"tax_class,omitempty"`
+	WeightGrams  *int64             `json:"weight_grams,omitempty"  bson:"weight_grams,omitempty"`
+}
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+type Review struct {
+	ReviewID  primitive.ObjectID `json:"review_id"  bson:"_id"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id"`
+	UserID    string             `json:"user_id"    bson:"user_id"`
+	Rating    uint8              `json:"rating"     bson:"rating"  validate:"required,min=1,max=5"`
+	Text      string             `json:"text"       bson:"text"    validate:"max=2000"`
+	Status    string             `json:"status"     bson:"status"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// GuestCart is the cart of a shopper who has not logged in. MongoDB deletes
+// it once ExpiresAt passes; every change pushes ExpiresAt back.
+type GuestCart struct {
+	CartID    primitive.ObjectID `json:"cart_id"    bson:"_id"`
+	Items     []ProductUser      `json:"items"      bson:"items"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+}
+
+// CartReminder records a reminder sent for a cart left idle and, once the
+// cart is checked out, the order that recovered it. CartValue is in the store
+// currency.
+type CartReminder struct {
+	ReminderID    primitive.ObjectID  `json:"reminder_id"            bson:"_id"`
+	UserID        string              `json:"user_id"                bson:"user_id"`
+	Items         int                 `json:"items"                  bson:"items"`
+	CartValue     money.Money         `json:"cart_value"             bson:"cart_value"`
+	CartUpdatedAt time.Time           `json:"cart_updated_at"        bson:"cart_updated_at"`
+	Notifier      string              `json:"notifier"               bson:"notifier"`
+	SentAt        time.Time           `json:"sent_at"                bson:"sent_at"`
+	RecoveredAt   *time.Time          `json:"recovered_at,omitempty" bson:"recovered_at,omitempty"`
+	OrderID       *primitive.ObjectID `json:"order_id,omitempty"     bson:"order_id,omitempty"`
+	OrderTotal    *money.Money        `json:"order_total,omitempty"  bson:"order_total,omitempty"`
+}
+
+// Wishlist is one named list of products a user saved for later. ShareToken
+// is set while the list is shared and is the only way to read it without
+// being its owner.
+type Wishlist struct {
+	WishlistID primitive.ObjectID `json:"wishlist_id" bson:"_id"`
+	UserID     string             `json:"user_id"     bson:"user_id"`
+	Name       string             `json:"name"        bson:"name"`
+	Items      []WishlistItem     `json:"items"       bson:"items"`
+	ShareToken string             `json:"-"           bson:"share_token,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"  bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at"  bson:"updated_at"`
+}
+
+// WishlistItem is a product on a wishlist with its base currency price at
+// the time it was saved, which current prices are compared with.
+type WishlistItem struct {
+	ProductID   primitive.ObjectID `json:"product_id"   bson:"product_id"`
 	ProductName *string            `json:"product_name" bson:"product_name"`
-	Price       int                `json:"price"  bson:"price"`
-	Rating      *uint              `json:"rating" bson:"rating"`
-	Image       *string            `json:"image"  bson:"image"`
+	Image       *string            `json:"image"        bson:"image"`
+	SavedPrice  money.Money        `json:"saved_price"  bson:"saved_price"`
+	AddedAt     time.Time          `json:"added_at"     bson:"added_at"`
 }
 
+const (
+	CouponPercentage   = "percentage"
+	CouponFixedAmount  = "fixed_amount"
+	CouponFreeShipping = "free_shipping"
+)
+
+// Coupon is an admin-managed discount code. Money amounts are in the store
+// currency and converted at checkout like prices are.
+type Coupon struct {
+	CouponID       primitive.ObjectID   `json:"coupon_id"                bson:"_id"`
+	Code           string               `json:"code"                     bson:"code"                  validate:"required,min=3,max=32,alphanum"`
+	Type           string               `json:"type"                     bson:"type"                  validate:"required,oneof=percentage fixed_amount free_shipping"`
+	PercentOff     int64                `json:"percent_off,omitempty"    bson:"percent_off,omitempty" validate:"min=0,max=100"`
+	AmountOff      *money.Money         `json:"amount_off,omitempty"     bson:"amount_off,omitempty"`
+	MinCartValue   *money.Money         `json:"min_cart_value,omitempty" bson:"min_cart_value,omitempty"`
+	ProductIDs     []primitive.ObjectID `json:"product_ids,omitempty"    bson:"product_ids,omitempty"`
+	Categories     []string             `json:"categories,omitempty"     bson:"categories,omitempty"`
+	StartsAt       *time.Time           `json:"starts_at,omitempty"      bson:"starts_at,omitempty"`
+	EndsAt         *time.Time           `json:"ends_at,omitempty"        bson:"ends_at,omitempty"`
+	MaxUses        int64                `json:"max_uses"                 bson:"max_uses"              validate:"min=0"`
+	MaxUsesPerUser int64                `json:"max_uses_per_user"        bson:"max_uses_per_user"     validate:"min=0"`
+	Uses           int64                `json:"uses"                     bson:"uses"`
+	Active         bool                 `json:"active"                   bson:"active"`
+	CreatedAt      time.Time            `json:"created_at"               bson:"created_at"`
+}
+
+// CouponRedemption records one use of a coupon. The per user limit is
+// enforced by User.CouponUses, not by counting redemptions.
+type CouponRedemption struct {
+	RedemptionID primitive.ObjectID `json:"redemption_id" bson:"_id"`
+	CouponID     primitive.ObjectID `json:"coupon_id"     bson:"coupon_id"`
+	Code         string             `json:"code"          bson:"code"`
+	UserID       string             `json:"user_id"       bson:"user_id"`
+	OrderID      primitive.ObjectID `json:"order_id"      bson:"order_id"`
+	Discount     money.Money        `json:"discount"      bson:"discount"`
+	RedeemedAt   time.Time          `json:"redeemed_at"   bson:"redeemed_at"`
+}
+
+const (
+	PromotionBuyXGetY       = "buy_x_get_y"
+	PromotionBundle         = "bundle"
+	PromotionSpendThreshold = "spend_threshold"
+)
+
+// Promotion is a rule applied to every cart automatically, without a code.
+// Which fields matter depends on Type; amounts are in the store currency.
+type Promotion struct {
+	PromotionID      primitive.ObjectID   `json:"promotion_id"                 bson:"_id"`
+	Name             string               `json:"name"                         bson:"name"                      validate:"required,max=100"`
+	Type             string               `json:"type"                         bson:"type"                      validate:"required,oneof=buy_x_get_y bundle spend_threshold"`
+	Priority         int                  `json:"priority"                     bson:"priority"`
+	Exclusive        bool                 `json:"exclusive"                    bson:"exclusive"`
+	Active           bool                 `json:"active"                       bson:"active"`
+	StartsAt         *time.Time           `json:"starts_at,omitempty"          bson:"starts_at,omitempty"`
+	EndsAt           *time.Time           `json:"ends_at,omitempty"            bson:"ends_at,omitempty"`
+	ProductIDs       []primitive.ObjectID `json:"product_ids,omitempty"        bson:"product_ids,omitempty"`
+	Categories       []string             `json:"categories,omitempty"         bson:"categories,omitempty"`
+	BuyQuantity      int64                `json:"buy_quantity,omitempty"       bson:"buy_quantity,omitempty"    validate:"min=0"`
+	GetQuantity      int64                `json:"get_quantity,omitempty"       bson:"get_quantity,omitempty"    validate:"min=0"`
+	GetPercentOff    int64                `json:"get_percent_off,omitempty"    bson:"get_percent_off,omitempty" validate:"min=0,max=100"`
+	BundleProductIDs []primitive.ObjectID `json:"bundle_product_ids,omitempty" bson:"bundle_product_ids,omitempty"`
+	BundlePrice      *money.Money         `json:"bundle_price,omitempty"       bson:"bundle_price,omitempty"`
+	Tiers            []PromotionTier      `json:"tiers,omitempty"              bson:"tiers,omitempty"           validate:"dive"`
+	CreatedAt        time.Time            `json:"created_at"                   bson:"created_at"`
+}
+
+// PromotionTier is one step of a spend threshold promotion. Exactly one of
+// PercentOff and AmountOff is set.
+type PromotionTier struct {
+	MinSubtotal money.Money  `json:"min_subtotal"          bson:"min_subtotal"`
+	PercentOff  int64        `json:"percent_off,omitempty" bson:"percent_off,omitempty" validate:"min=0,max=100"`
+	AmountOff   *money.Money `json:"amount_off,omitempty"  bson:"amount_off,omitempty"`
+}
+
+// AppliedPromotion itemizes what a promotion took off a cart or order and
+// which products it applied to.
+type AppliedPromotion struct {
+	PromotionID primitive.ObjectID   `json:"promotion_id"          bson:"promotion_id"`
+	Name        string               `json:"name"                  bson:"name"`
+	Type        string               `json:"type"                  bson:"type"`
+	Discount    money.Money          `json:"discount"              bson:"discount"`
+	ProductIDs  []primitive.ObjectID `json:"product_ids,omitempty" bson:"product_ids,omitempty"`
+}
+
+const (
+	ShippingFlat          = "flat"
+	ShippingWeightBased   = "weight_based"
+	ShippingFreeThreshold = "free_threshold"
+)
+
+// ShippingMethod is an admin-configured way of delivering orders. Rate is the
+// flat rate, or the base rate of a weight based method which adds PerKg for
+// every started kilogram. Free threshold methods are only offered once the
+// cart reaches MinSubtotal. Without postal prefixes or cities a method ships
+// everywhere. Amounts are in the store currency.
+type ShippingMethod struct {
+	MethodID       primitive.ObjectID `json:"method_id"                 bson:"_id"`
+	Name           string             `json:"name"                      bson:"name" validate:"required,max=100"`
+	Type           string             `json:"type"                      bson:"type" validate:"required,oneof=flat weight_based free_threshold"`
+	Rate           *money.Money       `json:"rate,omitempty"            bson:"rate,omitempty"`
+	PerKg          *money.Money       `json:"per_kg,omitempty"          bson:"per_kg,omitempty"`
+	MinSubtotal    *money.Money       `json:"min_subtotal,omitempty"    bson:"min_subtotal,omitempty"`
+	PostalPrefixes []string           `json:"postal_prefixes,omitempty" bson:"postal_prefixes,omitempty"`
+	Cities         []string           `json:"cities,omitempty"          bson:"cities,omitempty"`
+	Active         bool               `json:"active"                    bson:"active"`
+	CreatedAt      time.Time          `json:"created_at"                bson:"created_at"`
+}
+
+// Address is one entry of a user's address book. At most one address is the
+// default for shipping and one for billing; both default to the first
+// address added. Country is an ISO 3166-1 alpha-2 code and the postal code
+// is stored in the country's format.
 type Address struct {
-	AddressId primitive.ObjectID `bson:"_id"`
-	House     *string            `json:"house_name" bson:"house_name"`
-	Street    *string            `json:"street_name" bson:"street_name"`
-	City      *string            `json:"city_name" bson:"city_name"`
-	PinCode   *string            `json:"pin_code" bson:"pin_code"`
+	AddressId       primitive.ObjectID `json:"address_id"       bson:"_id"`
+	Label           string             `json:"label"            bson:"label,omitempty"`
+	RecipientName   string             `json:"recipient_name"   bson:"recipient_name"`
+	Line1           string             `json:"line1"            bson:"line1"`
+	Line2           string             `json:"line2"            bson:"line2,omitempty"`
+	City            string             `json:"city"             bson:"city"`
+	Region          string             `json:"region"           bson:"region,omitempty"`
+	PostalCode      string             `json:"postal_code"      bson:"postal_code,omitempty"`
+	Country         string             `json:"country"          bson:"country"`
+	Phone           string             `json:"phone"            bson:"phone,omitempty"`
+	DefaultShipping bool               `json:"default_shipping" bson:"default_shipping"`
+	DefaultBilling  bool               `json:"default_billing"  bson:"default_billing"`
 }
 
+// Order statuses. Orders placed before statuses existed have none and count
+// as placed. A cancelled order is never fulfilled; CancelReason says why.
+const (
+	OrderPlaced    = "placed"
+	OrderCancelled = "cancelled"
+
+	CancelPaymentDeclined = "payment_declined"
+)
+
 type Order struct {
-	OrderID       primitive.ObjectID `bson:"_id"`
-	OrderCart     []ProductUser      `json:"order_list"  bson:"order_list"`
-	OrderedAt     time.Time          `json:"ordered_on"  bson:"ordered_on"`
-	Price         int                `json:"total_price" bson:"total_price"`
-	Discount      *int               `json:"discount"    bson:"discount"`
-	PaymentMethod Payment            `json:"payment_method" bson:"payment_method"`
+	OrderID         primitive.ObjectID `bson:"_id"`
+	Status          string             `json:"status"           bson:"status,omitempty"`
+	CancelReason    string             `json:"cancel_reason"    bson:"cancel_reason,omitempty"`
+	OrderCart       []ProductUser      `json:"order_list"     bson:"order_list"`
+	OrderedAt       time.Time          `json:"ordered_on"     bson:"ordered_on"`
+	Price           money.Money        `json:"total_price"    bson:"total_price"`
+	BaseTotal       money.Money        `json:"base_total"     bson:"base_total"`
+	Currency        string             `json:"currency"       bson:"currency"`
+	ExchangeRate    string             `json:"exchange_rate"  bson:"exchange_rate,omitempty"`
+	Discount        *money.Money       `json:"discount"       bson:"discount"`
+	CouponCode      string             `json:"coupon_code"    bson:"coupon_code,omitempty"`
+	Promotions      []AppliedPromotion `json:"promotions"     bson:"promotions,omitempty"`
+	TaxMode         string             `json:"tax_mode"       bson:"tax_mode,omitempty"`
+	TaxRegion       string             `json:"tax_region"     bson:"tax_region,omitempty"`
+	Tax             *money.Money       `json:"tax"            bson:"tax,omitempty"`
+	TaxLines        []TaxLine          `json:"tax_lines"      bson:"tax_lines,omitempty"`
+	Shipping        *OrderShipping     `json:"shipping"         bson:"shipping,omitempty"`
+	FreeShipping    bool               `json:"free_shipping"    bson:"free_shipping"`
+	ShippingAddress *OrderAddress      `json:"shipping_address" bson:"shipping_address,omitempty"`
+	BillingAddress  *OrderAddress      `json:"billing_address"  bson:"billing_address,omitempty"`
+	PaymentMethod   Payment            `json:"payment_method"   bson:"payment_method"`
+}
+
+// OrderAddress is a copy of an address taken when the order was placed.
+// AddressID names the saved address it was copied from and is empty for an
+// address entered at checkout.
+type OrderAddress struct {
+	AddressID     *primitive.ObjectID `json:"address_id,omitempty" bson:"address_id,omitempty"`
+	RecipientName string              `json:"recipient_name"       bson:"recipient_name"`
+	Line1         string              `json:"line1"                bson:"line1"`
+	Line2         string              `json:"line2,omitempty"      bson:"line2,omitempty"`
+	City          string              `json:"city"                 bson:"city"`
+	Region        string              `json:"region,omitempty"     bson:"region,omitempty"`
+	PostalCode    string              `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+	Country       string              `json:"country"              bson:"country"`
+	Phone         string              `json:"phone,omitempty"      bson:"phone,omitempty"`
+}
+
+// OrderShipping is the delivery chosen for an order, priced in the order
+// currency.
+type OrderShipping struct {
+	MethodID    primitive.ObjectID `json:"method_id"    bson:"method_id"`
+	Name        string             `json:"name"         bson:"name"`
+	Type        string             `json:"type"         bson:"type"`
+	Cost        money.Money        `json:"cost"         bson:"cost"`
+	WeightGrams int64              `json:"weight_grams" bson:"weight_grams"`
 }
 
+// TaxLine is the tax on one order line. Taxable is the line price after
+// discounts; in inclusive mode it already contains Tax.
+type TaxLine struct {
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id"`
+	TaxClass  string             `json:"tax_class"  bson:"tax_class"`
+	Rate      string             `json:"rate"       bson:"rate"`
+	Taxable   money.Money        `json:"taxable"    bson:"taxable"`
+	Tax       money.Money        `json:"tax"        bson:"tax"`
+}
+
+// Payment is how an order is paid for. Status follows the provider's
+// payment.Status* values; Refunded is the part of the captured amount paid
+// back so far.
 type Payment struct {
-	Digital bool `json:"digital" bson:"digital"`
-	COD     bool `json:"cod"     bson:"cod"`
+	Digital   bool         `json:"digital"             bson:"digital"`
+	COD       bool         `json:"cod"                 bson:"cod"`
+	Provider  string       `json:"provider,omitempty"  bson:"provider,omitempty"`
+	Reference string       `json:"reference,omitempty" bson:"reference,omitempty"`
+	Status    string       `json:"status,omitempty"    bson:"status,omitempty"`
+	Amount    *money.Money `json:"amount,omitempty"    bson:"amount,omitempty"`
+	Refunded  *money.Money `json:"refunded,omitempty"  bson:"refunded,omitempty"`
+}
+
+const (
+	TransactionAuthorize = "authorize"
+	TransactionCapture   = "capture"
+	TransactionVoid      = "void"
+	TransactionRefund    = "refund"
+	TransactionWebhook   = "webhook"
+)
+
+// PaymentTransaction records one call to a payment provider, or one webhook
+// received from it, for an order.
+type PaymentTransaction struct {
+	TransactionID primitive.ObjectID `json:"transaction_id"           bson:"_id"`
+	OrderID       primitive.ObjectID `json:"order_id"                 bson:"order_id"`
+	UserID        string             `json:"user_id"                  bson:"user_id"`
+	Provider      string             `json:"provider"                 bson:"provider"`
+	Reference     string             `json:"reference"                bson:"reference"`
+	Operation     string             `json:"operation"                bson:"operation"`
+	Status        string             `json:"status"                   bson:"status"`
+	Amount        money.Money        `json:"amount"                   bson:"amount"`
+	DeclineReason string             `json:"decline_reason,omitempty" bson:"decline_reason,omitempty"`
+	Error         string             `json:"error,omitempty"          bson:"error,omitempty"`
+	EventID       string             `json:"event_id,omitempty"       bson:"event_id,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"               bson:"created_at"`
+}
+
+const (
+	IdempotencyProcessing = "processing"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord is the first response to a request sent with an
+// Idempotency-Key, kept so retries of the same request get it again.
+// Fingerprint identifies the request the key was first used with.
+type IdempotencyRecord struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Key            string             `bson:"key"`
+	UserID         string             `bson:"user_id"`
+	Fingerprint    string             `bson:"fingerprint"`
+	Status         string             `bson:"status"`
+	ResponseStatus int                `bson:"response_status,omitempty"`
+	ContentType    string             `bson:"content_type,omitempty"`
+	ResponseBody   []byte             `bson:"response_body,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	LockedUntil    time.Time          `bson:"locked_until"`
+	ExpiresAt      time.Time          `bson:"expires_at"`
 }
@@ -0,0 +1,77 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed swagger.html
+var swaggerPage string
+
+var swaggerTemplate = template.Must(template.New("swagger").Parse(swaggerPage))
+
+// SpecHandler serves doc as JSON. The document is encoded once, up front.
+func SpecHandler(doc *Document) gin.HandlerFunc {
+	body, err := json.Marshal(doc)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
+
+// UIHandler serves a Swagger UI page for the document at specURL, loading
+// the swagger-ui-dist assets from assetsURL.
+func UIHandler(title, specURL, assetsURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		_ = swaggerTemplate.Execute(c.Writer, struct{ Title, SpecURL, Assets string }{title, specURL, assetsURL})
+	}
+}
+
+// Undocumented lists the registered routes without an entry in routes, as
+// "METHOD path".
+func Undocumented(registered gin.RoutesInfo, routes []Route) []string {
+	documented := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		documented[Key(r.Method, r.Path)] = true
+	}
+
+	var missing []string
+	for _, r := range registered {
+		if !documented[Key(r.Method, r.Path)] {
+			missing = append(missing, Key(r.Method, r.Path))
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+// Unregistered lists the entries in routes that match no registered route,
+// which usually means a route was renamed or removed.
+func Unregistered(registered gin.RoutesInfo, routes []Route) []string {
+	served := make(map[string]bool, len(registered))
+	for _, r := range registered {
+		served[Key(r.Method, r.Path)] = true
+	}
+
+	var stale []string
+	for _, r := range routes {
+		if !served[Key(r.Method, r.Path)] {
+			stale = append(stale, Key(r.Method, r.Path))
+		}
+	}
+
+	sort.Strings(stale)
+	return stale
+}
@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const Version = "3.0.3"
+
+// Document is an OpenAPI 3 document, reduced to the parts this API uses.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations of one path, keyed by lower case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+const tokenScheme = "token"
+
+// Route describes one registered route. Path uses gin syntax, so :name and
+// *name segments become path parameters.
+type Route struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	Auth        bool
+	// Admin marks routes only admins may call.
+	Admin      bool
+	Deprecated bool
+	Query      []Param
+	Headers    []Param
+	// Body is a sample of the JSON request body, or nil for none.
+	Body any
+	// BodyContentType overrides application/json for Body, e.g. for uploads.
+	BodyContentType string
+	Responses       []Reply
+}
+
+// Param is a query or header parameter. Type is a JSON schema type and
+// defaults to string.
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string
+}
+
+// Reply is one documented response. Body is a sample of the JSON body, or
+// nil for none.
+type Reply struct {
+	Status      int
+	Description string
+	Body        any
+	ContentType string
+}
+
+// Key identifies a route by method and gin path.
+func Key(method, path string) string {
+	return method + " " + path
+}
+
+// Build turns routes into a document. Every operation also documents the
+// error envelope as its default response, and authenticated ones a 401.
+func Build(info Info, routes []Route, errorBody any) *Document {
+	gen := newGenerator()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: gen.components,
+			SecuritySchemes: map[string]SecurityScheme{tokenScheme: {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "token",
+				Description: "JWT returned by signup and login.",
+			}},
+		},
+	}
+
+	errorSchema := gen.schemaOf(errorBody)
+
+	for _, r := range routes {
+		path, pathParams := openAPIPath(r.Path)
+		op := &Operation{
+			Summary:     r.Summary,
+			Description: r.Description,
+			OperationID: operationID(r.Method, r.Path),
+			Deprecated:  r.Deprecated,
+			Responses:   make(map[string]Response),
+		}
+
+		if r.Tag != "" {
+			op.Tags = []string{r.Tag}
+		}
+
+		for _, name := range pathParams {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+
+		for _, p := range r.Query {
+			op.Parameters = append(op.Parameters, p.parameter("query"))
+		}
+
+		for _, p := range r.Headers {
+			op.Parameters = append(op.Parameters, p.parameter("header"))
+		}
+
+		if r.Body != nil {
+			contentType := r.BodyContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{contentType: {Schema: gen.schemaOf(r.Body)}},
+			}
+		}
+
+		for _, reply := range r.Responses {
+			response := Response{Description: reply.Description}
+			if response.Description == "" {
+				response.Description = http.StatusText(reply.Status)
+			}
+
+			if reply.Body != nil {
+				contentType := reply.ContentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				response.Content = map[string]MediaType{contentType: {Schema: gen.schemaOf(reply.Body)}}
+			}
+
+			op.Responses[strconv.Itoa(reply.Status)] = response
+		}
+
+		errorContent := map[string]MediaType{"application/json": {Schema: errorSchema}}
+
+		if r.Auth {
+			op.Security = []map[string][]string{{tokenScheme: {}}}
+			op.Responses[strconv.Itoa(http.StatusUnauthorized)] = Response{Description: "Missing or invalid token", Content: errorContent}
+		}
+
+		if r.Admin {
+			op.Responses[strconv.Itoa(http.StatusForbidden)] = Response{Description: "Admin role required", Content: errorContent}
+		}
+
+		op.Responses["default"] = Response{Description: "Error", Content: errorContent}
+
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(r.Method)] = op
+	}
+
+	return doc
+}
+
+func (p Param) parameter(in string) Parameter {
+	kind := p.Type
+	if kind == "" {
+		kind = "string"
+	}
+
+	return Parameter{Name: p.Name, In: in, Description: p.Description, Required: p.Required, Schema: &Schema{Type: kind}}
+}
+
+// openAPIPath converts a gin path into OpenAPI syntax and lists its path
+// parameters.
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.TrimLeft(segment, ":*")
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+
+	return b.String()
+}
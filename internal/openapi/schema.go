@@ -0,0 +1,215 @@
+package openapi
+
+import (
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is a JSON schema in the OpenAPI 3.0 dialect.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// File stands for an uploaded file in multipart request samples.
+type File []byte
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	fileType     = reflect.TypeOf(File{})
+)
+
+// generator derives schemas from Go types the way encoding/json serialises
+// them. Named structs become shared components referenced by $ref.
+type generator struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newGenerator() *generator {
+	return &generator{components: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+func (g *generator) schemaOf(sample any) *Schema {
+	return g.schema(reflect.TypeOf(sample))
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case objectIDType:
+		return &Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"}
+	case fileType:
+		return &Schema{Type: "string", Format: "binary"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + g.component(t)}
+	}
+
+	return &Schema{}
+}
+
+// component registers the named struct t once and returns its name. Types
+// from different packages that share a name get the package as prefix.
+func (g *generator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := g.components[name]; taken {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+
+	g.names[t] = name
+	g.components[name] = &Schema{}
+	*g.components[name] = *g.object(t)
+	return name
+}
+
+func (g *generator) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addFields(s, embedded)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := g.schema(field.Type)
+		if field.Type.Kind() == reflect.Pointer && prop.Ref == "" && !strings.Contains(opts, "omitempty") {
+			prop.Nullable = true
+		}
+
+		if applyValidation(prop, field.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+
+		s.Properties[name] = prop
+	}
+}
+
+// applyValidation documents the validator rules of a field on its schema
+// and reports whether the field is required.
+func applyValidation(s *Schema, rules string) bool {
+	required := false
+
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			s.Format = "email"
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			setBound(s, name == "min", n)
+		}
+	}
+
+	return required
+}
+
+func setBound(s *Schema, lower bool, n int) {
+	switch s.Type {
+	case "string":
+		if lower {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case "array":
+		if lower {
+			s.MinItems = &n
+		}
+	case "integer", "number":
+		f := float64(n)
+		if lower {
+			s.Minimum = &f
+		} else {
+			s.Maximum = &f
+		}
+	}
+}
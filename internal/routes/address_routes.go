@@ -6,13 +6,13 @@ import (
 	"github.com/maksimulitin/internal/middleware"
 )
 
-func setupAddressRoutes(router *gin.Engine) {
+func setupAddressRoutes(router gin.IRouter) {
 	address := router.Group("/address")
 	address.Use(middleware.Authentication())
 	{
 		address.POST("/add", controllers.AddAddress())
 		address.PUT("/edit/home", controllers.EditHomeAddress())
 		address.PUT("/edit/work", controllers.EditWorkAddress())
-		address.DELETE("/delete", controllers.DeleteAddress())
+		address.DELETE("/delete", controllers.DeleteAllAddresses())
 	}
 }
@@ -6,10 +6,34 @@ import (
 	"github.com/maksimulitin/internal/middleware"
 )
 
-func setupAdminRoutes(router *gin.Engine) {
+func setupAdminRoutes(router gin.IRouter, app *controllers.Application) {
 	admin := router.Group("/admin")
-	admin.Use(middleware.Authentication())
+	admin.Use(middleware.Authentication(), middleware.RequireAdmin(controllers.UserCollection))
 	{
 		admin.POST("/products/add", controllers.ProductViewerAdmin())
+		admin.POST("/products/import", controllers.ImportProducts())
+		admin.GET("/products/export", controllers.ExportProducts())
+		admin.POST("/products/images", app.UploadProductImages())
+		admin.PUT("/products/images/order", app.ReorderProductImages())
+		admin.DELETE("/products/images", app.DeleteProductImage())
+		admin.GET("/currencies/rates", controllers.GetExchangeRates())
+		admin.PUT("/currencies/rates", controllers.UpdateExchangeRates())
+		admin.GET("/taxes", controllers.GetTaxRules())
+		admin.PUT("/taxes", controllers.UpdateTaxRules())
+		admin.POST("/coupons", controllers.CreateCoupon())
+		admin.GET("/coupons", controllers.ListCoupons())
+		admin.PUT("/coupons/active", controllers.SetCouponActive())
+		admin.POST("/promotions", controllers.CreatePromotion())
+		admin.GET("/promotions", controllers.ListPromotions())
+		admin.PUT("/promotions/active", controllers.SetPromotionActive())
+		admin.POST("/shipping", controllers.CreateShippingMethod())
+		admin.GET("/shipping", controllers.ListShippingMethods())
+		admin.PUT("/shipping/active", controllers.SetShippingMethodActive())
+		admin.POST("/orders/payment/capture", controllers.CapturePayment())
+		admin.POST("/orders/payment/void", controllers.VoidPayment())
+		admin.POST("/orders/payment/refund", controllers.RefundPayment())
+		admin.GET("/orders/payment/transactions", controllers.ListPaymentTransactions())
+		admin.GET("/reviews/pending", controllers.PendingReviews())
+		admin.PUT("/reviews/moderate", controllers.ModerateReview())
 	}
 }
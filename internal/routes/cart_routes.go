@@ -6,14 +6,17 @@ import (
 	"github.com/maksimulitin/internal/middleware"
 )
 
-func setupCartRoutes(router *gin.Engine, app *controllers.Application) {
+func setupCartRoutes(router gin.IRouter, app *controllers.Application) {
 	cart := router.Group("/cart")
 	cart.Use(middleware.Authentication())
 	{
 		cart.GET("/add", app.AddToCart())
 		cart.GET("/remove", app.RemoveItem())
 		cart.GET("/list", controllers.GetItemFromCart())
-		cart.GET("/checkout", app.BuyFromCart())
-		cart.GET("/buy", app.InstantBuy())
+		cart.POST("/coupon", controllers.ApplyCoupon())
+		cart.DELETE("/coupon", controllers.RemoveCoupon())
+		cart.GET("/shipping", controllers.ShippingQuote())
+		cart.GET("/checkout", sameUser("id"), middleware.Idempotency(controllers.IdempotencyCollection), app.BuyFromCart())
+		cart.GET("/buy", sameUser("userid"), middleware.Idempotency(controllers.IdempotencyCollection), app.InstantBuy())
 	}
 }
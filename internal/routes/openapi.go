@@ -0,0 +1,435 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/internal/catalog"
+	"github.com/maksimulitin/internal/database"
+	"github.com/maksimulitin/internal/dto"
+	"github.com/maksimulitin/internal/middleware"
+	"github.com/maksimulitin/internal/openapi"
+	token "github.com/maksimulitin/internal/tokens"
+	"github.com/maksimulitin/lib/payment"
+	"github.com/maksimulitin/lib/search"
+	"net/http"
+	"os"
+)
+
+const (
+	specPath = "/openapi.json"
+	docsPath = "/docs"
+
+	// defaultSwaggerAssets is where the Swagger UI page loads swagger-ui-dist
+	// from unless SWAGGER_UI_ASSETS points somewhere else.
+	defaultSwaggerAssets = "https://unpkg.com/swagger-ui-dist@5.17.14"
+)
+
+var info = openapi.Info{
+	Title:       "E-commerce API",
+	Description: "Errors use the envelope described in the README. Routes outside " + apiV1 + " are deprecated.",
+	Version:     "1.0.0",
+}
+
+// Spec builds the OpenAPI document for the routes SetupRoutes registers.
+func Spec() *openapi.Document {
+	return openapi.Build(info, documentedRoutes(), apierror.Error{})
+}
+
+// Coverage compares the routes registered on router with the document and
+// returns the undocumented routes and the documented ones nobody serves.
+func Coverage(router *gin.Engine) (undocumented, unregistered []string) {
+	documented := documentedRoutes()
+	return openapi.Undocumented(router.Routes(), documented), openapi.Unregistered(router.Routes(), documented)
+}
+
+func setupDocsRoutes(router gin.IRouter) {
+	assets := os.Getenv("SWAGGER_UI_ASSETS")
+	if assets == "" {
+		assets = defaultSwaggerAssets
+	}
+
+	router.GET(specPath, openapi.SpecHandler(Spec()))
+	router.GET(docsPath, openapi.UIHandler(info.Title, specPath, assets))
+}
+
+func documentedRoutes() []openapi.Route {
+	documented := append(docsRoutes(), v1Routes()...)
+
+	if legacyRoutesEnabled() {
+		documented = append(documented, legacyDocRoutes(v1Routes())...)
+		documented = append(documented, retiredRoutes()...)
+	}
+
+	return documented
+}
+
+var (
+	currencyParam       = openapi.Param{Name: "currency", Description: "ISO 4217 code to price in. Defaults to the store currency."}
+	limitParam          = openapi.Param{Name: "limit", Description: "Maximum number of results.", Type: "integer"}
+	addressParam        = openapi.Param{Name: "address_id", Description: "Saved address to quote shipping and tax for."}
+	shipToParam         = openapi.Param{Name: "address_id", Description: "Saved address to ship to. Required unless the body has a shipping_address."}
+	billToParam         = openapi.Param{Name: "billing_address_id", Description: "Saved address to bill. Defaults to the shipping address."}
+	shippingParam       = openapi.Param{Name: "shipping_method", Description: "Shipping method to use instead of the cheapest."}
+	paymentMethodParam  = openapi.Param{Name: "payment_method", Description: "Payment provider, see /payment-methods."}
+	idempotencyKeyParam = openapi.Param{Name: middleware.IdempotencyKeyHeader, Description: "Replays the first response for repeated requests with the same key. Bodies over 1 MiB fail with request_too_large."}
+	formatParam         = openapi.Param{Name: "format", Description: "csv or jsonl."}
+	cartTokenParam      = openapi.Param{Name: token.GuestCartHeader, Description: "cart_token of the guest cart.", Required: true}
+	mergeCartParam      = openapi.Param{Name: token.GuestCartHeader, Description: "cart_token of a guest cart to merge into the user's cart."}
+)
+
+var (
+	checkoutQuery     = []openapi.Param{currencyParam, shipToParam, billToParam, shippingParam, paymentMethodParam}
+	cartCheckoutQuery = append(checkoutQuery,
+		openapi.Param{Name: "accept_price_changes", Description: "price_changes_token of the cart quote, to check out at the changed prices it lists."})
+	quoteQuery = []openapi.Param{currencyParam, addressParam, shippingParam}
+)
+
+const mergeCartDescription = "With a guest cart token its items are moved into the user's cart, with quantities combined per CART_MERGE_STRATEGY; " +
+	"merged_cart_items counts the units added. Signing in succeeds even when the merge fails."
+
+const cartIssuesDescription = "The cart is priced at current product prices. issues lists products whose price changed since they were added " +
+	"and products that are no longer available, which are left out of the totals; price_changes_token accepts the listed price changes at checkout."
+
+const checkoutDescription = "Ships to the saved address_id or to shipping_address in the body; one of them is required. " +
+	"Card payments send the provider's payment_token in the body. " +
+	"The order keeps a copy of the shipping and billing addresses, so later edits to the address book do not change it."
+
+func ok(body any) []openapi.Reply {
+	return []openapi.Reply{{Status: http.StatusOK, Body: body}}
+}
+
+func created(body any) []openapi.Reply {
+	return []openapi.Reply{{Status: http.StatusCreated, Body: body}}
+}
+
+func docsRoutes() []openapi.Route {
+	return []openapi.Route{
+		{Method: http.MethodGet, Path: specPath, Tag: "docs", Summary: "This OpenAPI document",
+			Responses: []openapi.Reply{{Status: http.StatusOK, Body: map[string]any{}}}},
+		{Method: http.MethodGet, Path: docsPath, Tag: "docs", Summary: "Swagger UI for this document",
+			Responses: []openapi.Reply{{Status: http.StatusOK, Body: "", ContentType: "text/html"}}},
+	}
+}
+
+// v1Routes documents every route of setupV1Routes. Keep the two in step:
+// the openapi check command fails on routes missing here.
+func v1Routes() []openapi.Route {
+	return []openapi.Route{
+		{Method: http.MethodPost, Path: apiV1 + "/auth/signup", Tag: "auth", Summary: "Create an account",
+			Description: mergeCartDescription, Headers: []openapi.Param{mergeCartParam},
+			Body: dto.SignUpRequest{}, Responses: created(dto.AuthResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/auth/login", Tag: "auth", Summary: "Log in with email and password",
+			Description: mergeCartDescription, Headers: []openapi.Param{mergeCartParam},
+			Body: dto.LoginRequest{}, Responses: ok(dto.AuthResponse{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/currencies", Tag: "store", Summary: "List supported currencies",
+			Responses: ok(struct {
+				Base       string   `json:"base"`
+				Currencies []string `json:"currencies"`
+			}{})},
+		{Method: http.MethodGet, Path: apiV1 + "/payment-methods", Tag: "store", Summary: "List enabled payment methods",
+			Responses: ok(struct {
+				PaymentMethods []string `json:"payment_methods"`
+			}{})},
+		{Method: http.MethodPost, Path: apiV1 + "/payments/webhooks/:provider", Tag: "payments", Summary: "Receive a payment provider webhook",
+			Headers: []openapi.Param{{Name: payment.SignatureHeader, Description: "HMAC-SHA256 of the body, hex encoded.", Required: true}},
+			Body:    payment.Event{},
+			Responses: ok(struct {
+				Received bool `json:"received"`
+			}{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/products", Tag: "products", Summary: "List products a page at a time",
+			Query: []openapi.Param{
+				currencyParam,
+				{Name: "sort", Description: "newest, price_asc, price_desc or rating."},
+				{Name: "page_token", Description: "next_page_token of the previous page."},
+				{Name: "category"},
+				limitParam,
+				{Name: "min_price", Description: "In minor units of the store currency.", Type: "integer"},
+				{Name: "max_price", Description: "In minor units of the store currency.", Type: "integer"},
+				{Name: "min_rating", Type: "integer"},
+				{Name: "in_stock", Type: "boolean"},
+			},
+			Responses: ok(dto.ProductPageResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/products/search", Tag: "products", Summary: "Search products by name",
+			Query:     []openapi.Param{{Name: "name", Required: true}, currencyParam, limitParam},
+			Responses: ok(dto.SearchResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/products/suggest", Tag: "products", Summary: "Suggest product names for a prefix",
+			Query: []openapi.Param{{Name: "q", Required: true}, limitParam},
+			Responses: ok(struct {
+				Query       string              `json:"query"`
+				Suggestions []search.Suggestion `json:"suggestions"`
+			}{})},
+		{Method: http.MethodGet, Path: apiV1 + "/products/:product_id/reviews", Tag: "reviews", Summary: "List approved reviews of a product",
+			Query: []openapi.Param{limitParam}, Responses: ok([]dto.ReviewResponse{})},
+
+		{Method: http.MethodPost, Path: apiV1 + "/products/:product_id/reviews", Tag: "reviews", Summary: "Review a purchased product", Auth: true,
+			Body: dto.ReviewRequest{}, Responses: created(dto.ReviewResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/products/:product_id/buy", Tag: "orders", Summary: "Buy one product now", Auth: true,
+			Description: checkoutDescription,
+			Query:       checkoutQuery, Headers: []openapi.Param{idempotencyKeyParam},
+			Body: dto.CheckoutRequest{}, Responses: ok(dto.OrderResponse{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/cart", Tag: "cart", Summary: "Price the cart", Auth: true,
+			Description: cartIssuesDescription,
+			Query:       quoteQuery, Responses: ok(dto.CartQuoteResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/cart/items/:product_id", Tag: "cart", Summary: "Add a product to the cart", Auth: true,
+			Responses: ok("")},
+		{Method: http.MethodDelete, Path: apiV1 + "/cart/items/:product_id", Tag: "cart", Summary: "Remove a product from the cart", Auth: true,
+			Responses: ok("")},
+		{Method: http.MethodPut, Path: apiV1 + "/cart/coupon", Tag: "cart", Summary: "Apply a coupon to the cart", Auth: true,
+			Query: quoteQuery,
+			Body:  dto.ApplyCouponRequest{}, Responses: ok(dto.CartQuoteResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/cart/coupon", Tag: "cart", Summary: "Remove the coupon from the cart", Auth: true,
+			Responses: ok("")},
+		{Method: http.MethodGet, Path: apiV1 + "/cart/shipping", Tag: "cart", Summary: "Quote the shipping options for the cart", Auth: true,
+			Query:     []openapi.Param{currencyParam, addressParam},
+			Responses: ok(dto.ShippingQuoteResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/cart/items/:product_id/save-for-later", Tag: "wishlists", Summary: "Move a cart item to a wishlist", Auth: true,
+			Description: "Without wishlist_id the item goes to the \"" + database.DefaultWishlistName + "\" list, which is created when missing.",
+			Body:        dto.SaveForLaterRequest{}, Responses: ok(dto.WishlistResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/cart/checkout", Tag: "orders", Summary: "Check out the cart", Auth: true,
+			Description: checkoutDescription + " Products that are no longer available fail the checkout with cart_items_unavailable; " +
+				"changed prices fail it with cart_prices_changed unless accept_price_changes is the price_changes_token of the cart quote showing the same changes, " +
+				"and the order is then charged at current prices.",
+			Query: cartCheckoutQuery, Headers: []openapi.Param{idempotencyKeyParam},
+			Body: dto.CheckoutRequest{}, Responses: ok(dto.OrderResponse{})},
+
+		{Method: http.MethodPost, Path: apiV1 + "/guest-cart", Tag: "cart", Summary: "Start a cart without an account",
+			Description: "The cart is kept for GUEST_CART_TTL after its last change. Send cart_token in the " + token.GuestCartHeader + " header to use it.",
+			Responses:   created(dto.GuestCartTokenResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/guest-cart", Tag: "cart", Summary: "Price the guest cart",
+			Query: []openapi.Param{currencyParam}, Headers: []openapi.Param{cartTokenParam}, Responses: ok(dto.GuestCartResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/guest-cart/items/:product_id", Tag: "cart", Summary: "Add a product to the guest cart",
+			Query: []openapi.Param{currencyParam}, Headers: []openapi.Param{cartTokenParam}, Responses: ok(dto.GuestCartResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/guest-cart/items/:product_id", Tag: "cart", Summary: "Remove a product from the guest cart",
+			Query: []openapi.Param{currencyParam}, Headers: []openapi.Param{cartTokenParam}, Responses: ok(dto.GuestCartResponse{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/orders", Tag: "orders", Summary: "List your orders, newest first", Auth: true,
+			Responses: ok([]dto.OrderResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/orders/:order_id", Tag: "orders", Summary: "Show one of your orders", Auth: true,
+			Description: "Addresses are shown as they were when the order was placed.",
+			Responses:   ok(dto.OrderResponse{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/wishlists", Tag: "wishlists", Summary: "List your wishlists", Auth: true,
+			Responses: ok([]dto.WishlistResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/wishlists", Tag: "wishlists", Summary: "Create a wishlist", Auth: true,
+			Description: "Names are unique per user.",
+			Body:        dto.WishlistRequest{}, Responses: created(dto.WishlistResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/wishlists/:wishlist_id", Tag: "wishlists", Summary: "Show a wishlist with current prices", Auth: true,
+			Description: "Each item has the price it was saved at, the current price and, when it got cheaper, the price_drop.",
+			Responses:   ok(dto.WishlistResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/wishlists/:wishlist_id", Tag: "wishlists", Summary: "Rename a wishlist", Auth: true,
+			Body: dto.WishlistRequest{}, Responses: ok(dto.WishlistResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/wishlists/:wishlist_id", Tag: "wishlists", Summary: "Delete a wishlist", Auth: true,
+			Responses: ok("")},
+		{Method: http.MethodPost, Path: apiV1 + "/wishlists/:wishlist_id/items/:product_id", Tag: "wishlists", Summary: "Save a product on a wishlist", Auth: true,
+			Description: "Records the current price. Saving a product that is already on the list keeps its original price.",
+			Responses:   ok(dto.WishlistResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/wishlists/:wishlist_id/items/:product_id", Tag: "wishlists", Summary: "Remove a product from a wishlist", Auth: true,
+			Responses: ok(dto.WishlistResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/wishlists/:wishlist_id/items/:product_id/move-to-cart", Tag: "wishlists", Summary: "Move a wishlist item to the cart", Auth: true,
+			Description: "A product that was deleted from the store fails with product_not_found and stays on the list.",
+			Responses:   ok(dto.WishlistResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/wishlists/:wishlist_id/share", Tag: "wishlists", Summary: "Share a wishlist by link", Auth: true,
+			Description: "Creates a new random share token; any earlier link stops working.",
+			Responses:   ok(dto.ShareWishlistResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/wishlists/:wishlist_id/share", Tag: "wishlists", Summary: "Stop sharing a wishlist", Auth: true,
+			Responses: ok("")},
+		{Method: http.MethodGet, Path: apiV1 + "/wishlists/shared/:token", Tag: "wishlists", Summary: "Show a shared wishlist",
+			Responses: ok(dto.SharedWishlistResponse{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/addresses", Tag: "addresses", Summary: "List the address book", Auth: true,
+			Responses: ok([]dto.AddressResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/addresses", Tag: "addresses", Summary: "Add an address", Auth: true,
+			Description: "The address is normalized and checked against the postal code rules of its country; each invalid field is listed in the error details. The first address becomes the default for shipping and billing. Fails with address_limit once MAX_ADDRESSES are stored.",
+			Body:        dto.AddressRequest{}, Responses: created(dto.AddressResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/addresses/:address_id", Tag: "addresses", Summary: "Change the fields of an address that are set", Auth: true,
+			Description: "The merged address is normalized and validated like a new one. Making an address a default clears the flag on the others.",
+			Body:        dto.AddressRequest{}, Responses: ok(dto.AddressResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/addresses/:address_id", Tag: "addresses", Summary: "Delete an address", Auth: true,
+			Responses: ok("")},
+
+		{Method: http.MethodPost, Path: apiV1 + "/admin/products", Tag: "admin", Summary: "Add a product", Auth: true, Admin: true,
+			Body: dto.ProductRequest{}, Responses: ok("")},
+		{Method: http.MethodPost, Path: apiV1 + "/admin/products/import", Tag: "admin", Summary: "Import products from CSV or JSON lines", Auth: true, Admin: true,
+			Description: "Send the file as the multipart field file, or as the raw body with format set.",
+			Query:       []openapi.Param{formatParam, {Name: "dry_run", Type: "boolean"}},
+			Body: struct {
+				File openapi.File `json:"file"`
+			}{}, BodyContentType: "multipart/form-data",
+			Responses: []openapi.Reply{
+				{Status: http.StatusOK, Body: catalog.Report{}},
+				{Status: http.StatusUnprocessableEntity, Description: "No row was valid", Body: catalog.Report{}},
+			}},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/products/export", Tag: "admin", Summary: "Export products as CSV or JSON lines", Auth: true, Admin: true,
+			Query:     []openapi.Param{formatParam},
+			Responses: []openapi.Reply{{Status: http.StatusOK, Description: "text/csv, or application/x-ndjson for jsonl", Body: "", ContentType: "text/csv"}}},
+		{Method: http.MethodPost, Path: apiV1 + "/admin/products/:product_id/images", Tag: "admin", Summary: "Upload product images", Auth: true, Admin: true,
+			Body: struct {
+				Images []openapi.File `json:"images"`
+			}{}, BodyContentType: "multipart/form-data", Responses: created([]dto.ProductImageResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/admin/products/:product_id/images", Tag: "admin", Summary: "Reorder product images", Auth: true, Admin: true,
+			Body: dto.ReorderImagesRequest{}, Responses: ok([]dto.ProductImageResponse{})},
+		{Method: http.MethodDelete, Path: apiV1 + "/admin/products/:product_id/images/:image_id", Tag: "admin", Summary: "Delete a product image", Auth: true, Admin: true,
+			Responses: ok("")},
+
+		{Method: http.MethodGet, Path: apiV1 + "/admin/currencies/rates", Tag: "admin", Summary: "Get exchange rates", Auth: true, Admin: true,
+			Responses: ok(dto.ExchangeRatesResponse{})},
+		{Method: http.MethodPut, Path: apiV1 + "/admin/currencies/rates", Tag: "admin", Summary: "Replace exchange rates", Auth: true, Admin: true,
+			Body: dto.ExchangeRatesRequest{}, Responses: ok(dto.ExchangeRatesResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/taxes", Tag: "admin", Summary: "Get tax rules", Auth: true, Admin: true,
+			Responses: ok(dto.TaxRulesResponse{})},
+		{Method: http.MethodPut, Path: apiV1 + "/admin/taxes", Tag: "admin", Summary: "Replace tax rules", Auth: true, Admin: true,
+			Body: dto.TaxRulesRequest{}, Responses: ok(dto.TaxRulesResponse{})},
+
+		{Method: http.MethodPost, Path: apiV1 + "/admin/coupons", Tag: "admin", Summary: "Create a coupon", Auth: true, Admin: true,
+			Body: dto.CouponRequest{}, Responses: created(dto.CouponResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/coupons", Tag: "admin", Summary: "List coupons", Auth: true, Admin: true,
+			Responses: ok([]dto.CouponResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/admin/coupons/:code", Tag: "admin", Summary: "Enable or disable a coupon", Auth: true, Admin: true,
+			Body: dto.SetActiveRequest{}, Responses: ok(dto.CouponResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/admin/promotions", Tag: "admin", Summary: "Create a promotion", Auth: true, Admin: true,
+			Body: dto.PromotionRequest{}, Responses: created(dto.PromotionResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/promotions", Tag: "admin", Summary: "List promotions", Auth: true, Admin: true,
+			Responses: ok([]dto.PromotionResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/admin/promotions/:promotion_id", Tag: "admin", Summary: "Enable or disable a promotion", Auth: true, Admin: true,
+			Body: dto.SetActiveRequest{}, Responses: ok(dto.PromotionResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/admin/shipping-methods", Tag: "admin", Summary: "Create a shipping method", Auth: true, Admin: true,
+			Body: dto.ShippingMethodRequest{}, Responses: created(dto.ShippingMethodResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/shipping-methods", Tag: "admin", Summary: "List shipping methods", Auth: true, Admin: true,
+			Responses: ok([]dto.ShippingMethodResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/admin/shipping-methods/:method_id", Tag: "admin", Summary: "Enable or disable a shipping method", Auth: true, Admin: true,
+			Body: dto.SetActiveRequest{}, Responses: ok(dto.ShippingMethodResponse{})},
+
+		{Method: http.MethodPost, Path: apiV1 + "/admin/orders/:order_id/payment/capture", Tag: "payments", Summary: "Capture an authorized payment", Auth: true, Admin: true,
+			Responses: ok(dto.PaymentResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/admin/orders/:order_id/payment/void", Tag: "payments", Summary: "Void an authorized payment", Auth: true, Admin: true,
+			Responses: ok(dto.PaymentResponse{})},
+		{Method: http.MethodPost, Path: apiV1 + "/admin/orders/:order_id/payment/refund", Tag: "payments", Summary: "Refund a captured payment", Auth: true, Admin: true,
+			Description: "Without a body the whole captured amount is refunded.",
+			Body:        dto.RefundRequest{}, Responses: ok(dto.PaymentResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/orders/:order_id", Tag: "admin", Summary: "Show any order", Auth: true, Admin: true,
+			Responses: ok(dto.AdminOrderResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/cart-reminders", Tag: "admin", Summary: "List abandoned cart reminders", Auth: true, Admin: true,
+			Description: "Newest first. A reminder is recovered when its customer checks out within CART_RECOVERY_WINDOW of it; sent and recovered count all reminders.",
+			Query:       []openapi.Param{limitParam}, Responses: ok(dto.CartRemindersResponse{})},
+		{Method: http.MethodGet, Path: apiV1 + "/admin/orders/:order_id/payment/transactions", Tag: "payments", Summary: "List payment transactions of an order", Auth: true, Admin: true,
+			Responses: ok([]dto.PaymentTransactionResponse{})},
+
+		{Method: http.MethodGet, Path: apiV1 + "/admin/reviews/pending", Tag: "reviews", Summary: "List reviews awaiting moderation", Auth: true, Admin: true,
+			Query: []openapi.Param{limitParam}, Responses: ok([]dto.ReviewResponse{})},
+		{Method: http.MethodPatch, Path: apiV1 + "/admin/reviews/:review_id", Tag: "reviews", Summary: "Moderate a review", Auth: true, Admin: true,
+			Body: dto.ModerateReviewRequest{}, Responses: ok(dto.ReviewResponse{})},
+	}
+}
+
+// legacyRoute maps an unversioned route onto its /api/v1 successor. Query
+// lists the parameters the legacy route takes in place of path parameters
+// and the authenticated user.
+type legacyRoute struct {
+	method, path                   string
+	successorMethod, successorPath string
+	query                          []openapi.Param
+}
+
+func id(name, description string) openapi.Param {
+	return openapi.Param{Name: name, Description: description, Required: true}
+}
+
+var (
+	legacyUser    = id("id", "User ID.")
+	legacyProduct = id("pid", "Product ID.")
+	legacyBuyer   = id("id", "User ID. Must be the user of the token.")
+)
+
+var legacyRoutes = []legacyRoute{
+	{http.MethodPost, "/users/signup", http.MethodPost, "/auth/signup", nil},
+	{http.MethodPost, "/users/login", http.MethodPost, "/auth/login", nil},
+	{http.MethodGet, "/users/productview", http.MethodGet, "/products", nil},
+	{http.MethodGet, "/users/search", http.MethodGet, "/products/search", nil},
+	{http.MethodGet, "/users/search/suggest", http.MethodGet, "/products/suggest", nil},
+	{http.MethodGet, "/users/currencies", http.MethodGet, "/currencies", nil},
+	{http.MethodGet, "/users/payment-methods", http.MethodGet, "/payment-methods", nil},
+	{http.MethodGet, "/users/reviews", http.MethodGet, "/products/:product_id/reviews", []openapi.Param{legacyProduct}},
+	{http.MethodPost, "/reviews/add", http.MethodPost, "/products/:product_id/reviews", []openapi.Param{legacyProduct}},
+
+	{http.MethodGet, "/cart/add", http.MethodPost, "/cart/items/:product_id", []openapi.Param{id("id", "Product ID."), id("userID", "User ID.")}},
+	{http.MethodGet, "/cart/remove", http.MethodDelete, "/cart/items/:product_id", []openapi.Param{id("id", "Product ID."), id("userID", "User ID.")}},
+	{http.MethodGet, "/cart/list", http.MethodGet, "/cart", []openapi.Param{legacyUser}},
+	{http.MethodPost, "/cart/coupon", http.MethodPut, "/cart/coupon", []openapi.Param{legacyUser}},
+	{http.MethodDelete, "/cart/coupon", http.MethodDelete, "/cart/coupon", []openapi.Param{legacyUser}},
+	{http.MethodGet, "/cart/shipping", http.MethodGet, "/cart/shipping", []openapi.Param{legacyUser}},
+	{http.MethodGet, "/cart/checkout", http.MethodPost, "/cart/checkout", []openapi.Param{legacyBuyer}},
+	{http.MethodGet, "/cart/buy", http.MethodPost, "/products/:product_id/buy", []openapi.Param{id("userid", "User ID. Must be the user of the token."), legacyProduct}},
+
+	{http.MethodPost, "/address/add", http.MethodPost, "/addresses", []openapi.Param{legacyUser}},
+	{http.MethodPut, "/address/edit/home", http.MethodPatch, "/addresses/:address_id", []openapi.Param{legacyUser}},
+	{http.MethodPut, "/address/edit/work", http.MethodPatch, "/addresses/:address_id", []openapi.Param{legacyUser}},
+
+	{http.MethodPost, "/admin/products/add", http.MethodPost, "/admin/products", nil},
+	{http.MethodPost, "/admin/products/import", http.MethodPost, "/admin/products/import", nil},
+	{http.MethodGet, "/admin/products/export", http.MethodGet, "/admin/products/export", nil},
+	{http.MethodPost, "/admin/products/images", http.MethodPost, "/admin/products/:product_id/images", []openapi.Param{legacyProduct}},
+	{http.MethodPut, "/admin/products/images/order", http.MethodPatch, "/admin/products/:product_id/images", []openapi.Param{legacyProduct}},
+	{http.MethodDelete, "/admin/products/images", http.MethodDelete, "/admin/products/:product_id/images/:image_id", []openapi.Param{legacyProduct, id("image_id", "Image ID.")}},
+	{http.MethodGet, "/admin/currencies/rates", http.MethodGet, "/admin/currencies/rates", nil},
+	{http.MethodPut, "/admin/currencies/rates", http.MethodPut, "/admin/currencies/rates", nil},
+	{http.MethodGet, "/admin/taxes", http.MethodGet, "/admin/taxes", nil},
+	{http.MethodPut, "/admin/taxes", http.MethodPut, "/admin/taxes", nil},
+	{http.MethodPost, "/admin/coupons", http.MethodPost, "/admin/coupons", nil},
+	{http.MethodGet, "/admin/coupons", http.MethodGet, "/admin/coupons", nil},
+	{http.MethodPut, "/admin/coupons/active", http.MethodPatch, "/admin/coupons/:code", []openapi.Param{id("code", "Coupon code.")}},
+	{http.MethodPost, "/admin/promotions", http.MethodPost, "/admin/promotions", nil},
+	{http.MethodGet, "/admin/promotions", http.MethodGet, "/admin/promotions", nil},
+	{http.MethodPut, "/admin/promotions/active", http.MethodPatch, "/admin/promotions/:promotion_id", []openapi.Param{id("id", "Promotion ID.")}},
+	{http.MethodPost, "/admin/shipping", http.MethodPost, "/admin/shipping-methods", nil},
+	{http.MethodGet, "/admin/shipping", http.MethodGet, "/admin/shipping-methods", nil},
+	{http.MethodPut, "/admin/shipping/active", http.MethodPatch, "/admin/shipping-methods/:method_id", []openapi.Param{id("id", "Shipping method ID.")}},
+	{http.MethodPost, "/admin/orders/payment/capture", http.MethodPost, "/admin/orders/:order_id/payment/capture", []openapi.Param{id("id", "Order ID.")}},
+	{http.MethodPost, "/admin/orders/payment/void", http.MethodPost, "/admin/orders/:order_id/payment/void", []openapi.Param{id("id", "Order ID.")}},
+	{http.MethodPost, "/admin/orders/payment/refund", http.MethodPost, "/admin/orders/:order_id/payment/refund", []openapi.Param{id("id", "Order ID.")}},
+	{http.MethodGet, "/admin/orders/payment/transactions", http.MethodGet, "/admin/orders/:order_id/payment/transactions", []openapi.Param{id("id", "Order ID.")}},
+	{http.MethodGet, "/admin/reviews/pending", http.MethodGet, "/admin/reviews/pending", nil},
+	{http.MethodPut, "/admin/reviews/moderate", http.MethodPatch, "/admin/reviews/:review_id", []openapi.Param{id("id", "Review ID.")}},
+}
+
+// retiredRoutes documents the legacy routes that have no v1 successor.
+func retiredRoutes() []openapi.Route {
+	return []openapi.Route{
+		{Method: http.MethodDelete, Path: "/address/delete", Tag: "addresses", Summary: "Delete every address", Auth: true, Deprecated: true,
+			Description: "Deprecated without a successor, delete addresses one at a time with DELETE " + apiV1 + "/addresses/{address_id}.",
+			Query:       []openapi.Param{legacyUser}, Responses: ok("")},
+	}
+}
+
+// legacyDocRoutes documents the legacy routes as deprecated copies of their
+// successors in v1.
+func legacyDocRoutes(v1 []openapi.Route) []openapi.Route {
+	successors := make(map[string]openapi.Route, len(v1))
+	for _, r := range v1 {
+		successors[openapi.Key(r.Method, r.Path)] = r
+	}
+
+	documented := make([]openapi.Route, 0, len(legacyRoutes))
+
+	for _, legacy := range legacyRoutes {
+		key := openapi.Key(legacy.successorMethod, apiV1+legacy.successorPath)
+		successor, found := successors[key]
+		if !found {
+			panic("routes: legacy route " + legacy.path + " has no documented successor " + key)
+		}
+
+		r := successor
+		r.Method, r.Path = legacy.method, legacy.path
+		r.Deprecated = true
+		r.Description = "Deprecated, use " + key + " instead."
+		r.Query = append(append([]openapi.Param{}, legacy.query...), successor.Query...)
+		if r.Method == http.MethodGet {
+			r.Body = nil
+		}
+		documented = append(documented, r)
+	}
+
+	return documented
+}
@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/maksimulitin/internal/apierror"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"net/http"
+)
+
+// pathQuery exposes the path parameter param under the query name the
+// handler reads, so versioned routes share handlers with the legacy ones.
+// It replaces any value the client put in the query.
+func pathQuery(param, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setQuery(c, name, c.Param(param))
+		c.Next()
+	}
+}
+
+// userQuery passes the authenticated user as the query parameter name, so
+// clients of the versioned API cannot act on behalf of someone else. It must
+// run after Authentication.
+func userQuery(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setQuery(c, name, c.GetString("uid"))
+		c.Next()
+	}
+}
+
+// sameUser refuses requests whose query parameter name is not the
+// authenticated user, for legacy routes that still take the user from the
+// query. It must run after Authentication.
+func sameUser(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query(name) != c.GetString("uid") {
+			logger.Warn("request for another user refused", slog.String("uid", c.GetString("uid")), slog.String("path", c.FullPath()))
+			apierror.Abort(c, http.StatusForbidden, apierror.CodeForbidden, "cannot act on behalf of another user")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func setQuery(c *gin.Context, name, value string) {
+	query := c.Request.URL.Query()
+	query.Set(name, value)
+	c.Request.URL.RawQuery = query.Encode()
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/maksimulitin/internal/controllers"
+	"github.com/maksimulitin/internal/middleware"
+)
+
+func setupReviewRoutes(router gin.IRouter) {
+	router.GET("/users/reviews", controllers.ProductReviews())
+
+	reviews := router.Group("/reviews")
+	reviews.Use(middleware.Authentication())
+	{
+		reviews.POST("/add", controllers.AddReview())
+	}
+}
@@ -3,11 +3,37 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/maksimulitin/internal/controllers"
+	"github.com/maksimulitin/internal/middleware"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"os"
+	"strconv"
 )
 
+// legacyRoutesEnabled reports whether the unversioned routes are served,
+// from LEGACY_ROUTES. They stay off unless it is set to true.
+func legacyRoutesEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("LEGACY_ROUTES"))
+	return enabled
+}
+
 func SetupRoutes(router *gin.Engine, app *controllers.Application) {
-	setupUserRoutes(router)
-	setupCartRoutes(router, app)
-	setupAddressRoutes(router)
-	setupAdminRoutes(router)
+	setupDocsRoutes(router)
+	setupV1Routes(router.Group(apiV1), app)
+
+	if legacyRoutesEnabled() {
+		legacy := router.Group("", middleware.Deprecated(apiV1))
+		setupUserRoutes(legacy)
+		setupCartRoutes(legacy, app)
+		setupAddressRoutes(legacy)
+		setupAdminRoutes(legacy, app)
+		setupReviewRoutes(legacy)
+	} else {
+		logger.Info("Legacy routes disabled, only " + apiV1 + " is served")
+	}
+
+	undocumented, _ := Coverage(router)
+	for _, route := range undocumented {
+		logger.Warn("Route is missing from the OpenAPI document", slog.String("route", route))
+	}
 }
@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/maksimulitin/internal/controllers"
+	"github.com/maksimulitin/internal/models"
+	token "github.com/maksimulitin/internal/tokens"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testRouter(t *testing.T, legacy bool) *gin.Engine {
+	t.Helper()
+	t.Setenv("LEGACY_ROUTES", strconv.FormatBool(legacy))
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	SetupRoutes(router, controllers.NewApplication(nil, nil, nil))
+
+	return router
+}
+
+// specPathOf converts a gin path into the OpenAPI path syntax used as a key
+// of the document.
+func specPathOf(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func TestEveryRouteIsInSpec(t *testing.T) {
+	spec := Spec()
+
+	for _, legacy := range []bool{true, false} {
+		router := testRouter(t, legacy)
+
+		for _, route := range router.Routes() {
+			item, found := spec.Paths[specPathOf(route.Path)]
+			if !found || item[strings.ToLower(route.Method)] == nil {
+				t.Errorf("legacy=%t: %s %s is not in the OpenAPI document", legacy, route.Method, route.Path)
+			}
+		}
+	}
+}
+
+func TestEveryDocumentedRouteIsRegistered(t *testing.T) {
+	undocumented, unregistered := Coverage(testRouter(t, true))
+
+	for _, route := range undocumented {
+		t.Errorf("undocumented route: %s", route)
+	}
+
+	for _, route := range unregistered {
+		t.Errorf("documented route is not registered: %s", route)
+	}
+}
+
+func TestAdminRoutesDocumentForbidden(t *testing.T) {
+	spec := Spec()
+
+	for path, item := range spec.Paths {
+		if !strings.HasPrefix(path, apiV1+"/admin/") {
+			continue
+		}
+
+		for method, op := range item {
+			if _, found := op.Responses[strconv.Itoa(http.StatusForbidden)]; !found {
+				t.Errorf("%s %s does not document the admin role requirement", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestLegacyRoutesAreOptIn(t *testing.T) {
+	for _, value := range []string{"", "false", "no"} {
+		t.Setenv("LEGACY_ROUTES", value)
+
+		if legacyRoutesEnabled() {
+			t.Errorf("LEGACY_ROUTES=%q enables the legacy routes", value)
+		}
+	}
+
+	t.Setenv("LEGACY_ROUTES", "true")
+
+	if !legacyRoutesEnabled() {
+		t.Error("LEGACY_ROUTES=true does not enable the legacy routes")
+	}
+}
+
+func TestLegacyPurchasesRefuseOtherUsers(t *testing.T) {
+	router := testRouter(t, true)
+	signed, _, err := token.TokenGenerator("jane@example.com", "Jane", "Doe", "64b7f0c2a1e4d5f6a7b8c9d0", models.RoleCustomer)
+
+	if err != nil {
+		t.Fatalf("TokenGenerator() error = %v", err)
+	}
+
+	for _, target := range []string{
+		"/cart/checkout?id=64b7f0c2a1e4d5f6a7b8c9d1",
+		"/cart/checkout",
+		"/cart/buy?userid=64b7f0c2a1e4d5f6a7b8c9d1&pid=64b7f0c2a1e4d5f6a7b8c9d2",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("token", signed)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s = %d, want %d", target, rec.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"github.com/maksimulitin/internal/controllers"
 )
 
-func setupUserRoutes(router *gin.Engine) {
+func setupUserRoutes(router gin.IRouter) {
 	public := router.Group("/users")
 	{
 		public.POST("/signup", controllers.SignUp())
 		public.POST("/login", controllers.Login())
 		public.GET("/productview", controllers.SearchProduct())
 		public.GET("/search", controllers.SearchProductByQuery())
+		public.GET("/search/suggest", controllers.SearchSuggest())
+		public.GET("/currencies", controllers.ListCurrencies())
+		public.GET("/payment-methods", controllers.ListPaymentMethods())
 	}
 }
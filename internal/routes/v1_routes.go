@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/maksimulitin/internal/controllers"
+	"github.com/maksimulitin/internal/middleware"
+)
+
+const apiV1 = "/api/v1"
+
+// setupV1Routes registers the versioned API. Reads are GET, creations POST,
+// partial updates PATCH, replacements PUT and removals DELETE; resources are
+// addressed by path, and customer routes act on the authenticated user.
+func setupV1Routes(v1 *gin.RouterGroup, app *controllers.Application) {
+	auth := v1.Group("/auth")
+	{
+		auth.POST("/signup", controllers.SignUp())
+		auth.POST("/login", controllers.Login())
+	}
+
+	v1.GET("/currencies", controllers.ListCurrencies())
+	v1.GET("/payment-methods", controllers.ListPaymentMethods())
+	v1.POST("/payments/webhooks/:provider", controllers.PaymentWebhook())
+
+	products := v1.Group("/products")
+	{
+		products.GET("", controllers.SearchProduct())
+		products.GET("/search", controllers.SearchProductByQuery())
+		products.GET("/suggest", controllers.SearchSuggest())
+		products.GET("/:product_id/reviews", pathQuery("product_id", "pid"), controllers.ProductReviews())
+	}
+
+	customer := v1.Group("", middleware.Authentication())
+	{
+		customer.POST("/products/:product_id/reviews", pathQuery("product_id", "pid"), controllers.AddReview())
+		customer.POST("/products/:product_id/buy",
+			userQuery("userid"), pathQuery("product_id", "pid"),
+			middleware.Idempotency(controllers.IdempotencyCollection), app.InstantBuy())
+	}
+
+	cart := v1.Group("/cart", middleware.Authentication())
+	{
+		cart.GET("", userQuery("id"), controllers.GetItemFromCart())
+		cart.POST("/items/:product_id", userQuery("userID"), pathQuery("product_id", "id"), app.AddToCart())
+		cart.DELETE("/items/:product_id", userQuery("userID"), pathQuery("product_id", "id"), app.RemoveItem())
+		cart.PUT("/coupon", userQuery("id"), controllers.ApplyCoupon())
+		cart.DELETE("/coupon", userQuery("id"), controllers.RemoveCoupon())
+		cart.GET("/shipping", userQuery("id"), controllers.ShippingQuote())
+		cart.POST("/items/:product_id/save-for-later", userQuery("userid"), pathQuery("product_id", "pid"), controllers.SaveForLater())
+		cart.POST("/checkout", userQuery("id"), middleware.Idempotency(controllers.IdempotencyCollection), app.BuyFromCart())
+	}
+
+	guestCart := v1.Group("/guest-cart")
+	{
+		guestCart.POST("", controllers.CreateGuestCart())
+		guestCart.GET("", controllers.GetGuestCart())
+		guestCart.POST("/items/:product_id", pathQuery("product_id", "id"), controllers.AddGuestCartItem())
+		guestCart.DELETE("/items/:product_id", pathQuery("product_id", "id"), controllers.RemoveGuestCartItem())
+	}
+
+	orders := v1.Group("/orders", middleware.Authentication(), userQuery("userid"))
+	{
+		orders.GET("", controllers.ListOrders())
+		orders.GET("/:order_id", pathQuery("order_id", "id"), controllers.GetOrder())
+	}
+
+	v1.GET("/wishlists/shared/:token", pathQuery("token", "token"), controllers.SharedWishlist())
+
+	wishlists := v1.Group("/wishlists", middleware.Authentication(), userQuery("userid"))
+	{
+		wishlists.GET("", controllers.ListWishlists())
+		wishlists.POST("", controllers.CreateWishlist())
+		wishlists.GET("/:wishlist_id", pathQuery("wishlist_id", "wishlist_id"), controllers.GetWishlist())
+		wishlists.PATCH("/:wishlist_id", pathQuery("wishlist_id", "wishlist_id"), controllers.RenameWishlist())
+		wishlists.DELETE("/:wishlist_id", pathQuery("wishlist_id", "wishlist_id"), controllers.DeleteWishlist())
+		wishlists.POST("/:wishlist_id/items/:product_id",
+			pathQuery("wishlist_id", "wishlist_id"), pathQuery("product_id", "pid"), controllers.AddWishlistItem())
+		wishlists.DELETE("/:wishlist_id/items/:product_id",
+			pathQuery("wishlist_id", "wishlist_id"), pathQuery("product_id", "pid"), controllers.RemoveWishlistItem())
+		wishlists.POST("/:wishlist_id/items/:product_id/move-to-cart",
+			pathQuery("wishlist_id", "wishlist_id"), pathQuery("product_id", "pid"), controllers.MoveWishlistItemToCart())
+		wishlists.POST("/:wishlist_id/share", pathQuery("wishlist_id", "wishlist_id"), controllers.ShareWishlist())
+		wishlists.DELETE("/:wishlist_id/share", pathQuery("wishlist_id", "wishlist_id"), controllers.UnshareWishlist())
+	}
+
+	addresses := v1.Group("/addresses", middleware.Authentication(), userQuery("id"))
+	{
+		addresses.GET("", controllers.ListAddresses())
+		addresses.POST("", controllers.AddAddress())
+		addresses.PATCH("/:address_id", pathQuery("address_id", "address_id"), controllers.UpdateAddress())
+		addresses.DELETE("/:address_id", pathQuery("address_id", "address_id"), controllers.DeleteAddress())
+	}
+
+	admin := v1.Group("/admin", middleware.Authentication(), middleware.RequireAdmin(controllers.UserCollection))
+	{
+		admin.POST("/products", controllers.ProductViewerAdmin())
+		admin.POST("/products/import", controllers.ImportProducts())
+		admin.GET("/products/export", controllers.ExportProducts())
+		admin.POST("/products/:product_id/images", pathQuery("product_id", "pid"), app.UploadProductImages())
+		admin.PATCH("/products/:product_id/images", pathQuery("product_id", "pid"), app.ReorderProductImages())
+		admin.DELETE("/products/:product_id/images/:image_id",
+			pathQuery("product_id", "pid"), pathQuery("image_id", "image_id"), app.DeleteProductImage())
+
+		admin.GET("/currencies/rates", controllers.GetExchangeRates())
+		admin.PUT("/currencies/rates", controllers.UpdateExchangeRates())
+		admin.GET("/taxes", controllers.GetTaxRules())
+		admin.PUT("/taxes", controllers.UpdateTaxRules())
+
+		admin.POST("/coupons", controllers.CreateCoupon())
+		admin.GET("/coupons", controllers.ListCoupons())
+		admin.PATCH("/coupons/:code", pathQuery("code", "code"), controllers.SetCouponActive())
+		admin.POST("/promotions", controllers.CreatePromotion())
+		admin.GET("/promotions", controllers.ListPromotions())
+		admin.PATCH("/promotions/:promotion_id", pathQuery("promotion_id", "id"), controllers.SetPromotionActive())
+		admin.POST("/shipping-methods", controllers.CreateShippingMethod())
+		admin.GET("/shipping-methods", controllers.ListShippingMethods())
+		admin.PATCH("/shipping-methods/:method_id", pathQuery("method_id", "id"), controllers.SetShippingMethodActive())
+
+		admin.GET("/orders/:order_id", pathQuery("order_id", "id"), controllers.GetOrderAdmin())
+		admin.GET("/cart-reminders", controllers.ListCartReminders())
+		admin.POST("/orders/:order_id/payment/capture", pathQuery("order_id", "id"), controllers.CapturePayment())
+		admin.POST("/orders/:order_id/payment/void", pathQuery("order_id", "id"), controllers.VoidPayment())
+		admin.POST("/orders/:order_id/payment/refund", pathQuery("order_id", "id"), controllers.RefundPayment())
+		admin.GET("/orders/:order_id/payment/transactions", pathQuery("order_id", "id"), controllers.ListPaymentTransactions())
+
+		admin.GET("/reviews/pending", controllers.PendingReviews())
+		admin.PATCH("/reviews/:review_id", pathQuery("review_id", "id"), controllers.ModerateReview())
+	}
+}
@@ -0,0 +1,76 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuestCartHeader carries the cart token of an anonymous shopper.
+const GuestCartHeader = "X-Cart-Token"
+
+var (
+	ErrInvalidCartToken   = errors.New("invalid cart token")
+	ErrMissingCartSecret  = errors.New("GUEST_CART_SECRET must be set")
+	ErrSharedCartSecret   = errors.New("GUEST_CART_SECRET must differ from the JWT secret")
+	ErrCartTokensDisabled = errors.New("guest cart tokens are not configured")
+)
+
+// guestCartKey signs cart tokens. It is set once at startup by
+// SetGuestCartSecret and is never derived from the JWT secret, so leaking one
+// does not forge the other.
+var guestCartKey []byte
+
+// SetGuestCartSecret sets the key cart tokens are signed with. The service
+// refuses to start without one.
+func SetGuestCartSecret(secret string) error {
+	if secret == "" {
+		return ErrMissingCartSecret
+	}
+
+	if secret == SECRET_KEY {
+		return ErrSharedCartSecret
+	}
+
+	guestCartKey = []byte(secret)
+	return nil
+}
+
+// GuestCartToken signs the ID of a guest cart, so clients can hold on to the
+// cart without being able to guess or forge anyone else's.
+func GuestCartToken(cartID primitive.ObjectID) (string, error) {
+	if len(guestCartKey) == 0 {
+		return "", ErrCartTokensDisabled
+	}
+
+	id := cartID.Hex()
+	return id + "." + guestCartSignature(id), nil
+}
+
+// ParseGuestCartToken checks the signature of a cart token and returns the
+// cart ID in it.
+func ParseGuestCartToken(cartToken string) (primitive.ObjectID, error) {
+	id, signature, found := strings.Cut(cartToken, ".")
+
+	if len(guestCartKey) == 0 || !found || !hmac.Equal([]byte(signature), []byte(guestCartSignature(id))) {
+		return primitive.NilObjectID, ErrInvalidCartToken
+	}
+
+	cartID, err := primitive.ObjectIDFromHex(id)
+
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidCartToken
+	}
+
+	return cartID, nil
+}
+
+func guestCartSignature(id string) string {
+	mac := hmac.New(sha256.New, guestCartKey)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -20,6 +20,7 @@ type SignedDetails struct {
 	FirstName string
 	LastName  string
 	Uid       string
+	Role      string
 	jwt.StandardClaims
 }
 
@@ -28,7 +29,7 @@ var (
 	SECRET_KEY                   = os.Getenv("SECRET_LOVE")
 )
 
-func TokenGenerator(email string, firstname string, lastname string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func TokenGenerator(email string, firstname string, lastname string, uid string, role string) (signedToken string, signedRefreshToken string, err error) {
 	logger.Info("Generating tokens", slog.String("email", email), slog.String("uid", uid))
 
 	claims := &SignedDetails{
@@ -36,6 +37,7 @@ func TokenGenerator(email string, firstname string, lastname string, uid string)
 		FirstName: firstname,
 		LastName:  lastname,
 		Uid:       uid,
+		Role:      role,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},
@@ -0,0 +1,251 @@
+package address
+
+import (
+	_ "embed"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Fields is a postal address as customers enter it. Country is an ISO 3166-1
+// alpha-2 code.
+type Fields struct {
+	RecipientName string
+	Line1         string
+	Line2         string
+	City          string
+	Region        string
+	PostalCode    string
+	Country       string
+	Phone         string
+}
+
+// FieldError is one invalid field, named as in the API.
+type FieldError struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// Country holds the address rules of one country. Countries without a
+// postal code pattern do not use postal codes.
+type Country struct {
+	Name               string `json:"name"`
+	PostalCode         string `json:"postal_code,omitempty"`
+	Example            string `json:"example,omitempty"`
+	Separator          string `json:"separator,omitempty"`
+	SeparatorAt        int    `json:"separator_at,omitempty"`
+	PostalCodeOptional bool   `json:"postal_code_optional,omitempty"`
+	RegionRequired     bool   `json:"region_required,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+const (
+	maxLineLength   = 100
+	maxCityLength   = 60
+	maxRegionLength = 60
+)
+
+//go:embed countries.json
+var countriesFile []byte
+
+var (
+	countries  = mustLoadCountries(countriesFile)
+	phoneRegex = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+)
+
+func mustLoadCountries(data []byte) map[string]*Country {
+	var loaded map[string]*Country
+
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		panic("address: invalid countries.json: " + err.Error())
+	}
+
+	for _, c := range loaded {
+		if c.PostalCode != "" {
+			c.pattern = regexp.MustCompile(c.PostalCode)
+		}
+	}
+
+	return loaded
+}
+
+// Lookup returns the rules for an ISO 3166-1 alpha-2 code.
+func Lookup(code string) (*Country, bool) {
+	c, ok := countries[strings.ToUpper(strings.TrimSpace(code))]
+	return c, ok
+}
+
+// Codes lists the supported country codes in order.
+func Codes() []string {
+	codes := make([]string, 0, len(countries))
+	for code := range countries {
+		codes = append(codes, code)
+	}
+
+	sort.Strings(codes)
+	return codes
+}
+
+// Normalize tidies whitespace and casing: text is trimmed with inner runs of
+// whitespace collapsed, words typed all in upper or lower case are
+// capitalised, country and region codes are upper-cased and postal codes are
+// written the way the country prints them.
+func Normalize(f Fields) Fields {
+	f.RecipientName = tidy(f.RecipientName)
+	f.Line1 = tidy(f.Line1)
+	f.Line2 = tidy(f.Line2)
+	f.City = tidy(f.City)
+	f.Country = strings.ToUpper(strings.TrimSpace(f.Country))
+	f.Phone = normalizePhone(f.Phone)
+
+	if region := strings.TrimSpace(f.Region); len(region) <= 3 {
+		f.Region = strings.ToUpper(region)
+	} else {
+		f.Region = tidy(region)
+	}
+
+	f.PostalCode = NormalizePostalCode(f.Country, f.PostalCode)
+	return f
+}
+
+// NormalizePostalCode upper-cases a postal code and rewrites its spaces and
+// dashes into the country's format, e.g. "sw1a1aa" into "SW1A 1AA" for GB.
+func NormalizePostalCode(country, code string) string {
+	compact := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, code)
+
+	c, ok := Lookup(country)
+	if !ok || c.Separator == "" {
+		return compact
+	}
+
+	at := c.SeparatorAt
+	if at < 0 {
+		at += len(compact)
+	}
+
+	if at <= 0 || at >= len(compact) {
+		return compact
+	}
+
+	return compact[:at] + c.Separator + compact[at:]
+}
+
+// Validate checks normalized fields against the rules of their country and
+// returns one error per invalid field.
+func Validate(f Fields) []FieldError {
+	var errs []FieldError
+
+	required := func(field, value string) bool {
+		if value == "" {
+			errs = append(errs, FieldError{Field: field, Rule: "required", Message: "is required"})
+			return false
+		}
+		return true
+	}
+
+	tooLong := func(field, value string, max int) {
+		if len([]rune(value)) > max {
+			errs = append(errs, FieldError{Field: field, Rule: "max", Message: "must be at most " + strconv.Itoa(max) + " characters long"})
+		}
+	}
+
+	if required("recipient_name", f.RecipientName) {
+		tooLong("recipient_name", f.RecipientName, maxLineLength)
+	}
+	if required("line1", f.Line1) {
+		tooLong("line1", f.Line1, maxLineLength)
+	}
+	tooLong("line2", f.Line2, maxLineLength)
+	if required("city", f.City) {
+		tooLong("city", f.City, maxCityLength)
+	}
+	tooLong("region", f.Region, maxRegionLength)
+
+	if f.Phone != "" && !phoneRegex.MatchString(f.Phone) {
+		errs = append(errs, FieldError{Field: "phone", Rule: "phone", Message: "must be 6 to 15 digits with an optional leading +"})
+	}
+
+	if !required("country", f.Country) {
+		return errs
+	}
+
+	c, ok := Lookup(f.Country)
+	if !ok {
+		return append(errs, FieldError{Field: "country", Rule: "country", Message: "must be a supported ISO 3166-1 alpha-2 country code"})
+	}
+
+	if c.RegionRequired {
+		required("region", f.Region)
+	}
+
+	switch {
+	case c.pattern == nil:
+		if f.PostalCode != "" {
+			errs = append(errs, FieldError{Field: "postal_code", Rule: "postal_code", Message: c.Name + " does not use postal codes"})
+		}
+	case f.PostalCode == "":
+		if !c.PostalCodeOptional {
+			required("postal_code", f.PostalCode)
+		}
+	case !c.pattern.MatchString(f.PostalCode):
+		errs = append(errs, FieldError{Field: "postal_code", Rule: "postal_code", Message: "is not a valid " + c.Name + " postal code, e.g. " + c.Example})
+	}
+
+	return errs
+}
+
+// tidy collapses whitespace and capitalises words typed all in one case, so
+// "  NEW   YORK" becomes "New York" while "McDonald" is left alone. Words
+// with digits, such as "4B", keep their casing.
+func tidy(s string) string {
+	words := strings.Fields(s)
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	joined := strings.Join(words, " ")
+	if joined != strings.ToUpper(joined) && joined != strings.ToLower(joined) {
+		return joined
+	}
+
+	for i, word := range words {
+		if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			continue
+		}
+
+		runes := []rune(strings.ToLower(word))
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+
+	return strings.Join(words, " ")
+}
+
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	plus := strings.HasPrefix(phone, "+")
+
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimPrefix(phone, "+"))
+
+	if plus {
+		return "+" + digits
+	}
+	return digits
+}
@@ -0,0 +1,146 @@
+package address
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePostalCode(t *testing.T) {
+	tests := []struct {
+		country, code string
+		want          string
+	}{
+		{"GB", "sw1a1aa", "SW1A 1AA"},
+		{"GB", " w1a-0ax ", "W1A 0AX"},
+		{"CA", "k1a0b1", "K1A 0B1"},
+		{"NL", "1012ab", "1012 AB"},
+		{"SE", "11122", "111 22"},
+		{"IE", "d02x285", "D02 X285"},
+		{"JP", "1000001", "100-0001"},
+		{"BR", "01310 100", "01310-100"},
+		{"PL", "00950", "00-950"},
+		{"US", "941051234", "94105-1234"},
+		{"US", "94105", "94105"},
+		{"us", " 94105 - 1234 ", "94105-1234"},
+		{"DE", "10 115", "10115"},
+		{"FR", "75-008", "75008"},
+		{"JP", "100", "100"},
+		{"CA", "k1", "K1"},
+		{"HK", "", ""},
+		{"ZZ", "ab-12", "AB12"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizePostalCode(tt.country, tt.code); got != tt.want {
+			t.Errorf("NormalizePostalCode(%q, %q) = %q, want %q", tt.country, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Fields
+		want Fields
+	}{
+		{
+			name: "one case text is capitalised",
+			in: Fields{RecipientName: "  JANE   DOE ", Line1: "12 main st", Line2: "APT 4B", City: "new york",
+				Region: " ny ", PostalCode: "941051234", Country: " us", Phone: "+1 (415) 555-0100"},
+			want: Fields{RecipientName: "Jane Doe", Line1: "12 Main St", Line2: "Apt 4B", City: "New York",
+				Region: "NY", PostalCode: "94105-1234", Country: "US", Phone: "+14155550100"},
+		},
+		{
+			name: "mixed case text is kept",
+			in: Fields{RecipientName: "Ronald  McDonald", Line1: "8 rue de la Paix", City: "Paris",
+				Region: "Île-de-France", PostalCode: "75 002", Country: "fr", Phone: "01.42.60.00.00"},
+			want: Fields{RecipientName: "Ronald McDonald", Line1: "8 rue de la Paix", City: "Paris",
+				Region: "Île-de-France", PostalCode: "75002", Country: "FR", Phone: "0142600000"},
+		},
+		{
+			name: "long region names are tidied",
+			in:   Fields{Region: "NEW SOUTH   WALES", PostalCode: " 2000", Country: "AU"},
+			want: Fields{Region: "New South Wales", PostalCode: "2000", Country: "AU"},
+		},
+		{
+			name: "blank fields stay blank",
+			in:   Fields{RecipientName: "   ", Line2: "\t", Country: "GB", Phone: " "},
+			want: Fields{Country: "GB"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func(country, region, postalCode string) Fields {
+		return Fields{RecipientName: "Jane Doe", Line1: "1 Main St", City: "Springfield",
+			Region: region, PostalCode: postalCode, Country: country}
+	}
+	with := func(f Fields, edit func(*Fields)) Fields {
+		edit(&f)
+		return f
+	}
+
+	tests := []struct {
+		name   string
+		fields Fields
+		want   []string
+	}{
+		{"valid US", valid("US", "CA", "94105"), nil},
+		{"valid US ZIP+4", valid("US", "CA", "94105-1234"), nil},
+		{"valid GB", valid("GB", "", "SW1A 1AA"), nil},
+		{"valid CA", valid("CA", "ON", "K1A 0B1"), nil},
+		{"short US postal code", valid("US", "CA", "9410"), []string{"postal_code/postal_code"}},
+		{"GB postal code without separator", valid("GB", "", "SW1A1AA"), []string{"postal_code/postal_code"}},
+		{"JP postal code with letters", valid("JP", "Tokyo", "100-000A"), []string{"postal_code/postal_code"}},
+		{"missing required region", valid("US", "", "94105"), []string{"region/required"}},
+		{"missing postal code", valid("DE", "", ""), []string{"postal_code/required"}},
+		{"optional postal code left out", valid("IE", "", ""), nil},
+		{"optional postal code invalid", valid("IE", "", "D02"), []string{"postal_code/postal_code"}},
+		{"country without postal codes", valid("HK", "", ""), nil},
+		{"postal code where none are used", valid("HK", "", "999077"), []string{"postal_code/postal_code"}},
+		{"region required without postal codes", valid("AE", "", ""), []string{"region/required"}},
+		{"unsupported country", valid("ZZ", "", "12345"), []string{"country/country"}},
+		{"missing country", valid("", "", "12345"), []string{"country/required"}},
+		{
+			"missing required fields",
+			with(valid("DE", "", "10115"), func(f *Fields) { f.RecipientName, f.Line1, f.City = "", "", "" }),
+			[]string{"recipient_name/required", "line1/required", "city/required"},
+		},
+		{
+			"lines too long",
+			with(valid("DE", "", "10115"), func(f *Fields) { f.Line1, f.Line2 = strings.Repeat("a", 101), strings.Repeat("b", 101) }),
+			[]string{"line1/max", "line2/max"},
+		},
+		{
+			"lengths count characters",
+			with(valid("DE", "", "10115"), func(f *Fields) { f.Line1, f.City = strings.Repeat("ä", 100), strings.Repeat("é", 60) }),
+			nil,
+		},
+		{"valid phone", with(valid("DE", "", "10115"), func(f *Fields) { f.Phone = "+491701234567" }), nil},
+		{"phone too short", with(valid("DE", "", "10115"), func(f *Fields) { f.Phone = "12345" }), []string{"phone/phone"}},
+		{"phone too long", with(valid("DE", "", "10115"), func(f *Fields) { f.Phone = "+1234567890123456" }), []string{"phone/phone"}},
+		{"phone with letters", with(valid("DE", "", "10115"), func(f *Fields) { f.Phone = "555CALLNOW" }), []string{"phone/phone"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range Validate(tt.fields) {
+				got = append(got, err.Field+"/"+err.Rule)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate(%+v) = %q, want %q", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+// MaxPixels rejects images whose declared dimensions would need an
+// unreasonable amount of memory to decode.
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image dimensions are too large")
+)
+
+type Size struct {
+	Name   string
+	MaxDim int
+}
+
+var ThumbnailSizes = []Size{
+	{Name: "small", MaxDim: 150},
+	{Name: "medium", MaxDim: 400},
+	{Name: "large", MaxDim: 800},
+}
+
+var allowedTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Sniff detects the content type from the data itself, ignoring whatever the
+// client claimed, and returns it with the file extension to store it under.
+func Sniff(data []byte) (contentType, ext string, err error) {
+	contentType = http.DetectContentType(data)
+	ext, ok := allowedTypes[contentType]
+
+	if !ok {
+		return contentType, "", ErrUnsupportedType
+	}
+
+	return contentType, ext, nil
+}
+
+func Decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	return img, nil
+}
+
+// Thumbnail scales img down to fit within a maxDim square, keeping the aspect
+// ratio. Images that already fit are returned unchanged.
+func Thumbnail(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+
+	dw, dh := maxDim, h*maxDim/w
+	if h > w {
+		dw, dh = w*maxDim/h, maxDim
+	}
+
+	return resize(img, max(dw, 1), max(dh, 1))
+}
+
+// resize downsamples by averaging every source pixel covered by a
+// destination pixel, which avoids the aliasing of nearest neighbour scaling.
+func resize(img image.Image, dw, dh int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+// Encode writes img in the same family as the original upload: PNG stays
+// lossless, GIF keeps its format and everything else becomes JPEG.
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case "image/png":
+		return png.Encode(w, img)
+	case "image/gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+}
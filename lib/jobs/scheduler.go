@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"context"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job is work the service repeats every Interval while it runs.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs in the background of the service. Each job has its own
+// goroutine, so a slow job never delays another, and a job never overlaps
+// with its own previous run.
+type Scheduler struct {
+	jobs   []Job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(jobs ...Job) *Scheduler {
+	return &Scheduler{jobs: jobs}
+}
+
+// Start runs every job after its first interval and then on each tick,
+// until ctx ends or Stop is called.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+
+	logger.Info("job scheduler started", slog.Int("jobs", len(s.jobs)))
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	s.wg.Wait()
+	logger.Info("job scheduler stopped")
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run(ctx, job)
+		}
+	}
+}
+
+// run calls a job once. Errors and panics are logged and the job is tried
+// again on the next tick.
+func run(ctx context.Context, job Job) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.Error("job panicked", slog.String("job", job.Name), slog.Any("panic", recovered))
+		}
+	}()
+
+	if err := job.Run(ctx); err != nil {
+		logger.Error("job failed", slog.String("job", job.Name), slog.Any("error", err))
+	}
+}
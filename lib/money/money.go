@@ -0,0 +1,218 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrOverflow         = errors.New("money amount overflow")
+	ErrInvalidCurrency  = errors.New("invalid currency code")
+	ErrInvalidAmount    = errors.New("invalid money amount")
+)
+
+// exponents lists ISO 4217 currencies whose minor unit is not 1/100.
+var exponents = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+}
+
+// Money is an exact amount in the minor unit of an ISO 4217 currency, e.g.
+// {1999, "USD"} is 19.99 US dollars. All arithmetic is checked for overflow.
+type Money struct {
+	Amount   int64  `json:"amount"   bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// Exponent returns the number of minor unit digits of currency.
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m Money) Validate() error {
+	if !ValidCurrency(m.Currency) {
+		return ErrInvalidCurrency
+	}
+	return nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+
+	product := m.Amount * n
+
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, ErrOverflow
+	}
+
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// Sum adds amounts that must all be in currency. An empty list sums to zero.
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Zero(currency)
+
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// Parse reads a decimal major unit string such as "19.99" into minor units.
+// More fraction digits than the currency supports are rejected rather than
+// rounded.
+func Parse(s, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, ErrInvalidCurrency
+	}
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	exp := Exponent(currency)
+
+	if whole == "" || len(fraction) > exp || strings.ContainsAny(whole+fraction, "+-") {
+		return Money{}, ErrInvalidAmount
+	}
+
+	fraction += strings.Repeat("0", exp-len(fraction))
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return Money{}, ErrOverflow
+		}
+		return Money{}, ErrInvalidAmount
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// Decimal formats the amount in major units, e.g. "19.99".
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	digits := strconv.FormatInt(m.Amount, 10)
+	sign := ""
+
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	if exp == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.Decimal(), m.Currency)
+}
+
+// Percent returns percent per cent of m, rounded half away from zero to the
+// minor unit.
+func (m Money) Percent(percent int64) (Money, error) {
+	return m.MulRat(big.NewRat(percent, 100))
+}
+
+// Min returns the smaller of two amounts in the same currency.
+func Min(a, b Money) (Money, error) {
+	if a.Currency != b.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	if b.Amount < a.Amount {
+		return b, nil
+	}
+
+	return a, nil
+}
+
+// MulRat multiplies m by an exact ratio, rounding half away from zero to the
+// minor unit.
+func (m Money) MulRat(r *big.Rat) (Money, error) {
+	amount, err := convert(m.Amount, r, 0)
+
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: m.Currency}, nil
+}
@@ -0,0 +1,259 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Money
+		want Money
+		err  error
+	}{
+		{"sum", New(1999, "USD"), New(1, "USD"), New(2000, "USD"), nil},
+		{"negative", New(-500, "EUR"), New(200, "EUR"), New(-300, "EUR"), nil},
+		{"max", New(math.MaxInt64-1, "USD"), New(1, "USD"), New(math.MaxInt64, "USD"), nil},
+		{"overflow", New(math.MaxInt64, "USD"), New(1, "USD"), Money{}, ErrOverflow},
+		{"underflow", New(math.MinInt64, "USD"), New(-1, "USD"), Money{}, ErrOverflow},
+		{"currency mismatch", New(100, "USD"), New(100, "EUR"), Money{}, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Add(tt.b)
+
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("%v.Add(%v) = %v, %v; want %v, %v", tt.a, tt.b, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestSub(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Money
+		want Money
+		err  error
+	}{
+		{"difference", New(2000, "USD"), New(1, "USD"), New(1999, "USD"), nil},
+		{"below zero", New(100, "USD"), New(250, "USD"), New(-150, "USD"), nil},
+		{"overflow", New(math.MaxInt64, "USD"), New(-1, "USD"), Money{}, ErrOverflow},
+		{"underflow", New(math.MinInt64, "USD"), New(1, "USD"), Money{}, ErrOverflow},
+		{"negate min", New(0, "USD"), New(math.MinInt64, "USD"), Money{}, ErrOverflow},
+		{"currency mismatch", New(100, "USD"), New(100, "JPY"), Money{}, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Sub(tt.b)
+
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("%v.Sub(%v) = %v, %v; want %v, %v", tt.a, tt.b, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Money
+		n    int64
+		want Money
+		err  error
+	}{
+		{"product", New(1999, "USD"), 3, New(5997, "USD"), nil},
+		{"by zero", New(math.MaxInt64, "USD"), 0, New(0, "USD"), nil},
+		{"negative", New(250, "USD"), -2, New(-500, "USD"), nil},
+		{"overflow", New(math.MaxInt64/2+1, "USD"), 2, Money{}, ErrOverflow},
+		{"min by minus one", New(math.MinInt64, "USD"), -1, Money{}, ErrOverflow},
+		{"minus one by min", New(-1, "USD"), math.MinInt64, Money{}, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Mul(tt.n)
+
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("%v.Mul(%d) = %v, %v; want %v, %v", tt.m, tt.n, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []Money
+		want    Money
+		err     error
+	}{
+		{"empty", nil, New(0, "USD"), nil},
+		{"several", []Money{New(100, "USD"), New(250, "USD"), New(-50, "USD")}, New(300, "USD"), nil},
+		{"overflow", []Money{New(math.MaxInt64, "USD"), New(1, "USD")}, Money{}, ErrOverflow},
+		{"currency mismatch", []Money{New(100, "USD"), New(100, "EUR")}, Money{}, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sum("USD", tt.amounts...)
+
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("Sum(%v) = %v, %v; want %v, %v", tt.amounts, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestMin(t *testing.T) {
+	if _, err := Min(New(1, "USD"), New(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Min across currencies: got %v, want %v", err, ErrCurrencyMismatch)
+	}
+
+	if got, _ := Min(New(500, "USD"), New(300, "USD")); got != New(300, "USD") {
+		t.Errorf("Min = %v, want 3.00 USD", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     Money
+		err      error
+	}{
+		{"19.99", "USD", New(1999, "USD"), nil},
+		{"19.9", "USD", New(1990, "USD"), nil},
+		{"19", "USD", New(1900, "USD"), nil},
+		{" 0.05 ", "EUR", New(5, "EUR"), nil},
+		{"-3.50", "USD", New(-350, "USD"), nil},
+		{"1500", "JPY", New(1500, "JPY"), nil},
+		{"1.234", "KWD", New(1234, "KWD"), nil},
+		{"19.999", "USD", Money{}, ErrInvalidAmount},
+		{"1.5", "JPY", Money{}, ErrInvalidAmount},
+		{".50", "USD", Money{}, ErrInvalidAmount},
+		{"", "USD", Money{}, ErrInvalidAmount},
+		{"+1.00", "USD", Money{}, ErrInvalidAmount},
+		{"--1", "USD", Money{}, ErrInvalidAmount},
+		{"1.-5", "USD", Money{}, ErrInvalidAmount},
+		{"abc", "USD", Money{}, ErrInvalidAmount},
+		{"92233720368547758.08", "USD", Money{}, ErrOverflow},
+		{"1.00", "usd", Money{}, ErrInvalidCurrency},
+		{"1.00", "US", Money{}, ErrInvalidCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.in, tt.currency)
+
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("Parse(%q, %q) = %v, %v; want %v, %v", tt.in, tt.currency, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{New(1999, "USD"), "19.99"},
+		{New(5, "USD"), "0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(-5, "USD"), "-0.05"},
+		{New(-1999, "EUR"), "-19.99"},
+		{New(1500, "JPY"), "1500"},
+		{New(-7, "JPY"), "-7"},
+		{New(1234, "KWD"), "1.234"},
+		{New(12, "KWD"), "0.012"},
+		{New(math.MinInt64, "USD"), "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.m.Decimal(); got != tt.want {
+				t.Errorf("%d %s Decimal() = %q, want %q", tt.m.Amount, tt.m.Currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimalRoundTrip(t *testing.T) {
+	for _, m := range []Money{New(1999, "USD"), New(-5, "EUR"), New(1500, "JPY"), New(12, "KWD"), New(math.MaxInt64, "USD")} {
+		got, err := Parse(m.Decimal(), m.Currency)
+
+		if err != nil || got != m {
+			t.Errorf("Parse(%q) = %v, %v; want %v", m.Decimal(), got, err, m)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	if got := New(1999, "USD").String(); got != "19.99 USD" {
+		t.Errorf("String() = %q, want %q", got, "19.99 USD")
+	}
+}
+
+func TestMulRatRounding(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		rate   *big.Rat
+		want   int64
+	}{
+		{"exact", 1000, big.NewRat(3, 2), 1500},
+		{"below half", 1, big.NewRat(49, 100), 0},
+		{"half up", 1, big.NewRat(1, 2), 1},
+		{"negative half", -1, big.NewRat(1, 2), -1},
+		{"above half", 3, big.NewRat(1, 2), 2},
+		{"negative above half", -3, big.NewRat(1, 2), -2},
+		{"negative below half", -1, big.NewRat(49, 100), 0},
+		{"third", 100, big.NewRat(1, 3), 33},
+		{"two thirds", 100, big.NewRat(2, 3), 67},
+		{"negative two thirds", -100, big.NewRat(2, 3), -67},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.amount, "USD").MulRat(tt.rate)
+
+			if err != nil || got != New(tt.want, "USD") {
+				t.Errorf("MulRat(%d, %s) = %v, %v; want %d", tt.amount, tt.rate, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := New(math.MaxInt64, "USD").MulRat(big.NewRat(2, 1)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("MulRat overflow: got %v, want %v", err, ErrOverflow)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent int64
+		want    int64
+	}{
+		{1999, 10, 200},
+		{1995, 10, 200},
+		{1994, 10, 199},
+		{-1995, 10, -200},
+		{50, 1, 1},
+		{49, 1, 0},
+		{1000, 100, 1000},
+	}
+
+	for _, tt := range tests {
+		got, err := New(tt.amount, "USD").Percent(tt.percent)
+
+		if err != nil || got.Amount != tt.want {
+			t.Errorf("%d.Percent(%d) = %v, %v; want %d", tt.amount, tt.percent, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("no exchange rate for currency")
+	ErrInvalidRate     = errors.New("exchange rates must be positive decimals")
+)
+
+// RateSnapshot is the serialised form of a RateTable. Each rate is the price
+// of one unit of Base in the keyed currency, written as a decimal string so
+// no precision is lost on the way in or out.
+type RateSnapshot struct {
+	Base      string            `json:"base"`
+	Rates     map[string]string `json:"rates"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// RateTable converts amounts between the store base currency and the
+// currencies it has rates for. It is safe for concurrent use.
+type RateTable struct {
+	mu        sync.RWMutex
+	base      string
+	rates     map[string]*big.Rat
+	updatedAt time.Time
+}
+
+func NewRateTable(base string) *RateTable {
+	return &RateTable{base: base, rates: make(map[string]*big.Rat)}
+}
+
+func (t *RateTable) Base() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.base
+}
+
+// Currencies lists the base currency followed by every quoted currency.
+func (t *RateTable) Currencies() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	quoted := make([]string, 0, len(t.rates))
+	for currency := range t.rates {
+		quoted = append(quoted, currency)
+	}
+	sort.Strings(quoted)
+
+	return append([]string{t.base}, quoted...)
+}
+
+func (t *RateTable) Supports(currency string) bool {
+	_, err := t.Rate(currency)
+	return err == nil
+}
+
+// Rate returns how many units of currency one unit of the base buys.
+func (t *RateTable) Rate(currency string) (*big.Rat, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if currency == t.base {
+		return big.NewRat(1, 1), nil
+	}
+
+	rate, ok := t.rates[currency]
+
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+
+	return new(big.Rat).Set(rate), nil
+}
+
+// Replace swaps in a whole new set of rates after validating every entry.
+// The base currency cannot change, since stored prices are denominated in it.
+func (t *RateTable) Replace(snapshot RateSnapshot) error {
+	if snapshot.Base != t.Base() {
+		return ErrCurrencyMismatch
+	}
+
+	rates := make(map[string]*big.Rat, len(snapshot.Rates))
+
+	for currency, raw := range snapshot.Rates {
+		currency = strings.ToUpper(currency)
+
+		if !ValidCurrency(currency) {
+			return ErrInvalidCurrency
+		}
+
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(raw))
+
+		if !ok || rate.Sign() <= 0 {
+			return ErrInvalidRate
+		}
+
+		if currency != snapshot.Base {
+			rates[currency] = rate
+		}
+	}
+
+	if snapshot.UpdatedAt.IsZero() {
+		snapshot.UpdatedAt = time.Now().UTC()
+	}
+
+	t.mu.Lock()
+	t.rates = rates
+	t.updatedAt = snapshot.UpdatedAt
+	t.mu.Unlock()
+
+	return nil
+}
+
+func (t *RateTable) Snapshot() RateSnapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rates := make(map[string]string, len(t.rates))
+	for currency, rate := range t.rates {
+		rates[currency] = FormatRate(rate)
+	}
+
+	return RateSnapshot{Base: t.base, Rates: rates, UpdatedAt: t.updatedAt}
+}
+
+// Convert changes m into currency to and returns the effective rate applied,
+// rounding half away from zero to the minor unit of the target currency.
+func (t *RateTable) Convert(m Money, to string) (Money, *big.Rat, error) {
+	if m.Currency == to {
+		return m, big.NewRat(1, 1), nil
+	}
+
+	fromRate, err := t.Rate(m.Currency)
+
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	toRate, err := t.Rate(to)
+
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	rate := new(big.Rat).Quo(toRate, fromRate)
+	converted, err := convert(m.Amount, rate, Exponent(to)-Exponent(m.Currency))
+
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	return Money{Amount: converted, Currency: to}, rate, nil
+}
+
+// PriceIn returns the price of an item in currency: an explicit entry of its
+// price list wins, otherwise the base price is converted at the current rate.
+func (t *RateTable) PriceIn(base Money, priceList []Money, currency string) (Money, error) {
+	if base.Currency == currency {
+		return base, nil
+	}
+
+	for _, price := range priceList {
+		if price.Currency == currency {
+			return price, nil
+		}
+	}
+
+	converted, _, err := t.Convert(base, currency)
+	return converted, err
+}
+
+func convert(amount int64, rate *big.Rat, expShift int) (int64, error) {
+	value := new(big.Rat).SetInt64(amount)
+	value.Mul(value, rate)
+
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(expShift))), nil))
+	if expShift >= 0 {
+		value.Mul(value, scale)
+	} else {
+		value.Quo(value, scale)
+	}
+
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(value.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(value.Sign())))
+	}
+
+	if !quotient.IsInt64() {
+		return 0, ErrOverflow
+	}
+
+	return quotient.Int64(), nil
+}
+
+// FormatRate renders a rate as a plain decimal with at most 12 fraction
+// digits and no trailing zeros.
+func FormatRate(rate *big.Rat) string {
+	s := rate.FloatString(12)
+
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	return s
+}
+
+func LoadRates(path string) (RateSnapshot, error) {
+	var snapshot RateSnapshot
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return snapshot, err
+	}
+
+	err = json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+// SaveRates writes the snapshot next to path and renames it into place.
+func SaveRates(path string, snapshot RateSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".rates-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
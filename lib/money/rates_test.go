@@ -0,0 +1,132 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		rate *big.Rat
+		want string
+	}{
+		{big.NewRat(1, 1), "1"},
+		{big.NewRat(0, 1), "0"},
+		{big.NewRat(92, 100), "0.92"},
+		{big.NewRat(150, 1), "150"},
+		{big.NewRat(1, 3), "0.333333333333"},
+		{big.NewRat(2, 3), "0.666666666667"},
+		{big.NewRat(1, 8), "0.125"},
+		{big.NewRat(1, 10_000_000_000_000), "0"},
+		{big.NewRat(-5, 4), "-1.25"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := FormatRate(tt.rate); got != tt.want {
+				t.Errorf("FormatRate(%s) = %q, want %q", tt.rate, got, tt.want)
+			}
+		})
+	}
+}
+
+func testRates(t *testing.T) *RateTable {
+	t.Helper()
+
+	table := NewRateTable("USD")
+	err := table.Replace(RateSnapshot{Base: "USD", Rates: map[string]string{"EUR": "0.92", "JPY": "150.5", "KWD": "0.3075"}})
+
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	return table
+}
+
+func TestConvert(t *testing.T) {
+	table := testRates(t)
+
+	tests := []struct {
+		name string
+		m    Money
+		to   string
+		want Money
+		rate string
+		err  error
+	}{
+		{"same currency", New(1999, "USD"), "USD", New(1999, "USD"), "1", nil},
+		{"to quoted", New(1000, "USD"), "EUR", New(920, "EUR"), "0.92", nil},
+		{"rounds down", New(1999, "USD"), "EUR", New(1839, "EUR"), "0.92", nil},
+		{"half away from zero", New(100, "USD"), "JPY", New(151, "JPY"), "150.5", nil},
+		{"negative half away from zero", New(-100, "USD"), "JPY", New(-151, "JPY"), "150.5", nil},
+		{"to zero exponent", New(1999, "USD"), "JPY", New(3008, "JPY"), "150.5", nil},
+		{"to three digit exponent", New(1999, "USD"), "KWD", New(6147, "KWD"), "0.3075", nil},
+		{"from quoted to base", New(920, "EUR"), "USD", New(1000, "USD"), "1.086956521739", nil},
+		{"cross rate", New(1000, "EUR"), "JPY", New(1636, "JPY"), "163.586956521739", nil},
+		{"unknown target", New(1000, "USD"), "GBP", Money{}, "", ErrUnknownCurrency},
+		{"unknown source", New(1000, "GBP"), "USD", Money{}, "", ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rate, err := table.Convert(tt.m, tt.to)
+
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("Convert(%v, %s) = %v, %v; want %v, %v", tt.m, tt.to, got, err, tt.want, tt.err)
+			}
+
+			if err == nil && FormatRate(rate) != tt.rate {
+				t.Errorf("Convert(%v, %s) rate = %s, want %s", tt.m, tt.to, FormatRate(rate), tt.rate)
+			}
+		})
+	}
+}
+
+func TestPriceIn(t *testing.T) {
+	table := testRates(t)
+	base := New(1999, "USD")
+	priceList := []Money{New(1900, "EUR")}
+
+	tests := []struct {
+		currency string
+		want     Money
+		err      error
+	}{
+		{"USD", base, nil},
+		{"EUR", New(1900, "EUR"), nil},
+		{"JPY", New(3008, "JPY"), nil},
+		{"GBP", Money{}, ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		got, err := table.PriceIn(base, priceList, tt.currency)
+
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("PriceIn(%s) = %v, %v; want %v, %v", tt.currency, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot RateSnapshot
+		err      error
+	}{
+		{"valid", RateSnapshot{Base: "USD", Rates: map[string]string{"eur": "0.92"}}, nil},
+		{"other base", RateSnapshot{Base: "EUR", Rates: map[string]string{"USD": "1.08"}}, ErrCurrencyMismatch},
+		{"bad currency", RateSnapshot{Base: "USD", Rates: map[string]string{"EURO": "0.92"}}, ErrInvalidCurrency},
+		{"zero rate", RateSnapshot{Base: "USD", Rates: map[string]string{"EUR": "0"}}, ErrInvalidRate},
+		{"negative rate", RateSnapshot{Base: "USD", Rates: map[string]string{"EUR": "-0.92"}}, ErrInvalidRate},
+		{"not a number", RateSnapshot{Base: "USD", Rates: map[string]string{"EUR": "abc"}}, ErrInvalidRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewRateTable("USD").Replace(tt.snapshot); !errors.Is(err, tt.err) {
+				t.Errorf("Replace() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"github.com/maksimulitin/lib/logger"
+	"log/slog"
+)
+
+const NotifierLog = "log"
+
+var ErrUnknownNotifier = errors.New("unknown notifier")
+
+// Message is one notification to a customer. Kind names what it is about,
+// e.g. "cart_reminder", and Data carries values a template may use.
+type Message struct {
+	Kind    string
+	UserID  string
+	Email   string
+	Subject string
+	Body    string
+	Data    map[string]string
+}
+
+// Notifier delivers messages to customers, by email, push or anything else
+// a deployment plugs in.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns the notifier called name. Only NotifierLog, which writes
+// messages to the service log, exists so far.
+func New(name string) (Notifier, error) {
+	switch name {
+	case "", NotifierLog:
+		return Log{}, nil
+	default:
+		return nil, ErrUnknownNotifier
+	}
+}
+
+// Log writes messages to the service log instead of sending them, for local
+// development and for deployments without a delivery channel yet.
+type Log struct{}
+
+func (Log) Name() string { return NotifierLog }
+
+func (Log) Notify(_ context.Context, msg Message) error {
+	logger.Info("notification",
+		slog.String("kind", msg.Kind),
+		slog.String("userID", msg.UserID),
+		slog.String("email", msg.Email),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+		slog.Any("data", msg.Data))
+	return nil
+}
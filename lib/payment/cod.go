@@ -0,0 +1,31 @@
+package payment
+
+import (
+	"context"
+	"github.com/maksimulitin/lib/money"
+)
+
+const ProviderCOD = "cod"
+
+// COD is cash on delivery. Nothing is charged up front: the authorization
+// only reserves the order total, and capturing records that the courier
+// collected the cash. Order state is kept by the caller, so COD holds none.
+type COD struct{}
+
+func (COD) Name() string { return ProviderCOD }
+
+func (COD) Authorize(_ context.Context, req Request) (Result, error) {
+	return Result{Reference: NewReference(ProviderCOD), Status: StatusAuthorized, Amount: req.Amount}, nil
+}
+
+func (COD) Capture(_ context.Context, reference string, amount money.Money) (Result, error) {
+	return Result{Reference: reference, Status: StatusCaptured, Amount: amount}, nil
+}
+
+func (COD) Void(_ context.Context, reference string) (Result, error) {
+	return Result{Reference: reference, Status: StatusVoided}, nil
+}
+
+func (COD) Refund(_ context.Context, reference string, amount money.Money) (Result, error) {
+	return Result{Reference: reference, Status: StatusRefunded, Amount: amount}, nil
+}
@@ -0,0 +1,264 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/maksimulitin/lib/logger"
+	"github.com/maksimulitin/lib/money"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ProviderCard = "card"
+
+	// SignatureHeader carries the hex HMAC-SHA256 of a mock webhook body.
+	SignatureHeader = "X-Mock-Signature"
+)
+
+// Card tokens the mock gateway understands. Any other token is declined as
+// an unknown card.
+const (
+	TokenApprove           = "tok_approve"
+	TokenDecline           = "tok_decline"
+	TokenInsufficientFunds = "tok_insufficient_funds"
+	TokenDelayedApprove    = "tok_delayed_approve"
+	TokenDelayedDecline    = "tok_delayed_decline"
+)
+
+const webhookAttempts = 3
+
+// MockGateway is an in-process card gateway for local development. Test
+// tokens decide whether an authorization is approved, declined, or left
+// pending and settled Delay later by a signed webhook posted to WebhookURL.
+// Payments live in memory and are lost on restart.
+type MockGateway struct {
+	Secret     string
+	WebhookURL string
+	Delay      time.Duration
+	Client     *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*mockPayment
+}
+
+type mockPayment struct {
+	status     string
+	authorized money.Money
+	captured   money.Money
+	refunded   money.Money
+}
+
+func NewMockGateway(secret, webhookURL string, delay time.Duration) *MockGateway {
+	return &MockGateway{
+		Secret:     secret,
+		WebhookURL: webhookURL,
+		Delay:      delay,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		payments:   make(map[string]*mockPayment),
+	}
+}
+
+func (g *MockGateway) Name() string { return ProviderCard }
+
+func (g *MockGateway) Authorize(_ context.Context, req Request) (Result, error) {
+	if req.Token == "" {
+		return Result{}, ErrTokenRequired
+	}
+
+	reference := NewReference("mock")
+	result := Result{Reference: reference, Amount: req.Amount}
+
+	switch req.Token {
+	case TokenApprove:
+		result.Status = StatusAuthorized
+	case TokenDecline:
+		result.Status, result.DeclineReason = StatusDeclined, "card_declined"
+	case TokenInsufficientFunds:
+		result.Status, result.DeclineReason = StatusDeclined, "insufficient_funds"
+	case TokenDelayedApprove, TokenDelayedDecline:
+		result.Status = StatusPending
+	default:
+		result.Status, result.DeclineReason = StatusDeclined, "unknown_card"
+	}
+
+	g.mu.Lock()
+	g.payments[reference] = &mockPayment{
+		status:     result.Status,
+		authorized: req.Amount,
+		captured:   money.Zero(req.Amount.Currency),
+		refunded:   money.Zero(req.Amount.Currency),
+	}
+	g.mu.Unlock()
+
+	if result.Status == StatusPending {
+		approve := req.Token == TokenDelayedApprove
+		time.AfterFunc(g.Delay, func() { g.settle(reference, approve) })
+	}
+
+	return result, nil
+}
+
+func (g *MockGateway) Capture(_ context.Context, reference string, amount money.Money) (Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+
+	if !ok {
+		return Result{}, ErrUnknownReference
+	}
+
+	if p.status != StatusAuthorized {
+		return Result{}, ErrInvalidTransition
+	}
+
+	if amount.Currency != p.authorized.Currency || amount.Amount <= 0 || amount.Amount > p.authorized.Amount {
+		return Result{}, ErrInvalidAmount
+	}
+
+	p.status, p.captured = StatusCaptured, amount
+	return Result{Reference: reference, Status: p.status, Amount: amount}, nil
+}
+
+func (g *MockGateway) Void(_ context.Context, reference string) (Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+
+	if !ok {
+		return Result{}, ErrUnknownReference
+	}
+
+	if p.status != StatusAuthorized && p.status != StatusPending {
+		return Result{}, ErrInvalidTransition
+	}
+
+	p.status = StatusVoided
+	return Result{Reference: reference, Status: p.status}, nil
+}
+
+func (g *MockGateway) Refund(_ context.Context, reference string, amount money.Money) (Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[reference]
+
+	if !ok {
+		return Result{}, ErrUnknownReference
+	}
+
+	if p.status != StatusCaptured && p.status != StatusPartiallyRefunded {
+		return Result{}, ErrInvalidTransition
+	}
+
+	if amount.Currency != p.captured.Currency || amount.Amount <= 0 || p.refunded.Amount+amount.Amount > p.captured.Amount {
+		return Result{}, ErrInvalidAmount
+	}
+
+	p.refunded.Amount += amount.Amount
+	p.status = StatusPartiallyRefunded
+
+	if p.refunded.Amount == p.captured.Amount {
+		p.status = StatusRefunded
+	}
+
+	return Result{Reference: reference, Status: p.status, Amount: amount}, nil
+}
+
+// VerifyWebhook checks the signature a mock webhook was sent with.
+func (g *MockGateway) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	var event Event
+
+	expected, err := hex.DecodeString(signature)
+
+	if err != nil || !hmac.Equal(expected, g.sign(payload)) {
+		return event, ErrInvalidSignature
+	}
+
+	err = json.Unmarshal(payload, &event)
+	return event, err
+}
+
+func (g *MockGateway) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(g.Secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// settle resolves a pending authorization and tells the shop about it.
+func (g *MockGateway) settle(reference string, approve bool) {
+	g.mu.Lock()
+	p, ok := g.payments[reference]
+
+	if !ok || p.status != StatusPending {
+		g.mu.Unlock()
+		return
+	}
+
+	event := Event{ID: NewReference("evt"), Reference: reference, Amount: p.authorized, CreatedAt: time.Now().UTC()}
+
+	if approve {
+		p.status = StatusAuthorized
+	} else {
+		p.status, event.DeclineReason = StatusDeclined, "card_declined"
+	}
+
+	event.Status = p.status
+	g.mu.Unlock()
+
+	g.deliver(event)
+}
+
+// deliver posts event to WebhookURL, retrying with a growing pause.
+func (g *MockGateway) deliver(event Event) {
+	payload, err := json.Marshal(event)
+
+	if err != nil {
+		logger.Error("error encoding mock webhook", slog.String("reference", event.Reference), slog.Any("error", err))
+		return
+	}
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = g.post(payload); err == nil {
+			logger.Info("mock webhook delivered", slog.String("reference", event.Reference), slog.String("status", event.Status))
+			return
+		}
+
+		logger.Warn("mock webhook delivery failed", slog.String("reference", event.Reference), slog.Int("attempt", attempt), slog.Any("error", err))
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (g *MockGateway) post(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, g.WebhookURL, bytes.NewReader(payload))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, hex.EncodeToString(g.sign(payload)))
+
+	resp, err := g.Client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
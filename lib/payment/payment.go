@@ -0,0 +1,119 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/maksimulitin/lib/money"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StatusPending           = "pending"
+	StatusAuthorized        = "authorized"
+	StatusCaptured          = "captured"
+	StatusVoided            = "voided"
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
+	StatusDeclined          = "declined"
+)
+
+var (
+	ErrUnknownProvider    = errors.New("unknown payment method")
+	ErrUnknownReference   = errors.New("unknown payment reference")
+	ErrInvalidTransition  = errors.New("payment is not in a state that allows this")
+	ErrInvalidAmount      = errors.New("amount exceeds what the payment allows")
+	ErrTokenRequired      = errors.New("payment token is required")
+	ErrInvalidSignature   = errors.New("invalid webhook signature")
+	ErrWebhooksNotEnabled = errors.New("payment method does not send webhooks")
+)
+
+// Request is a charge to authorize. Token identifies the card or account
+// for providers that need one; OrderID is passed on for reconciliation.
+type Request struct {
+	OrderID string
+	Amount  money.Money
+	Token   string
+}
+
+// Result is the outcome of one provider call. A decline is a normal result
+// with DeclineReason set, not an error. A pending authorization is settled
+// later by a webhook Event.
+type Result struct {
+	Reference     string
+	Status        string
+	Amount        money.Money
+	DeclineReason string
+}
+
+// Event is an asynchronous notification from a provider about a payment.
+type Event struct {
+	ID            string      `json:"id"`
+	Reference     string      `json:"reference"`
+	Status        string      `json:"status"`
+	Amount        money.Money `json:"amount"`
+	DeclineReason string      `json:"decline_reason,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+}
+
+// Provider moves money for orders. Real gateways only have to satisfy this
+// interface to be plugged into checkout.
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, req Request) (Result, error)
+	Capture(ctx context.Context, reference string, amount money.Money) (Result, error)
+	Void(ctx context.Context, reference string) (Result, error)
+	Refund(ctx context.Context, reference string, amount money.Money) (Result, error)
+}
+
+// WebhookVerifier is implemented by providers that report outcomes
+// asynchronously. It authenticates a delivery and decodes its event.
+type WebhookVerifier interface {
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// Registry holds the providers customers can choose from at checkout.
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider, len(providers))}
+
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+
+	return r
+}
+
+func (r *Registry) Get(name string) (Provider, error) {
+	p, ok := r.providers[strings.ToLower(strings.TrimSpace(name))]
+
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+
+	for name := range r.providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// NewReference returns a random provider reference such as "cod_1f9c...".
+func NewReference(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + "_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,156 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxScannedKeys caps the work a single Lookup may do so that very short
+// prefixes stay within the suggestion latency budget.
+const maxScannedKeys = 2000
+
+type Suggestion struct {
+	Text   string  `json:"text"`
+	Kind   string  `json:"kind"`
+	Weight float64 `json:"-"`
+}
+
+type indexKey struct {
+	key        string
+	suggestion int
+}
+
+// PrefixIndex is an immutable-per-build, in-memory index answering prefix
+// lookups over suggestion texts and the words they contain. It is safe for
+// concurrent use; Build swaps the whole index atomically.
+type PrefixIndex struct {
+	mu          sync.RWMutex
+	suggestions []Suggestion
+	keys        []indexKey
+	vocabulary  []string
+}
+
+func NewPrefixIndex() *PrefixIndex {
+	return &PrefixIndex{}
+}
+
+func (idx *PrefixIndex) Build(suggestions []Suggestion) {
+	keys := make([]indexKey, 0, len(suggestions)*2)
+	words := make(map[string]struct{})
+
+	for i, suggestion := range suggestions {
+		lower := strings.ToLower(strings.TrimSpace(suggestion.Text))
+		keys = append(keys, indexKey{key: lower, suggestion: i})
+
+		for _, word := range Tokenize(lower) {
+			words[word] = struct{}{}
+			if !strings.HasPrefix(lower, word) {
+				keys = append(keys, indexKey{key: word, suggestion: i})
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+
+	vocabulary := make([]string, 0, len(words))
+	for word := range words {
+		vocabulary = append(vocabulary, word)
+	}
+	sort.Strings(vocabulary)
+
+	idx.mu.Lock()
+	idx.suggestions = suggestions
+	idx.keys = keys
+	idx.vocabulary = vocabulary
+	idx.mu.Unlock()
+}
+
+func (idx *PrefixIndex) Size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.suggestions)
+}
+
+// Lookup returns up to limit suggestions having a word or the whole text
+// starting with prefix, heaviest first.
+func (idx *PrefixIndex) Lookup(prefix string, limit int) []Suggestion {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	result := make([]Suggestion, 0, limit)
+
+	if prefix == "" || limit <= 0 {
+		return result
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	start := sort.Search(len(idx.keys), func(i int) bool { return idx.keys[i].key >= prefix })
+	seen := make(map[int]struct{})
+
+	for i := start; i < len(idx.keys) && i-start < maxScannedKeys; i++ {
+		if !strings.HasPrefix(idx.keys[i].key, prefix) {
+			break
+		}
+
+		if _, ok := seen[idx.keys[i].suggestion]; ok {
+			continue
+		}
+		seen[idx.keys[i].suggestion] = struct{}{}
+		result = append(result, idx.suggestions[idx.keys[i].suggestion])
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Weight != result[j].Weight {
+			return result[i].Weight > result[j].Weight
+		}
+		return result[i].Text < result[j].Text
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
+
+// DidYouMean replaces every query term missing from the indexed vocabulary
+// with its closest known word. It returns "" when nothing could be corrected.
+func (idx *PrefixIndex) DidYouMean(query string) string {
+	terms := QueryTerms(query)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	corrected := make([]string, len(terms))
+	changed := false
+
+	for i, term := range terms {
+		corrected[i] = term
+
+		if n := sort.SearchStrings(idx.vocabulary, term); n < len(idx.vocabulary) && idx.vocabulary[n] == term {
+			continue
+		}
+
+		limit := max(MaxTypos(term), 1)
+		best, bestDistance := "", limit+1
+
+		for _, word := range idx.vocabulary {
+			if d := Distance(term, word, limit); d < bestDistance {
+				best, bestDistance = word, d
+			}
+		}
+
+		if best != "" {
+			corrected[i] = best
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ""
+	}
+
+	return strings.Join(corrected, " ")
+}
@@ -0,0 +1,236 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxQueryLength = 100
+	MaxQueryTerms  = 8
+
+	HighlightOpen  = "<mark>"
+	HighlightClose = "</mark>"
+)
+
+// Tokenize lowercases text and splits it into unique words made of letters
+// and digits, preserving the order of first appearance.
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]struct{}, len(fields))
+	tokens := make([]string, 0, len(fields))
+
+	for _, field := range fields {
+		if _, ok := seen[field]; ok {
+			continue
+		}
+		seen[field] = struct{}{}
+		tokens = append(tokens, field)
+	}
+
+	return tokens
+}
+
+// QueryTerms turns raw user input into a bounded list of search terms.
+func QueryTerms(query string) []string {
+	if len(query) > MaxQueryLength {
+		query = query[:MaxQueryLength]
+	}
+
+	terms := Tokenize(query)
+
+	if len(terms) > MaxQueryTerms {
+		terms = terms[:MaxQueryTerms]
+	}
+
+	return terms
+}
+
+// MaxTypos returns how many edits a term of the given length tolerates.
+func MaxTypos(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Distance is the Levenshtein distance between a and b. It stops early and
+// returns limit+1 once the distance is known to exceed limit.
+func Distance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, curr[j])
+		}
+
+		if rowMin > limit {
+			return limit + 1
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// Match reports how well token matches term: 1 for an exact match, less for
+// a prefix or a typo-tolerant match and 0 when it does not match at all.
+func Match(term, token string) float64 {
+	switch {
+	case token == term:
+		return 1
+	case strings.HasPrefix(token, term):
+		return 0.7
+	}
+
+	if limit := MaxTypos(term); limit > 0 && Distance(term, token, limit) <= limit {
+		return 0.5
+	}
+
+	return 0
+}
+
+// Score sums, over every term, the best match found among the words of text.
+func Score(text string, terms []string) float64 {
+	tokens := Tokenize(text)
+	var score float64
+
+	for _, term := range terms {
+		var best float64
+		for _, token := range tokens {
+			best = max(best, Match(term, token))
+		}
+		score += best
+	}
+
+	return score
+}
+
+// Highlight HTML-escapes text and wraps every word matching one of the terms
+// in HighlightOpen and HighlightClose.
+func Highlight(text string, terms []string) string {
+	var (
+		out   strings.Builder
+		runes = []rune(text)
+		start = -1
+	)
+
+	flush := func(end int) {
+		word := string(runes[start:end])
+		lower := strings.ToLower(word)
+
+		for _, term := range terms {
+			if Match(term, lower) > 0 {
+				out.WriteString(HighlightOpen + html.EscapeString(word) + HighlightClose)
+				return
+			}
+		}
+
+		out.WriteString(html.EscapeString(word))
+	}
+
+	for i, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			flush(i)
+			start = -1
+		}
+
+		out.WriteString(html.EscapeString(string(r)))
+	}
+
+	if start >= 0 {
+		flush(len(runes))
+	}
+
+	return out.String()
+}
+
+// trigramPad marks the start of a word. Tokens hold only letters and digits,
+// so it never occurs inside one.
+const trigramPad = "^^"
+
+// Trigrams returns the distinct three-rune substrings of term, padded at the
+// start only, so every prefix of a word shares all of its trigrams with it.
+func Trigrams(term string) []string {
+	runes := []rune(trigramPad + term)
+	seen := make(map[string]struct{}, len(runes))
+	grams := make([]string, 0, len(runes))
+
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if _, ok := seen[gram]; ok {
+			continue
+		}
+		seen[gram] = struct{}{}
+		grams = append(grams, gram)
+	}
+
+	return grams
+}
+
+// WordTrigrams returns the distinct trigrams of every word.
+func WordTrigrams(words []string) []string {
+	seen := make(map[string]struct{})
+	grams := make([]string, 0)
+
+	for _, word := range words {
+		for _, gram := range Trigrams(word) {
+			if _, ok := seen[gram]; ok {
+				continue
+			}
+			seen[gram] = struct{}{}
+			grams = append(grams, gram)
+		}
+	}
+
+	return grams
+}
+
+// MinSharedTrigrams is how many trigrams of term a word must share with it to
+// possibly Match. A word that term is a prefix of shares all of them, and one
+// edit changes at most three, so a word within MaxTypos edits keeps this many.
+func MinSharedTrigrams(term string) int {
+	return max(1, len(Trigrams(term))-3*MaxTypos(term))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
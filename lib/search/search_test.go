@@ -0,0 +1,86 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrigrams(t *testing.T) {
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"a", []string{"^^a"}},
+		{"tv", []string{"^^t", "^tv"}},
+		{"lamp", []string{"^^l", "^la", "lam", "amp"}},
+		{"aaaa", []string{"^^a", "^aa", "aaa"}},
+		{"café", []string{"^^c", "^ca", "caf", "afé"}},
+	}
+
+	for _, tt := range tests {
+		if got := Trigrams(tt.term); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Trigrams(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
+
+func shared(term, word string) int {
+	grams := make(map[string]bool)
+	for _, gram := range Trigrams(word) {
+		grams[gram] = true
+	}
+
+	n := 0
+	for _, gram := range Trigrams(term) {
+		if grams[gram] {
+			n++
+		}
+	}
+	return n
+}
+
+// Every word Match accepts must survive the trigram candidate filter.
+func TestMinSharedTrigramsKeepsMatches(t *testing.T) {
+	tests := []struct {
+		term, word string
+	}{
+		{"tv", "tv"},
+		{"tv", "tvs"},
+		{"lap", "laptop"},
+		{"lapt", "laptop"},
+		{"labtop", "laptop"},
+		{"laptp", "laptop"},
+		{"xaptop", "laptop"},
+		{"laptopx", "laptop"},
+		{"headphnes", "headphones"},
+		{"haedphones", "headphones"},
+		{"keybaord", "keyboard"},
+		{"mechanicl", "mechanical"},
+	}
+
+	for _, tt := range tests {
+		if Match(tt.term, tt.word) == 0 {
+			t.Fatalf("Match(%q, %q) = 0, the case is not a match", tt.term, tt.word)
+		}
+
+		if got, need := shared(tt.term, tt.word), MinSharedTrigrams(tt.term); got < need {
+			t.Errorf("%q shares %d trigrams with %q, the filter needs %d", tt.word, got, tt.term, need)
+		}
+	}
+}
+
+func TestMinSharedTrigramsRejectsUnrelated(t *testing.T) {
+	tests := []struct {
+		term, word string
+	}{
+		{"tv", "table"},
+		{"lamp", "chair"},
+		{"laptop", "desktop"},
+	}
+
+	for _, tt := range tests {
+		if got, need := shared(tt.term, tt.word), MinSharedTrigrams(tt.term); got >= need {
+			t.Errorf("%q shares %d trigrams with %q, expected fewer than %d", tt.word, got, tt.term, need)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files below Root and exposes them under BaseURL,
+// which is expected to be served statically by the HTTP router.
+type Local struct {
+	Root    string
+	BaseURL string
+}
+
+func NewLocal(root, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Local{Root: root, BaseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+
+	if key == "" || clean == "/" || clean != "/"+key {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(l.Root, filepath.FromSlash(clean)), nil
+}
+
+// Put writes to a temporary file first and renames it into place, so readers
+// never observe a partially written object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, _ string) error {
+	target, err := l.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	target, err := l.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.BaseURL + "/" + key
+}
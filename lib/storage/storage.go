@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage persists binary objects under slash separated keys. Implementations
+// other than the local filesystem (S3 compatible stores, CDNs) only have to
+// satisfy this interface to be plugged into the application.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
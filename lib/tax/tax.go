@@ -0,0 +1,288 @@
+package tax
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/maksimulitin/lib/money"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ModeExclusive adds tax on top of catalog prices.
+	ModeExclusive = "exclusive"
+	// ModeInclusive treats catalog prices as already containing tax.
+	ModeInclusive = "inclusive"
+
+	// DefaultClass is used for products without a tax class, and for classes
+	// a region has no rate for.
+	DefaultClass = "standard"
+)
+
+var (
+	ErrInvalidMode   = errors.New("tax mode must be inclusive or exclusive")
+	ErrInvalidRate   = errors.New("tax rates must be decimals from 0 to 1")
+	ErrInvalidRegion = errors.New("every tax region needs a name and a standard rate")
+)
+
+// Region is an area with its own rates. It matches an address by postal code
+// prefix or, failing that, by city name.
+type Region struct {
+	Name           string            `json:"name"`
+	PostalPrefixes []string          `json:"postal_prefixes,omitempty"`
+	Cities         []string          `json:"cities,omitempty"`
+	Rates          map[string]string `json:"rates"`
+}
+
+// Rules is the serialised form of a Table. Default applies to addresses no
+// region matches, and to carts without an address.
+type Rules struct {
+	Mode      string    `json:"mode"`
+	Default   Region    `json:"default"`
+	Regions   []Region  `json:"regions"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Rate is the rate resolved for one line.
+type Rate struct {
+	Region string
+	Class  string
+	Value  *big.Rat
+}
+
+type region struct {
+	name     string
+	prefixes []string
+	cities   []string
+	rates    map[string]*big.Rat
+}
+
+// Table resolves tax rates for addresses and product classes. It is safe for
+// concurrent use.
+type Table struct {
+	mu       sync.RWMutex
+	rules    Rules
+	mode     string
+	fallback region
+	regions  []region
+}
+
+// NewTable returns a table that charges no tax until rules are loaded.
+func NewTable() *Table {
+	t := &Table{}
+	_ = t.Replace(Rules{Mode: ModeExclusive, Default: Region{Name: "default", Rates: map[string]string{DefaultClass: "0"}}})
+	return t
+}
+
+func (t *Table) Mode() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.mode
+}
+
+func (t *Table) Rules() Rules {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.rules
+}
+
+// Replace validates and swaps in a whole new set of rules.
+func (t *Table) Replace(rules Rules) error {
+	rules.Mode = strings.ToLower(strings.TrimSpace(rules.Mode))
+
+	if rules.Mode != ModeExclusive && rules.Mode != ModeInclusive {
+		return ErrInvalidMode
+	}
+
+	fallback, err := compileRegion(rules.Default)
+
+	if err != nil {
+		return err
+	}
+
+	regions := make([]region, 0, len(rules.Regions))
+
+	for _, r := range rules.Regions {
+		compiled, err := compileRegion(r)
+
+		if err != nil {
+			return err
+		}
+
+		regions = append(regions, compiled)
+	}
+
+	if rules.UpdatedAt.IsZero() {
+		rules.UpdatedAt = time.Now().UTC()
+	}
+
+	t.mu.Lock()
+	t.rules = rules
+	t.mode = rules.Mode
+	t.fallback = fallback
+	t.regions = regions
+	t.mu.Unlock()
+
+	return nil
+}
+
+func compileRegion(r Region) (region, error) {
+	if strings.TrimSpace(r.Name) == "" {
+		return region{}, ErrInvalidRegion
+	}
+
+	compiled := region{name: r.Name, rates: make(map[string]*big.Rat, len(r.Rates))}
+
+	for class, raw := range r.Rates {
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(raw))
+
+		if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(1, 1)) > 0 {
+			return region{}, ErrInvalidRate
+		}
+
+		compiled.rates[strings.ToLower(strings.TrimSpace(class))] = rate
+	}
+
+	if _, ok := compiled.rates[DefaultClass]; !ok {
+		return region{}, ErrInvalidRegion
+	}
+
+	for _, prefix := range r.PostalPrefixes {
+		if prefix = NormalizePostalCode(prefix); prefix != "" {
+			compiled.prefixes = append(compiled.prefixes, prefix)
+		}
+	}
+
+	for _, city := range r.Cities {
+		if city = strings.TrimSpace(city); city != "" {
+			compiled.cities = append(compiled.cities, city)
+		}
+	}
+
+	return compiled, nil
+}
+
+// NormalizePostalCode upper-cases a postal code and drops spaces and dashes
+// so "sw1a 1aa" and "SW1A1AA" compare equal.
+func NormalizePostalCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// Lookup returns the rate for a product class at an address. The region with
+// the longest matching postal prefix wins; otherwise the first region listing
+// the city; otherwise the default region.
+func (t *Table) Lookup(city, postalCode, class string) Rate {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	match := t.match(city, postalCode)
+	class = strings.ToLower(strings.TrimSpace(class))
+
+	if class == "" {
+		class = DefaultClass
+	}
+
+	rate, ok := match.rates[class]
+
+	if !ok {
+		class, rate = DefaultClass, match.rates[DefaultClass]
+	}
+
+	return Rate{Region: match.name, Class: class, Value: new(big.Rat).Set(rate)}
+}
+
+func (t *Table) match(city, postalCode string) *region {
+	var (
+		best    *region
+		bestLen int
+	)
+
+	if postal := NormalizePostalCode(postalCode); postal != "" {
+		for i := range t.regions {
+			for _, prefix := range t.regions[i].prefixes {
+				if len(prefix) > bestLen && strings.HasPrefix(postal, prefix) {
+					best, bestLen = &t.regions[i], len(prefix)
+				}
+			}
+		}
+	}
+
+	if best != nil {
+		return best
+	}
+
+	if city = strings.TrimSpace(city); city != "" {
+		for i := range t.regions {
+			for _, name := range t.regions[i].cities {
+				if strings.EqualFold(name, city) {
+					return &t.regions[i]
+				}
+			}
+		}
+	}
+
+	return &t.fallback
+}
+
+// Amount returns the tax on price at rate. In inclusive mode the tax is the
+// part of price that is tax, price * rate / (1 + rate).
+func Amount(price money.Money, rate *big.Rat, mode string) (money.Money, error) {
+	if mode == ModeInclusive {
+		gross := new(big.Rat).Add(big.NewRat(1, 1), rate)
+		return price.MulRat(new(big.Rat).Quo(rate, gross))
+	}
+
+	return price.MulRat(rate)
+}
+
+func LoadRules(path string) (Rules, error) {
+	var rules Rules
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return rules, err
+	}
+
+	err = json.Unmarshal(data, &rules)
+	return rules, err
+}
+
+// SaveRules writes the rules next to path and renames them into place.
+func SaveRules(path string, rules Rules) error {
+	data, err := json.MarshalIndent(rules, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".taxes-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
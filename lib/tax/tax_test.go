@@ -0,0 +1,122 @@
+package tax
+
+import (
+	"errors"
+	"github.com/maksimulitin/lib/money"
+	"math/big"
+	"testing"
+)
+
+func rat(t *testing.T, s string) *big.Rat {
+	t.Helper()
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		t.Fatalf("bad rate %q", s)
+	}
+	return r
+}
+
+func TestLookup(t *testing.T) {
+	table := NewTable()
+	err := table.Replace(Rules{
+		Mode:    ModeExclusive,
+		Default: Region{Name: "default", Rates: map[string]string{DefaultClass: "0.1", "reduced": "0.05"}},
+		Regions: []Region{
+			{Name: "state", PostalPrefixes: []string{"9"}, Rates: map[string]string{DefaultClass: "0.07"}},
+			{Name: "county", PostalPrefixes: []string{"941"}, Rates: map[string]string{DefaultClass: "0.08", "food": "0.02"}},
+			{Name: "springfield", Cities: []string{"Springfield"}, Rates: map[string]string{DefaultClass: "0.06"}},
+			{Name: "shelbyville", Cities: []string{"Springfield", "Shelbyville"}, Rates: map[string]string{DefaultClass: "0.09"}},
+			{Name: "london", PostalPrefixes: []string{"sw1a 1"}, Rates: map[string]string{DefaultClass: "0.2"}},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		city, postalCode string
+		class            string
+		region           string
+		wantClass        string
+		rate             string
+	}{
+		{"longest prefix wins", "", "94105", "", "county", DefaultClass, "0.08"},
+		{"shorter prefix", "", "90210", "", "state", DefaultClass, "0.07"},
+		{"prefix before city", "Springfield", "94105", "", "county", DefaultClass, "0.08"},
+		{"city when no prefix matches", "springfield ", "10001", "", "springfield", DefaultClass, "0.06"},
+		{"first region listing the city", "Shelbyville", "", "", "shelbyville", DefaultClass, "0.09"},
+		{"default without a match", "Ogdenville", "10001", "", "default", DefaultClass, "0.1"},
+		{"default without an address", "", "", "", "default", DefaultClass, "0.1"},
+		{"postal code normalized", "", " sw1a-1aa ", "", "london", DefaultClass, "0.2"},
+		{"class rate", "", "94105", "Food ", "county", "food", "0.02"},
+		{"class missing in region", "", "90210", "food", "state", DefaultClass, "0.07"},
+		{"class in default region", "", "", "reduced", "default", "reduced", "0.05"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := table.Lookup(tt.city, tt.postalCode, tt.class)
+
+			if got.Region != tt.region || got.Class != tt.wantClass || got.Value.Cmp(rat(t, tt.rate)) != 0 {
+				t.Errorf("Lookup(%q, %q, %q) = %s/%s/%s, want %s/%s/%s", tt.city, tt.postalCode, tt.class,
+					got.Region, got.Class, got.Value.FloatString(4), tt.region, tt.wantClass, tt.rate)
+			}
+		})
+	}
+}
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		name  string
+		price money.Money
+		rate  string
+		mode  string
+		want  money.Money
+	}{
+		{"exclusive", money.New(1000, "USD"), "0.2", ModeExclusive, money.New(200, "USD")},
+		{"exclusive rounds half up", money.New(999, "USD"), "0.075", ModeExclusive, money.New(75, "USD")},
+		{"exclusive zero rate", money.New(1000, "USD"), "0", ModeExclusive, money.New(0, "USD")},
+		{"inclusive", money.New(1200, "USD"), "0.2", ModeInclusive, money.New(200, "USD")},
+		{"inclusive rounds", money.New(1000, "USD"), "0.2", ModeInclusive, money.New(167, "USD")},
+		{"inclusive zero rate", money.New(1000, "USD"), "0", ModeInclusive, money.New(0, "USD")},
+		{"zero decimal currency", money.New(1080, "JPY"), "0.08", ModeInclusive, money.New(80, "JPY")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Amount(tt.price, rat(t, tt.rate), tt.mode)
+
+			if err != nil || got != tt.want {
+				t.Errorf("Amount(%v, %s, %s) = %v, %v; want %v", tt.price, tt.rate, tt.mode, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	standard := map[string]string{DefaultClass: "0.1"}
+
+	tests := []struct {
+		name  string
+		rules Rules
+		err   error
+	}{
+		{"valid", Rules{Mode: " Inclusive ", Default: Region{Name: "default", Rates: standard}}, nil},
+		{"unknown mode", Rules{Mode: "gross", Default: Region{Name: "default", Rates: standard}}, ErrInvalidMode},
+		{"rate above one", Rules{Mode: ModeExclusive, Default: Region{Name: "default", Rates: map[string]string{DefaultClass: "1.5"}}}, ErrInvalidRate},
+		{"negative rate", Rules{Mode: ModeExclusive, Default: Region{Name: "default", Rates: map[string]string{DefaultClass: "-0.1"}}}, ErrInvalidRate},
+		{"no standard rate", Rules{Mode: ModeExclusive, Default: Region{Name: "default", Rates: map[string]string{"food": "0.1"}}}, ErrInvalidRegion},
+		{"unnamed region", Rules{Mode: ModeExclusive, Default: Region{Name: "default", Rates: standard}, Regions: []Region{{Rates: standard}}}, ErrInvalidRegion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewTable().Replace(tt.rules); !errors.Is(err, tt.err) {
+				t.Errorf("Replace() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}